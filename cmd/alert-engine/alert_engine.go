@@ -1,15 +1,45 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/compress"
+	"google.golang.org/protobuf/proto"
+
+	"gpu-telemetry/alert-engine/gpupb"
 )
 
 type GPUMetric struct {
@@ -21,7 +51,42 @@ type GPUMetric struct {
 	MemoryTotalMB      float64   `json:"memory_total_mb"`
 	UtilizationPercent float64   `json:"utilization_percent"`
 	SMClockMHz         int       `json:"sm_clock_mhz"`
+	FanSpeedPercent    float64   `json:"fan_speed_percent"`
+	RowRemapPending    int       `json:"row_remap_pending"`
 	CollectedAt        time.Time `json:"collected_at"`
+	// EnforcedPowerLimitWatts is the GPU's enforced power cap; zero when
+	// the collector didn't report it
+	EnforcedPowerLimitWatts float64 `json:"enforced_power_limit_watts"`
+	// GPUUUID identifies the card across moves between nodes and indexes;
+	// empty when the collector didn't report it
+	GPUUUID string `json:"gpu_uuid,omitempty"`
+	// Labels attribute the sample, e.g. to a job or team
+	Labels map[string]string `json:"labels,omitempty"`
+	// Histograms carry distribution-shaped fields such as sm_occupancy
+	Histograms map[string]Histogram `json:"histograms,omitempty"`
+	// Unreported lists optional fields, such as fan_speed_percent, that the
+	// collector's source didn't report and sent as zero
+	Unreported []string `json:"unreported,omitempty"`
+
+	// ClockSkewSeconds is set by the engine when collected_at is further
+	// than MaxClockSkew from ingestion time
+	ClockSkewSeconds *float64 `json:"-"`
+	// scrapedAt is the collector's timestamp before COLLECTED_AT_SOURCE
+	// replaced it, identifying the scrape the sample came from
+	scrapedAt time.Time
+}
+
+// Histogram is a Prometheus-style distribution with cumulative buckets
+type Histogram struct {
+	Buckets []HistogramBucket `json:"buckets"`
+	Count   float64           `json:"count"`
+	Sum     float64           `json:"sum"`
+}
+
+// HistogramBucket is one cumulative histogram bucket
+type HistogramBucket struct {
+	LE    float64 `json:"le"`
+	Count float64 `json:"count"`
 }
 
 type Alert struct {
@@ -32,251 +97,6844 @@ type Alert struct {
 	Message        string
 	ThresholdValue float64
 	ActualValue    float64
+	// RunbookURL links to remediation steps, from the rule that raised the
+	// alert; empty falls back to RunbookBaseURL
+	RunbookURL string
+	// Schedule is the notify schedule of the rule that raised the alert;
+	// nil notifies at any hour
+	Schedule *NotifySchedule
+}
+
+// DedupKey identifies the logical alert (node, gpu, type) across repeats, so
+// external incident tools can group them
+func (a Alert) DedupKey() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d/%s", a.NodeID, a.GPUIndex, a.AlertType)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Config holds alert engine settings, loaded from the environment
+type Config struct {
+	DBConnStr     string
+	KafkaBrokers  []string
+	KafkaTopic    string
+	ConsumerGroup string
+	// DBConnectAttempts and DBConnectBackoff bound the startup wait for Postgres
+	DBConnectAttempts int
+	DBConnectBackoff  time.Duration
+	// SessionTimeout and RebalanceTimeout bound how long the group waits on
+	// a dead or rebalancing member before reassigning its partitions
+	SessionTimeout   time.Duration
+	RebalanceTimeout time.Duration
+	// StartOffset is where a consumer group without committed offsets starts
+	// reading: kafka.FirstOffset or kafka.LastOffset. When StartTime is set,
+	// a new group's offsets are first committed at that time instead.
+	StartOffset int64
+	StartTime   time.Time
+	// GroupCheckInterval is how often the topic's partition count and the
+	// group's member count are compared; zero disables the check
+	GroupCheckInterval time.Duration
+	// FetchMinBytes and FetchMaxWait let the broker accumulate small
+	// messages into fewer, larger fetches: a fetch returns once it has
+	// FetchMinBytes or FetchMaxWait has passed. FetchMaxBytes caps a fetch
+	// and QueueCapacity is how many fetched messages are buffered ahead of
+	// the consume loop.
+	FetchMinBytes int
+	FetchMaxBytes int
+	FetchMaxWait  time.Duration
+	QueueCapacity int
+	// KafkaClientID identifies this instance to the brokers, in their
+	// request logs and quotas; defaults to alert-engine-<hostname>
+	KafkaClientID string
+
+	// DryRun records alerts from live traffic to shadow_alerts instead of
+	// creating real alerts and taking actions
+	DryRun bool
+	// BacktestSince, when set, replays stored metrics from that far back
+	// through the rules into shadow_alerts and exits
+	BacktestSince time.Duration
+
+	// SlackWebhookURL receives warning notifications when no channels file
+	// is given; empty only logs them
+	SlackWebhookURL string
+	// AlertTopicEnabled publishes every created alert to AlertTopic
+	AlertTopicEnabled bool
+	AlertTopic        string
+	// NotifyChannelsFile is a JSON list of notification channels, each with
+	// its own severities and message format
+	NotifyChannelsFile string
+	// RunbookBaseURL, when set, links notifications to <base>/<alert_type>
+	RunbookBaseURL string
+	// DrainWebhookURL, when set, is POSTed to cordon and drain a node on
+	// critical alerts (e.g. a Kubernetes cordon service); DrainWebhookToken
+	// is sent as a bearer token. Unset, migration only marks the node degraded.
+	DrainWebhookURL   string
+	DrainWebhookToken string
+	DrainTimeout      time.Duration
+	// ActionMaxAttempts and ActionRetryInterval control retries of failed actions
+	ActionMaxAttempts   int
+	ActionRetryInterval time.Duration
+	// ActionRetention deletes finished alert_actions rows older than this,
+	// independently of their alerts; zero keeps them as long as the alert
+	ActionRetention time.Duration
+	// MetricsRetention deletes gpu_metrics rows collected longer ago than
+	// this every MetricsRetentionInterval; zero keeps them forever. With
+	// ArchiveBucket set the rows are first exported to S3-compatible
+	// storage as gzipped NDJSON under ArchivePrefix, and only deleted once
+	// the upload succeeds.
+	MetricsRetention         time.Duration
+	MetricsRetentionInterval time.Duration
+	ArchiveBucket            string
+	ArchivePrefix            string
+	ArchiveEndpoint          string // empty is AWS S3 in ArchiveRegion
+	ArchiveRegion            string
+	ArchiveAccessKeyID       string
+	ArchiveSecretAccessKey   string
+	// NotifyConcurrency is how many notifications are sent at once by a
+	// worker pool fed from a queue of NotifyQueueSize; when the queue is
+	// full, notifications are left pending for the action retry worker.
+	// Zero sends them inline on the consume path.
+	NotifyConcurrency int
+	NotifyQueueSize   int
+
+	// MessageFormat is json, avro or protobuf, used for messages without a
+	// content-type header; avro expects Confluent-framed records whose writer
+	// schemas are fetched from SchemaRegistryURL
+	MessageFormat     string
+	SchemaRegistryURL string
+
+	// Source is where metrics are consumed from: kafka, or file to tail
+	// SourceFile, a collector's SINK_FILE on the same host. The file has no
+	// committed position, so it is read from its end on every start, or
+	// from its start when KAFKA_START_OFFSET is earliest.
+	Source     string
+	SourceFile string
+
+	// CollectedAtSource picks the timestamp stored as collected_at: the
+	// collector's clock, the Kafka message timestamp, or the engine's clock.
+	// MaxClockSkew flags samples whose collector timestamp is further than
+	// that from ingestion time; zero disables the check.
+	CollectedAtSource string
+	MaxClockSkew      time.Duration
+
+	// ClockSkewSamples is how many consecutive scrapes from a node must be
+	// flagged by MaxClockSkew before a clock_skew alert; zero disables it
+	ClockSkewSamples int
+
+	// WarmupPeriod suppresses alerts from a node for this long after it is
+	// registered or reappears after WarmupRestartGap of silence; zero disables it
+	WarmupPeriod     time.Duration
+	WarmupRestartGap time.Duration
+
+	// DBStatementTimeout bounds each metric/alert write so a locked table
+	// can't stall the consumer
+	DBStatementTimeout time.Duration
+
+	// RulesReloadInterval is how often alert_rules is re-read; zero disables reloading
+	RulesReloadInterval time.Duration
+
+	// HistorySamples is how many recent samples are kept in memory per GPU
+	// for rules that look back; GPUs silent for HistoryEvictAfter are
+	// dropped along with their state (zero keeps them forever)
+	HistorySamples    int
+	HistoryEvictAfter time.Duration
+
+	// StoreSampleEvery stores only every Nth sample of a GPU, except while
+	// the GPU or its node has an active alert, when every sample is kept;
+	// 1 stores everything
+	StoreSampleEvery int
+	// StorePrecision rounds the named gpu_metrics fields to that many
+	// decimal places as they are stored; rules still see exact values.
+	// Empty stores everything exactly.
+	StorePrecision map[string]int
+
+	// StuckSensorSamples is how many consecutive identical samples from a GPU
+	// raise a stuck_sensor alert; zero disables the check
+	StuckSensorSamples int
+
+	// IncompleteMetricGrace is how many consecutive samples from a GPU may
+	// lack memory_total_mb before a metric_incomplete alert; zero disables
+	// the alert. Memory rules are skipped for such samples regardless.
+	IncompleteMetricGrace int
+
+	// SensorInconsistencySamples is how many consecutive physically
+	// inconsistent samples (full utilization at no power, or high power at
+	// no utilization) raise a sensor_inconsistency alert; zero disables it
+	SensorInconsistencySamples int
+
+	// LeakedMemoryWindow is how long a GPU must hold more than
+	// LeakedMemoryPercent memory at under LeakedMemoryUtilization
+	// utilization before a leaked_memory alert; zero disables the check
+	LeakedMemoryWindow      time.Duration
+	LeakedMemoryPercent     float64
+	LeakedMemoryUtilization float64
+
+	// PowerCapWindow is how long a GPU's power must sit within
+	// PowerCapMarginWatts of its enforced power limit at PowerCapUtilization
+	// or more before a power_capped alert; zero disables the check
+	PowerCapWindow      time.Duration
+	PowerCapMarginWatts float64
+	PowerCapUtilization float64
+
+	// JobStopSamples is how many consecutive samples at or below
+	// JobStopUtilization, after the GPU averaged at least
+	// JobStopBaselineUtilization over the rest of JobStopBaselineWindow,
+	// raise a job_stopped alert; zero disables the check
+	JobStopSamples             int
+	JobStopUtilization         float64
+	JobStopBaselineUtilization float64
+	JobStopBaselineWindow      time.Duration
+
+	// NodePowerBudgetWatts is the most a node's GPUs may draw together
+	// (summing each GPU's latest sample) for NodePowerWindow before a
+	// node_power_budget alert; zero disables the check
+	NodePowerBudgetWatts float64
+	NodePowerWindow      time.Duration
+
+	// MissingGPUWindow is how long a node must report fewer GPUs than its
+	// gpu_nodes.expected_gpu_count before a gpu_missing alert; a GPU counts
+	// as reporting if it sent a sample within the window. Zero disables it.
+	MissingGPUWindow time.Duration
+
+	// SaturationWindow is how long every GPU of a node must hold at least
+	// SaturationUtilization percent utilization (by each GPU's latest
+	// sample) before a saturation alert of SaturationSeverity, a capacity
+	// signal rather than a fault; zero disables the check
+	SaturationWindow      time.Duration
+	SaturationUtilization float64
+	SaturationSeverity    string
+
+	// EscalationCount escalates a warning to critical once the same alert
+	// (node, gpu, type) has fired that many times within EscalationWindow;
+	// zero disables escalation
+	EscalationCount  int
+	EscalationWindow time.Duration
+	// DedupWindow reopens the alert resolved most recently for the same
+	// (node, gpu, type) when it re-fires within this long of resolving,
+	// instead of creating a new one; zero disables it
+	DedupWindow time.Duration
+
+	// DatacenterSeverity clamps the severity of alerts on a datacenter's
+	// nodes between a floor and a ceiling before they are stored and
+	// notified; escalation never raises an alert past the ceiling
+	DatacenterSeverity map[string]severityBounds
+	// MinPersistSeverity is the lowest severity written to the alerts
+	// table; alerts below it (after datacenter clamping) are only counted
+	MinPersistSeverity string
+
+	// BaselineRefreshInterval is how often per-GPU baselines are recomputed
+	// from the last BaselineWindow of stored metrics; zero disables baseline
+	// drift alerts. A sample drifts when it is more than BaselineDriftSigma
+	// standard deviations from its GPU's mean, once the baseline has at
+	// least BaselineMinSamples samples behind it.
+	BaselineRefreshInterval time.Duration
+	BaselineWindow          time.Duration
+	BaselineDriftSigma      float64
+	BaselineMinSamples      int
+
+	// ThresholdSuggestInterval is how often each fleet rule's least severe
+	// threshold is compared with the ThresholdSuggestPercentile of its
+	// metric per GPU model (the GPUModelLabel label) over
+	// ThresholdSuggestWindow, storing differing values in
+	// threshold_suggestions for approval; zero disables it. With
+	// ThresholdAutoApply suggestions are applied as model-scoped rules
+	// straight away.
+	ThresholdSuggestInterval   time.Duration
+	ThresholdSuggestWindow     time.Duration
+	ThresholdSuggestPercentile float64
+	ThresholdSuggestMinSamples int
+	ThresholdAutoApply         bool
+	GPUModelLabel              string
+
+	// ConsumerLagAlertThreshold raises a consumer_lag alert once this
+	// instance's consumer lag has stayed at or above it for
+	// ConsumerLagAlertFor, resolving it when lag drops back; zero disables
+	// it. The alert is filed under SelfNodeID, registered in gpu_nodes on
+	// first use since alerts must reference a node.
+	ConsumerLagAlertThreshold int
+	ConsumerLagAlertFor       time.Duration
+	SelfNodeID                string
+
+	// DeadmanAfter is how long the engine may go without successfully
+	// processing a message before /readyz reports the pipeline stalled;
+	// with DeadmanAlert a critical pipeline_stalled alert is also raised
+	// under SelfNodeID. HeartbeatInterval is how often the last processed
+	// time is written to pipeline_heartbeats; zero disables the row.
+	DeadmanAfter      time.Duration
+	DeadmanAlert      bool
+	HeartbeatInterval time.Duration
+
+	// IngestRateInterval is how often each node's samples over the last
+	// minute are written to node_ingest_rates for the API; zero disables
+	// it, leaving them on /metrics only
+	IngestRateInterval time.Duration
+
+	// ShutdownDrainTimeout bounds how long shutdown waits for the message
+	// being processed to be stored, evaluated and committed
+	ShutdownDrainTimeout time.Duration
+
+	// AutoResolveInterval is how often active alerts older than their
+	// rule's auto_resolve_after are resolved; zero disables the sweep
+	AutoResolveInterval time.Duration
+
+	// ResolutionScanInterval is how often alerts resolved since the last
+	// scan, by the engine or through the API, are observed into the
+	// alert_resolution_duration_seconds histogram; zero disables it
+	ResolutionScanInterval time.Duration
+
+	// AlertStormThreshold is how many alerts created within a minute make an
+	// alert storm, announced by one alert_storm notification; zero disables
+	// detection. AlertStormPauseNotifications also holds back per-alert
+	// notifications while the storm lasts.
+	AlertStormThreshold          int
+	AlertStormPauseNotifications bool
+
+	// StoreMode picks how metrics reach gpu_metrics. In sync mode each
+	// metric is written before its offset is committed, so a crash never
+	// loses a committed sample. In async mode metrics are queued (up to
+	// StoreQueueSize, blocking the consumer when full) and written in
+	// batches of StoreBatchSize or every StoreFlushInterval; offsets are
+	// committed once queued, so a crash or a failed batch loses whatever
+	// was buffered.
+	StoreMode          string
+	StoreQueueSize     int
+	StoreBatchSize     int
+	StoreFlushInterval time.Duration
+
+	// InternalAddr is the operator-only HTTP listen address (e.g. 127.0.0.1:6060);
+	// empty disables it. PprofEnabled serves /debug/pprof there.
+	InternalAddr string
+	PprofEnabled bool
+
+	// LogRedactFields names fields whose values are masked in log output
+	// (e.g. hostname, datacenter) before the logs leave the host
+	LogRedactFields []string
+}
+
+// LoadConfig reads configuration from environment variables, falling back to
+// local development defaults
+func LoadConfig() (Config, error) {
+	cfg := Config{
+		DBConnStr:     getEnv("DB_CONN_STR", "host=localhost port=5432 user=telemetry password=telemetry123 dbname=gpu_telemetry sslmode=disable"),
+		KafkaBrokers:  splitList(getEnv("KAFKA_BROKERS", "localhost:9093")),
+		KafkaTopic:    getEnv("KAFKA_TOPIC", "gpu-telemetry"),
+		ConsumerGroup: getEnv("KAFKA_CONSUMER_GROUP", "alert-engine"),
+		KafkaClientID: getEnv("KAFKA_CLIENT_ID", defaultClientID("alert-engine")),
+
+		MessageFormat:     getEnv("MESSAGE_FORMAT", formatJSON),
+		SchemaRegistryURL: getEnv("SCHEMA_REGISTRY_URL", ""),
+
+		Source:     getEnv("SOURCE", sourceKafka),
+		SourceFile: getEnv("SOURCE_FILE", ""),
+	}
+
+	var err error
+	if cfg.DBConnectAttempts, err = getEnvInt("DB_CONNECT_ATTEMPTS", 10); err != nil {
+		return cfg, err
+	}
+	if cfg.DBConnectAttempts < 1 {
+		return cfg, fmt.Errorf("DB_CONNECT_ATTEMPTS must be at least 1")
+	}
+	if cfg.DBConnectBackoff, err = getEnvDuration("DB_CONNECT_BACKOFF", time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.SessionTimeout, err = getEnvDuration("KAFKA_SESSION_TIMEOUT", 30*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.RebalanceTimeout, err = getEnvDuration("KAFKA_REBALANCE_TIMEOUT", 30*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.StartOffset, cfg.StartTime, err = parseStartOffset(getEnv("KAFKA_START_OFFSET", "latest")); err != nil {
+		return cfg, err
+	}
+	if cfg.GroupCheckInterval, err = getEnvDuration("KAFKA_GROUP_CHECK_INTERVAL", time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.GroupCheckInterval < 0 {
+		return cfg, fmt.Errorf("KAFKA_GROUP_CHECK_INTERVAL must not be negative")
+	}
+	if cfg.FetchMinBytes, err = getEnvInt("KAFKA_FETCH_MIN_BYTES", 64<<10); err != nil {
+		return cfg, err
+	}
+	if cfg.FetchMaxBytes, err = getEnvInt("KAFKA_FETCH_MAX_BYTES", 10<<20); err != nil {
+		return cfg, err
+	}
+	if cfg.FetchMinBytes <= 0 || cfg.FetchMaxBytes < cfg.FetchMinBytes {
+		return cfg, fmt.Errorf("KAFKA_FETCH_MIN_BYTES must be positive and at most KAFKA_FETCH_MAX_BYTES")
+	}
+	if cfg.FetchMaxWait, err = getEnvDuration("KAFKA_FETCH_MAX_WAIT", 250*time.Millisecond); err != nil {
+		return cfg, err
+	}
+	if cfg.FetchMaxWait <= 0 {
+		return cfg, fmt.Errorf("KAFKA_FETCH_MAX_WAIT must be positive")
+	}
+	if cfg.QueueCapacity, err = getEnvInt("KAFKA_QUEUE_CAPACITY", 1000); err != nil {
+		return cfg, err
+	}
+	if cfg.QueueCapacity <= 0 {
+		return cfg, fmt.Errorf("KAFKA_QUEUE_CAPACITY must be positive")
+	}
+	if cfg.DryRun, err = getEnvBool("DRY_RUN", false); err != nil {
+		return cfg, err
+	}
+	if cfg.BacktestSince, err = getEnvDuration("BACKTEST_SINCE", 0); err != nil {
+		return cfg, err
+	}
+	cfg.SlackWebhookURL = getEnv("SLACK_WEBHOOK_URL", "")
+	if cfg.AlertTopicEnabled, err = getEnvBool("ALERT_TOPIC_ENABLED", false); err != nil {
+		return cfg, err
+	}
+	cfg.AlertTopic = getEnv("ALERT_TOPIC", "gpu-alerts")
+	if cfg.AlertTopicEnabled && strings.TrimSpace(cfg.AlertTopic) == "" {
+		return cfg, fmt.Errorf("ALERT_TOPIC must not be empty when ALERT_TOPIC_ENABLED is set")
+	}
+	cfg.NotifyChannelsFile = getEnv("NOTIFY_CHANNELS", "")
+	cfg.RunbookBaseURL = getEnv("RUNBOOK_BASE_URL", "")
+	cfg.DrainWebhookURL = getEnv("DRAIN_WEBHOOK_URL", "")
+	cfg.DrainWebhookToken = getEnv("DRAIN_WEBHOOK_TOKEN", "")
+	if cfg.DrainTimeout, err = getEnvDuration("DRAIN_TIMEOUT", 10*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.DrainTimeout <= 0 {
+		return cfg, fmt.Errorf("DRAIN_TIMEOUT must be positive")
+	}
+	if cfg.ActionMaxAttempts, err = getEnvInt("ACTION_MAX_ATTEMPTS", 3); err != nil {
+		return cfg, err
+	}
+	if cfg.ActionMaxAttempts < 1 {
+		return cfg, fmt.Errorf("ACTION_MAX_ATTEMPTS must be at least 1")
+	}
+	if cfg.ActionRetryInterval, err = getEnvDuration("ACTION_RETRY_INTERVAL", 30*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.ActionRetryInterval <= 0 {
+		return cfg, fmt.Errorf("ACTION_RETRY_INTERVAL must be positive")
+	}
+	if cfg.ActionRetention, err = getEnvDuration("ACTION_RETENTION", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.NotifyConcurrency, err = getEnvInt("NOTIFY_CONCURRENCY", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.NotifyQueueSize, err = getEnvInt("NOTIFY_QUEUE_SIZE", 1000); err != nil {
+		return cfg, err
+	}
+	if cfg.NotifyConcurrency < 0 || cfg.NotifyQueueSize <= 0 {
+		return cfg, fmt.Errorf("NOTIFY_CONCURRENCY must not be negative and NOTIFY_QUEUE_SIZE must be positive")
+	}
+	if cfg.ActionRetention < 0 {
+		return cfg, fmt.Errorf("ACTION_RETENTION must not be negative")
+	}
+	if cfg.MetricsRetention, err = getEnvDuration("METRICS_RETENTION", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.MetricsRetentionInterval, err = getEnvDuration("METRICS_RETENTION_INTERVAL", time.Hour); err != nil {
+		return cfg, err
+	}
+	if cfg.MetricsRetention < 0 || cfg.MetricsRetentionInterval <= 0 {
+		return cfg, fmt.Errorf("METRICS_RETENTION must not be negative and METRICS_RETENTION_INTERVAL must be positive")
+	}
+	cfg.ArchiveBucket = getEnv("ARCHIVE_S3_BUCKET", "")
+	cfg.ArchivePrefix = getEnv("ARCHIVE_S3_PREFIX", "gpu_metrics/")
+	cfg.ArchiveRegion = getEnv("ARCHIVE_S3_REGION", "us-east-1")
+	cfg.ArchiveEndpoint = getEnv("ARCHIVE_S3_ENDPOINT", "https://s3."+cfg.ArchiveRegion+".amazonaws.com")
+	cfg.ArchiveAccessKeyID = getEnv("ARCHIVE_S3_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID"))
+	cfg.ArchiveSecretAccessKey = getEnv("ARCHIVE_S3_SECRET_ACCESS_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY"))
+	if cfg.ArchiveBucket != "" {
+		if cfg.MetricsRetention == 0 {
+			return cfg, fmt.Errorf("ARCHIVE_S3_BUCKET requires METRICS_RETENTION, the age at which metrics are archived")
+		}
+		if cfg.ArchiveAccessKeyID == "" || cfg.ArchiveSecretAccessKey == "" {
+			return cfg, fmt.Errorf("ARCHIVE_S3_BUCKET requires ARCHIVE_S3_ACCESS_KEY_ID and ARCHIVE_S3_SECRET_ACCESS_KEY")
+		}
+		if u, err := url.Parse(cfg.ArchiveEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return cfg, fmt.Errorf("ARCHIVE_S3_ENDPOINT must be an http or https URL")
+		}
+	}
+	if cfg.WarmupPeriod, err = getEnvDuration("WARMUP_PERIOD", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.WarmupRestartGap, err = getEnvDuration("WARMUP_RESTART_GAP", 5*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.WarmupPeriod < 0 || cfg.WarmupRestartGap <= 0 {
+		return cfg, fmt.Errorf("WARMUP_PERIOD must not be negative and WARMUP_RESTART_GAP must be positive")
+	}
+	if cfg.HistorySamples, err = getEnvInt("GPU_HISTORY_SAMPLES", 120); err != nil {
+		return cfg, err
+	}
+	if cfg.HistorySamples < 1 {
+		return cfg, fmt.Errorf("GPU_HISTORY_SAMPLES must be at least 1")
+	}
+	if cfg.HistoryEvictAfter, err = getEnvDuration("GPU_HISTORY_EVICT_AFTER", time.Hour); err != nil {
+		return cfg, err
+	}
+	if cfg.HistoryEvictAfter < 0 {
+		return cfg, fmt.Errorf("GPU_HISTORY_EVICT_AFTER must not be negative")
+	}
+	if cfg.StoreSampleEvery, err = getEnvInt("STORE_SAMPLE_EVERY", 1); err != nil {
+		return cfg, err
+	}
+	if cfg.StoreSampleEvery < 1 {
+		return cfg, fmt.Errorf("STORE_SAMPLE_EVERY must be at least 1")
+	}
+	if cfg.StorePrecision, err = parseStorePrecision(getEnv("STORE_PRECISION", "")); err != nil {
+		return cfg, err
+	}
+	if cfg.StuckSensorSamples, err = getEnvInt("STUCK_SENSOR_SAMPLES", 10); err != nil {
+		return cfg, err
+	}
+	if cfg.StuckSensorSamples < 0 || cfg.StuckSensorSamples == 1 {
+		return cfg, fmt.Errorf("STUCK_SENSOR_SAMPLES must be 0 or at least 2")
+	}
+	if cfg.IncompleteMetricGrace, err = getEnvInt("INCOMPLETE_METRIC_GRACE", 3); err != nil {
+		return cfg, err
+	}
+	if cfg.IncompleteMetricGrace < 0 {
+		return cfg, fmt.Errorf("INCOMPLETE_METRIC_GRACE must not be negative")
+	}
+	if cfg.SensorInconsistencySamples, err = getEnvInt("SENSOR_INCONSISTENCY_SAMPLES", 3); err != nil {
+		return cfg, err
+	}
+	if cfg.SensorInconsistencySamples < 0 {
+		return cfg, fmt.Errorf("SENSOR_INCONSISTENCY_SAMPLES must not be negative")
+	}
+	if cfg.LeakedMemoryWindow, err = getEnvDuration("LEAKED_MEMORY_WINDOW", 10*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.LeakedMemoryWindow < 0 {
+		return cfg, fmt.Errorf("LEAKED_MEMORY_WINDOW must not be negative")
+	}
+	if cfg.LeakedMemoryPercent, err = getEnvFloat("LEAKED_MEMORY_PERCENT", 50); err != nil {
+		return cfg, err
+	}
+	if cfg.LeakedMemoryUtilization, err = getEnvFloat("LEAKED_MEMORY_UTILIZATION", 2); err != nil {
+		return cfg, err
+	}
+	if cfg.PowerCapWindow, err = getEnvDuration("POWER_CAP_WINDOW", 5*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.PowerCapMarginWatts, err = getEnvFloat("POWER_CAP_MARGIN_WATTS", 5); err != nil {
+		return cfg, err
+	}
+	if cfg.PowerCapUtilization, err = getEnvFloat("POWER_CAP_UTILIZATION", 90); err != nil {
+		return cfg, err
+	}
+	if cfg.PowerCapWindow < 0 || cfg.PowerCapMarginWatts < 0 {
+		return cfg, fmt.Errorf("POWER_CAP_WINDOW and POWER_CAP_MARGIN_WATTS must not be negative")
+	}
+	if cfg.JobStopSamples, err = getEnvInt("JOB_STOP_SAMPLES", 3); err != nil {
+		return cfg, err
+	}
+	if cfg.JobStopUtilization, err = getEnvFloat("JOB_STOP_UTILIZATION", 5); err != nil {
+		return cfg, err
+	}
+	if cfg.JobStopBaselineUtilization, err = getEnvFloat("JOB_STOP_BASELINE_UTILIZATION", 90); err != nil {
+		return cfg, err
+	}
+	if cfg.JobStopBaselineWindow, err = getEnvDuration("JOB_STOP_BASELINE_WINDOW", 10*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.JobStopSamples < 0 || cfg.JobStopBaselineWindow <= 0 {
+		return cfg, fmt.Errorf("JOB_STOP_SAMPLES must not be negative and JOB_STOP_BASELINE_WINDOW must be positive")
+	}
+	if cfg.JobStopUtilization >= cfg.JobStopBaselineUtilization {
+		return cfg, fmt.Errorf("JOB_STOP_UTILIZATION must be below JOB_STOP_BASELINE_UTILIZATION")
+	}
+	if cfg.NodePowerBudgetWatts, err = getEnvFloat("NODE_POWER_BUDGET_WATTS", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.NodePowerWindow, err = getEnvDuration("NODE_POWER_WINDOW", 5*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.NodePowerBudgetWatts < 0 || cfg.NodePowerWindow <= 0 {
+		return cfg, fmt.Errorf("NODE_POWER_BUDGET_WATTS must not be negative and NODE_POWER_WINDOW must be positive")
+	}
+	if cfg.MissingGPUWindow, err = getEnvDuration("MISSING_GPU_WINDOW", 5*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.MissingGPUWindow < 0 {
+		return cfg, fmt.Errorf("MISSING_GPU_WINDOW must not be negative")
+	}
+	if cfg.SaturationWindow, err = getEnvDuration("SATURATION_WINDOW", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.SaturationUtilization, err = getEnvFloat("SATURATION_UTILIZATION", 98); err != nil {
+		return cfg, err
+	}
+	if cfg.SaturationWindow < 0 || cfg.SaturationUtilization <= 0 || cfg.SaturationUtilization > 100 {
+		return cfg, fmt.Errorf("SATURATION_WINDOW must not be negative and SATURATION_UTILIZATION must be in (0, 100]")
+	}
+	cfg.SaturationSeverity = getEnv("SATURATION_SEVERITY", "info")
+	if cfg.SaturationSeverity != "info" && cfg.SaturationSeverity != "warning" {
+		return cfg, fmt.Errorf("SATURATION_SEVERITY must be info or warning")
+	}
+	if cfg.EscalationCount, err = getEnvInt("ESCALATION_COUNT", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.EscalationWindow, err = getEnvDuration("ESCALATION_WINDOW", time.Hour); err != nil {
+		return cfg, err
+	}
+	if cfg.EscalationCount < 0 || cfg.EscalationWindow <= 0 {
+		return cfg, fmt.Errorf("ESCALATION_COUNT must not be negative and ESCALATION_WINDOW must be positive")
+	}
+	if cfg.DedupWindow, err = getEnvDuration("ALERT_DEDUP_WINDOW", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.DedupWindow < 0 {
+		return cfg, fmt.Errorf("ALERT_DEDUP_WINDOW must not be negative")
+	}
+	if cfg.DatacenterSeverity, err = parseDatacenterSeverity(getEnv("DATACENTER_SEVERITY", "")); err != nil {
+		return cfg, err
+	}
+	cfg.MinPersistSeverity = getEnv("ALERT_MIN_PERSIST_SEVERITY", "info")
+	if !validSeverities[cfg.MinPersistSeverity] {
+		return cfg, fmt.Errorf("ALERT_MIN_PERSIST_SEVERITY must be info, warning or critical")
+	}
+	if cfg.BaselineRefreshInterval, err = getEnvDuration("BASELINE_REFRESH_INTERVAL", time.Hour); err != nil {
+		return cfg, err
+	}
+	if cfg.BaselineWindow, err = getEnvDuration("BASELINE_WINDOW", 7*24*time.Hour); err != nil {
+		return cfg, err
+	}
+	if cfg.BaselineDriftSigma, err = getEnvFloat("BASELINE_DRIFT_SIGMA", 4); err != nil {
+		return cfg, err
+	}
+	if cfg.BaselineMinSamples, err = getEnvInt("BASELINE_MIN_SAMPLES", 100); err != nil {
+		return cfg, err
+	}
+	if cfg.BaselineRefreshInterval < 0 || cfg.BaselineWindow <= 0 || cfg.BaselineDriftSigma <= 0 || cfg.BaselineMinSamples < 2 {
+		return cfg, fmt.Errorf("BASELINE_REFRESH_INTERVAL must not be negative, BASELINE_WINDOW and BASELINE_DRIFT_SIGMA must be positive, and BASELINE_MIN_SAMPLES must be at least 2")
+	}
+	if cfg.ThresholdSuggestInterval, err = getEnvDuration("THRESHOLD_SUGGEST_INTERVAL", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.ThresholdSuggestWindow, err = getEnvDuration("THRESHOLD_SUGGEST_WINDOW", 7*24*time.Hour); err != nil {
+		return cfg, err
+	}
+	if cfg.ThresholdSuggestPercentile, err = getEnvFloat("THRESHOLD_SUGGEST_PERCENTILE", 0.99); err != nil {
+		return cfg, err
+	}
+	if cfg.ThresholdSuggestMinSamples, err = getEnvInt("THRESHOLD_SUGGEST_MIN_SAMPLES", 1000); err != nil {
+		return cfg, err
+	}
+	if cfg.ThresholdSuggestInterval < 0 || cfg.ThresholdSuggestWindow <= 0 || cfg.ThresholdSuggestMinSamples < 1 {
+		return cfg, fmt.Errorf("THRESHOLD_SUGGEST_INTERVAL must not be negative, THRESHOLD_SUGGEST_WINDOW must be positive and THRESHOLD_SUGGEST_MIN_SAMPLES at least 1")
+	}
+	if cfg.ThresholdSuggestPercentile <= 0.5 || cfg.ThresholdSuggestPercentile >= 1 {
+		return cfg, fmt.Errorf("THRESHOLD_SUGGEST_PERCENTILE must be between 0.5 and 1, exclusive")
+	}
+	if cfg.ThresholdAutoApply, err = getEnvBool("THRESHOLD_AUTO_APPLY", false); err != nil {
+		return cfg, err
+	}
+	cfg.GPUModelLabel = getEnv("GPU_MODEL_LABEL", "gpu_model")
+	if cfg.GPUModelLabel == "" {
+		return cfg, fmt.Errorf("GPU_MODEL_LABEL must not be empty")
+	}
+	if cfg.AutoResolveInterval, err = getEnvDuration("AUTO_RESOLVE_INTERVAL", time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.AutoResolveInterval < 0 {
+		return cfg, fmt.Errorf("AUTO_RESOLVE_INTERVAL must not be negative")
+	}
+	if cfg.ResolutionScanInterval, err = getEnvDuration("RESOLUTION_SCAN_INTERVAL", 30*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.ResolutionScanInterval < 0 {
+		return cfg, fmt.Errorf("RESOLUTION_SCAN_INTERVAL must not be negative")
+	}
+	if cfg.AlertStormThreshold, err = getEnvInt("ALERT_STORM_THRESHOLD", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.AlertStormThreshold < 0 {
+		return cfg, fmt.Errorf("ALERT_STORM_THRESHOLD must not be negative")
+	}
+	if cfg.AlertStormPauseNotifications, err = getEnvBool("ALERT_STORM_PAUSE_NOTIFICATIONS", false); err != nil {
+		return cfg, err
+	}
+	cfg.StoreMode = getEnv("STORE_MODE", storeModeSync)
+	switch cfg.StoreMode {
+	case storeModeSync, storeModeAsync:
+	default:
+		return cfg, fmt.Errorf("STORE_MODE must be one of sync, async; got %q", cfg.StoreMode)
+	}
+	if cfg.StoreQueueSize, err = getEnvInt("STORE_QUEUE_SIZE", 10000); err != nil {
+		return cfg, err
+	}
+	if cfg.StoreBatchSize, err = getEnvInt("STORE_BATCH_SIZE", 500); err != nil {
+		return cfg, err
+	}
+	if cfg.StoreQueueSize <= 0 || cfg.StoreBatchSize <= 0 {
+		return cfg, fmt.Errorf("STORE_QUEUE_SIZE and STORE_BATCH_SIZE must be positive")
+	}
+	if cfg.StoreFlushInterval, err = getEnvDuration("STORE_FLUSH_INTERVAL", time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.StoreFlushInterval <= 0 {
+		return cfg, fmt.Errorf("STORE_FLUSH_INTERVAL must be positive")
+	}
+	if cfg.ConsumerLagAlertThreshold, err = getEnvInt("CONSUMER_LAG_ALERT_THRESHOLD", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.ConsumerLagAlertThreshold < 0 {
+		return cfg, fmt.Errorf("CONSUMER_LAG_ALERT_THRESHOLD must not be negative")
+	}
+	if cfg.ConsumerLagAlertFor, err = getEnvDuration("CONSUMER_LAG_ALERT_FOR", 5*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.ConsumerLagAlertFor < 0 {
+		return cfg, fmt.Errorf("CONSUMER_LAG_ALERT_FOR must not be negative")
+	}
+	cfg.SelfNodeID = getEnv("SELF_NODE_ID", "alert-engine")
+	if cfg.SelfNodeID == "" {
+		return cfg, fmt.Errorf("SELF_NODE_ID must not be empty")
+	}
+	if cfg.DeadmanAfter, err = getEnvDuration("PIPELINE_DEADMAN_AFTER", 5*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.DeadmanAfter <= 0 {
+		return cfg, fmt.Errorf("PIPELINE_DEADMAN_AFTER must be positive")
+	}
+	if cfg.DeadmanAlert, err = getEnvBool("PIPELINE_DEADMAN_ALERT", false); err != nil {
+		return cfg, err
+	}
+	if cfg.HeartbeatInterval, err = getEnvDuration("PIPELINE_HEARTBEAT_INTERVAL", 30*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.HeartbeatInterval < 0 {
+		return cfg, fmt.Errorf("PIPELINE_HEARTBEAT_INTERVAL must not be negative")
+	}
+	if cfg.IngestRateInterval, err = getEnvDuration("INGEST_RATE_INTERVAL", 30*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.IngestRateInterval < 0 {
+		return cfg, fmt.Errorf("INGEST_RATE_INTERVAL must not be negative")
+	}
+	cfg.LogRedactFields = splitList(getEnv("LOG_REDACT_FIELDS", ""))
+	if cfg.ShutdownDrainTimeout, err = getEnvDuration("SHUTDOWN_DRAIN_TIMEOUT", 10*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.ShutdownDrainTimeout <= 0 {
+		return cfg, fmt.Errorf("SHUTDOWN_DRAIN_TIMEOUT must be positive")
+	}
+	if cfg.DBStatementTimeout, err = getEnvDuration("DB_STATEMENT_TIMEOUT", 5*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.DBStatementTimeout <= 0 {
+		return cfg, fmt.Errorf("DB_STATEMENT_TIMEOUT must be positive")
+	}
+	if cfg.RulesReloadInterval, err = getEnvDuration("RULES_RELOAD_INTERVAL", 30*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.RulesReloadInterval < 0 {
+		return cfg, fmt.Errorf("RULES_RELOAD_INTERVAL must not be negative")
+	}
+	cfg.CollectedAtSource = getEnv("COLLECTED_AT_SOURCE", timeSourceCollector)
+	switch cfg.CollectedAtSource {
+	case timeSourceCollector, timeSourceKafka, timeSourceServer:
+	default:
+		return cfg, fmt.Errorf("COLLECTED_AT_SOURCE must be one of collector, kafka, server; got %q", cfg.CollectedAtSource)
+	}
+	if cfg.MaxClockSkew, err = getEnvDuration("MAX_CLOCK_SKEW", 5*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxClockSkew < 0 {
+		return cfg, fmt.Errorf("MAX_CLOCK_SKEW must not be negative")
+	}
+	if cfg.ClockSkewSamples, err = getEnvInt("CLOCK_SKEW_SAMPLES", 10); err != nil {
+		return cfg, err
+	}
+	if cfg.ClockSkewSamples < 0 {
+		return cfg, fmt.Errorf("CLOCK_SKEW_SAMPLES must not be negative")
+	}
+	cfg.InternalAddr = getEnv("INTERNAL_ADDR", "")
+	if cfg.PprofEnabled, err = getEnvBool("PPROF_ENABLED", false); err != nil {
+		return cfg, err
+	}
+	if cfg.PprofEnabled && cfg.InternalAddr == "" {
+		return cfg, fmt.Errorf("PPROF_ENABLED requires INTERNAL_ADDR")
+	}
+
+	if len(cfg.KafkaBrokers) == 0 {
+		return cfg, fmt.Errorf("KAFKA_BROKERS must list at least one broker")
+	}
+	if strings.TrimSpace(cfg.KafkaTopic) == "" {
+		return cfg, fmt.Errorf("KAFKA_TOPIC must not be empty")
+	}
+	if strings.TrimSpace(cfg.ConsumerGroup) == "" {
+		return cfg, fmt.Errorf("KAFKA_CONSUMER_GROUP must not be empty")
+	}
+	switch cfg.MessageFormat {
+	case formatJSON, formatProtobuf:
+	case formatAvro:
+		if cfg.SchemaRegistryURL == "" {
+			return cfg, fmt.Errorf("SCHEMA_REGISTRY_URL is required when MESSAGE_FORMAT=avro")
+		}
+	default:
+		return cfg, fmt.Errorf("MESSAGE_FORMAT must be one of json, avro, protobuf; got %q", cfg.MessageFormat)
+	}
+	switch cfg.Source {
+	case sourceKafka:
+	case sourceFile:
+		if cfg.SourceFile == "" {
+			return cfg, fmt.Errorf("SOURCE_FILE is required when SOURCE=file")
+		}
+		if !cfg.StartTime.IsZero() {
+			return cfg, fmt.Errorf("KAFKA_START_OFFSET must be earliest or latest when SOURCE=file")
+		}
+	default:
+		return cfg, fmt.Errorf("SOURCE must be one of kafka, file; got %q", cfg.Source)
+	}
+	return cfg, nil
+}
+
+// getEnv returns the value of an environment variable or a default.
+// A variable that is set but empty is returned as-is so validation can reject it.
+func getEnv(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
+
+// getEnvBool parses a boolean environment variable
+func getEnvBool(key string, fallback bool) (bool, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fallback, fmt.Errorf("%s: invalid boolean %q", key, v)
+	}
+	return b, nil
+}
+
+// getEnvFloat parses a floating-point environment variable
+func getEnvFloat(key string, fallback float64) (float64, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return fallback, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fallback, fmt.Errorf("%s: invalid number %q", key, v)
+	}
+	return f, nil
+}
+
+// getEnvInt parses an integer environment variable
+func getEnvInt(key string, fallback int) (int, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fallback, fmt.Errorf("%s: invalid integer %q", key, v)
+	}
+	return n, nil
+}
+
+// getEnvDuration parses a Go duration environment variable (e.g. "30s", "24h")
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fallback, fmt.Errorf("%s: invalid duration %q", key, v)
+	}
+	return d, nil
+}
+
+// parseStartOffset parses KAFKA_START_OFFSET: latest, earliest, or an
+// RFC3339 timestamp. It returns the offset a new consumer group starts from
+// and, for a timestamp, the time seekGroupToTime moves the group to.
+func parseStartOffset(v string) (int64, time.Time, error) {
+	switch v {
+	case "latest":
+		return kafka.LastOffset, time.Time{}, nil
+	case "earliest":
+		return kafka.FirstOffset, time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("KAFKA_START_OFFSET must be latest, earliest or an RFC3339 timestamp; got %q", v)
+	}
+	return kafka.LastOffset, t, nil
+}
+
+// defaultClientID names a Kafka client after the service and host, so each
+// instance is distinct on the brokers
+func defaultClientID(service string) string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return service
+	}
+	return service + "-" + hostname
+}
+
+// kafkaErrorLogger routes kafka-go's internal errors (failed fetches,
+// rebalances, commit retries) to the service log
+func kafkaErrorLogger(component string) kafka.Logger {
+	return kafka.LoggerFunc(func(msg string, args ...interface{}) {
+		log.Printf("kafka %s: %s", component, fmt.Sprintf(msg, args...))
+	})
+}
+
+// splitList parses a comma-separated list, dropping empty entries
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// redactedValue replaces masked values in log output
+const redactedValue = "[REDACTED]"
+
+// logRedactor is the log output when LOG_REDACT_FIELDS is set. It masks the
+// value after each configured field name (field=value, field: value or
+// "field":"value"), and every known value set by refreshRedactions: for
+// hostname the hosts the engine connects to, which Kafka, database,
+// notification and archive errors quote verbatim, and for hostname and
+// datacenter the values in gpu_nodes.
+type logRedactor struct {
+	out    io.Writer
+	fields []string
+	keyed  *regexp.Regexp
+
+	mu     sync.RWMutex
+	values []string
+}
+
+func newLogRedactor(out io.Writer, fields []string) *logRedactor {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = regexp.QuoteMeta(f)
+	}
+	return &logRedactor{
+		out:    out,
+		fields: fields,
+		keyed:  regexp.MustCompile(`((?:\b|")(?:` + strings.Join(names, "|") + `)"?\s*[=:]\s*)(?:(")[^"]*"|[^\s,;}]+)`),
+	}
+}
+
+// redacts reports whether field is one of the masked fields
+func (l *logRedactor) redacts(field string) bool {
+	for _, f := range l.fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// setValues replaces the known values masked wherever they appear
+func (l *logRedactor) setValues(values []string) {
+	// Longest first so a value containing another is masked whole
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	l.mu.Lock()
+	l.values = values
+	l.mu.Unlock()
+}
+
+// Write masks one log line and passes it on
+func (l *logRedactor) Write(p []byte) (int, error) {
+	line := l.keyed.ReplaceAllString(string(p), "${1}${2}"+redactedValue+"${2}")
+	l.mu.RLock()
+	for _, v := range l.values {
+		line = strings.ReplaceAll(line, v, redactedValue)
+	}
+	l.mu.RUnlock()
+	if _, err := io.WriteString(l.out, line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactColumns are the gpu_nodes columns whose values are masked in logs
+// when LOG_REDACT_FIELDS names them
+var redactColumns = []string{"hostname", "datacenter"}
+
+// redactRefreshInterval is how often the masked gpu_nodes values are re-read
+const redactRefreshInterval = 5 * time.Minute
+
+// dbHost returns the host in a Postgres connection string, URL or
+// key=value form
+func dbHost(connStr string) string {
+	if u, err := url.Parse(connStr); err == nil && u.Scheme != "" {
+		return u.Hostname()
+	}
+	for _, kv := range strings.Fields(connStr) {
+		if host, ok := strings.CutPrefix(kv, "host="); ok {
+			return strings.Trim(host, "'")
+		}
+	}
+	return ""
+}
+
+// knownHosts returns the hosts the engine connects to: the database, the
+// Kafka brokers and every notification, drain and archive URL
+func (ae *AlertEngine) knownHosts() []string {
+	hosts := []string{dbHost(ae.cfg.DBConnStr)}
+	for _, broker := range ae.cfg.KafkaBrokers {
+		host, _, err := net.SplitHostPort(broker)
+		if err != nil {
+			host = broker
+		}
+		hosts = append(hosts, host)
+	}
+	urls := []string{ae.cfg.SlackWebhookURL, ae.cfg.DrainWebhookURL}
+	if ae.archive != nil {
+		urls = append(urls, ae.cfg.ArchiveEndpoint)
+	}
+	for _, ch := range ae.channels {
+		urls = append(urls, ch.URL)
+	}
+	for _, raw := range urls {
+		if u, err := url.Parse(raw); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	return hosts
+}
+
+// refreshRedactions sets the redactor's known values: knownHosts for
+// hostname, and the gpu_nodes values of each redacted column
+func (ae *AlertEngine) refreshRedactions(ctx context.Context) error {
+	var values []string
+	if ae.redactor.redacts("hostname") {
+		for _, h := range ae.knownHosts() {
+			if h != "" {
+				values = append(values, h)
+			}
+		}
+	}
+	for _, col := range redactColumns {
+		if !ae.redactor.redacts(col) {
+			continue
+		}
+		stmtCtx, cancel := ae.statementContext(ctx)
+		// col comes from redactColumns, never from input
+		rows, err := ae.db.QueryContext(stmtCtx, fmt.Sprintf(
+			"SELECT DISTINCT %[1]s FROM gpu_nodes WHERE %[1]s <> ''", col))
+		if err != nil {
+			cancel()
+			return dbError(stmtCtx, err)
+		}
+		for rows.Next() {
+			var v string
+			if err := rows.Scan(&v); err != nil {
+				rows.Close()
+				cancel()
+				return err
+			}
+			values = append(values, v)
+		}
+		err = rows.Err()
+		rows.Close()
+		cancel()
+		if err != nil {
+			return dbError(stmtCtx, err)
+		}
+	}
+	ae.redactor.setValues(values)
+	return nil
+}
+
+// watchRedactions re-reads the masked gpu_nodes values every
+// redactRefreshInterval, so newly registered nodes are masked too
+func (ae *AlertEngine) watchRedactions(ctx context.Context) {
+	ticker := time.NewTicker(redactRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := ae.refreshRedactions(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to refresh redacted log values: %v", err)
+		}
+	}
+}
+
+// maxDBBackoff caps the delay between database connection attempts
+const maxDBBackoff = 30 * time.Second
+
+// connectDB opens the database and pings it with bounded exponential backoff,
+// so a service started before Postgres is ready waits instead of crash-looping.
+// Once connected, database/sql's pool transparently replaces dropped connections.
+func connectDB(connStr string, attempts int, backoff time.Duration) (*sql.DB, error) {
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	db.SetConnMaxLifetime(30 * time.Minute)
+
+	for attempt := 1; ; attempt++ {
+		err = db.Ping()
+		if err == nil {
+			return db, nil
+		}
+		if attempt >= attempts {
+			db.Close()
+			return nil, fmt.Errorf("failed to ping database after %d attempts: %w", attempts, err)
+		}
+
+		log.Printf("Database not ready (attempt %d/%d): %v; retrying in %s", attempt, attempts, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxDBBackoff {
+			backoff = maxDBBackoff
+		}
+	}
+}
+
+type AlertEngine struct {
+	db *sql.DB
+	// source feeds the consume loop; it is kafkaReader unless Source is
+	// file, when kafkaReader is nil
+	source      messageSource
+	kafkaReader *kafka.Reader
+	cfg         Config
+	channels    []NotifyChannel
+	// redactor masks LOG_REDACT_FIELDS in log output; nil when unset
+	redactor *logRedactor
+	// drainer cordons nodes on critical alerts
+	drainer Drainer
+	// alertWriter publishes created alerts when AlertTopicEnabled
+	alertWriter *kafka.Writer
+	// transport carries KafkaClientID for the alert writer and admin clients
+	transport *kafka.Transport
+	// nodeLastSeen and nodeWarmupStart track warmup windows per node; only
+	// the consume loop touches them
+	nodeLastSeen    map[string]time.Time
+	nodeWarmupStart map[string]time.Time
+	// gpus holds the last sample seen from each GPU; only the consume loop
+	// (or a backtest replay) touches it
+	gpus map[gpuKey]*gpuHistory
+	// nodes holds per-node state built from gpus, with the same ownership
+	nodes map[string]*nodeHistory
+	// lastEviction is the sample time gpus was last swept for silent GPUs
+	lastEviction time.Time
+	// rulesMu guards rules, which are swapped wholesale on reload
+	rulesMu sync.RWMutex
+	rules   []AlertRule
+	// baselinesMu guards baselines, swapped wholesale on each refresh
+	baselinesMu sync.RWMutex
+	baselines   map[gpuKey]map[string]baseline
+	// avroSchemas caches writer schemas by registry ID
+	avroSchemas map[int][]avroField
+	// alertingMu guards alerting, the GPUs (and, under nodeWideGPU, nodes)
+	// with an active alert, which StoreSampleEvery stores in full
+	alertingMu sync.RWMutex
+	alerting   map[gpuKey]bool
+	// expectedMu guards expectedGPUs, each node's expected_gpu_count,
+	// swapped wholesale on each refresh
+	expectedMu   sync.RWMutex
+	expectedGPUs map[string]int
+
+	// errorStreak counts consecutive failed iterations of the consume loop;
+	// errorsTotal counts all of them. Both are exported on /metrics.
+	errorStreak atomic.Int64
+	errorsTotal atomic.Int64
+	// decodeErrors counts messages skipped because they could not be decoded
+	decodeErrors atomic.Int64
+	// incompleteMetrics counts samples stored as partial (no memory total)
+	incompleteMetrics atomic.Int64
+	// topicPartitions and groupMembers are the last values seen by
+	// checkGroupSize, exported on /metrics
+	topicPartitions atomic.Int64
+	groupMembers    atomic.Int64
+
+	// alertRate counts alerts created over the last minute; inStorm is set
+	// while that rate is at or above AlertStormThreshold, and stormStarted
+	// holds the Unix millisecond the current or last storm began
+	alertRate    rateWindow
+	inStorm      atomic.Bool
+	stormStarted atomic.Int64
+
+	// storeQueue feeds the async metric writer; nil in sync store mode.
+	// storeDone is closed once the writer has flushed its last batch.
+	storeQueue chan GPUMetric
+	storeDone  chan struct{}
+	// storeDropped counts buffered metrics lost to failed batch writes
+	storeDropped atomic.Int64
+
+	// notifyQueue feeds the notification workers; nil when NotifyConcurrency
+	// is zero. notifyDeferred counts notifications left to the retry worker
+	// because the queue was full; notifyWG tracks the workers.
+	notifyQueue    chan notifyJob
+	notifyDeferred atomic.Int64
+	notifyWG       sync.WaitGroup
+	// sampledOut counts metrics not stored because of StoreSampleEvery
+	sampledOut atomic.Int64
+	// notPersisted counts alerts below MinPersistSeverity, by severityRank
+	notPersisted [3]atomic.Int64
+	// startedAt and lastProcessed (unix nanoseconds, zero until the first
+	// message) time the dead-man switch
+	startedAt     time.Time
+	lastProcessed atomic.Int64
+	// ingest counts the samples received from each node
+	ingest ingestRates
+	// archive receives expired metrics before deletion; nil deletes them outright
+	archive         *s3Store
+	archivedMetrics atomic.Int64
+
+	// resolutions holds how long resolved alerts were active, by severity
+	resolutions resolutionHistogram
+
+	// fetchStats accumulates the reader's fetch statistics, which kafka-go
+	// resets on every Stats call
+	fetchStats fetchStats
+
+	// lagMu guards partitionLag, the messages behind the high-water mark
+	// on each partition as of its last fetched message
+	lagMu        sync.Mutex
+	partitionLag map[int]int64
+}
+
+// recordLag notes how far msg's partition is behind its high-water mark
+func (ae *AlertEngine) recordLag(msg kafka.Message) {
+	ae.lagMu.Lock()
+	defer ae.lagMu.Unlock()
+	if ae.partitionLag == nil {
+		ae.partitionLag = make(map[int]int64)
+	}
+	ae.partitionLag[msg.Partition] = msg.HighWaterMark - msg.Offset - 1
+}
+
+// consumerLag returns the total lag across the partitions this instance
+// has consumed from
+func (ae *AlertEngine) consumerLag() int64 {
+	ae.lagMu.Lock()
+	defer ae.lagMu.Unlock()
+	var total int64
+	for _, lag := range ae.partitionLag {
+		total += lag
+	}
+	return total
+}
+
+// Metric sources
+const (
+	sourceKafka = "kafka"
+	sourceFile  = "file"
+)
+
+// messageSource is what the consume loop fetches from and commits to
+type messageSource interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// filePollInterval is how often fileSource checks for new lines at the end
+// of the file
+const filePollInterval = 500 * time.Millisecond
+
+// fileSource tails a collector's NDJSON sink file, one metric per line. It
+// follows the file across the collector's rotation (a rename to <path>.1)
+// and truncation, and waits for it to appear if it doesn't exist yet.
+type fileSource struct {
+	path string
+	// fromStart reads the next file opened from its start rather than its
+	// end; only the first open honours KAFKA_START_OFFSET=latest
+	fromStart bool
+
+	f       *os.File
+	r       *bufio.Reader
+	offset  int64  // bytes of f consumed so far
+	partial []byte // the start of a line still being written
+}
+
+// FetchMessage blocks until the next complete line is available
+func (s *fileSource) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for {
+		if s.f == nil {
+			if err := s.open(); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return kafka.Message{}, err
+			}
+		}
+		if s.f != nil {
+			msg, ok, err := s.readLine()
+			if err != nil || ok {
+				return msg, err
+			}
+			if s.replaced() {
+				// Drain anything written before the rotation first
+				if msg, ok, err := s.readLine(); err != nil || ok {
+					return msg, err
+				}
+				s.f.Close()
+				s.f = nil
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		case <-time.After(filePollInterval):
+		}
+	}
+}
+
+// open opens path, positioned at its end unless fromStart is set
+func (s *fileSource) open() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		// A file created later is read from its start
+		s.fromStart = true
+		return err
+	}
+	s.offset = 0
+	if !s.fromStart {
+		if s.offset, err = f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	s.f = f
+	s.r = bufio.NewReader(f)
+	s.partial = nil
+	s.fromStart = true
+	return nil
+}
+
+// readLine returns the next complete, non-empty line as a JSON message, or
+// ok=false at the end of the file
+func (s *fileSource) readLine() (kafka.Message, bool, error) {
+	for {
+		line, err := s.r.ReadBytes('\n')
+		s.offset += int64(len(line))
+		if errors.Is(err, io.EOF) {
+			s.partial = append(s.partial, line...)
+			return kafka.Message{}, false, nil
+		}
+		if err != nil {
+			return kafka.Message{}, false, err
+		}
+		if len(s.partial) > 0 {
+			line = append(s.partial, line...)
+			s.partial = nil
+		}
+		start := s.offset - int64(len(line))
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			continue
+		}
+		return kafka.Message{
+			Topic:   s.path,
+			Offset:  start,
+			Value:   line,
+			Time:    time.Now(),
+			Headers: []kafka.Header{{Key: contentTypeHeader, Value: []byte("application/json")}},
+		}, true, nil
+	}
+}
+
+// replaced reports whether path now names a different file, or the same
+// file truncated below what has been read
+func (s *fileSource) replaced() bool {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		// Mid-rotation: keep the old file until the new one exists
+		return false
+	}
+	cur, err := s.f.Stat()
+	if err != nil {
+		return true
+	}
+	return !os.SameFile(info, cur) || info.Size() < s.offset
+}
+
+// CommitMessages is a no-op: the file has no committed position
+func (s *fileSource) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	return nil
+}
+
+// Close closes the file being tailed
+func (s *fileSource) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	return s.f.Close()
+}
+
+// rateWindow counts events over a rolling minute in one-second buckets
+type rateWindow struct {
+	mu     sync.Mutex
+	counts [60]int64
+	// secs holds the Unix second each bucket was last written in
+	secs [60]int64
+}
+
+// Add records an event at now and returns the count over the last minute
+func (rw *rateWindow) Add(now time.Time) int64 {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	sec := now.Unix()
+	i := sec % 60
+	if rw.secs[i] != sec {
+		rw.secs[i], rw.counts[i] = sec, 0
+	}
+	rw.counts[i]++
+	return rw.sumLocked(sec)
+}
+
+// Count returns the number of events over the minute before now
+func (rw *rateWindow) Count(now time.Time) int64 {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.sumLocked(now.Unix())
+}
+
+func (rw *rateWindow) sumLocked(sec int64) int64 {
+	var total int64
+	for i, c := range rw.counts {
+		if sec-rw.secs[i] < 60 {
+			total += c
+		}
+	}
+	return total
+}
+
+// ingestIdleDrop is how long a node sends nothing before ingestRates
+// forgets it; until then it is reported at a rate of zero
+const ingestIdleDrop = time.Hour
+
+// ingestRates counts the samples received from each node over a rolling
+// minute, and the GPUs they came from
+type ingestRates struct {
+	mu    sync.Mutex
+	nodes map[string]*nodeIngest
+}
+
+type nodeIngest struct {
+	window rateWindow
+	// gpus holds the Unix second each GPU index last reported
+	gpus map[int]int64
+}
+
+// nodeIngestRate is a node's samples and reporting GPUs over the last minute
+type nodeIngestRate struct {
+	NodeID  string
+	Samples int64
+	GPUs    int
+}
+
+// Add records a sample from the node's GPU at now
+func (ir *ingestRates) Add(nodeID string, gpuIndex int, now time.Time) {
+	ir.mu.Lock()
+	n, ok := ir.nodes[nodeID]
+	if !ok {
+		n = &nodeIngest{gpus: make(map[int]int64)}
+		ir.nodes[nodeID] = n
+	}
+	n.gpus[gpuIndex] = now.Unix()
+	ir.mu.Unlock()
+	n.window.Add(now)
+}
+
+// Rates returns every node's rate at now, sorted by node, dropping nodes
+// idle for longer than ingestIdleDrop
+func (ir *ingestRates) Rates(now time.Time) []nodeIngestRate {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+
+	sec := now.Unix()
+	rates := make([]nodeIngestRate, 0, len(ir.nodes))
+	for nodeID, n := range ir.nodes {
+		rate := nodeIngestRate{NodeID: nodeID, Samples: n.window.Count(now)}
+		for gpu, seen := range n.gpus {
+			if sec-seen < 60 {
+				rate.GPUs++
+			} else if sec-seen >= int64(ingestIdleDrop/time.Second) {
+				delete(n.gpus, gpu)
+			}
+		}
+		if len(n.gpus) == 0 {
+			delete(ir.nodes, nodeID)
+			continue
+		}
+		rates = append(rates, rate)
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].NodeID < rates[j].NodeID })
+	return rates
+}
+
+// seekGroupToTime commits, for a consumer group with no committed offsets,
+// each partition's first offset at or after cfg.StartTime, so the reader
+// starts there. Partitions with nothing that recent fall back to StartOffset.
+// Groups that already have offsets are left alone.
+func seekGroupToTime(ctx context.Context, cfg Config, transport *kafka.Transport) error {
+	client := &kafka.Client{Addr: kafka.TCP(cfg.KafkaBrokers...), Timeout: 10 * time.Second, Transport: transport}
+
+	meta, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{cfg.KafkaTopic}})
+	if err != nil {
+		return fmt.Errorf("failed to read topic metadata: %w", err)
+	}
+	if len(meta.Topics) != 1 || meta.Topics[0].Error != nil {
+		return fmt.Errorf("topic %q not found", cfg.KafkaTopic)
+	}
+	var partitions []int
+	for _, p := range meta.Topics[0].Partitions {
+		partitions = append(partitions, p.ID)
+	}
+
+	committed, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: cfg.ConsumerGroup,
+		Topics:  map[string][]int{cfg.KafkaTopic: partitions},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch committed offsets: %w", err)
+	}
+	if committed.Error != nil {
+		return fmt.Errorf("failed to fetch committed offsets: %w", committed.Error)
+	}
+	for _, p := range committed.Topics[cfg.KafkaTopic] {
+		if p.CommittedOffset >= 0 {
+			log.Printf("Consumer group %q already has committed offsets, ignoring KAFKA_START_OFFSET", cfg.ConsumerGroup)
+			return nil
+		}
+	}
+
+	requests := make([]kafka.OffsetRequest, len(partitions))
+	for i, p := range partitions {
+		requests[i] = kafka.TimeOffsetOf(p, cfg.StartTime)
+	}
+	listed, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{cfg.KafkaTopic: requests},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to look up offsets at %s: %w", cfg.StartTime.Format(time.RFC3339), err)
+	}
+
+	var commits []kafka.OffsetCommit
+	for _, p := range listed.Topics[cfg.KafkaTopic] {
+		if p.Error != nil {
+			return fmt.Errorf("partition %d: %w", p.Partition, p.Error)
+		}
+		for offset := range p.Offsets {
+			if offset >= 0 {
+				commits = append(commits, kafka.OffsetCommit{Partition: p.Partition, Offset: offset})
+			}
+		}
+	}
+	if len(commits) == 0 {
+		log.Printf("No messages on %q since %s", cfg.KafkaTopic, cfg.StartTime.Format(time.RFC3339))
+		return nil
+	}
+
+	// Generation -1 commits on behalf of a group with no active members
+	resp, err := client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      cfg.ConsumerGroup,
+		GenerationID: -1,
+		Topics:       map[string][]kafka.OffsetCommit{cfg.KafkaTopic: commits},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit start offsets: %w", err)
+	}
+	for _, p := range resp.Topics[cfg.KafkaTopic] {
+		if p.Error != nil {
+			return fmt.Errorf("failed to commit start offset for partition %d: %w", p.Partition, p.Error)
+		}
+	}
+
+	log.Printf("Consumer group %q starts at %s on %d partitions", cfg.ConsumerGroup, cfg.StartTime.Format(time.RFC3339), len(commits))
+	return nil
+}
+
+// kafkaCodecs are the compression codecs the reader decodes. Each batch
+// names its codec in its attributes, so producers may mix them on one topic.
+var kafkaCodecs = []compress.Codec{
+	&compress.GzipCodec,
+	&compress.SnappyCodec,
+	&compress.Lz4Codec,
+	&compress.ZstdCodec,
+}
+
+// registerCodecs installs kafkaCodecs in kafka-go's codec table, so decoding
+// doesn't depend on which codecs the library registers by default
+func registerCodecs() {
+	for _, codec := range kafkaCodecs {
+		compress.Codecs[codec.Code()] = codec
+	}
+}
+
+// supportedCodecs lists the compression codecs registered for decoding
+func supportedCodecs() []string {
+	var names []string
+	for _, codec := range compress.Codecs {
+		if codec != nil {
+			names = append(names, codec.Name())
+		}
+	}
+	return names
+}
+
+// newMetricReader builds the consumer group reader for the metrics topic
+func newMetricReader(cfg Config) *kafka.Reader {
+	registerCodecs()
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:       cfg.KafkaBrokers,
+		Topic:         cfg.KafkaTopic,
+		GroupID:       cfg.ConsumerGroup,
+		MinBytes:      cfg.FetchMinBytes,
+		MaxBytes:      cfg.FetchMaxBytes,
+		MaxWait:       cfg.FetchMaxWait,
+		QueueCapacity: cfg.QueueCapacity,
+		StartOffset:   cfg.StartOffset,
+		// Commit synchronously after each message so nothing processed is
+		// left uncommitted when partitions are revoked in a rebalance
+		CommitInterval:   0,
+		SessionTimeout:   cfg.SessionTimeout,
+		RebalanceTimeout: cfg.RebalanceTimeout,
+		GroupBalancers:   []kafka.GroupBalancer{kafka.RangeGroupBalancer{}},
+		Dialer: &kafka.Dialer{
+			ClientID:  cfg.KafkaClientID,
+			Timeout:   10 * time.Second,
+			DualStack: true,
+		},
+		ErrorLogger: kafkaErrorLogger("reader"),
+	})
+
+	log.Printf("Consuming topic %q as consumer group %q", cfg.KafkaTopic, cfg.ConsumerGroup)
+	log.Printf("Decompressing message batches with: %s", strings.Join(supportedCodecs(), ", "))
+	return reader
+}
+
+func NewAlertEngine(cfg Config) (*AlertEngine, error) {
+	channels, err := loadNotifyChannels(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := connectDB(cfg.DBConnStr, cfg.DBConnectAttempts, cfg.DBConnectBackoff)
+	if err != nil {
+		return nil, err
+	}
+
+	// One transport carries the client ID on every request outside the
+	// reader's own connections
+	transport := &kafka.Transport{ClientID: cfg.KafkaClientID}
+
+	if !cfg.StartTime.IsZero() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := seekGroupToTime(ctx, cfg, transport)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var reader *kafka.Reader
+	var source messageSource
+	if cfg.Source == sourceFile {
+		source = &fileSource{path: cfg.SourceFile, fromStart: cfg.StartOffset == kafka.FirstOffset}
+		log.Printf("Tailing metrics from %s", cfg.SourceFile)
+	} else {
+		reader = newMetricReader(cfg)
+		source = reader
+	}
+
+	engine := &AlertEngine{
+		db:          db,
+		kafkaReader: reader,
+		source:      source,
+		cfg:         cfg,
+		transport:   transport,
+		channels:    channels,
+		drainer:     newDrainer(cfg),
+		startedAt:   time.Now(),
+
+		nodeLastSeen:    make(map[string]time.Time),
+		nodeWarmupStart: make(map[string]time.Time),
+		gpus:            make(map[gpuKey]*gpuHistory),
+		nodes:           make(map[string]*nodeHistory),
+		alerting:        make(map[gpuKey]bool),
+		ingest:          ingestRates{nodes: make(map[string]*nodeIngest)},
+	}
+
+	if cfg.StoreMode == storeModeAsync {
+		engine.storeQueue = make(chan GPUMetric, cfg.StoreQueueSize)
+		engine.storeDone = make(chan struct{})
+		log.Printf("Storing metrics asynchronously (queue %d, batches of %d, flushed every %s)",
+			cfg.StoreQueueSize, cfg.StoreBatchSize, cfg.StoreFlushInterval)
+	}
+
+	if cfg.NotifyConcurrency > 0 {
+		engine.notifyQueue = make(chan notifyJob, cfg.NotifyQueueSize)
+	}
+
+	if cfg.ArchiveBucket != "" {
+		engine.archive = newS3Store(cfg)
+		log.Printf("Archiving metrics older than %s to s3://%s/%s", cfg.MetricsRetention, cfg.ArchiveBucket, cfg.ArchivePrefix)
+	}
+
+	if cfg.AlertTopicEnabled {
+		engine.alertWriter = &kafka.Writer{
+			Addr:     kafka.TCP(cfg.KafkaBrokers...),
+			Topic:    cfg.AlertTopic,
+			Balancer: &kafka.Hash{},
+			// Alerts are written one at a time; don't wait to fill a batch
+			BatchTimeout: 10 * time.Millisecond,
+			Transport:    transport,
+			ErrorLogger:  kafkaErrorLogger("alert writer"),
+		}
+		log.Printf("Publishing alerts to topic %q", cfg.AlertTopic)
+	}
+
+	if err := engine.LoadRules(); err != nil {
+		log.Printf("Failed to load alert rules, using built-in defaults: %v", err)
+		engine.rules = defaultRules
+	}
+
+	return engine, nil
+}
+
+// SeverityBand is one threshold of a rule and the severity it fires at
+type SeverityBand struct {
+	Threshold float64 `json:"threshold"`
+	Severity  string  `json:"severity"`
+}
+
+// AlertRule is a threshold rule loaded from the alert_rules table
+type AlertRule struct {
+	ID        int
+	AlertType string
+	Metric    string
+	Operator  string // ">" fires above a band's threshold, "<" below it
+	Bands     []SeverityBand
+	// GPU scopes the rule to one GPU, where it replaces the fleet-wide
+	// rules of the same alert type; nil applies it to every GPU
+	GPU *gpuKey
+	// Model scopes the rule to GPUs whose GPUModelLabel label matches,
+	// replacing fleet-wide rules of the same alert type but not GPU-scoped
+	// ones; empty for fleet and GPU rules
+	Model string
+	// RunbookURL is carried by the rule's alerts; empty when it has none
+	RunbookURL string
+	// Schedule limits when the rule's non-critical alerts notify; nil
+	// when it has no notify_schedule
+	Schedule *NotifySchedule
+}
+
+// Outside-hours behaviours of a NotifySchedule
+const (
+	scheduleDefer    = "defer"
+	scheduleSuppress = "suppress"
+)
+
+// NotifySchedule is a rule's notify_schedule: the local business hours in
+// which its non-critical alerts notify. Outside them alerts are still
+// recorded, but their notifications wait for the window to open ("defer",
+// the default) or are dropped ("suppress").
+type NotifySchedule struct {
+	Days     []string `json:"days,omitempty"`     // mon..sun; empty means every day
+	Start    string   `json:"start"`              // HH:MM, inclusive
+	End      string   `json:"end"`                // HH:MM, exclusive
+	Timezone string   `json:"timezone,omitempty"` // IANA name; empty means UTC
+	Outside  string   `json:"outside,omitempty"`  // defer or suppress
+}
+
+// scheduleDays maps notify_schedule day names to weekdays
+var scheduleDays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseClock parses an HH:MM time of day into minutes after midnight
+func parseClock(v string) (int, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", v)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Validate checks the schedule's timezone, days and window
+func (s NotifySchedule) Validate() error {
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("notify_schedule: unknown timezone %q", s.Timezone)
+	}
+	start, err := parseClock(s.Start)
+	if err != nil {
+		return fmt.Errorf("notify_schedule: start: %w", err)
+	}
+	end, err := parseClock(s.End)
+	if err != nil {
+		return fmt.Errorf("notify_schedule: end: %w", err)
+	}
+	if start >= end {
+		return fmt.Errorf("notify_schedule: start %s must be before end %s", s.Start, s.End)
+	}
+	for _, d := range s.Days {
+		if _, ok := scheduleDays[strings.ToLower(d)]; !ok {
+			return fmt.Errorf("notify_schedule: unknown day %q", d)
+		}
+	}
+	if s.Outside != "" && s.Outside != scheduleDefer && s.Outside != scheduleSuppress {
+		return fmt.Errorf("notify_schedule: outside must be defer or suppress, got %q", s.Outside)
+	}
+	return nil
+}
+
+// onDay reports whether the window opens on weekday
+func (s NotifySchedule) onDay(weekday time.Weekday) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, d := range s.Days {
+		if scheduleDays[strings.ToLower(d)] == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// nextOpen returns t when the window is open at t, otherwise when it next
+// opens. The schedule must have passed Validate.
+func (s NotifySchedule) nextOpen(t time.Time) time.Time {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return t
+	}
+	start, _ := parseClock(s.Start)
+	end, _ := parseClock(s.End)
+	local := t.In(loc)
+	for d := 0; d <= 7; d++ {
+		y, m, day := local.Date()
+		open := time.Date(y, m, day+d, start/60, start%60, 0, 0, loc)
+		if !s.onDay(open.Weekday()) {
+			continue
+		}
+		if local.Before(open) {
+			return open
+		}
+		if local.Before(time.Date(y, m, day+d, end/60, end%60, 0, 0, loc)) {
+			return t
+		}
+	}
+	return t
+}
+
+// defaultRules are evaluated when the alert_rules table can't be loaded or is empty
+var defaultRules = []AlertRule{
+	{AlertType: "high_temperature", Metric: "temperature_celsius", Operator: ">",
+		Bands: []SeverityBand{{90.0, "warning"}, {95.0, "critical"}}},
+	{AlertType: "high_power", Metric: "power_watts", Operator: ">",
+		Bands: []SeverityBand{{330.0, "warning"}, {350.0, "critical"}}},
+	{AlertType: "high_memory", Metric: "memory_percent", Operator: ">",
+		Bands: []SeverityBand{{95.0, "warning"}}},
+	{AlertType: "low_free_memory", Metric: "memory_free_mb", Operator: "<",
+		Bands: []SeverityBand{{2048.0, "warning"}, {512.0, "critical"}}},
+	{AlertType: "row_remap_pending", Metric: "row_remap_pending", Operator: ">",
+		Bands: []SeverityBand{{0, "critical"}}},
+}
+
+// ruleMetrics describes the metrics a rule can reference: how to read the
+// value from a sample and how to describe it in alert messages
+var ruleMetrics = map[string]struct {
+	label string
+	unit  string
+	value func(m GPUMetric) float64
+}{
+	"temperature_celsius": {"temperature", "°C", func(m GPUMetric) float64 { return m.TemperatureCelsius }},
+	"power_watts":         {"power consumption", "W", func(m GPUMetric) float64 { return m.PowerWatts }},
+	"memory_percent":      {"memory usage", "%", func(m GPUMetric) float64 { return (m.MemoryUsedMB / m.MemoryTotalMB) * 100.0 }},
+	"memory_used_mb":      {"memory used", "MB", func(m GPUMetric) float64 { return m.MemoryUsedMB }},
+	"memory_free_mb":      {"free memory", "MB", func(m GPUMetric) float64 { return m.MemoryTotalMB - m.MemoryUsedMB }},
+	"utilization_percent": {"utilization", "%", func(m GPUMetric) float64 { return m.UtilizationPercent }},
+	"sm_clock_mhz":        {"SM clock", "MHz", func(m GPUMetric) float64 { return float64(m.SMClockMHz) }},
+	"fan_speed_percent":   {"fan speed", "%", func(m GPUMetric) float64 { return m.FanSpeedPercent }},
+	"row_remap_pending":   {"pending row remaps", "", func(m GPUMetric) float64 { return float64(m.RowRemapPending) }},
+}
+
+// ruleMetricAdvice is appended to alert messages for metrics with a known remedy
+var ruleMetricAdvice = map[string]string{
+	"row_remap_pending": "drain the node and reset the GPU before scheduling new work",
+}
+
+// validSeverities lists the severities a rule band may declare
+var validSeverities = map[string]bool{"info": true, "warning": true, "critical": true}
+
+// severityRank orders severities from least to most urgent
+var severityRank = map[string]int{"info": 0, "warning": 1, "critical": 2}
+
+// severityBounds are a datacenter's severity floor and ceiling; an empty
+// bound leaves that side unclamped
+type severityBounds struct {
+	Floor   string
+	Ceiling string
+}
+
+// clamp raises severity to the floor or lowers it to the ceiling
+func (b severityBounds) clamp(severity string) string {
+	if b.Floor != "" && severityRank[severity] < severityRank[b.Floor] {
+		return b.Floor
+	}
+	if b.Ceiling != "" && severityRank[severity] > severityRank[b.Ceiling] {
+		return b.Ceiling
+	}
+	return severity
+}
+
+// parseDatacenterSeverity parses DATACENTER_SEVERITY, a comma-separated list
+// of datacenter=floor:ceiling entries such as "dev=:warning,prod=warning:"
+func parseDatacenterSeverity(v string) (map[string]severityBounds, error) {
+	bounds := make(map[string]severityBounds)
+	for _, entry := range splitList(v) {
+		dc, rng, ok := strings.Cut(entry, "=")
+		floor, ceiling, ok2 := strings.Cut(rng, ":")
+		if !ok || !ok2 || dc == "" {
+			return nil, fmt.Errorf("DATACENTER_SEVERITY: expected datacenter=floor:ceiling, got %q", entry)
+		}
+		for _, sev := range []string{floor, ceiling} {
+			if sev != "" && !validSeverities[sev] {
+				return nil, fmt.Errorf("DATACENTER_SEVERITY: unknown severity %q for %s", sev, dc)
+			}
+		}
+		if floor != "" && ceiling != "" && severityRank[floor] > severityRank[ceiling] {
+			return nil, fmt.Errorf("DATACENTER_SEVERITY: floor %s is above ceiling %s for %s", floor, ceiling, dc)
+		}
+		bounds[dc] = severityBounds{Floor: floor, Ceiling: ceiling}
+	}
+	return bounds, nil
+}
+
+// storePrecisionFields are the gpu_metrics fields STORE_PRECISION can
+// round; each returns the field's address, or nil when it is unset
+var storePrecisionFields = map[string]func(m *GPUMetric) *float64{
+	"temperature_celsius": func(m *GPUMetric) *float64 { return &m.TemperatureCelsius },
+	"power_watts":         func(m *GPUMetric) *float64 { return &m.PowerWatts },
+	"memory_used_mb":      func(m *GPUMetric) *float64 { return &m.MemoryUsedMB },
+	"memory_total_mb":     func(m *GPUMetric) *float64 { return &m.MemoryTotalMB },
+	"utilization_percent": func(m *GPUMetric) *float64 { return &m.UtilizationPercent },
+	"fan_speed_percent":   func(m *GPUMetric) *float64 { return &m.FanSpeedPercent },
+	"clock_skew_seconds":  func(m *GPUMetric) *float64 { return m.ClockSkewSeconds },
+}
+
+// maxStorePrecision bounds STORE_PRECISION decimals; beyond it rounding
+// no longer saves anything on a float64
+const maxStorePrecision = 10
+
+// parseStorePrecision parses STORE_PRECISION, a comma-separated list of
+// field=decimals entries such as "temperature_celsius=1,power_watts=0"
+func parseStorePrecision(v string) (map[string]int, error) {
+	precision := make(map[string]int)
+	for _, entry := range splitList(v) {
+		field, d, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("STORE_PRECISION: expected field=decimals, got %q", entry)
+		}
+		if _, known := storePrecisionFields[field]; !known {
+			return nil, fmt.Errorf("STORE_PRECISION: unsupported field %q", field)
+		}
+		decimals, err := strconv.Atoi(d)
+		if err != nil || decimals < 0 || decimals > maxStorePrecision {
+			return nil, fmt.Errorf("STORE_PRECISION: decimals for %s must be 0-%d, got %q", field, maxStorePrecision, d)
+		}
+		precision[field] = decimals
+	}
+	return precision, nil
+}
+
+// storedMetric returns metric with StorePrecision applied, leaving the
+// original (and the value behind its ClockSkewSeconds) untouched
+func (ae *AlertEngine) storedMetric(metric GPUMetric) GPUMetric {
+	if len(ae.cfg.StorePrecision) == 0 {
+		return metric
+	}
+	if metric.ClockSkewSeconds != nil {
+		skew := *metric.ClockSkewSeconds
+		metric.ClockSkewSeconds = &skew
+	}
+	for field, decimals := range ae.cfg.StorePrecision {
+		if p := storePrecisionFields[field](&metric); p != nil {
+			scale := math.Pow10(decimals)
+			*p = math.Round(*p*scale) / scale
+		}
+	}
+	return metric
+}
+
+// Validate checks a rule references a known metric, operator and severities
+func (r AlertRule) Validate() error {
+	if r.AlertType == "" {
+		return fmt.Errorf("rule has no alert_type")
+	}
+	if _, ok := ruleMetrics[r.Metric]; !ok {
+		return fmt.Errorf("rule %s: unknown metric %q", r.AlertType, r.Metric)
+	}
+	if r.Operator != ">" && r.Operator != "<" {
+		return fmt.Errorf("rule %s: unsupported operator %q", r.AlertType, r.Operator)
+	}
+	if len(r.Bands) == 0 {
+		return fmt.Errorf("rule %s: no severity bands", r.AlertType)
+	}
+	for _, b := range r.Bands {
+		if !validSeverities[b.Severity] {
+			return fmt.Errorf("rule %s: invalid severity %q", r.AlertType, b.Severity)
+		}
+	}
+	if r.Schedule != nil {
+		if err := r.Schedule.Validate(); err != nil {
+			return fmt.Errorf("rule %s: %w", r.AlertType, err)
+		}
+	}
+	return nil
+}
+
+// Rule scopes, in increasing precedence
+const (
+	scopeFleet = iota
+	scopeModel
+	scopeGPU
+)
+
+// scope returns how specifically the rule applies to the GPU, and false
+// when it doesn't apply to it
+func (r AlertRule) scope(key gpuKey, model string) (int, bool) {
+	switch {
+	case r.GPU != nil:
+		return scopeGPU, *r.GPU == key
+	case r.Model != "":
+		return scopeModel, r.Model == model
+	default:
+		return scopeFleet, true
+	}
+}
+
+// Evaluate returns the band breached furthest by value, if any. For ">" that
+// is the highest threshold exceeded, for "<" the lowest threshold undercut.
+func (r AlertRule) Evaluate(value float64) (SeverityBand, bool) {
+	var matched SeverityBand
+	found := false
+	for _, b := range r.Bands {
+		if r.Operator == ">" && value > b.Threshold && (!found || b.Threshold > matched.Threshold) {
+			matched, found = b, true
+		}
+		if r.Operator == "<" && value < b.Threshold && (!found || b.Threshold < matched.Threshold) {
+			matched, found = b, true
+		}
+	}
+	return matched, found
+}
+
+// LoadRules reads enabled threshold rules from alert_rules, falling back to
+// the built-in defaults when the table is empty
+func (ae *AlertEngine) LoadRules() error {
+	rows, err := ae.db.Query(`
+		SELECT id, alert_type, metric, operator, severity_bands, node_id, gpu_index,
+		       COALESCE(runbook_url, ''), notify_schedule, COALESCE(gpu_model, '')
+		FROM alert_rules
+		WHERE enabled
+		ORDER BY id
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query alert rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []AlertRule
+	for rows.Next() {
+		var rule AlertRule
+		var bands, schedule []byte
+		var nodeID sql.NullString
+		var gpuIndex sql.NullInt64
+		if err := rows.Scan(&rule.ID, &rule.AlertType, &rule.Metric, &rule.Operator, &bands,
+			&nodeID, &gpuIndex, &rule.RunbookURL, &schedule, &rule.Model); err != nil {
+			return fmt.Errorf("failed to scan alert rule: %w", err)
+		}
+		if nodeID.Valid && gpuIndex.Valid {
+			rule.GPU = &gpuKey{NodeID: nodeID.String, GPUIndex: int(gpuIndex.Int64)}
+		}
+		if err := json.Unmarshal(bands, &rule.Bands); err != nil {
+			return fmt.Errorf("rule %d: invalid severity_bands: %w", rule.ID, err)
+		}
+		if schedule != nil {
+			rule.Schedule = &NotifySchedule{}
+			if err := json.Unmarshal(schedule, rule.Schedule); err != nil {
+				log.Printf("Skipping rule %d: invalid notify_schedule: %v", rule.ID, err)
+				continue
+			}
+		}
+		if err := rule.Validate(); err != nil {
+			log.Printf("Skipping invalid rule %d: %v", rule.ID, err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	useDefaults := len(rules) == 0
+	if useDefaults {
+		rules = defaultRules
+	}
+
+	ae.rulesMu.Lock()
+	changed := ae.rules == nil || !reflect.DeepEqual(ae.rules, rules)
+	ae.rules = rules
+	ae.rulesMu.Unlock()
+
+	if changed {
+		if useDefaults {
+			log.Println("No alert rules configured, using built-in defaults")
+		}
+		log.Printf("Loaded %d alert rules", len(rules))
+	}
+	return nil
+}
+
+// currentRules returns the active rule set
+func (ae *AlertEngine) currentRules() []AlertRule {
+	ae.rulesMu.RLock()
+	defer ae.rulesMu.RUnlock()
+	return ae.rules
+}
+
+// baselineMetrics are the metrics learned per GPU for drift detection
+var baselineMetrics = []string{"temperature_celsius", "power_watts"}
+
+// baseline is a GPU's learned normal for one metric
+type baseline struct {
+	Mean    float64
+	Stddev  float64
+	Samples int
+}
+
+// RefreshBaselines recomputes each GPU's mean and standard deviation over
+// the last BaselineWindow into gpu_baselines, then loads them
+func (ae *AlertEngine) RefreshBaselines(ctx context.Context) error {
+	for _, metric := range baselineMetrics {
+		// metric comes from baselineMetrics, never from input
+		_, err := ae.db.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO gpu_baselines (node_id, gpu_index, metric, mean, stddev, samples, computed_at)
+			SELECT node_id, gpu_index, $1, AVG(%[1]s), COALESCE(STDDEV_SAMP(%[1]s), 0), COUNT(%[1]s), NOW()
+			FROM gpu_metrics
+			WHERE collected_at > NOW() - make_interval(secs => $2) AND %[1]s IS NOT NULL
+			GROUP BY node_id, gpu_index
+			ON CONFLICT (node_id, gpu_index, metric) DO UPDATE
+			SET mean = EXCLUDED.mean, stddev = EXCLUDED.stddev,
+			    samples = EXCLUDED.samples, computed_at = EXCLUDED.computed_at
+		`, metric), metric, ae.cfg.BaselineWindow.Seconds())
+		if err != nil {
+			return fmt.Errorf("failed to compute %s baselines: %w", metric, err)
+		}
+	}
+
+	rows, err := ae.db.QueryContext(ctx, `
+		SELECT node_id, gpu_index, metric, mean, stddev, samples
+		FROM gpu_baselines
+		WHERE computed_at > NOW() - make_interval(secs => $1)
+	`, ae.cfg.BaselineWindow.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to load baselines: %w", err)
+	}
+	defer rows.Close()
+
+	baselines := make(map[gpuKey]map[string]baseline)
+	for rows.Next() {
+		var key gpuKey
+		var metric string
+		var b baseline
+		if err := rows.Scan(&key.NodeID, &key.GPUIndex, &metric, &b.Mean, &b.Stddev, &b.Samples); err != nil {
+			return fmt.Errorf("failed to scan baseline: %w", err)
+		}
+		if baselines[key] == nil {
+			baselines[key] = make(map[string]baseline)
+		}
+		baselines[key][metric] = b
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	ae.baselinesMu.Lock()
+	ae.baselines = baselines
+	ae.baselinesMu.Unlock()
+
+	log.Printf("Loaded baselines for %d GPUs", len(baselines))
+	return nil
+}
+
+// baselineFor returns the learned baseline of one GPU metric, if any
+func (ae *AlertEngine) baselineFor(key gpuKey, metric string) (baseline, bool) {
+	ae.baselinesMu.RLock()
+	defer ae.baselinesMu.RUnlock()
+	b, ok := ae.baselines[key][metric]
+	return b, ok
+}
+
+// watchBaselines refreshes baselines now and every BaselineRefreshInterval
+// until ctx is cancelled
+func (ae *AlertEngine) watchBaselines(ctx context.Context) {
+	ticker := time.NewTicker(ae.cfg.BaselineRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := ae.RefreshBaselines(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to refresh baselines, keeping current ones: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ruleMetricSQL are the gpu_metrics expressions of the rule metrics that
+// thresholds can be suggested for; row_remap_pending is a flag, not a
+// distribution
+var ruleMetricSQL = map[string]string{
+	"temperature_celsius": "temperature_celsius",
+	"power_watts":         "power_watts",
+	"memory_percent":      "memory_used_mb / memory_total_mb * 100",
+	"memory_used_mb":      "memory_used_mb",
+	"memory_free_mb":      "memory_total_mb - memory_used_mb",
+	"utilization_percent": "utilization_percent",
+	"sm_clock_mhz":        "sm_clock_mhz",
+	"fan_speed_percent":   "fan_speed_percent",
+}
+
+// loosestBand returns the index of the band nearest normal readings: the
+// lowest threshold for ">" rules, the highest for "<"
+func (r AlertRule) loosestBand() int {
+	loosest := 0
+	for i, b := range r.Bands {
+		if r.Operator == ">" && b.Threshold < r.Bands[loosest].Threshold ||
+			r.Operator == "<" && b.Threshold > r.Bands[loosest].Threshold {
+			loosest = i
+		}
+	}
+	return loosest
+}
+
+// SuggestThresholds compares each fleet rule's loosest threshold with the
+// ThresholdSuggestPercentile of its metric (the complementary percentile
+// for "<" rules) per GPU model over ThresholdSuggestWindow. Each differing
+// value becomes a pending threshold_suggestions row holding the bands the
+// model's rule would have, replacing that model's earlier pending one.
+// Models with fewer than ThresholdSuggestMinSamples samples are skipped.
+func (ae *AlertEngine) SuggestThresholds(ctx context.Context) error {
+	rules := ae.currentRules()
+	modelRules := make(map[string]AlertRule)
+	for _, rule := range rules {
+		if rule.Model != "" {
+			modelRules[rule.AlertType+"/"+rule.Model] = rule
+		}
+	}
+
+	for _, fleet := range rules {
+		expr, ok := ruleMetricSQL[fleet.Metric]
+		// Built-in defaults have no row for a suggestion to reference
+		if !ok || fleet.ID == 0 || fleet.GPU != nil || fleet.Model != "" {
+			continue
+		}
+		percentile := ae.cfg.ThresholdSuggestPercentile
+		if fleet.Operator == "<" {
+			percentile = math.Round((1-percentile)*1e6) / 1e6
+		}
+		filter := ""
+		if memoryTotalMetrics[fleet.Metric] {
+			filter = "AND memory_total_mb > 0"
+		}
+
+		// expr and filter come from fixed tables, never from input
+		rows, err := ae.db.QueryContext(ctx, fmt.Sprintf(`
+			SELECT labels ->> $1::text, COUNT(*), percentile_cont($2) WITHIN GROUP (ORDER BY v)
+			FROM (
+				SELECT labels, (%s)::float8 AS v
+				FROM gpu_metrics
+				WHERE collected_at > NOW() - make_interval(secs => $3)
+				  AND labels ? $1::text %s
+			) s
+			WHERE v IS NOT NULL
+			GROUP BY 1
+			HAVING COUNT(*) >= $4
+		`, expr, filter), ae.cfg.GPUModelLabel, percentile, ae.cfg.ThresholdSuggestWindow.Seconds(), ae.cfg.ThresholdSuggestMinSamples)
+		if err != nil {
+			return fmt.Errorf("failed to compute %s distribution: %w", fleet.Metric, err)
+		}
+		type distribution struct {
+			model   string
+			samples int
+			value   float64
+		}
+		var dists []distribution
+		for rows.Next() {
+			var d distribution
+			if err := rows.Scan(&d.model, &d.samples, &d.value); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan %s distribution: %w", fleet.Metric, err)
+			}
+			dists = append(dists, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, d := range dists {
+			current, ok := modelRules[fleet.AlertType+"/"+d.model]
+			if !ok {
+				current = fleet
+			}
+			id, err := ae.suggestThreshold(ctx, fleet, current, d.model, percentile, d.value, d.samples)
+			if err != nil {
+				return err
+			}
+			if id != 0 && ae.cfg.ThresholdAutoApply {
+				if err := ae.applyThresholdSuggestion(ctx, id); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// suggestThreshold records value as the new loosest threshold of current,
+// the rule in force for model, against the fleet rule it derives from. It
+// returns the suggestion's ID, or 0 when nothing was suggested because the
+// threshold already matches or would overtake a more severe band.
+func (ae *AlertEngine) suggestThreshold(ctx context.Context, fleet, current AlertRule, model string, percentile, value float64, samples int) (int, error) {
+	value = math.Round(value*10) / 10
+	loosest := current.loosestBand()
+	for i, b := range current.Bands {
+		if i != loosest && (current.Operator == ">" && value >= b.Threshold || current.Operator == "<" && value <= b.Threshold) {
+			return 0, nil
+		}
+	}
+
+	if value == current.Bands[loosest].Threshold {
+		// Nothing left to suggest; drop a pending suggestion that is now stale
+		_, err := ae.db.ExecContext(ctx, `
+			DELETE FROM threshold_suggestions WHERE rule_id = $1 AND gpu_model = $2 AND status = 'pending'
+		`, fleet.ID, model)
+		return 0, err
+	}
+
+	bands := append([]SeverityBand(nil), current.Bands...)
+	bands[loosest].Threshold = value
+	rawBands, _ := json.Marshal(bands)
+
+	var id int
+	err := ae.db.QueryRowContext(ctx, `
+		INSERT INTO threshold_suggestions
+			(rule_id, gpu_model, percentile, samples, current_threshold, suggested_threshold, severity_bands)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (rule_id, gpu_model) WHERE status = 'pending' DO UPDATE
+		SET percentile = EXCLUDED.percentile, samples = EXCLUDED.samples,
+		    current_threshold = EXCLUDED.current_threshold,
+		    suggested_threshold = EXCLUDED.suggested_threshold,
+		    severity_bands = EXCLUDED.severity_bands, computed_at = NOW()
+		RETURNING id
+	`, fleet.ID, model, percentile, samples, current.Bands[loosest].Threshold, value, rawBands).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store %s threshold suggestion for %s: %w", fleet.AlertType, model, err)
+	}
+	return id, nil
+}
+
+// applyThresholdSuggestion applies a pending suggestion through
+// apply_threshold_suggestion (init.sql), the same function the API's
+// approve endpoint calls. A suggestion someone decided in the meantime is
+// left alone.
+func (ae *AlertEngine) applyThresholdSuggestion(ctx context.Context, id int) error {
+	var appliedID sql.NullInt64
+	err := ae.db.QueryRowContext(ctx,
+		"SELECT apply_threshold_suggestion($1, 'alert-engine')", id,
+	).Scan(&appliedID)
+	if err != nil {
+		return fmt.Errorf("failed to apply threshold suggestion %d: %w", id, err)
+	}
+	if appliedID.Valid {
+		log.Printf("Applied threshold suggestion %d to rule %d", id, appliedID.Int64)
+	}
+	return nil
+}
+
+// watchThresholdSuggestions runs SuggestThresholds every
+// ThresholdSuggestInterval, reloading rules after it may have applied some
+func (ae *AlertEngine) watchThresholdSuggestions(ctx context.Context) {
+	ticker := time.NewTicker(ae.cfg.ThresholdSuggestInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := ae.SuggestThresholds(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to suggest thresholds: %v", err)
+		}
+		if ae.cfg.ThresholdAutoApply {
+			if err := ae.LoadRules(); err != nil {
+				log.Printf("Failed to reload alert rules after applying suggestions: %v", err)
+			}
+		}
+	}
+}
+
+// Resolutions recorded on alerts the engine resolves
+const (
+	resolutionTimeout   = "timeout"   // outlived its rule's auto_resolve_after
+	resolutionRecovered = "recovered" // the condition cleared
+)
+
+// ResolveExpiredAlerts resolves active alerts older than the
+// auto_resolve_after of their enabled rule. It is meant for one-shot
+// conditions that never read as recovered, so they don't pile up as active.
+func (ae *AlertEngine) ResolveExpiredAlerts(ctx context.Context) (int64, error) {
+	stmtCtx, cancel := ae.statementContext(ctx)
+	defer cancel()
+
+	res, err := ae.db.ExecContext(stmtCtx, `
+		UPDATE alerts a
+		SET status = 'resolved', resolved_at = NOW(), resolution = $1
+		FROM alert_rules r
+		WHERE a.status = 'active'
+		  AND r.alert_type = a.alert_type
+		  AND r.enabled
+		  AND r.auto_resolve_after IS NOT NULL
+		  AND a.triggered_at < NOW() - r.auto_resolve_after
+		  -- a rule scoped to the alert's GPU takes precedence over fleet rules
+		  AND (
+		      (r.node_id = a.node_id AND r.gpu_index = a.gpu_index)
+		      -- model rules time out with their fleet rule, since alerts
+		      -- don't record the GPU model
+		      OR (r.node_id IS NULL AND r.gpu_model IS NULL AND NOT EXISTS (
+		          SELECT 1 FROM alert_rules o
+		          WHERE o.enabled AND o.alert_type = a.alert_type
+		            AND o.node_id = a.node_id AND o.gpu_index = a.gpu_index
+		      ))
+		  )
+	`, resolutionTimeout)
+	if err != nil {
+		return 0, dbError(stmtCtx, fmt.Errorf("failed to resolve expired alerts: %w", err))
+	}
+	return res.RowsAffected()
+}
+
+// watchAutoResolve runs ResolveExpiredAlerts every AutoResolveInterval
+func (ae *AlertEngine) watchAutoResolve(ctx context.Context) {
+	ticker := time.NewTicker(ae.cfg.AutoResolveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := ae.ResolveExpiredAlerts(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Auto-resolve failed: %v", err)
+			}
+			continue
+		}
+		if n > 0 {
+			log.Printf("Auto-resolved %d alerts past their rule's auto_resolve_after", n)
+		}
+	}
+}
+
+// resolutionBuckets are the upper bounds, in seconds, of the
+// alert_resolution_duration_seconds buckets: one minute to one week
+var resolutionBuckets = []float64{60, 300, 900, 1800, 3600, 7200, 14400, 43200, 86400, 259200, 604800}
+
+// resolutionHistogram is a Prometheus histogram of alert resolution times
+// per severity
+type resolutionHistogram struct {
+	mu       sync.Mutex
+	severity map[string]*resolutionCounts
+}
+
+// resolutionCounts are one severity's non-cumulative bucket counts (the
+// last is +Inf), observation count and sum
+type resolutionCounts struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// Observe records one alert resolved after seconds
+func (h *resolutionHistogram) Observe(severity string, seconds float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.severity == nil {
+		h.severity = make(map[string]*resolutionCounts)
+	}
+	c := h.severity[severity]
+	if c == nil {
+		c = &resolutionCounts{buckets: make([]uint64, len(resolutionBuckets)+1)}
+		h.severity[severity] = c
+	}
+	c.buckets[sort.SearchFloat64s(resolutionBuckets, seconds)]++
+	c.count++
+	c.sum += seconds
+}
+
+// WriteTo writes the histogram in the Prometheus text format
+func (h *resolutionHistogram) WriteTo(w io.Writer, name, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+
+	severities := make([]string, 0, len(h.severity))
+	for sev := range h.severity {
+		severities = append(severities, sev)
+	}
+	sort.Strings(severities)
+	for _, sev := range severities {
+		c := h.severity[sev]
+		var cumulative uint64
+		for i, le := range resolutionBuckets {
+			cumulative += c.buckets[i]
+			fmt.Fprintf(w, "%s_bucket{severity=%q,le=\"%g\"} %d\n", name, sev, le, cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{severity=%q,le=\"+Inf\"} %d\n", name, sev, c.count)
+		fmt.Fprintf(w, "%s_sum{severity=%q} %g\n", name, sev, c.sum)
+		fmt.Fprintf(w, "%s_count{severity=%q} %d\n", name, sev, c.count)
+	}
+}
+
+// resolutionSettle is how long a resolution is left before it is scanned,
+// so one committed late with an earlier resolved_at isn't skipped
+const resolutionSettle = 10 * time.Second
+
+// observeResolutions adds alerts resolved after since, and at least
+// resolutionSettle ago, to the resolution histogram and returns the
+// latest resolved_at seen, or since when there were none
+func (ae *AlertEngine) observeResolutions(ctx context.Context, since time.Time) (time.Time, error) {
+	stmtCtx, cancel := ae.statementContext(ctx)
+	defer cancel()
+
+	rows, err := ae.db.QueryContext(stmtCtx, `
+		SELECT severity, EXTRACT(EPOCH FROM resolved_at - triggered_at), resolved_at
+		FROM alerts
+		WHERE status = 'resolved'
+		  AND resolved_at > $1
+		  AND resolved_at <= NOW() - make_interval(secs => $2)
+		ORDER BY resolved_at
+	`, since, resolutionSettle.Seconds())
+	if err != nil {
+		return since, dbError(stmtCtx, fmt.Errorf("failed to scan resolved alerts: %w", err))
+	}
+	defer rows.Close()
+
+	latest := since
+	for rows.Next() {
+		var severity string
+		var seconds float64
+		var resolvedAt time.Time
+		if err := rows.Scan(&severity, &seconds, &resolvedAt); err != nil {
+			return latest, err
+		}
+		ae.resolutions.Observe(severity, math.Max(seconds, 0))
+		latest = resolvedAt
+	}
+	return latest, rows.Err()
+}
+
+// watchResolutions runs observeResolutions every ResolutionScanInterval,
+// starting from the first resolutions settled after the engine started
+func (ae *AlertEngine) watchResolutions(ctx context.Context) {
+	ticker := time.NewTicker(ae.cfg.ResolutionScanInterval)
+	defer ticker.Stop()
+
+	var since time.Time
+	for {
+		if since.IsZero() {
+			err := ae.db.QueryRowContext(ctx, `SELECT NOW() - make_interval(secs => $1)`, resolutionSettle.Seconds()).Scan(&since)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Failed to start alert resolution scan: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if since.IsZero() {
+			continue
+		}
+		next, err := ae.observeResolutions(ctx, since)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to observe alert resolutions: %v", err)
+		}
+		since = next
+	}
+}
+
+// checkBaselineDrift returns a baseline_drift alert for each learned metric
+// that is more than BaselineDriftSigma standard deviations from its GPU's mean
+func (ae *AlertEngine) checkBaselineDrift(metric GPUMetric) []Alert {
+	if ae.cfg.BaselineRefreshInterval <= 0 {
+		return nil
+	}
+
+	var alerts []Alert
+	key := gpuKey{NodeID: metric.NodeID, GPUIndex: metric.GPUIndex}
+	for _, name := range baselineMetrics {
+		b, ok := ae.baselineFor(key, name)
+		if !ok || b.Samples < ae.cfg.BaselineMinSamples {
+			continue
+		}
+
+		// Floor the deviation so a GPU with nearly constant readings doesn't
+		// alert on a one-unit change
+		stddev := math.Max(b.Stddev, 1)
+		m := ruleMetrics[name]
+		value := m.value(metric)
+		sigma := (value - b.Mean) / stddev
+		if math.Abs(sigma) <= ae.cfg.BaselineDriftSigma {
+			continue
+		}
+
+		alerts = append(alerts, Alert{
+			NodeID:    metric.NodeID,
+			GPUIndex:  metric.GPUIndex,
+			AlertType: "baseline_drift",
+			Severity:  "warning",
+			Message: fmt.Sprintf("GPU %s is %.1f%s, %.1f standard deviations from its baseline of %.1f%s",
+				m.label, value, m.unit, sigma, b.Mean, m.unit),
+			ThresholdValue: b.Mean + math.Copysign(ae.cfg.BaselineDriftSigma*stddev, sigma),
+			ActualValue:    value,
+		})
+	}
+	return alerts
+}
+
+// watchRules reloads alert_rules every RulesReloadInterval so threshold
+// changes take effect without a restart. A failed reload keeps the current rules.
+func (ae *AlertEngine) watchRules(ctx context.Context) {
+	ticker := time.NewTicker(ae.cfg.RulesReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ae.LoadRules(); err != nil {
+				log.Printf("Failed to reload alert rules, keeping current rules: %v", err)
+			}
+		}
+	}
+}
+
+// gpuKey identifies a single GPU in the fleet
+type gpuKey struct {
+	NodeID   string
+	GPUIndex int
+}
+
+// ringSample is the compact form of a GPUMetric kept in a sampleRing: the
+// readings rules evaluate, without labels or histograms
+type ringSample struct {
+	at              int64 // CollectedAt in Unix nanoseconds
+	temperature     float32
+	power           float32
+	memoryUsed      float32
+	memoryTotal     float32
+	utilization     float32
+	fanSpeed        float32
+	smClock         int32
+	rowRemapPending int32
+}
+
+// sampleRing holds a GPU's last len(buf) samples, overwriting the oldest
+type sampleRing struct {
+	buf  []ringSample
+	next int // index the next sample is written to
+	n    int // samples held, up to len(buf)
+}
+
+func newSampleRing(size int) *sampleRing {
+	return &sampleRing{buf: make([]ringSample, size)}
+}
+
+// push appends a sample, evicting the oldest once the ring is full
+func (r *sampleRing) push(m GPUMetric) {
+	r.buf[r.next] = ringSample{
+		at:              m.CollectedAt.UnixNano(),
+		temperature:     float32(m.TemperatureCelsius),
+		power:           float32(m.PowerWatts),
+		memoryUsed:      float32(m.MemoryUsedMB),
+		memoryTotal:     float32(m.MemoryTotalMB),
+		utilization:     float32(m.UtilizationPercent),
+		fanSpeed:        float32(m.FanSpeedPercent),
+		smClock:         int32(m.SMClockMHz),
+		rowRemapPending: int32(m.RowRemapPending),
+	}
+	r.next = (r.next + 1) % len(r.buf)
+	if r.n < len(r.buf) {
+		r.n++
+	}
+}
+
+// since returns the held samples collected at or after t, oldest first,
+// as metrics of the given GPU
+func (r *sampleRing) since(key gpuKey, t time.Time) []GPUMetric {
+	cutoff := t.UnixNano()
+	var out []GPUMetric
+	for i := 0; i < r.n; i++ {
+		s := r.buf[(r.next-r.n+i+len(r.buf))%len(r.buf)]
+		if s.at < cutoff {
+			continue
+		}
+		out = append(out, GPUMetric{
+			NodeID:             key.NodeID,
+			GPUIndex:           key.GPUIndex,
+			TemperatureCelsius: float64(s.temperature),
+			PowerWatts:         float64(s.power),
+			MemoryUsedMB:       float64(s.memoryUsed),
+			MemoryTotalMB:      float64(s.memoryTotal),
+			UtilizationPercent: float64(s.utilization),
+			FanSpeedPercent:    float64(s.fanSpeed),
+			SMClockMHz:         int(s.smClock),
+			RowRemapPending:    int(s.rowRemapPending),
+			CollectedAt:        time.Unix(0, s.at),
+		})
+	}
+	return out
+}
+
+// gpuHistory is the state kept per GPU between samples
+type gpuHistory struct {
+	last GPUMetric
+	// recent holds the GPU's last HistorySamples samples for rules that
+	// look back, so they need not query gpu_metrics
+	recent *sampleRing
+	// identical counts consecutive samples whose readings equal last,
+	// including last itself
+	identical int
+	// idleSince is when the GPU started holding memory while idle; zero
+	// when it isn't. idleAlerted is set once leaked_memory has fired for
+	// that stretch.
+	idleSince   time.Time
+	idleAlerted bool
+	// cappedSince is when the GPU started running at its enforced power
+	// limit under load; zero when it isn't. cappedAlerted is set once
+	// power_capped has fired for that stretch.
+	cappedSince   time.Time
+	cappedAlerted bool
+	// stopped counts consecutive samples at or below JobStopUtilization
+	stopped int
+	// incomplete counts consecutive samples without memory_total_mb
+	incomplete int
+	// unstored counts samples skipped by StoreSampleEvery since the GPU's
+	// last stored one
+	unstored int
+	// inconsistent counts consecutive samples whose power and
+	// utilization contradict each other
+	inconsistent int
+}
+
+// nodeWideGPU is the GPUIndex of alerts about a whole node
+const nodeWideGPU = -1
+
+// nodeHistory is the state kept per node between samples
+type nodeHistory struct {
+	// gpuIndexes lists the node's GPUs seen so far
+	gpuIndexes []int
+	// overBudgetSince is when the node's total power went over
+	// NodePowerBudgetWatts; zero when it isn't. powerAlerted is set once
+	// node_power_budget has fired for that stretch.
+	overBudgetSince time.Time
+	powerAlerted    bool
+	// saturatedSince is when all the node's GPUs reached
+	// SaturationUtilization; zero when they aren't. saturationAlerted is
+	// set once saturation has fired for that stretch.
+	saturatedSince    time.Time
+	saturationAlerted bool
+	// skewed counts consecutive scrapes from the node flagged by
+	// MaxClockSkew; skewedScrape is the collector timestamp of the last one
+	// counted, so the node's GPUs in one scrape count once
+	skewed       int
+	skewedScrape time.Time
+	// shortSince is when the node started reporting fewer GPUs than
+	// expected; zero when it isn't. missingAlerted is set once gpu_missing
+	// has fired for that stretch.
+	shortSince     time.Time
+	missingAlerted bool
+}
+
+// reported reports whether the sample's source reported field, which is
+// only in doubt for the optional fields listed in Unreported
+func (m GPUMetric) reported(field string) bool {
+	for _, f := range m.Unreported {
+		if f == field {
+			return false
+		}
+	}
+	return true
+}
+
+// memoryKnown reports whether a sample carries memory_total_mb; a GPU that
+// failed to report it has no basis for memory percentages or free memory
+func memoryKnown(m GPUMetric) bool {
+	return m.MemoryTotalMB > 0
+}
+
+// memoryTotalMetrics are the rule metrics derived from memory_total_mb,
+// skipped for samples without it
+var memoryTotalMetrics = map[string]bool{"memory_percent": true, "memory_free_mb": true}
+
+// Power and utilization readings that can't occur together on a healthy
+// GPU; the margins allow for sampling the two at slightly different times
+const (
+	inconsistentBusyUtilization = 90.0  // percent, at or above
+	inconsistentBusyPowerWatts  = 5.0   // at or below, i.e. no power draw
+	inconsistentIdlePowerWatts  = 300.0 // at or above
+	inconsistentIdleUtilization = 1.0   // percent, at or below
+)
+
+// sensorInconsistency describes why a sample's power and utilization are
+// physically inconsistent, or returns "" when they are not. A GPU that
+// didn't report power is never inconsistent, since its 0W means nothing.
+func sensorInconsistency(m GPUMetric) string {
+	if !m.reported("power_watts") {
+		return ""
+	}
+	switch {
+	case m.UtilizationPercent >= inconsistentBusyUtilization && m.PowerWatts <= inconsistentBusyPowerWatts:
+		return fmt.Sprintf("%.1f%% utilization at %.1fW", m.UtilizationPercent, m.PowerWatts)
+	case m.PowerWatts >= inconsistentIdlePowerWatts && m.UtilizationPercent <= inconsistentIdleUtilization:
+		return fmt.Sprintf("%.1fW at %.1f%% utilization", m.PowerWatts, m.UtilizationPercent)
+	}
+	return ""
+}
+
+// sameReadings reports whether two samples carry exactly the same readings
+func sameReadings(a, b GPUMetric) bool {
+	return a.TemperatureCelsius == b.TemperatureCelsius &&
+		a.PowerWatts == b.PowerWatts &&
+		a.MemoryUsedMB == b.MemoryUsedMB &&
+		a.MemoryTotalMB == b.MemoryTotalMB &&
+		a.UtilizationPercent == b.UtilizationPercent &&
+		a.SMClockMHz == b.SMClockMHz &&
+		a.FanSpeedPercent == b.FanSpeedPercent &&
+		a.RowRemapPending == b.RowRemapPending
+}
+
+// observe records metric as the latest sample of its GPU and returns the
+// GPU's updated history
+func (ae *AlertEngine) observe(metric GPUMetric) *gpuHistory {
+	key := gpuKey{NodeID: metric.NodeID, GPUIndex: metric.GPUIndex}
+	hist, ok := ae.gpus[key]
+	if !ok {
+		hist = &gpuHistory{recent: newSampleRing(ae.cfg.HistorySamples)}
+		ae.gpus[key] = hist
+
+		node, known := ae.nodes[metric.NodeID]
+		if !known {
+			node = &nodeHistory{}
+			ae.nodes[metric.NodeID] = node
+		}
+		node.gpuIndexes = append(node.gpuIndexes, metric.GPUIndex)
+	}
+
+	if ok && sameReadings(hist.last, metric) {
+		hist.identical++
+	} else {
+		hist.identical = 1
+	}
+	if memoryKnown(metric) {
+		hist.incomplete = 0
+	} else {
+		hist.incomplete++
+	}
+	if sensorInconsistency(metric) != "" {
+		hist.inconsistent++
+	} else {
+		hist.inconsistent = 0
+	}
+	hist.last = metric
+	hist.recent.push(metric)
+
+	if ae.cfg.HistoryEvictAfter > 0 && metric.CollectedAt.Sub(ae.lastEviction) >= evictionSweepInterval {
+		ae.evictSilentGPUs(metric.CollectedAt)
+	}
+	return hist
+}
+
+// evictionSweepInterval is how often, in sample time, gpus is swept
+const evictionSweepInterval = time.Minute
+
+// evictSilentGPUs drops the history of GPUs with no sample in the
+// HistoryEvictAfter before now, and nodes left with no GPUs
+func (ae *AlertEngine) evictSilentGPUs(now time.Time) {
+	ae.lastEviction = now
+	for key, hist := range ae.gpus {
+		if now.Sub(hist.last.CollectedAt) < ae.cfg.HistoryEvictAfter {
+			continue
+		}
+		delete(ae.gpus, key)
+
+		node := ae.nodes[key.NodeID]
+		if node == nil {
+			continue
+		}
+		for i, idx := range node.gpuIndexes {
+			if idx == key.GPUIndex {
+				node.gpuIndexes = append(node.gpuIndexes[:i], node.gpuIndexes[i+1:]...)
+				break
+			}
+		}
+		if len(node.gpuIndexes) == 0 {
+			delete(ae.nodes, key.NodeID)
+		}
+	}
+}
+
+// checkLeakedMemory tracks how long a GPU has held memory above
+// LeakedMemoryPercent at under LeakedMemoryUtilization utilization, and
+// returns a leaked_memory alert once that has lasted LeakedMemoryWindow
+func (ae *AlertEngine) checkLeakedMemory(metric GPUMetric, hist *gpuHistory) (Alert, bool) {
+	if ae.cfg.LeakedMemoryWindow <= 0 || metric.MemoryTotalMB <= 0 {
+		return Alert{}, false
+	}
+
+	memoryPercent := ruleMetrics["memory_percent"].value(metric)
+	if memoryPercent <= ae.cfg.LeakedMemoryPercent || metric.UtilizationPercent >= ae.cfg.LeakedMemoryUtilization {
+		hist.idleSince, hist.idleAlerted = time.Time{}, false
+		return Alert{}, false
+	}
+
+	if hist.idleSince.IsZero() {
+		hist.idleSince = metric.CollectedAt
+	}
+	idle := metric.CollectedAt.Sub(hist.idleSince)
+	if hist.idleAlerted || idle < ae.cfg.LeakedMemoryWindow {
+		return Alert{}, false
+	}
+	hist.idleAlerted = true
+
+	return Alert{
+		NodeID:    metric.NodeID,
+		GPUIndex:  metric.GPUIndex,
+		AlertType: "leaked_memory",
+		Severity:  "warning",
+		Message: fmt.Sprintf("GPU memory at %.1f%% with %.1f%% utilization for %s; check for a hung process holding VRAM",
+			memoryPercent, metric.UtilizationPercent, idle.Round(time.Second)),
+		ThresholdValue: ae.cfg.LeakedMemoryPercent,
+		ActualValue:    memoryPercent,
+	}, true
+}
+
+// checkPowerCap tracks how long a busy GPU's power has sat at its enforced
+// power limit, and returns a power_capped alert once that has lasted
+// PowerCapWindow. GPUs that don't report a limit are skipped.
+func (ae *AlertEngine) checkPowerCap(metric GPUMetric, hist *gpuHistory) (Alert, bool) {
+	limit := metric.EnforcedPowerLimitWatts
+	if ae.cfg.PowerCapWindow <= 0 || limit <= 0 {
+		return Alert{}, false
+	}
+
+	if metric.PowerWatts < limit-ae.cfg.PowerCapMarginWatts || metric.UtilizationPercent < ae.cfg.PowerCapUtilization {
+		hist.cappedSince, hist.cappedAlerted = time.Time{}, false
+		return Alert{}, false
+	}
+
+	if hist.cappedSince.IsZero() {
+		hist.cappedSince = metric.CollectedAt
+	}
+	capped := metric.CollectedAt.Sub(hist.cappedSince)
+	if hist.cappedAlerted || capped < ae.cfg.PowerCapWindow {
+		return Alert{}, false
+	}
+	hist.cappedAlerted = true
+
+	return Alert{
+		NodeID:    metric.NodeID,
+		GPUIndex:  metric.GPUIndex,
+		AlertType: "power_capped",
+		Severity:  "warning",
+		Message: fmt.Sprintf("GPU held at its %.0fW power limit (%.1fW) with %.1f%% utilization for %s; it is being throttled, check the power limit and cooling",
+			limit, metric.PowerWatts, metric.UtilizationPercent, capped.Round(time.Second)),
+		ThresholdValue: limit,
+		ActualValue:    metric.PowerWatts,
+	}, true
+}
+
+// checkJobStopped returns a job_stopped alert when a GPU that was busy
+// drops to near-idle and stays there: its JobStopSamples latest samples are
+// at or below JobStopUtilization, and its earlier samples within
+// JobStopBaselineWindow, taken from the recent-sample ring, averaged at
+// least JobStopBaselineUtilization. It fires once per drop.
+func (ae *AlertEngine) checkJobStopped(metric GPUMetric, hist *gpuHistory) (Alert, bool) {
+	n := ae.cfg.JobStopSamples
+	if n <= 0 {
+		return Alert{}, false
+	}
+	if metric.UtilizationPercent > ae.cfg.JobStopUtilization {
+		hist.stopped = 0
+		return Alert{}, false
+	}
+	hist.stopped++
+	if hist.stopped != n {
+		return Alert{}, false
+	}
+
+	key := gpuKey{NodeID: metric.NodeID, GPUIndex: metric.GPUIndex}
+	recent := hist.recent.since(key, metric.CollectedAt.Add(-ae.cfg.JobStopBaselineWindow))
+	if len(recent) <= n {
+		// Nothing before the drop to compare with
+		return Alert{}, false
+	}
+	before := recent[:len(recent)-n]
+	var sum float64
+	for _, m := range before {
+		sum += m.UtilizationPercent
+	}
+	baseline := sum / float64(len(before))
+	if baseline < ae.cfg.JobStopBaselineUtilization {
+		return Alert{}, false
+	}
+
+	return Alert{
+		NodeID:    metric.NodeID,
+		GPUIndex:  metric.GPUIndex,
+		AlertType: "job_stopped",
+		Severity:  "info",
+		Message: fmt.Sprintf("GPU utilization dropped from %.1f%% to %.1f%% and has stayed there for %d samples; the job running on it likely finished or crashed",
+			baseline, metric.UtilizationPercent, n),
+		ThresholdValue: ae.cfg.JobStopBaselineUtilization,
+		ActualValue:    metric.UtilizationPercent,
+	}, true
+}
+
+// checkNodePower sums the latest power sample of each of the node's GPUs
+// and returns a node_power_budget alert once the total has stayed over
+// NodePowerBudgetWatts for NodePowerWindow. GPUs silent for longer than the
+// window are left out of the sum.
+func (ae *AlertEngine) checkNodePower(metric GPUMetric) (Alert, bool) {
+	node := ae.nodes[metric.NodeID]
+	if ae.cfg.NodePowerBudgetWatts <= 0 || node == nil {
+		return Alert{}, false
+	}
+
+	var total float64
+	for _, idx := range node.gpuIndexes {
+		last := ae.gpus[gpuKey{NodeID: metric.NodeID, GPUIndex: idx}].last
+		if metric.CollectedAt.Sub(last.CollectedAt) <= ae.cfg.NodePowerWindow {
+			total += last.PowerWatts
+		}
+	}
+
+	if total <= ae.cfg.NodePowerBudgetWatts {
+		node.overBudgetSince, node.powerAlerted = time.Time{}, false
+		return Alert{}, false
+	}
+	if node.overBudgetSince.IsZero() {
+		node.overBudgetSince = metric.CollectedAt
+	}
+	over := metric.CollectedAt.Sub(node.overBudgetSince)
+	if node.powerAlerted || over < ae.cfg.NodePowerWindow {
+		return Alert{}, false
+	}
+	node.powerAlerted = true
+
+	return Alert{
+		NodeID:    metric.NodeID,
+		GPUIndex:  nodeWideGPU,
+		AlertType: "node_power_budget",
+		Severity:  "warning",
+		Message: fmt.Sprintf("Node GPUs drawing %.0fW together, over the %.0fW budget for %s; rebalance workloads across racks",
+			total, ae.cfg.NodePowerBudgetWatts, over.Round(time.Second)),
+		ThresholdValue: ae.cfg.NodePowerBudgetWatts,
+		ActualValue:    total,
+	}, true
+}
+
+// checkSaturation returns a node-level saturation alert once every GPU of
+// the node has held SaturationUtilization for SaturationWindow, going by each
+// GPU's latest sample. GPUs silent for longer than the window are left out;
+// any GPU below the threshold ends the stretch.
+func (ae *AlertEngine) checkSaturation(metric GPUMetric) (Alert, bool) {
+	node := ae.nodes[metric.NodeID]
+	if ae.cfg.SaturationWindow <= 0 || node == nil {
+		return Alert{}, false
+	}
+
+	saturated := true
+	lowest := 100.0
+	gpus := 0
+	for _, idx := range node.gpuIndexes {
+		last := ae.gpus[gpuKey{NodeID: metric.NodeID, GPUIndex: idx}].last
+		if metric.CollectedAt.Sub(last.CollectedAt) > ae.cfg.SaturationWindow {
+			continue
+		}
+		gpus++
+		lowest = math.Min(lowest, last.UtilizationPercent)
+		if last.UtilizationPercent < ae.cfg.SaturationUtilization {
+			saturated = false
+			break
+		}
+	}
+
+	if !saturated || gpus == 0 {
+		node.saturatedSince, node.saturationAlerted = time.Time{}, false
+		return Alert{}, false
+	}
+	if node.saturatedSince.IsZero() {
+		node.saturatedSince = metric.CollectedAt
+	}
+	held := metric.CollectedAt.Sub(node.saturatedSince)
+	if node.saturationAlerted || held < ae.cfg.SaturationWindow {
+		return Alert{}, false
+	}
+	node.saturationAlerted = true
+
+	return Alert{
+		NodeID:    metric.NodeID,
+		GPUIndex:  nodeWideGPU,
+		AlertType: "saturation",
+		Severity:  ae.cfg.SaturationSeverity,
+		Message: fmt.Sprintf("All %d GPUs at %.0f%%+ utilization for %s; consider sharding the job across more nodes",
+			gpus, ae.cfg.SaturationUtilization, held.Round(time.Second)),
+		ThresholdValue: ae.cfg.SaturationUtilization,
+		ActualValue:    lowest,
+	}, true
+}
+
+// checkMissingGPUs returns a node-level gpu_missing alert once the node has
+// reported fewer GPUs than its expected_gpu_count for MissingGPUWindow,
+// counting the GPUs with a sample within the window
+func (ae *AlertEngine) checkMissingGPUs(metric GPUMetric) (Alert, bool) {
+	node := ae.nodes[metric.NodeID]
+	expected := ae.expectedGPUCount(metric.NodeID)
+	if ae.cfg.MissingGPUWindow <= 0 || node == nil || expected <= 0 {
+		return Alert{}, false
+	}
+
+	reporting := make(map[int]bool)
+	for _, idx := range node.gpuIndexes {
+		last := ae.gpus[gpuKey{NodeID: metric.NodeID, GPUIndex: idx}].last
+		if metric.CollectedAt.Sub(last.CollectedAt) <= ae.cfg.MissingGPUWindow {
+			reporting[idx] = true
+		}
+	}
+	if len(reporting) >= expected {
+		node.shortSince, node.missingAlerted = time.Time{}, false
+		return Alert{}, false
+	}
+	if node.shortSince.IsZero() {
+		node.shortSince = metric.CollectedAt
+	}
+	short := metric.CollectedAt.Sub(node.shortSince)
+	if node.missingAlerted || short < ae.cfg.MissingGPUWindow {
+		return Alert{}, false
+	}
+	node.missingAlerted = true
+
+	var missing []string
+	for idx := 0; idx < expected; idx++ {
+		if !reporting[idx] {
+			missing = append(missing, strconv.Itoa(idx))
+		}
+	}
+	return Alert{
+		NodeID:    metric.NodeID,
+		GPUIndex:  nodeWideGPU,
+		AlertType: "gpu_missing",
+		Severity:  "warning",
+		Message: fmt.Sprintf("Node reporting %d of %d expected GPUs for %s (silent: %s); check the GPUs with nvidia-smi and the DCGM exporter",
+			len(reporting), expected, short.Round(time.Second), strings.Join(missing, ", ")),
+		ThresholdValue: float64(expected),
+		ActualValue:    float64(len(reporting)),
+	}, true
+}
+
+// expectedGPUCount returns the node's expected_gpu_count, or 0 when unset
+func (ae *AlertEngine) expectedGPUCount(nodeID string) int {
+	ae.expectedMu.RLock()
+	defer ae.expectedMu.RUnlock()
+	return ae.expectedGPUs[nodeID]
+}
+
+// expectedGPUsRefreshInterval is how often expected_gpu_count is reloaded
+const expectedGPUsRefreshInterval = time.Minute
+
+// refreshExpectedGPUs reloads each node's expected_gpu_count
+func (ae *AlertEngine) refreshExpectedGPUs(ctx context.Context) error {
+	stmtCtx, cancel := ae.statementContext(ctx)
+	defer cancel()
+
+	rows, err := ae.db.QueryContext(stmtCtx,
+		"SELECT node_id, expected_gpu_count FROM gpu_nodes WHERE expected_gpu_count > 0")
+	if err != nil {
+		return dbError(stmtCtx, err)
+	}
+	defer rows.Close()
+
+	expected := make(map[string]int)
+	for rows.Next() {
+		var nodeID string
+		var count int
+		if err := rows.Scan(&nodeID, &count); err != nil {
+			return err
+		}
+		expected[nodeID] = count
+	}
+	if err := rows.Err(); err != nil {
+		return dbError(stmtCtx, err)
+	}
+
+	ae.expectedMu.Lock()
+	ae.expectedGPUs = expected
+	ae.expectedMu.Unlock()
+	return nil
+}
+
+// watchExpectedGPUs runs refreshExpectedGPUs every expectedGPUsRefreshInterval
+func (ae *AlertEngine) watchExpectedGPUs(ctx context.Context) {
+	ticker := time.NewTicker(expectedGPUsRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := ae.refreshExpectedGPUs(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to refresh expected GPU counts: %v", err)
+		}
+	}
+}
+
+// checkClockSkew counts consecutive scrapes from the node whose collector
+// timestamp was flagged by MaxClockSkew and returns a node-level clock_skew
+// alert once the run reaches ClockSkewSamples. Any unflagged sample ends it.
+func (ae *AlertEngine) checkClockSkew(metric GPUMetric) (Alert, bool) {
+	node := ae.nodes[metric.NodeID]
+	n := ae.cfg.ClockSkewSamples
+	if n <= 0 || node == nil {
+		return Alert{}, false
+	}
+	if metric.ClockSkewSeconds == nil {
+		node.skewed, node.skewedScrape = 0, time.Time{}
+		return Alert{}, false
+	}
+	if metric.scrapedAt.Equal(node.skewedScrape) {
+		return Alert{}, false
+	}
+	node.skewed, node.skewedScrape = node.skewed+1, metric.scrapedAt
+	if node.skewed != n {
+		return Alert{}, false
+	}
+
+	skew := *metric.ClockSkewSeconds
+	direction := "ahead of"
+	if skew < 0 {
+		direction = "behind"
+	}
+	return Alert{
+		NodeID:    metric.NodeID,
+		GPUIndex:  nodeWideGPU,
+		AlertType: "clock_skew",
+		Severity:  "warning",
+		Message: fmt.Sprintf("Collector clock %s ingestion time by %s for %d consecutive scrapes; check NTP on the collection host",
+			direction, time.Duration(math.Abs(skew)*float64(time.Second)).Round(time.Second), n),
+		ThresholdValue: ae.cfg.MaxClockSkew.Seconds(),
+		ActualValue:    skew,
+	}, true
+}
+
+// EvaluateRules checks metrics against thresholds
+func (ae *AlertEngine) EvaluateRules(metric GPUMetric) []Alert {
+	var alerts []Alert
+	hist := ae.observe(metric)
+
+	// Threshold rules from the rules table, each with its own severity
+	// bands. Rules scoped to this GPU take the place of those scoped to its
+	// model, which take the place of fleet-wide rules of the same alert type.
+	key := gpuKey{NodeID: metric.NodeID, GPUIndex: metric.GPUIndex}
+	model := metric.Labels[ae.cfg.GPUModelLabel]
+	rules := ae.currentRules()
+	var narrowest map[string]int
+	for _, rule := range rules {
+		if scope, ok := rule.scope(key, model); ok && scope > narrowest[rule.AlertType] {
+			if narrowest == nil {
+				narrowest = make(map[string]int)
+			}
+			narrowest[rule.AlertType] = scope
+		}
+	}
+	for _, rule := range rules {
+		if scope, ok := rule.scope(key, model); !ok || scope < narrowest[rule.AlertType] {
+			continue
+		}
+		if memoryTotalMetrics[rule.Metric] && !memoryKnown(metric) {
+			continue
+		}
+		m := ruleMetrics[rule.Metric]
+		value := m.value(metric)
+
+		band, fired := rule.Evaluate(value)
+		if !fired {
+			continue
+		}
+
+		message := fmt.Sprintf("GPU %s is %.1f%s", m.label, value, m.unit)
+		if advice, ok := ruleMetricAdvice[rule.Metric]; ok {
+			message += "; " + advice
+		}
+
+		alerts = append(alerts, Alert{
+			NodeID:         metric.NodeID,
+			GPUIndex:       metric.GPUIndex,
+			AlertType:      rule.AlertType,
+			Severity:       band.Severity,
+			Message:        message,
+			ThresholdValue: band.Threshold,
+			ActualValue:    value,
+			RunbookURL:     rule.RunbookURL,
+			Schedule:       rule.Schedule,
+		})
+	}
+
+	// Rule 4: Fan failure - pinned at max (overworked) or stopped while hot
+	// (likely dead); skipped for GPUs that report no fan speed, such as
+	// passively cooled ones
+	if metric.reported("fan_speed_percent") {
+		if metric.FanSpeedPercent <= 0.0 && metric.TemperatureCelsius > 80.0 {
+			alerts = append(alerts, Alert{
+				NodeID:         metric.NodeID,
+				GPUIndex:       metric.GPUIndex,
+				AlertType:      "fan_failure",
+				Severity:       "critical",
+				Message:        fmt.Sprintf("GPU fan stopped while temperature is %.1f°C", metric.TemperatureCelsius),
+				ThresholdValue: 0.0,
+				ActualValue:    metric.FanSpeedPercent,
+			})
+		} else if metric.FanSpeedPercent >= 98.0 {
+			alerts = append(alerts, Alert{
+				NodeID:         metric.NodeID,
+				GPUIndex:       metric.GPUIndex,
+				AlertType:      "fan_failure",
+				Severity:       "warning",
+				Message:        fmt.Sprintf("GPU fan pinned at %.1f%%", metric.FanSpeedPercent),
+				ThresholdValue: 98.0,
+				ActualValue:    metric.FanSpeedPercent,
+			})
+		}
+	}
+
+	// Stuck sensor - DCGM keeps reporting the same readings; fires once when
+	// the run of identical samples reaches the limit
+	if n := ae.cfg.StuckSensorSamples; n > 0 && hist.identical == n {
+		alerts = append(alerts, Alert{
+			NodeID:         metric.NodeID,
+			GPUIndex:       metric.GPUIndex,
+			AlertType:      "stuck_sensor",
+			Severity:       "warning",
+			Message:        fmt.Sprintf("GPU readings unchanged for %d consecutive samples; restart DCGM on the node", n),
+			ThresholdValue: float64(n),
+			ActualValue:    float64(hist.identical),
+		})
+	}
+
+	// Incomplete sample - no memory total, so memory rules were skipped;
+	// fires once when the run of such samples reaches the grace
+	if n := ae.cfg.IncompleteMetricGrace; n > 0 && hist.incomplete == n {
+		alerts = append(alerts, Alert{
+			NodeID:         metric.NodeID,
+			GPUIndex:       metric.GPUIndex,
+			AlertType:      "metric_incomplete",
+			Severity:       "info",
+			Message:        fmt.Sprintf("GPU reported no memory_total_mb for %d consecutive samples; memory rules are skipped until it does (check DCGM_FI_DEV_FB_FREE)", n),
+			ThresholdValue: float64(n),
+			ActualValue:    float64(hist.incomplete),
+		})
+	}
+
+	// Sensor inconsistency - power and utilization contradict each other,
+	// so this GPU's telemetry can't be trusted; fires once per run
+	if n := ae.cfg.SensorInconsistencySamples; n > 0 && hist.inconsistent == n {
+		alerts = append(alerts, Alert{
+			NodeID:         metric.NodeID,
+			GPUIndex:       metric.GPUIndex,
+			AlertType:      "sensor_inconsistency",
+			Severity:       "warning",
+			Message:        fmt.Sprintf("GPU reports %s for %d consecutive samples; its telemetry is unreliable, check the sensors and DCGM", sensorInconsistency(metric), n),
+			ThresholdValue: float64(n),
+			ActualValue:    float64(hist.inconsistent),
+		})
+	}
+
+	// Leaked memory - VRAM held while the GPU does no work, usually a hung process
+	if alert, ok := ae.checkLeakedMemory(metric, hist); ok {
+		alerts = append(alerts, alert)
+	}
+
+	// Power capped - pinned at the enforced limit under load, so throttled
+	if alert, ok := ae.checkPowerCap(metric, hist); ok {
+		alerts = append(alerts, alert)
+	}
+
+	// Job stopped - a busy GPU suddenly went idle, often a crashed job
+	if alert, ok := ae.checkJobStopped(metric, hist); ok {
+		alerts = append(alerts, alert)
+	}
+
+	// Baseline drift - far from this GPU's own learned normal
+	alerts = append(alerts, ae.checkBaselineDrift(metric)...)
+
+	// Node power budget - the node's GPUs together, not this one alone
+	if alert, ok := ae.checkNodePower(metric); ok {
+		alerts = append(alerts, alert)
+	}
+	if alert, ok := ae.checkSaturation(metric); ok {
+		alerts = append(alerts, alert)
+	}
+
+	// Missing GPUs - fewer reporting than the node's registered count
+	if alert, ok := ae.checkMissingGPUs(metric); ok {
+		alerts = append(alerts, alert)
+	}
+
+	// Clock skew - the collection host's clock, so every collected_at is off
+	if alert, ok := ae.checkClockSkew(metric); ok {
+		alerts = append(alerts, alert)
+	}
+
+	return alerts
+}
+
+// Sources for the stored collected_at timestamp
+const (
+	timeSourceCollector = "collector" // trust the collector's timestamp
+	timeSourceKafka     = "kafka"     // use the Kafka message timestamp
+	timeSourceServer    = "server"    // use the engine's clock at ingestion
+)
+
+// applyTimeSource checks the collector's timestamp against the Kafka
+// message timestamp, flagging skew beyond MaxClockSkew, and overrides
+// collected_at when a different source is configured. The message
+// timestamp rather than the engine's clock is compared so a consumer
+// backlog isn't mistaken for skew; messages without one aren't checked.
+func (ae *AlertEngine) applyTimeSource(metric *GPUMetric, msgTime time.Time) {
+	metric.scrapedAt = metric.CollectedAt
+	ingested := time.Now()
+	if ae.cfg.CollectedAtSource == timeSourceKafka && !msgTime.IsZero() {
+		ingested = msgTime
+	}
+
+	if ae.cfg.MaxClockSkew > 0 && !msgTime.IsZero() {
+		skew := metric.CollectedAt.Sub(msgTime)
+		if skew > ae.cfg.MaxClockSkew || skew < -ae.cfg.MaxClockSkew {
+			seconds := skew.Seconds()
+			metric.ClockSkewSeconds = &seconds
+			log.Printf("Clock skew on %s GPU %d: collected_at is %s from the message timestamp",
+				metric.NodeID, metric.GPUIndex, skew.Round(time.Second))
+		}
+	}
+
+	if ae.cfg.CollectedAtSource != timeSourceCollector {
+		metric.CollectedAt = ingested
+	}
+}
+
+// Error kinds. Pipeline errors are tagged with one so retry and
+// offset-commit decisions branch on errors.Is rather than on messages;
+// untagged errors are neither retried in place nor treated as permanent.
+var (
+	// ErrRetriable marks a transient failure, such as a lost database
+	// connection, a timeout or an unreachable schema registry, that may
+	// succeed if the same work is retried
+	ErrRetriable = errors.New("retriable")
+	// ErrFatal marks a failure retrying can't fix, such as a malformed
+	// message or action details
+	ErrFatal = errors.New("fatal")
+)
+
+// kindError tags err with an error kind without changing its message
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string   { return e.err.Error() }
+func (e *kindError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// retriable tags err with ErrRetriable
+func retriable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind: ErrRetriable, err: err}
+}
+
+// fatal tags err with ErrFatal
+func fatal(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind: ErrFatal, err: err}
+}
+
+// errStatementTimeout marks a write that hit DBStatementTimeout. Errors
+// carrying it are also ErrRetriable: the consume loop retries them rather
+// than committing past them.
+var errStatementTimeout = errors.New("database statement timed out")
+
+// retriablePQClasses are the Postgres SQLSTATE classes of transient
+// failures: connection exceptions, serialization failures and deadlocks,
+// insufficient resources, and operator intervention (e.g. a restart)
+var retriablePQClasses = map[pq.ErrorClass]bool{"08": true, "40": true, "53": true, "57": true}
+
+// isConnectionError reports whether err means the database was unreachable
+// or shed the statement, rather than rejecting it
+func isConnectionError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return retriablePQClasses[pqErr.Code.Class()]
+	}
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr)
+}
+
+// statementContext bounds a single statement by DBStatementTimeout
+func (ae *AlertEngine) statementContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, ae.cfg.DBStatementTimeout)
+}
+
+// dbError tags a statement's error ErrRetriable when the statement's
+// deadline passed, wrapping it with errStatementTimeout, or when the
+// database was unreachable
+func dbError(stmtCtx context.Context, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(stmtCtx.Err(), context.DeadlineExceeded):
+		return retriable(fmt.Errorf("%w: %v", errStatementTimeout, err))
+	case isConnectionError(err):
+		return retriable(err)
+	}
+	return err
+}
+
+// retryRetriable runs fn, retrying with backoff while it fails with
+// ErrRetriable and ctx is live
+func (ae *AlertEngine) retryRetriable(ctx context.Context, what string, fn func() error) error {
+	err := fn()
+	for errors.Is(err, ErrRetriable) && ctx.Err() == nil {
+		log.Printf("%s failed, retrying: %v", what, err)
+		ae.backoffOnError(ctx)
+		err = fn()
+	}
+	return err
+}
+
+// Metric store modes
+const (
+	storeModeSync  = "sync"
+	storeModeAsync = "async"
+)
+
+// insertMetricQuery writes one row to gpu_metrics; see insertMetricArgs
+const insertMetricQuery = `
+	INSERT INTO gpu_metrics (
+		node_id, gpu_index, temperature_celsius, power_watts,
+		memory_used_mb, memory_total_mb, utilization_percent,
+		sm_clock_mhz, fan_speed_percent, row_remap_pending, collected_at,
+		clock_skew_seconds, labels, histograms, partial, gpu_uuid
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+`
+
+// StoreMetric saves metric to database
+func (ae *AlertEngine) StoreMetric(ctx context.Context, metric GPUMetric) error {
+	stmtCtx, cancel := ae.statementContext(ctx)
+	defer cancel()
+
+	_, err := ae.db.ExecContext(stmtCtx, insertMetricQuery, insertMetricArgs(ae.storedMetric(metric))...)
+	return dbError(stmtCtx, err)
+}
+
+// StoreMetrics saves a batch of metrics in one transaction
+func (ae *AlertEngine) StoreMetrics(ctx context.Context, metrics []GPUMetric) error {
+	stmtCtx, cancel := ae.statementContext(ctx)
+	defer cancel()
+
+	tx, err := ae.db.BeginTx(stmtCtx, nil)
+	if err != nil {
+		return dbError(stmtCtx, err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(stmtCtx, insertMetricQuery)
+	if err != nil {
+		return dbError(stmtCtx, err)
+	}
+	defer stmt.Close()
+
+	for _, metric := range metrics {
+		if _, err := stmt.ExecContext(stmtCtx, insertMetricArgs(ae.storedMetric(metric))...); err != nil {
+			return dbError(stmtCtx, err)
+		}
+	}
+	return dbError(stmtCtx, tx.Commit())
+}
+
+// insertMetricArgs returns the insertMetricQuery parameters for metric
+func insertMetricArgs(metric GPUMetric) []any {
+	var labels, histograms []byte
+	if len(metric.Labels) > 0 {
+		labels, _ = json.Marshal(metric.Labels)
+	}
+	if len(metric.Histograms) > 0 {
+		histograms, _ = json.Marshal(metric.Histograms)
+	}
+
+	return []any{
+		metric.NodeID,
+		metric.GPUIndex,
+		metric.TemperatureCelsius,
+		reportedReading(metric, "power_watts", metric.PowerWatts),
+		metric.MemoryUsedMB,
+		metric.MemoryTotalMB,
+		metric.UtilizationPercent,
+		metric.SMClockMHz,
+		reportedReading(metric, "fan_speed_percent", metric.FanSpeedPercent),
+		metric.RowRemapPending,
+		metric.CollectedAt,
+		metric.ClockSkewSeconds,
+		labels,
+		histograms,
+		!memoryKnown(metric),
+		sql.NullString{String: metric.GPUUUID, Valid: metric.GPUUUID != ""},
+	}
+}
+
+// reportedReading stores an optional field as NULL when the source didn't
+// report it, rather than as a zero reading
+func reportedReading(metric GPUMetric, field string, v float64) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: v, Valid: metric.reported(field)}
+}
+
+// queueMetric hands metric to the async writer, blocking while the queue
+// is full so a slow database throttles consumption instead of growing memory
+func (ae *AlertEngine) queueMetric(ctx context.Context, metric GPUMetric) error {
+	select {
+	case ae.storeQueue <- metric:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runMetricWriter drains storeQueue in batches until ctx is cancelled, then
+// flushes what is left and closes storeDone
+func (ae *AlertEngine) runMetricWriter(ctx context.Context) {
+	defer close(ae.storeDone)
+
+	ticker := time.NewTicker(ae.cfg.StoreFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]GPUMetric, 0, ae.cfg.StoreBatchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := ae.StoreMetrics(ctx, batch); err != nil {
+			ae.storeDropped.Add(int64(len(batch)))
+			log.Printf("Error storing batch of %d metrics, dropping it: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// The consume loop has stopped; write out the remainder
+		drain:
+			for {
+				select {
+				case metric := <-ae.storeQueue:
+					batch = append(batch, metric)
+					if len(batch) == ae.cfg.StoreBatchSize {
+						flush(context.Background())
+					}
+				default:
+					break drain
+				}
+			}
+			flush(context.Background())
+			return
+		case metric := <-ae.storeQueue:
+			batch = append(batch, metric)
+			if len(batch) == ae.cfg.StoreBatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		}
+	}
+}
+
+// Alert statuses written by the engine
+const (
+	alertStatusActive     = "active"
+	alertStatusSuppressed = "suppressed"
+	alertStatusWarmup     = "warmup" // raised during the node's warmup window
+)
+
+// suppressionPattern is the SQL turning a suppression rule's pattern column
+// into a LIKE pattern for ESCAPE '\': %, _ and \ match only themselves and
+// * matches anything
+func suppressionPattern(column string) string {
+	return `replace(replace(replace(replace(` + column + `, '\', '\\'), '%', '\%'), '_', '\_'), '*', '%')`
+}
+
+// IsSuppressed reports whether a suppression rule matches the alert. Rules
+// match alert_type and node_id with * wildcards and optionally scope to the
+// node's datacenter.
+func (ae *AlertEngine) IsSuppressed(ctx context.Context, alert Alert) (bool, error) {
+	stmtCtx, cancel := ae.statementContext(ctx)
+	defer cancel()
+
+	var suppressed bool
+	err := ae.db.QueryRowContext(stmtCtx, fmt.Sprintf(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM suppression_rules s
+			LEFT JOIN gpu_nodes n ON n.node_id = $1
+			WHERE $2 LIKE %s ESCAPE '\'
+			  AND (s.node_id IS NULL OR $1 LIKE %s ESCAPE '\')
+			  AND (s.datacenter IS NULL OR s.datacenter = n.datacenter)
+		)
+	`, suppressionPattern("s.alert_type"), suppressionPattern("s.node_id")), alert.NodeID, alert.AlertType).Scan(&suppressed)
+	return suppressed, dbError(stmtCtx, err)
+}
+
+// severityBounds returns the DatacenterSeverity bounds of the node's
+// datacenter, or none when it has no entry
+func (ae *AlertEngine) severityBounds(ctx context.Context, nodeID string) (severityBounds, error) {
+	if len(ae.cfg.DatacenterSeverity) == 0 {
+		return severityBounds{}, nil
+	}
+
+	stmtCtx, cancel := ae.statementContext(ctx)
+	defer cancel()
+
+	var datacenter sql.NullString
+	err := ae.db.QueryRowContext(stmtCtx,
+		"SELECT datacenter FROM gpu_nodes WHERE node_id = $1", nodeID,
+	).Scan(&datacenter)
+	if err == sql.ErrNoRows {
+		return severityBounds{}, nil
+	}
+	if err != nil {
+		return severityBounds{}, dbError(stmtCtx, err)
+	}
+	return ae.cfg.DatacenterSeverity[datacenter.String], nil
+}
+
+// insertAlert writes an alert row with the given status and returns its ID.
+// An active alert also records a firing in alert_firings for escalation.
+func (ae *AlertEngine) insertAlert(ctx context.Context, alert Alert, status string) (int, error) {
+	query := `
+		WITH inserted AS (
+			INSERT INTO alerts (
+				node_id, gpu_index, alert_type, severity, message,
+				threshold_value, actual_value, status, dedup_key, runbook_url
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''))
+			RETURNING id, dedup_key, status
+		), firing AS (
+			INSERT INTO alert_firings (alert_id, dedup_key)
+			SELECT id, dedup_key FROM inserted WHERE status = 'active'
+		)
+		SELECT id FROM inserted
+	`
+
+	stmtCtx, cancel := ae.statementContext(ctx)
+	defer cancel()
+
+	var alertID int
+	err := ae.db.QueryRowContext(stmtCtx, query,
+		alert.NodeID,
+		alert.GPUIndex,
+		alert.AlertType,
+		alert.Severity,
+		alert.Message,
+		alert.ThresholdValue,
+		alert.ActualValue,
+		status,
+		alert.DedupKey(),
+		alert.RunbookURL,
+	).Scan(&alertID)
+
+	return alertID, dbError(stmtCtx, err)
+}
+
+// RecordWarmupAlert records an alert raised while its node is warming up.
+// Like suppressed alerts it is kept for visibility but never notified.
+func (ae *AlertEngine) RecordWarmupAlert(ctx context.Context, alert Alert) error {
+	alertID, err := ae.insertAlert(ctx, alert, alertStatusWarmup)
+	if err != nil {
+		return err
+	}
+	log.Printf("Warmup alert ID=%d: [%s] %s on %s GPU %d",
+		alertID, alert.Severity, alert.AlertType, alert.NodeID, alert.GPUIndex)
+	return nil
+}
+
+// inWarmup reports whether a node is within its warmup window. The window
+// starts at the node's registration (gpu_nodes.created_at) and restarts
+// whenever the node reappears after a gap longer than WarmupRestartGap.
+func (ae *AlertEngine) inWarmup(ctx context.Context, metric GPUMetric) bool {
+	if ae.cfg.WarmupPeriod <= 0 {
+		return false
+	}
+
+	nodeID := metric.NodeID
+	lastSeen, seen := ae.nodeLastSeen[nodeID]
+	ae.nodeLastSeen[nodeID] = metric.CollectedAt
+
+	switch {
+	case !seen:
+		start := metric.CollectedAt
+		stmtCtx, cancel := ae.statementContext(ctx)
+		err := ae.db.QueryRowContext(stmtCtx,
+			"SELECT created_at FROM gpu_nodes WHERE node_id = $1", nodeID,
+		).Scan(&start)
+		cancel()
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Failed to read registration time of %s: %v", nodeID, err)
+		}
+		ae.nodeWarmupStart[nodeID] = start
+	case metric.CollectedAt.Sub(lastSeen) > ae.cfg.WarmupRestartGap:
+		log.Printf("Node %s reappeared after %s, starting %s warmup",
+			nodeID, metric.CollectedAt.Sub(lastSeen).Round(time.Second), ae.cfg.WarmupPeriod)
+		ae.nodeWarmupStart[nodeID] = metric.CollectedAt
+	}
+
+	return metric.CollectedAt.Sub(ae.nodeWarmupStart[nodeID]) < ae.cfg.WarmupPeriod
+}
+
+// escalate handles a repeating warning once it has fired EscalationCount
+// times within EscalationWindow. Firings are counted from alert_firings, so
+// an alert reopened by DedupWindow counts each time it re-fires. The GPU's newest active alert of that type
+// is raised to critical in place, with critical actions taken, and handled is
+// true so no new alert is created; later repeats are absorbed into it. With
+// no active alert left to update, alert itself is raised to critical and
+// created as usual.
+func (ae *AlertEngine) escalate(ctx context.Context, alert *Alert) (handled bool, err error) {
+	stmtCtx, cancel := ae.statementContext(ctx)
+	defer cancel()
+
+	var fired int
+	err = ae.db.QueryRowContext(stmtCtx, `
+		SELECT COUNT(*)
+		FROM alert_firings
+		WHERE dedup_key = $1 AND fired_at > NOW() - make_interval(secs => $2)
+	`, alert.DedupKey(), ae.cfg.EscalationWindow.Seconds()).Scan(&fired)
+	if err != nil {
+		return false, dbError(stmtCtx, err)
+	}
+	// This firing counts too
+	if fired+1 < ae.cfg.EscalationCount {
+		return false, nil
+	}
+
+	escalated := *alert
+	escalated.Severity = "critical"
+	escalated.Message = fmt.Sprintf("%s (escalated after %d occurrences within %s)",
+		alert.Message, fired+1, ae.cfg.EscalationWindow)
+
+	var alertID int
+	var previous string
+	err = ae.db.QueryRowContext(stmtCtx, `
+		WITH target AS (
+			SELECT id, severity
+			FROM alerts
+			WHERE dedup_key = $1 AND status = 'active'
+			ORDER BY triggered_at DESC
+			LIMIT 1
+			FOR UPDATE
+		)
+		UPDATE alerts a
+		SET severity = $2, message = $3, actual_value = $4
+		FROM target
+		WHERE a.id = target.id
+		RETURNING a.id, target.severity
+	`, alert.DedupKey(), escalated.Severity, escalated.Message, escalated.ActualValue).Scan(&alertID, &previous)
+	if err == sql.ErrNoRows {
+		*alert = escalated
+		return false, nil
+	}
+	if err != nil {
+		return false, dbError(stmtCtx, err)
+	}
+	if previous == escalated.Severity {
+		// Already escalated; this repeat only refreshed it
+		return true, nil
+	}
+
+	log.Printf("Escalated alert ID=%d to critical: %s on %s GPU %d fired %d times within %s",
+		alertID, alert.AlertType, alert.NodeID, alert.GPUIndex, fired+1, ae.cfg.EscalationWindow)
+
+	if ae.alertWriter != nil {
+		if err := ae.PublishAlert(ctx, alertID, escalated); err != nil {
+			log.Printf("Failed to publish alert ID=%d to %s: %v", alertID, ae.cfg.AlertTopic, err)
+		}
+	}
+	return true, ae.TakeAction(alertID, escalated)
+}
+
+// reopenRecent reactivates the newest alert with alert's dedup key that was
+// resolved within DedupWindow, updating it to the new firing. triggered_at
+// restarts so auto_resolve_after counts from the reopening rather than
+// timing the alert out again on the next sweep, and the reopening is
+// recorded in alert_firings. reopened is false when there is none, or when
+// the same alert is already active again.
+func (ae *AlertEngine) reopenRecent(ctx context.Context, alert Alert) (alertID int, reopened bool, err error) {
+	stmtCtx, cancel := ae.statementContext(ctx)
+	defer cancel()
+
+	err = ae.db.QueryRowContext(stmtCtx, `
+		WITH target AS (
+			SELECT id
+			FROM alerts
+			WHERE dedup_key = $1 AND status = 'resolved'
+			  AND resolved_at > NOW() - make_interval(secs => $2)
+			  AND NOT EXISTS (
+				SELECT 1 FROM alerts
+				WHERE dedup_key = $1 AND status = 'active'
+			  )
+			ORDER BY resolved_at DESC
+			LIMIT 1
+			FOR UPDATE
+		), reopened AS (
+			UPDATE alerts a
+			SET status = 'active', resolved_at = NULL, resolution = NULL,
+			    acknowledged_at = NULL, acknowledged_by = NULL, triggered_at = NOW(),
+			    severity = $3, message = $4, threshold_value = $5, actual_value = $6
+			FROM target
+			WHERE a.id = target.id
+			RETURNING a.id, a.dedup_key
+		)
+		INSERT INTO alert_firings (alert_id, dedup_key)
+		SELECT id, dedup_key FROM reopened
+		RETURNING alert_id
+	`, alert.DedupKey(), ae.cfg.DedupWindow.Seconds(), alert.Severity, alert.Message,
+		alert.ThresholdValue, alert.ActualValue).Scan(&alertID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, dbError(stmtCtx, err)
+	}
+	return alertID, true, nil
+}
+
+// loadAlert reads a stored alert back for re-notification
+func (ae *AlertEngine) loadAlert(alertID int) (Alert, error) {
+	var alert Alert
+	err := ae.db.QueryRow(`
+		SELECT node_id, gpu_index, alert_type, severity, message,
+		       COALESCE(threshold_value, 0), COALESCE(actual_value, 0),
+		       COALESCE(runbook_url, '')
+		FROM alerts
+		WHERE id = $1
+	`, alertID).Scan(&alert.NodeID, &alert.GPUIndex, &alert.AlertType, &alert.Severity,
+		&alert.Message, &alert.ThresholdValue, &alert.ActualValue, &alert.RunbookURL)
+	if err != nil {
+		return Alert{}, fmt.Errorf("failed to load alert %d: %w", alertID, err)
+	}
+	return alert, nil
+}
+
+// CreateAlert saves alert to database
+func (ae *AlertEngine) CreateAlert(ctx context.Context, alert Alert) error {
+	status := alertStatusActive
+	suppressed, err := ae.IsSuppressed(ctx, alert)
+	if err != nil {
+		log.Printf("Failed to check suppression rules, alerting anyway: %v", err)
+	} else if suppressed {
+		status = alertStatusSuppressed
+	}
+
+	bounds, err := ae.severityBounds(ctx, alert.NodeID)
+	if err != nil {
+		log.Printf("Failed to look up the datacenter of %s, leaving severity unclamped: %v", alert.NodeID, err)
+	}
+	alert.Severity = bounds.clamp(alert.Severity)
+
+	if status == alertStatusActive && alert.Severity == "warning" && ae.cfg.EscalationCount > 0 &&
+		bounds.clamp("critical") == "critical" {
+		handled, err := ae.escalate(ctx, &alert)
+		if err != nil {
+			return err
+		}
+		if handled {
+			return nil
+		}
+	}
+
+	if severityRank[alert.Severity] < severityRank[ae.cfg.MinPersistSeverity] {
+		ae.notPersisted[severityRank[alert.Severity]].Add(1)
+		return nil
+	}
+
+	var alertID int
+	reopened := false
+	if status == alertStatusActive && ae.cfg.DedupWindow > 0 {
+		if alertID, reopened, err = ae.reopenRecent(ctx, alert); err != nil {
+			return err
+		}
+	}
+	if !reopened {
+		if alertID, err = ae.insertAlert(ctx, alert, status); err != nil {
+			return err
+		}
+	}
+
+	if status == alertStatusSuppressed {
+		// Recorded for visibility, but never active and never notified
+		log.Printf("Suppressed alert ID=%d: [%s] %s on %s GPU %d",
+			alertID, alert.Severity, alert.AlertType, alert.NodeID, alert.GPUIndex)
+		return nil
+	}
+
+	if reopened {
+		log.Printf("Reopened alert ID=%d: [%s] %s on %s GPU %d re-fired within %s of resolving",
+			alertID, alert.Severity, alert.AlertType, alert.NodeID, alert.GPUIndex, ae.cfg.DedupWindow)
+	} else {
+		log.Printf("Created alert ID=%d: [%s] %s on %s GPU %d",
+			alertID, alert.Severity, alert.AlertType, alert.NodeID, alert.GPUIndex)
+	}
+	ae.markAlerting(alert.NodeID, alert.GPUIndex)
+	ae.trackAlertRate()
+
+	if ae.alertWriter != nil {
+		if err := ae.PublishAlert(ctx, alertID, alert); err != nil {
+			log.Printf("Failed to publish alert ID=%d to %s: %v", alertID, ae.cfg.AlertTopic, err)
+		}
+	}
+
+	// Take automated actions based on severity
+	return ae.TakeAction(alertID, alert)
+}
+
+// lagCheckInterval is how often consumer lag is compared with
+// ConsumerLagAlertThreshold
+const lagCheckInterval = 15 * time.Second
+
+// watchConsumerLag raises a consumer_lag alert against SelfNodeID once lag
+// has stayed at or above ConsumerLagAlertThreshold for ConsumerLagAlertFor,
+// and resolves it once lag is back under the threshold
+func (ae *AlertEngine) watchConsumerLag(ctx context.Context) {
+	ticker := time.NewTicker(lagCheckInterval)
+	defer ticker.Stop()
+
+	var highSince time.Time
+	// An alert left active by a previous run is resolved at the first
+	// healthy check and not raised again while lag stays high
+	alerted := ae.selfAlertActive(ctx, "consumer_lag")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		lag := ae.consumerLag()
+		threshold := int64(ae.cfg.ConsumerLagAlertThreshold)
+		if lag < threshold {
+			highSince = time.Time{}
+			if alerted {
+				if err := ae.resolveSelfAlerts(ctx, "consumer_lag"); err != nil {
+					log.Printf("Failed to resolve consumer_lag alert: %v", err)
+					continue
+				}
+				alerted = false
+			}
+			continue
+		}
+
+		if highSince.IsZero() {
+			highSince = time.Now()
+		}
+		if alerted || time.Since(highSince) < ae.cfg.ConsumerLagAlertFor {
+			continue
+		}
+		if err := ae.registerSelfNode(ctx); err != nil {
+			log.Printf("Failed to register %s for the consumer_lag alert: %v", ae.cfg.SelfNodeID, err)
+			continue
+		}
+		err := ae.CreateAlert(ctx, Alert{
+			NodeID:    ae.cfg.SelfNodeID,
+			GPUIndex:  nodeWideGPU,
+			AlertType: "consumer_lag",
+			Severity:  "critical",
+			Message: fmt.Sprintf("Alert engine is %d messages behind on %s (threshold %d for %s); alerts are delayed",
+				lag, ae.cfg.KafkaTopic, threshold, ae.cfg.ConsumerLagAlertFor),
+			ThresholdValue: float64(threshold),
+			ActualValue:    float64(lag),
+		})
+		if err != nil {
+			log.Printf("Failed to create consumer_lag alert: %v", err)
+			continue
+		}
+		alerted = true
+	}
+}
+
+// idleFor returns how long since a message was last processed, or since
+// startup when none has been, and whether one has been
+func (ae *AlertEngine) idleFor(now time.Time) (time.Duration, bool) {
+	last := ae.lastProcessed.Load()
+	if last == 0 {
+		return now.Sub(ae.startedAt), false
+	}
+	return now.Sub(time.Unix(0, last)), true
+}
+
+// watchDeadman raises a pipeline_stalled alert against SelfNodeID once no
+// message has been processed for DeadmanAfter, and resolves it when one is
+func (ae *AlertEngine) watchDeadman(ctx context.Context) {
+	ticker := time.NewTicker(lagCheckInterval)
+	defer ticker.Stop()
+
+	// An alert left active by a previous run is resolved once messages
+	// flow again; without one, a stall that predates startup is raised
+	// once DeadmanAfter has passed since startup
+	alerted := ae.selfAlertActive(ctx, "pipeline_stalled")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		idle, processed := ae.idleFor(time.Now())
+		if idle < ae.cfg.DeadmanAfter {
+			// Before the first message, not having stalled yet says
+			// nothing about whether the pipeline recovered
+			if alerted && processed {
+				if err := ae.resolveSelfAlerts(ctx, "pipeline_stalled"); err != nil {
+					log.Printf("Failed to resolve pipeline_stalled alert: %v", err)
+					continue
+				}
+				alerted = false
+			}
+			continue
+		}
+		if alerted {
+			continue
+		}
+		if err := ae.registerSelfNode(ctx); err != nil {
+			log.Printf("Failed to register %s for the pipeline_stalled alert: %v", ae.cfg.SelfNodeID, err)
+			continue
+		}
+		err := ae.CreateAlert(ctx, Alert{
+			NodeID:    ae.cfg.SelfNodeID,
+			GPUIndex:  nodeWideGPU,
+			AlertType: "pipeline_stalled",
+			Severity:  "critical",
+			Message: fmt.Sprintf("No telemetry processed from %s for %s (limit %s); the collectors, Kafka or the alert engine may be down",
+				ae.cfg.KafkaTopic, idle.Round(time.Second), ae.cfg.DeadmanAfter),
+			ThresholdValue: ae.cfg.DeadmanAfter.Seconds(),
+			ActualValue:    idle.Seconds(),
+		})
+		if err != nil {
+			log.Printf("Failed to create pipeline_stalled alert: %v", err)
+			continue
+		}
+		alerted = true
+	}
+}
+
+// watchHeartbeat writes the last processed time to pipeline_heartbeats
+// every HeartbeatInterval while it advances, so the pipeline's liveness
+// can be checked from the database alone
+func (ae *AlertEngine) watchHeartbeat(ctx context.Context) {
+	ticker := time.NewTicker(ae.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	instance, _ := os.Hostname()
+	var written int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		last := ae.lastProcessed.Load()
+		if last == 0 || last == written {
+			continue
+		}
+		stmtCtx, cancel := ae.statementContext(ctx)
+		_, err := ae.db.ExecContext(stmtCtx, `
+			INSERT INTO pipeline_heartbeats (component, instance, last_processed_at, updated_at)
+			VALUES ($1, $2, $3, NOW())
+			ON CONFLICT (component, instance) DO UPDATE
+			SET last_processed_at = GREATEST(pipeline_heartbeats.last_processed_at, EXCLUDED.last_processed_at),
+			    updated_at = NOW()
+		`, ae.cfg.SelfNodeID, instance, time.Unix(0, last))
+		err = dbError(stmtCtx, err)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Failed to write pipeline heartbeat: %v", err)
+			}
+			continue
+		}
+		written = last
+	}
+}
+
+// watchIngestRates writes every node's rate to node_ingest_rates each
+// IngestRateInterval, one row per node and engine instance since each
+// instance only sees the nodes on its partitions. Rows of nodes this
+// instance no longer receives are deleted.
+func (ae *AlertEngine) watchIngestRates(ctx context.Context) {
+	ticker := time.NewTicker(ae.cfg.IngestRateInterval)
+	defer ticker.Stop()
+
+	instance, _ := os.Hostname()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		rates := ae.ingest.Rates(time.Now())
+		nodes := make([]string, len(rates))
+		samples := make([]int64, len(rates))
+		gpus := make([]int64, len(rates))
+		for i, rate := range rates {
+			nodes[i], samples[i], gpus[i] = rate.NodeID, rate.Samples, int64(rate.GPUs)
+		}
+
+		stmtCtx, cancel := ae.statementContext(ctx)
+		err := ae.writeIngestRates(stmtCtx, instance, nodes, samples, gpus)
+		err = dbError(stmtCtx, err)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to write node ingest rates: %v", err)
+		}
+	}
+}
+
+// writeIngestRates replaces instance's rows in node_ingest_rates
+func (ae *AlertEngine) writeIngestRates(ctx context.Context, instance string, nodes []string, samples, gpus []int64) error {
+	tx, err := ae.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM node_ingest_rates WHERE instance = $1 AND NOT (node_id = ANY($2))
+	`, instance, pq.Array(nodes)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO node_ingest_rates (node_id, instance, samples_per_minute, gpus, updated_at)
+		SELECT node_id, $1, samples, gpus, NOW()
+		FROM unnest($2::text[], $3::bigint[], $4::bigint[]) AS r(node_id, samples, gpus)
+		ON CONFLICT (node_id, instance) DO UPDATE
+		SET samples_per_minute = EXCLUDED.samples_per_minute, gpus = EXCLUDED.gpus, updated_at = NOW()
+	`, instance, pq.Array(nodes), pq.Array(samples), pq.Array(gpus)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// registerSelfNode adds SelfNodeID to gpu_nodes so self-monitoring alerts
+// can reference it. It is marked is_gpu = FALSE so the API leaves it out of
+// the fleet's node lists and counts.
+func (ae *AlertEngine) registerSelfNode(ctx context.Context) error {
+	hostname, _ := os.Hostname()
+	stmtCtx, cancel := ae.statementContext(ctx)
+	defer cancel()
+	_, err := ae.db.ExecContext(stmtCtx, `
+		INSERT INTO gpu_nodes (node_id, hostname, datacenter, is_gpu)
+		VALUES ($1, $2, 'pipeline', FALSE)
+		ON CONFLICT (node_id) DO UPDATE SET is_gpu = FALSE
+	`, ae.cfg.SelfNodeID, hostname)
+	return dbError(stmtCtx, err)
+}
+
+// selfAlertActive reports whether SelfNodeID has an active alert of
+// alertType, assuming not when that cannot be checked
+func (ae *AlertEngine) selfAlertActive(ctx context.Context, alertType string) bool {
+	stmtCtx, cancel := ae.statementContext(ctx)
+	defer cancel()
+	var active bool
+	err := ae.db.QueryRowContext(stmtCtx, `
+		SELECT EXISTS (SELECT 1 FROM alerts WHERE node_id = $1 AND alert_type = $2 AND status = 'active')
+	`, ae.cfg.SelfNodeID, alertType).Scan(&active)
+	if err != nil {
+		log.Printf("Failed to check for an active %s alert: %v", alertType, dbError(stmtCtx, err))
+		return false
+	}
+	return active
+}
+
+// resolveSelfAlerts resolves SelfNodeID's active alerts of alertType as recovered
+func (ae *AlertEngine) resolveSelfAlerts(ctx context.Context, alertType string) error {
+	stmtCtx, cancel := ae.statementContext(ctx)
+	defer cancel()
+	res, err := ae.db.ExecContext(stmtCtx, `
+		UPDATE alerts
+		SET status = 'resolved', resolved_at = NOW(), resolution = $3
+		WHERE node_id = $1 AND alert_type = $2 AND status = 'active'
+	`, ae.cfg.SelfNodeID, alertType, resolutionRecovered)
+	if err != nil {
+		return dbError(stmtCtx, err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Resolved %d %s alert(s) on %s as recovered", n, alertType, ae.cfg.SelfNodeID)
+	}
+	return nil
+}
+
+// trackAlertRate counts a created alert, which may start an alert storm
+func (ae *AlertEngine) trackAlertRate() {
+	rate := ae.alertRate.Add(time.Now())
+	if ae.cfg.AlertStormThreshold > 0 {
+		ae.updateAlertStorm(rate)
+	}
+}
+
+// alertStormCheckInterval is how often watchAlertStorm rechecks the rate,
+// so a storm is declared over even when no further alert is created
+const alertStormCheckInterval = 10 * time.Second
+
+// watchAlertStorm rechecks the alert rate every alertStormCheckInterval
+func (ae *AlertEngine) watchAlertStorm(ctx context.Context) {
+	ticker := time.NewTicker(alertStormCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ae.updateAlertStorm(ae.alertRate.Count(time.Now()))
+	}
+}
+
+// updateAlertStorm compares the alerts created over the last minute with
+// AlertStormThreshold and announces the start and end of a storm on every
+// channel that receives critical alerts. Each storm has its own dedup key,
+// and its end notice a distinct one, so receivers that deduplicate by key
+// neither drop the end notice nor fold a later storm into an earlier one.
+func (ae *AlertEngine) updateAlertStorm(rate int64) {
+	threshold := ae.cfg.AlertStormThreshold
+	storm := rate >= int64(threshold)
+	if ae.inStorm.Swap(storm) == storm {
+		return
+	}
+
+	var message string
+	if storm {
+		ae.stormStarted.Store(time.Now().UnixMilli())
+		message = fmt.Sprintf("Alert storm: %d alerts created in the last minute (threshold %d); check for a bad deploy or fleet-wide event", rate, threshold)
+		if ae.cfg.AlertStormPauseNotifications {
+			message += ". Per-alert notifications are paused until it subsides."
+		}
+	} else {
+		message = fmt.Sprintf("Alert storm over: %d alerts in the last minute (threshold %d)", rate, threshold)
+		if ae.cfg.AlertStormPauseNotifications {
+			message += ". Per-alert notifications resumed; see the active alerts list for what was held back."
+		}
+	}
+	log.Println(message)
+
+	dedupKey := alertStormDedupKey(ae.stormStarted.Load(), !storm)
+	for _, ch := range ae.channels {
+		if !ch.wants("critical") {
+			continue
+		}
+		if err := ch.send(message, dedupKey); err != nil {
+			log.Printf("Failed to send alert storm notice to %s: %v", ch.Name, err)
+		}
+	}
+}
+
+// alertStormDedupKey is the dedup key of the storm started at started (Unix
+// milliseconds): alert_storm-<started>, with -over appended for its end notice
+func alertStormDedupKey(started int64, over bool) string {
+	key := fmt.Sprintf("alert_storm-%d", started)
+	if over {
+		key += "-over"
+	}
+	return key
+}
+
+// AlertEvent is the message published to the alert topic for each new alert
+type AlertEvent struct {
+	ID             int       `json:"id"`
+	NodeID         string    `json:"node_id"`
+	GPUIndex       int       `json:"gpu_index"`
+	AlertType      string    `json:"alert_type"`
+	Severity       string    `json:"severity"`
+	Message        string    `json:"message"`
+	ThresholdValue float64   `json:"threshold_value"`
+	ActualValue    float64   `json:"actual_value"`
+	Status         string    `json:"status"`
+	TriggeredAt    time.Time `json:"triggered_at"`
+	DedupKey       string    `json:"dedup_key"`
+	RunbookURL     string    `json:"runbook_url,omitempty"`
+}
+
+// PublishAlert writes a created alert to the alert topic so downstream
+// systems can subscribe instead of polling the API
+func (ae *AlertEngine) PublishAlert(ctx context.Context, alertID int, alert Alert) error {
+	data, err := json.Marshal(AlertEvent{
+		ID:             alertID,
+		NodeID:         alert.NodeID,
+		GPUIndex:       alert.GPUIndex,
+		AlertType:      alert.AlertType,
+		Severity:       alert.Severity,
+		Message:        alert.Message,
+		ThresholdValue: alert.ThresholdValue,
+		ActualValue:    alert.ActualValue,
+		Status:         alertStatusActive,
+		TriggeredAt:    time.Now().UTC(),
+		DedupKey:       alert.DedupKey(),
+		RunbookURL:     alert.RunbookURL,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+
+	return ae.alertWriter.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(fmt.Sprintf("%s-gpu-%d", alert.NodeID, alert.GPUIndex)),
+		Value: data,
+	})
+}
+
+// Notification channel types
+const (
+	channelSlack   = "slack"   // Slack incoming webhook, {"text": ..., "dedup_key": ...}
+	channelWebhook = "webhook" // generic JSON webhook, {"text": ..., "dedup_key": ...}
+	channelLog     = "log"     // log only, for development
+)
+
+// Notification formats, each a built-in template
+var notifyFormats = map[string]string{
+	"terse": `{{upper .Alert.Severity}} {{.Alert.AlertType}} {{.Alert.NodeID}}/gpu{{.Alert.GPUIndex}}` +
+		`{{if .Include.value}} value={{printf "%.1f" .Alert.ActualValue}}{{end}}` +
+		`{{if .Include.threshold}} threshold={{printf "%.1f" .Alert.ThresholdValue}}{{end}}` +
+		`{{if and .Include.runbook .Runbook}} {{.Runbook}}{{end}}`,
+	"verbose": `[{{upper .Alert.Severity}}] {{.Alert.AlertType}} on {{.Alert.NodeID}} GPU {{.Alert.GPUIndex}}` +
+		`{{if .Include.message}}
+{{.Alert.Message}}{{end}}` +
+		`{{if .Include.value}}
+Value: {{printf "%.1f" .Alert.ActualValue}}{{end}}` +
+		`{{if .Include.threshold}}
+Threshold: {{printf "%.1f" .Alert.ThresholdValue}}{{end}}` +
+		`{{if .Include.time}}
+Triggered: {{.Time.Format "2006-01-02T15:04:05Z07:00"}}{{end}}` +
+		`{{if and .Include.runbook .Runbook}}
+Runbook: {{.Runbook}}{{end}}`,
+}
+
+// notifyFormatDefaults lists the fields each format includes unless a
+// channel overrides them with include/exclude
+var notifyFormatDefaults = map[string][]string{
+	"terse":   {"value", "runbook"},
+	"verbose": {"message", "value", "threshold", "runbook"},
+}
+
+// notifyFields are the optional fields a channel can include or exclude
+var notifyFields = map[string]bool{
+	"message": true, "value": true, "threshold": true, "time": true, "runbook": true,
+}
+
+// NotifyChannel is a notification destination with its own message format
+type NotifyChannel struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	URL  string `json:"url,omitempty"`
+	// Severities the channel receives (default warning)
+	Severities []string `json:"severities,omitempty"`
+	// Format is terse or verbose; Template, when set, replaces it with a
+	// custom text/template over .Alert, .Runbook, .Include and .Time
+	// (.Alert.DedupKey gives the alert's dedup key)
+	Format   string `json:"format,omitempty"`
+	Template string `json:"template,omitempty"`
+	// Include and Exclude adjust the format's default fields
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+
+	tmpl   *template.Template
+	fields map[string]bool
+}
+
+// notifyData is the template input for one notification
+type notifyData struct {
+	Alert   Alert
+	Runbook string
+	Include map[string]bool
+	Time    time.Time
+}
+
+// prepare validates the channel and compiles its template
+func (ch *NotifyChannel) prepare() error {
+	if ch.Name == "" {
+		return fmt.Errorf("notification channel missing name")
+	}
+	switch ch.Type {
+	case channelSlack, channelWebhook:
+		if ch.URL == "" {
+			return fmt.Errorf("channel %s: url is required for type %s", ch.Name, ch.Type)
+		}
+	case channelLog:
+	default:
+		return fmt.Errorf("channel %s: type must be one of slack, webhook, log; got %q", ch.Name, ch.Type)
+	}
+	if len(ch.Severities) == 0 {
+		ch.Severities = []string{"warning"}
+	}
+	for _, sev := range ch.Severities {
+		if !validSeverities[sev] {
+			return fmt.Errorf("channel %s: unknown severity %q", ch.Name, sev)
+		}
+	}
+
+	if ch.Format == "" {
+		ch.Format = "verbose"
+	}
+	text, ok := notifyFormats[ch.Format]
+	if !ok {
+		return fmt.Errorf("channel %s: format must be terse or verbose; got %q", ch.Name, ch.Format)
+	}
+	if ch.Template != "" {
+		text = ch.Template
+	}
+
+	ch.fields = make(map[string]bool)
+	for _, f := range notifyFormatDefaults[ch.Format] {
+		ch.fields[f] = true
+	}
+	for _, list := range []struct {
+		names []string
+		on    bool
+	}{{ch.Include, true}, {ch.Exclude, false}} {
+		for _, f := range list.names {
+			if !notifyFields[f] {
+				return fmt.Errorf("channel %s: unknown field %q", ch.Name, f)
+			}
+			ch.fields[f] = list.on
+		}
+	}
+
+	tmpl, err := template.New(ch.Name).Funcs(template.FuncMap{"upper": strings.ToUpper}).Parse(text)
+	if err != nil {
+		return fmt.Errorf("channel %s: invalid template: %w", ch.Name, err)
+	}
+	ch.tmpl = tmpl
+	return nil
+}
+
+// wants reports whether the channel receives alerts of a severity
+func (ch *NotifyChannel) wants(severity string) bool {
+	for _, s := range ch.Severities {
+		if s == severity {
+			return true
+		}
+	}
+	return false
+}
+
+// render formats an alert for this channel. The runbook is the alert's own,
+// or <runbookBaseURL>/<alert_type> when it has none.
+func (ch *NotifyChannel) render(alert Alert, runbookBaseURL string) (string, error) {
+	data := notifyData{Alert: alert, Include: ch.fields, Time: time.Now().UTC()}
+	if alert.RunbookURL != "" {
+		data.Runbook = alert.RunbookURL
+	} else if runbookBaseURL != "" {
+		data.Runbook = strings.TrimRight(runbookBaseURL, "/") + "/" + alert.AlertType
+	}
+
+	var b strings.Builder
+	if err := ch.tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// send delivers a rendered message to the channel along with the alert's
+// dedup key, so every channel's receivers can group repeats into one incident
+func (ch *NotifyChannel) send(message, dedupKey string) error {
+	if ch.Type == channelLog {
+		log.Printf("[%s] %s (dedup_key=%s)", ch.Name, message, dedupKey)
+		return nil
+	}
+
+	payload := map[string]string{"text": message}
+	if dedupKey != "" {
+		payload["dedup_key"] = dedupKey
+	}
+	body, _ := json.Marshal(payload)
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(ch.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s webhook request failed: %w", ch.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s webhook returned HTTP %d", ch.Name, resp.StatusCode)
+	}
+	return nil
+}
+
+// loadNotifyChannels reads notification channels from a JSON file. Without
+// a file, warnings go to SLACK_WEBHOOK_URL, or are only logged when unset.
+func loadNotifyChannels(cfg Config) ([]NotifyChannel, error) {
+	var channels []NotifyChannel
+	if cfg.NotifyChannelsFile != "" {
+		data, err := os.ReadFile(cfg.NotifyChannelsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read notification channels: %w", err)
+		}
+		if err := json.Unmarshal(data, &channels); err != nil {
+			return nil, fmt.Errorf("failed to parse notification channels: %w", err)
+		}
+	} else if cfg.SlackWebhookURL != "" {
+		channels = []NotifyChannel{{Name: "slack", Type: channelSlack, URL: cfg.SlackWebhookURL}}
+	} else {
+		channels = []NotifyChannel{{Name: "log", Type: channelLog}}
+	}
+
+	seen := make(map[string]bool)
+	for i := range channels {
+		if err := channels[i].prepare(); err != nil {
+			return nil, err
+		}
+		if seen[channels[i].Name] {
+			return nil, fmt.Errorf("duplicate notification channel %q", channels[i].Name)
+		}
+		seen[channels[i].Name] = true
+	}
+	return channels, nil
+}
+
+// channel looks up a configured notification channel by name
+func (ae *AlertEngine) channel(name string) (*NotifyChannel, bool) {
+	for i := range ae.channels {
+		if ae.channels[i].Name == name {
+			return &ae.channels[i], true
+		}
+	}
+	return nil, false
+}
+
+// Action statuses recorded in alert_actions
+const (
+	actionPending   = "pending"
+	actionSucceeded = "succeeded"
+	actionFailed    = "failed"
+)
+
+// Drainer cordons a node and moves its workloads elsewhere. Drain may be
+// retried for the same node, so implementations must be idempotent.
+type Drainer interface {
+	Name() string
+	Drain(nodeID string, gpuIndex int, reason string) error
+}
+
+// newDrainer returns the webhook drainer when DrainWebhookURL is set, and
+// otherwise one that does nothing
+func newDrainer(cfg Config) Drainer {
+	if cfg.DrainWebhookURL == "" {
+		return noopDrainer{}
+	}
+	return &webhookDrainer{
+		url:    cfg.DrainWebhookURL,
+		token:  cfg.DrainWebhookToken,
+		client: &http.Client{Timeout: cfg.DrainTimeout},
+	}
+}
+
+// noopDrainer leaves workloads in place
+type noopDrainer struct{}
+
+func (noopDrainer) Name() string { return "none" }
+
+func (noopDrainer) Drain(nodeID string, gpuIndex int, reason string) error { return nil }
+
+// webhookDrainer asks an external orchestrator to cordon and drain a node
+// with a JSON POST; any 2xx response counts as done
+type webhookDrainer struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (d *webhookDrainer) Name() string { return "webhook" }
+
+// drainRequest is the body webhookDrainer posts
+type drainRequest struct {
+	Action   string `json:"action"` // always cordon
+	NodeID   string `json:"node_id"`
+	GPUIndex int    `json:"gpu_index"`
+	Reason   string `json:"reason"`
+}
+
+func (d *webhookDrainer) Drain(nodeID string, gpuIndex int, reason string) error {
+	body, _ := json.Marshal(drainRequest{
+		Action:   "cordon",
+		NodeID:   nodeID,
+		GPUIndex: gpuIndex,
+		Reason:   reason,
+	})
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("drain webhook request failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.token != "" {
+		req.Header.Set("Authorization", "Bearer "+d.token)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("drain webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("drain webhook returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// TakeAction performs automated responses to alerts. Each action is recorded
+// as pending, executed immediately, and its real outcome written back; failed
+// attempts are retried by ProcessPendingActions.
+func (ae *AlertEngine) TakeAction(alertID int, alert Alert) error {
+	actions := ae.remediationActions(alert)
+
+	muted, err := ae.nodeMuted(alert.NodeID)
+	if err != nil {
+		return err
+	}
+	if muted {
+		log.Printf("Node %s is muted; not notifying for alert ID=%d", alert.NodeID, alertID)
+	} else if ae.cfg.AlertStormPauseNotifications && ae.inStorm.Load() {
+		log.Printf("Alert storm in progress; not notifying for alert ID=%d", alertID)
+	} else {
+		actions = append(actions, ae.scheduledNotifications(alertID, alert)...)
+	}
+	return ae.recordActions(alertID, actions)
+}
+
+// remediationActions plans the alert's remediation: a critical alert marks
+// its node degraded and migrates its workloads. Self-monitoring alerts
+// under SelfNodeID only notify, since there is nothing on it to drain.
+func (ae *AlertEngine) remediationActions(alert Alert) []plannedAction {
+	if alert.Severity != "critical" || alert.NodeID == ae.cfg.SelfNodeID {
+		return nil
+	}
+	return []plannedAction{{actionType: actionMigration, details: MigrationDetails{
+		Action:   "migrate_workloads",
+		FromNode: alert.NodeID,
+		FromGPU:  alert.GPUIndex,
+		Reason:   alert.Message,
+		Drainer:  ae.drainer.Name(),
+	}}}
+}
+
+// scheduledNotifications plans the alert's notifications under its rule's
+// notify schedule. Critical alerts always notify; others outside the
+// window are deferred until it opens or dropped, per the schedule.
+func (ae *AlertEngine) scheduledNotifications(alertID int, alert Alert) []plannedAction {
+	actions := ae.notificationActions(alert)
+	if alert.Schedule == nil || alert.Severity == "critical" || len(actions) == 0 {
+		return actions
+	}
+	now := time.Now()
+	opens := alert.Schedule.nextOpen(now)
+	if !opens.After(now) {
+		return actions
+	}
+	if alert.Schedule.Outside == scheduleSuppress {
+		log.Printf("Outside notify schedule of %s; not notifying for alert ID=%d", alert.AlertType, alertID)
+		return nil
+	}
+	log.Printf("Outside notify schedule of %s; deferring notifications for alert ID=%d until %s",
+		alert.AlertType, alertID, opens.Format(time.RFC3339))
+	for i := range actions {
+		d := actions[i].details.(NotificationDetails)
+		d.AlertID = alertID
+		actions[i].details = d
+		actions[i].notBefore = opens
+	}
+	return actions
+}
+
+// nodeMuted reports whether the node's alerts are muted via the API, which
+// silences notifications but not alert creation or remediation
+func (ae *AlertEngine) nodeMuted(nodeID string) (bool, error) {
+	var muted bool
+	err := ae.db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM gpu_nodes WHERE node_id = $1 AND muted_until > NOW())", nodeID,
+	).Scan(&muted)
+	if err != nil {
+		return false, fmt.Errorf("failed to check mute for node %s: %w", nodeID, err)
+	}
+	return muted, nil
+}
+
+// Action types recorded in alert_actions. renotify and resolve are queued
+// by the API server.
+const (
+	actionMigration    = "workload_migration"
+	actionNotification = "notification"
+	actionRenotify     = "renotify"
+	actionResolve      = "resolve"
+)
+
+// MigrationDetails are the action_details of a workload_migration action
+type MigrationDetails struct {
+	Action   string `json:"action"` // always migrate_workloads
+	FromNode string `json:"from_node"`
+	FromGPU  int    `json:"from_gpu"`
+	Reason   string `json:"reason"`
+	Drainer  string `json:"drainer"`
+}
+
+// NotificationDetails are the action_details of a notification action;
+// Message is already rendered in the channel's format
+type NotificationDetails struct {
+	Action    string `json:"action"` // always send_notification
+	Channel   string `json:"channel"`
+	Message   string `json:"message"`
+	AlertType string `json:"alert_type"`
+	NodeID    string `json:"node_id"`
+	GPUIndex  int    `json:"gpu_index"`
+	DedupKey  string `json:"dedup_key"`
+	// AlertID is set on notifications deferred by a notify schedule, which
+	// are dropped if the alert resolves before they come due
+	AlertID int `json:"alert_id,omitempty"`
+}
+
+// AlertRefDetails are the action_details of renotify and resolve actions,
+// which act on an existing alert
+type AlertRefDetails struct {
+	Action  string `json:"action"` // the action type
+	AlertID int    `json:"alert_id"`
+}
+
+// plannedAction is an action to record in alert_actions and run; details
+// is one of the *Details types above
+type plannedAction struct {
+	actionType string
+	details    any
+	// notBefore defers the first attempt; zero runs the action at once
+	notBefore time.Time
+}
+
+// notificationActions plans one notification per channel subscribed to the
+// alert's severity, each rendered in that channel's format
+func (ae *AlertEngine) notificationActions(alert Alert) []plannedAction {
+	var actions []plannedAction
+	for _, ch := range ae.channels {
+		if !ch.wants(alert.Severity) {
+			continue
+		}
+		message, err := ch.render(alert, ae.cfg.RunbookBaseURL)
+		if err != nil {
+			log.Printf("Failed to render notification for channel %s: %v", ch.Name, err)
+			continue
+		}
+		actions = append(actions, plannedAction{actionType: actionNotification, details: NotificationDetails{
+			Action:    "send_notification",
+			Channel:   ch.Name,
+			Message:   message,
+			AlertType: alert.AlertType,
+			NodeID:    alert.NodeID,
+			GPUIndex:  alert.GPUIndex,
+			DedupKey:  alert.DedupKey(),
+		}})
+	}
+	return actions
+}
+
+// recordActions logs each action against the alert in alert_actions and
+// runs it; failures are left pending for retry
+func (ae *AlertEngine) recordActions(alertID int, actions []plannedAction) error {
+	for _, a := range actions {
+		// Log action to database
+		detailsJSON, _ := json.Marshal(a.details)
+		var notBefore sql.NullTime
+		if !a.notBefore.IsZero() {
+			notBefore = sql.NullTime{Time: a.notBefore, Valid: true}
+		}
+		var actionID int
+		err := ae.db.QueryRow(`
+			INSERT INTO alert_actions (alert_id, action_type, action_status, action_details, next_attempt_at)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id
+		`, alertID, a.actionType, actionPending, detailsJSON, notBefore).Scan(&actionID)
+		if err != nil {
+			return err
+		}
+		if notBefore.Valid {
+			// Left for the retry worker once next_attempt_at comes due
+			continue
+		}
+
+		if a.actionType == actionNotification && ae.notifyQueue != nil {
+			ae.queueNotification(notifyJob{actionID: actionID, details: detailsJSON})
+			continue
+		}
+		if err := ae.runAction(actionID, a.actionType, detailsJSON, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyJob is a recorded notification action waiting for a worker
+type notifyJob struct {
+	actionID int
+	details  []byte
+}
+
+// queueNotification hands a notification to the worker pool. When the queue
+// is full the action stays pending and the retry worker sends it later, so
+// a burst never blocks the consumer or spawns unbounded sends.
+func (ae *AlertEngine) queueNotification(job notifyJob) {
+	select {
+	case ae.notifyQueue <- job:
+	default:
+		ae.notifyDeferred.Add(1)
+		log.Printf("Notification queue full; action %d left for the retry worker", job.actionID)
+	}
+}
+
+// runNotifier sends queued notifications until ctx is done. Jobs still
+// queued then stay pending and are picked up by the retry worker.
+func (ae *AlertEngine) runNotifier(ctx context.Context) {
+	defer ae.notifyWG.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-ae.notifyQueue:
+			claimed, err := ae.claimAction(job.actionID)
+			if err != nil {
+				log.Printf("Error claiming notification action %d: %v", job.actionID, err)
+				continue
+			}
+			if !claimed {
+				// The retry worker got to it first
+				continue
+			}
+			if err := ae.runAction(job.actionID, actionNotification, job.details, 0); err != nil {
+				log.Printf("Error recording action %d: %v", job.actionID, err)
+			}
+		}
+	}
+}
+
+// claimAction takes a never-attempted pending action for a notification
+// worker, setting next_attempt_at as ProcessPendingActions does, so the two
+// never run the same action
+func (ae *AlertEngine) claimAction(actionID int) (bool, error) {
+	res, err := ae.db.Exec(`
+		UPDATE alert_actions
+		SET next_attempt_at = NOW() + make_interval(secs => $2)
+		WHERE id = $1 AND action_status = 'pending' AND next_attempt_at IS NULL
+	`, actionID, ae.cfg.ActionRetryInterval.Seconds())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// decodeDetails unmarshals an action's recorded details into v
+func decodeDetails(actionType string, data []byte, v any) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return fatal(fmt.Errorf("invalid %s action details: %w", actionType, err))
+	}
+	return nil
+}
+
+// executeAction carries out one action from its recorded details
+func (ae *AlertEngine) executeAction(actionType string, detailsJSON []byte) error {
+	switch actionType {
+	case actionMigration:
+		var d MigrationDetails
+		if err := decodeDetails(actionType, detailsJSON, &d); err != nil {
+			return err
+		}
+
+		// Update node status
+		if _, err := ae.db.Exec(
+			"UPDATE gpu_nodes SET status = 'degraded' WHERE node_id = $1",
+			d.FromNode,
+		); err != nil {
+			return fmt.Errorf("failed to update node status: %w", err)
+		}
+
+		log.Printf("🚨 CRITICAL ACTION: Initiating workload migration from %s GPU %d (drainer: %s)",
+			d.FromNode, d.FromGPU, ae.drainer.Name())
+		// A failed drain leaves the action pending, so it is retried and
+		// the outcome recorded like any other action
+		return ae.drainer.Drain(d.FromNode, d.FromGPU, d.Reason)
+
+	case actionRenotify:
+		// Queued by the API to re-deliver an existing alert's notifications
+		var d AlertRefDetails
+		if err := decodeDetails(actionType, detailsJSON, &d); err != nil {
+			return err
+		}
+		alert, err := ae.loadAlert(d.AlertID)
+		if err != nil {
+			return err
+		}
+		actions := ae.notificationActions(alert)
+		log.Printf("Re-sending alert ID=%d to %d notification channels", d.AlertID, len(actions))
+		return ae.recordActions(d.AlertID, actions)
+
+	case actionResolve:
+		// Queued by the API to auto-resolve a synthetic test alert
+		var d AlertRefDetails
+		if err := decodeDetails(actionType, detailsJSON, &d); err != nil {
+			return err
+		}
+		if _, err := ae.db.Exec(`
+			UPDATE alerts SET status = 'resolved', resolved_at = NOW(), resolution = $2
+			WHERE id = $1 AND status = 'active'
+		`, d.AlertID, resolutionTimeout); err != nil {
+			return fmt.Errorf("failed to resolve alert %d: %w", d.AlertID, err)
+		}
+		log.Printf("Auto-resolved alert ID=%d", d.AlertID)
+		return nil
+
+	case actionNotification:
+		var d NotificationDetails
+		if err := decodeDetails(actionType, detailsJSON, &d); err != nil {
+			return err
+		}
+		if d.AlertID != 0 {
+			var resolved bool
+			if err := ae.db.QueryRow(
+				"SELECT EXISTS (SELECT 1 FROM alerts WHERE id = $1 AND status = 'resolved')", d.AlertID,
+			).Scan(&resolved); err != nil {
+				return fmt.Errorf("failed to check alert %d: %w", d.AlertID, err)
+			}
+			if resolved {
+				log.Printf("Alert ID=%d resolved before its deferred %s notification; dropping it", d.AlertID, d.Channel)
+				return nil
+			}
+		}
+		ch, ok := ae.channel(d.Channel)
+		if !ok {
+			return fatal(fmt.Errorf("notification channel %q is no longer configured", d.Channel))
+		}
+		log.Printf("⚠️  Sending %s notification for %s on %s GPU %d",
+			ch.Name, d.AlertType, d.NodeID, d.GPUIndex)
+		return ch.send(d.Message, d.DedupKey)
+
+	default:
+		return fatal(fmt.Errorf("unknown action type %q", actionType))
+	}
+}
+
+// runAction executes an action and records the attempt. A failed attempt
+// stays pending until ActionMaxAttempts is reached, then becomes failed.
+func (ae *AlertEngine) runAction(actionID int, actionType string, detailsJSON []byte, attempts int) error {
+	execErr := ae.executeAction(actionType, detailsJSON)
+	attempts++
+
+	status := actionSucceeded
+	var lastError sql.NullString
+	var nextAttempt sql.NullTime
+	if execErr != nil {
+		lastError = sql.NullString{String: execErr.Error(), Valid: true}
+		if errors.Is(execErr, ErrFatal) {
+			status = actionFailed
+			log.Printf("Action %d (%s) failed permanently, not retrying: %v", actionID, actionType, execErr)
+		} else if attempts >= ae.cfg.ActionMaxAttempts {
+			status = actionFailed
+			log.Printf("Action %d (%s) failed permanently after %d attempts: %v", actionID, actionType, attempts, execErr)
+		} else {
+			status = actionPending
+			nextAttempt = sql.NullTime{Time: time.Now().Add(ae.cfg.ActionRetryInterval), Valid: true}
+			log.Printf("Action %d (%s) failed (attempt %d/%d), will retry: %v",
+				actionID, actionType, attempts, ae.cfg.ActionMaxAttempts, execErr)
+		}
+	}
+
+	_, err := ae.db.Exec(`
+		UPDATE alert_actions
+		SET action_status = $2, attempts = $3, last_error = $4,
+		    next_attempt_at = $5, updated_at = NOW()
+		WHERE id = $1
+	`, actionID, status, attempts, lastError, nextAttempt)
+	return err
+}
+
+// ProcessPendingActions retries pending actions whose next attempt is due.
+// Rows are claimed with SKIP LOCKED so several engine instances can share the work.
+// Pending rows that were never attempted (e.g. the engine crashed mid-action)
+// are picked up once they are older than one retry interval.
+func (ae *AlertEngine) ProcessPendingActions() {
+	rows, err := ae.db.Query(`
+		UPDATE alert_actions
+		SET next_attempt_at = NOW() + make_interval(secs => $1)
+		WHERE id IN (
+			SELECT id FROM alert_actions
+			WHERE action_status = 'pending'
+			  AND (next_attempt_at <= NOW()
+			       OR (next_attempt_at IS NULL AND executed_at < NOW() - make_interval(secs => $1)))
+			ORDER BY id
+			LIMIT 100
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, action_type, action_details, attempts
+	`, ae.cfg.ActionRetryInterval.Seconds())
+	if err != nil {
+		log.Printf("Error claiming pending actions: %v", err)
+		return
+	}
+
+	type pendingAction struct {
+		id         int
+		actionType string
+		details    []byte
+		attempts   int
+	}
+	var pending []pendingAction
+	for rows.Next() {
+		var p pendingAction
+		if err := rows.Scan(&p.id, &p.actionType, &p.details, &p.attempts); err != nil {
+			log.Printf("Error scanning pending action: %v", err)
+			continue
+		}
+		pending = append(pending, p)
+	}
+	rows.Close()
+
+	for _, p := range pending {
+		if err := ae.runAction(p.id, p.actionType, p.details, p.attempts); err != nil {
+			log.Printf("Error recording action %d: %v", p.id, err)
+		}
+	}
+}
+
+// runActionRetries periodically retries pending actions until ctx is cancelled
+func (ae *AlertEngine) runActionRetries(ctx context.Context) {
+	ticker := time.NewTicker(ae.cfg.ActionRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ae.ProcessPendingActions()
+		}
+	}
+}
+
+const (
+	// actionRetentionInterval is how often expired alert_actions are purged
+	actionRetentionInterval = time.Hour
+	// actionPurgeBatch bounds the rows deleted per statement, so a large
+	// backlog doesn't hold one long transaction
+	actionPurgeBatch = 10000
+)
+
+// PurgeExpiredActions deletes succeeded and failed alert_actions older than
+// ActionRetention. Pending actions are kept so they still run, and the
+// parent alerts are left alone.
+func (ae *AlertEngine) PurgeExpiredActions(ctx context.Context) (int64, error) {
+	var total int64
+	for {
+		stmtCtx, cancel := ae.statementContext(ctx)
+		res, err := ae.db.ExecContext(stmtCtx, `
+			DELETE FROM alert_actions
+			WHERE id IN (
+				SELECT id FROM alert_actions
+				WHERE action_status <> 'pending'
+				  AND executed_at < NOW() - make_interval(secs => $1)
+				LIMIT $2
+			)
+		`, ae.cfg.ActionRetention.Seconds(), actionPurgeBatch)
+		err = dbError(stmtCtx, err)
+		cancel()
+		if err != nil {
+			return total, fmt.Errorf("failed to purge expired actions: %w", err)
+		}
+		n, _ := res.RowsAffected()
+		total += n
+		if n < actionPurgeBatch {
+			return total, nil
+		}
+	}
+}
+
+// watchActionRetention runs PurgeExpiredActions every actionRetentionInterval
+func (ae *AlertEngine) watchActionRetention(ctx context.Context) {
+	ticker := time.NewTicker(actionRetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := ae.PurgeExpiredActions(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Action retention failed: %v", err)
+			}
+			continue
+		}
+		if n > 0 {
+			log.Printf("Purged %d alert actions older than %s", n, ae.cfg.ActionRetention)
+		}
+	}
+}
+
+// metricsRetentionBatch bounds the gpu_metrics rows archived into one
+// object, or deleted per statement without an archive
+const metricsRetentionBatch = 10000
+
+// EnforceMetricsRetention deletes gpu_metrics rows older than
+// MetricsRetention in batches, oldest first. With an archive each batch is
+// uploaded before it is deleted, so a failed upload keeps its rows for the
+// next run; a batch that is uploaded but not deleted is re-uploaded under
+// the same key.
+func (ae *AlertEngine) EnforceMetricsRetention(ctx context.Context) (int64, error) {
+	var total int64
+	for ctx.Err() == nil {
+		n, err := ae.retireMetricsBatch(ctx)
+		total += n
+		if err != nil || n < metricsRetentionBatch {
+			return total, err
+		}
+	}
+	return total, ctx.Err()
+}
+
+// retireMetricsBatch archives (when configured) and deletes one batch of
+// expired metrics, returning how many rows it removed
+func (ae *AlertEngine) retireMetricsBatch(ctx context.Context) (int64, error) {
+	if ae.archive == nil {
+		stmtCtx, cancel := ae.statementContext(ctx)
+		defer cancel()
+		res, err := ae.db.ExecContext(stmtCtx, `
+			DELETE FROM gpu_metrics
+			WHERE id IN (
+				SELECT id FROM gpu_metrics
+				WHERE collected_at < NOW() - make_interval(secs => $1)
+				LIMIT $2
+			)
+		`, ae.cfg.MetricsRetention.Seconds(), metricsRetentionBatch)
+		if err = dbError(stmtCtx, err); err != nil {
+			return 0, fmt.Errorf("failed to delete expired metrics: %w", err)
+		}
+		n, _ := res.RowsAffected()
+		return n, nil
+	}
+
+	stmtCtx, cancel := ae.statementContext(ctx)
+	defer cancel()
+	// row_to_json exports every column, so the archive follows the schema.
+	// Ordered by collected_at so the scan follows idx_metrics_collected_at.
+	rows, err := ae.db.QueryContext(stmtCtx, `
+		SELECT m.id, m.collected_at, row_to_json(m)::text
+		FROM gpu_metrics m
+		WHERE m.collected_at < NOW() - make_interval(secs => $1)
+		ORDER BY collected_at, id
+		LIMIT $2
+	`, ae.cfg.MetricsRetention.Seconds(), metricsRetentionBatch)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read expired metrics: %w", dbError(stmtCtx, err))
+	}
+	var ids []int64
+	var minID, maxID int64
+	var oldest time.Time
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	for rows.Next() {
+		var id int64
+		var collectedAt time.Time
+		var row string
+		if err := rows.Scan(&id, &collectedAt, &row); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to read expired metrics: %w", err)
+		}
+		if len(ids) == 0 {
+			minID, maxID, oldest = id, id, collectedAt
+		}
+		minID, maxID = min(minID, id), max(maxID, id)
+		ids = append(ids, id)
+		zw.Write([]byte(row))
+		zw.Write([]byte{'\n'})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read expired metrics: %w", dbError(stmtCtx, err))
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+
+	// Keyed only by the batch's data, the day its oldest row was collected
+	// and its id range, so a retried upload of the same rows overwrites
+	// rather than duplicates whenever it runs
+	key := fmt.Sprintf("%s%s/gpu_metrics-%d-%d.ndjson.gz",
+		ae.cfg.ArchivePrefix, oldest.UTC().Format("2006/01/02"), minID, maxID)
+	if err := ae.archive.Put(ctx, key, buf.Bytes(), "application/x-ndjson", "gzip"); err != nil {
+		return 0, fmt.Errorf("failed to archive metrics to %s: %w", key, err)
+	}
+	ae.archivedMetrics.Add(int64(len(ids)))
+
+	delCtx, delCancel := ae.statementContext(ctx)
+	defer delCancel()
+	res, err := ae.db.ExecContext(delCtx, "DELETE FROM gpu_metrics WHERE id = ANY($1)", pq.Array(ids))
+	if err = dbError(delCtx, err); err != nil {
+		return 0, fmt.Errorf("failed to delete archived metrics: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// watchMetricsRetention runs EnforceMetricsRetention every MetricsRetentionInterval
+func (ae *AlertEngine) watchMetricsRetention(ctx context.Context) {
+	ticker := time.NewTicker(ae.cfg.MetricsRetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := ae.EnforceMetricsRetention(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Metrics retention failed after removing %d rows: %v", n, err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("Removed %d metrics older than %s", n, ae.cfg.MetricsRetention)
+		}
+	}
+}
+
+// s3Store uploads objects to an S3-compatible bucket with path-style URLs
+// and AWS Signature Version 4, so MinIO and the like work as well as S3
+type s3Store struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func newS3Store(cfg Config) *s3Store {
+	// LoadConfig has already checked the endpoint parses
+	endpoint, _ := url.Parse(strings.TrimRight(cfg.ArchiveEndpoint, "/"))
+	return &s3Store{
+		endpoint:  endpoint,
+		region:    cfg.ArchiveRegion,
+		bucket:    cfg.ArchiveBucket,
+		accessKey: cfg.ArchiveAccessKeyID,
+		secretKey: cfg.ArchiveSecretAccessKey,
+		client:    &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Put uploads body as the object key
+func (s *s3Store) Put(ctx context.Context, key string, body []byte, contentType, contentEncoding string) error {
+	u := *s.endpoint
+	u.Path += "/" + s.bucket + "/" + key
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+	s.sign(req, body, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return retriable(fmt.Errorf("archive upload failed: %w", err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("archive upload returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// sign adds AWS Signature Version 4 headers to req, signing the host, the
+// payload hash and every header already set
+func (s *s3Store) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// s3EscapePath URI-encodes each path segment as Signature Version 4 expects
+func s3EscapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Shadow alert sources
+const (
+	shadowSourceLive     = "live"
+	shadowSourceBacktest = "backtest"
+)
+
+// RecordShadowAlert stores a hypothetical alert without notifying or acting on it
+func (ae *AlertEngine) RecordShadowAlert(alert Alert, source string, collectedAt time.Time) error {
+	_, err := ae.db.Exec(`
+		INSERT INTO shadow_alerts (
+			node_id, gpu_index, alert_type, severity, message,
+			threshold_value, actual_value, source, metric_collected_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`,
+		alert.NodeID,
+		alert.GPUIndex,
+		alert.AlertType,
+		alert.Severity,
+		alert.Message,
+		alert.ThresholdValue,
+		alert.ActualValue,
+		source,
+		collectedAt,
+	)
+	return err
+}
+
+// backtestColumns are the gpu_metrics columns scanStoredMetric reads
+const backtestColumns = `node_id, gpu_index, COALESCE(temperature_celsius, 0), power_watts,
+		       COALESCE(memory_used_mb, 0), COALESCE(memory_total_mb, 0),
+		       COALESCE(utilization_percent, 0), COALESCE(sm_clock_mhz, 0),
+		       fan_speed_percent, COALESCE(row_remap_pending, 0), collected_at,
+		       labels, COALESCE(gpu_uuid, '')`
+
+// scanStoredMetric scans backtestColumns back into the metric as the live
+// path evaluated it: optional fields stored as NULL are listed in
+// Unreported again and labels are restored. The enforced power limit
+// isn't stored, so power cap checks never fire on replayed samples.
+func scanStoredMetric(rows *sql.Rows) (GPUMetric, error) {
+	var m GPUMetric
+	var power, fan sql.NullFloat64
+	var labels []byte
+	if err := rows.Scan(&m.NodeID, &m.GPUIndex, &m.TemperatureCelsius,
+		&power, &m.MemoryUsedMB, &m.MemoryTotalMB,
+		&m.UtilizationPercent, &m.SMClockMHz, &fan, &m.RowRemapPending,
+		&m.CollectedAt, &labels, &m.GPUUUID); err != nil {
+		return m, err
+	}
+	for _, f := range []struct {
+		field string
+		value sql.NullFloat64
+		dst   *float64
+	}{{"power_watts", power, &m.PowerWatts}, {"fan_speed_percent", fan, &m.FanSpeedPercent}} {
+		if f.value.Valid {
+			*f.dst = f.value.Float64
+		} else {
+			m.Unreported = append(m.Unreported, f.field)
+		}
+	}
+	if len(labels) > 0 {
+		if err := json.Unmarshal(labels, &m.Labels); err != nil {
+			return m, fmt.Errorf("invalid labels: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// Backtest replays stored metrics through EvaluateRules and records what
+// would have fired to shadow_alerts, for tuning rules before enabling them
+func (ae *AlertEngine) Backtest(ctx context.Context, since time.Duration) error {
+	rows, err := ae.db.QueryContext(ctx, `
+		SELECT `+backtestColumns+`
+		FROM gpu_metrics
+		WHERE collected_at >= $1
+		ORDER BY collected_at
+	`, time.Now().Add(-since))
+	if err != nil {
+		return fmt.Errorf("failed to query historical metrics: %w", err)
+	}
+	defer rows.Close()
+
+	evaluated := 0
+	counts := make(map[string]int)
+	for rows.Next() {
+		m, err := scanStoredMetric(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan metric: %w", err)
+		}
+		evaluated++
+
+		for _, alert := range ae.EvaluateRules(m) {
+			counts[alert.AlertType+"/"+alert.Severity]++
+			if err := ae.RecordShadowAlert(alert, shadowSourceBacktest, m.CollectedAt); err != nil {
+				return fmt.Errorf("failed to record shadow alert: %w", err)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	log.Printf("Backtest evaluated %d metrics from the last %s", evaluated, since)
+	for key, n := range counts {
+		log.Printf("  %s: %d hypothetical alerts", key, n)
+	}
+	return nil
+}
+
+// commitAttempts bounds retries of an offset commit interrupted by a rebalance
+const commitAttempts = 3
+
+// isRebalanceError reports whether err means the group generation changed
+// under us, in which case the commit can be retried once the group settles
+func isRebalanceError(err error) bool {
+	return errors.Is(err, kafka.RebalanceInProgress) ||
+		errors.Is(err, kafka.IllegalGeneration) ||
+		errors.Is(err, kafka.UnknownMemberId) ||
+		errors.Is(err, kafka.NotCoordinatorForGroup)
+}
+
+// commitMessage commits a processed message's offset, retrying while the
+// group rebalances. If the partition has moved to another member the commit
+// is dropped and that member re-reads from the last committed offset.
+func (ae *AlertEngine) commitMessage(ctx context.Context, msg kafka.Message) {
+	for attempt := 1; attempt <= commitAttempts; attempt++ {
+		err := ae.source.CommitMessages(ctx, msg)
+		if err == nil {
+			return
+		}
+		if !isRebalanceError(err) || attempt == commitAttempts {
+			log.Printf("Error committing offset %d on partition %d: %v", msg.Offset, msg.Partition, err)
+			return
+		}
+
+		log.Printf("Commit interrupted by rebalance (attempt %d/%d), retrying", attempt, commitAttempts)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(attempt) * 500 * time.Millisecond):
+		}
+	}
+}
+
+// Message value formats
+const (
+	formatJSON     = "json"
+	formatAvro     = "avro"
+	formatProtobuf = "protobuf"
+)
+
+// contentTypeHeader names the Kafka header declaring a message's format;
+// formatsByContentType maps its values back to formats
+const contentTypeHeader = "content-type"
+
+var formatsByContentType = map[string]string{
+	"application/json":                   formatJSON,
+	"application/vnd.apache.avro+binary": formatAvro,
+	"application/x-protobuf":             formatProtobuf,
+}
+
+// messageFormat returns the format declared by msg's content-type header,
+// or MessageFormat when it has none, so producers can migrate one by one
+func (ae *AlertEngine) messageFormat(msg kafka.Message) (string, error) {
+	for _, h := range msg.Headers {
+		if strings.EqualFold(h.Key, contentTypeHeader) {
+			format, ok := formatsByContentType[string(h.Value)]
+			if !ok {
+				return "", fmt.Errorf("unsupported content-type %q", h.Value)
+			}
+			return format, nil
+		}
+	}
+	return ae.cfg.MessageFormat, nil
+}
+
+// avroField is one field of a writer schema. Types holds the field's type,
+// or every branch of a union in declaration order.
+type avroField struct {
+	Name  string
+	Types []string
+}
+
+// decodeMetrics decodes a message into its metrics: one per message, or a
+// node's whole set when a collector batches by node (a JSON array)
+func (ae *AlertEngine) decodeMetrics(msg kafka.Message) ([]GPUMetric, error) {
+	format, err := ae.messageFormat(msg)
+	if err != nil {
+		return nil, err
+	}
+	if format == formatJSON && bytes.HasPrefix(bytes.TrimSpace(msg.Value), []byte("[")) {
+		var metrics []GPUMetric
+		err := json.Unmarshal(msg.Value, &metrics)
+		return metrics, err
+	}
+
+	metric, err := ae.decodeMetric(msg)
+	if err != nil {
+		return nil, err
+	}
+	return []GPUMetric{metric}, nil
+}
+
+// decodeMetric decodes a Kafka message value in the format it declares
+func (ae *AlertEngine) decodeMetric(msg kafka.Message) (GPUMetric, error) {
+	var metric GPUMetric
+	format, err := ae.messageFormat(msg)
+	if err != nil {
+		return metric, err
+	}
+	value := msg.Value
+	switch format {
+	case formatProtobuf:
+		return decodeProtoMetric(value)
+	case formatJSON:
+		err := json.Unmarshal(value, &metric)
+		return metric, err
+	}
+
+	// Confluent framing: magic byte 0, 4-byte big-endian schema ID, Avro body
+	if len(value) < 5 || value[0] != 0 {
+		return metric, fmt.Errorf("message is not Confluent-framed Avro")
+	}
+	schemaID := int(binary.BigEndian.Uint32(value[1:5]))
+	fields, err := ae.avroSchema(schemaID)
+	if err != nil {
+		return metric, err
+	}
+
+	r := &avroReader{buf: value[5:]}
+	for _, f := range fields {
+		v, err := r.readField(f.Types)
+		if err != nil {
+			return metric, fmt.Errorf("field %s: %w", f.Name, err)
+		}
+		switch f.Name {
+		case "node_id":
+			metric.NodeID, _ = v.(string)
+		case "gpu_index":
+			metric.GPUIndex = int(avroFloat(v))
+		case "temperature_celsius":
+			metric.TemperatureCelsius = avroFloat(v)
+		case "power_watts":
+			metric.PowerWatts = avroFloat(v)
+		case "memory_used_mb":
+			metric.MemoryUsedMB = avroFloat(v)
+		case "memory_total_mb":
+			metric.MemoryTotalMB = avroFloat(v)
+		case "utilization_percent":
+			metric.UtilizationPercent = avroFloat(v)
+		case "sm_clock_mhz":
+			metric.SMClockMHz = int(avroFloat(v))
+		case "fan_speed_percent":
+			metric.FanSpeedPercent = avroFloat(v)
+		case "row_remap_pending":
+			metric.RowRemapPending = int(avroFloat(v))
+		case "collected_at":
+			if ms, ok := v.(int64); ok {
+				metric.CollectedAt = time.UnixMilli(ms).UTC()
+			}
+		case "labels":
+			metric.Labels, _ = v.(map[string]string)
+		case "enforced_power_limit_watts":
+			metric.EnforcedPowerLimitWatts = avroFloat(v)
+		case "gpu_uuid":
+			metric.GPUUUID, _ = v.(string)
+		case "unreported":
+			metric.Unreported, _ = v.([]string)
+		case "histograms":
+			if h, ok := v.(map[string]Histogram); ok && len(h) > 0 {
+				metric.Histograms = h
+			}
+		}
+	}
+	return metric, nil
+}
+
+// decodeProtoMetric decodes a proto/gpu_metric.proto GPUMetric with the
+// generated gpupb types. Unknown fields are skipped so producers can add
+// fields ahead of the engine.
+func decodeProtoMetric(data []byte) (GPUMetric, error) {
+	var pm gpupb.GPUMetric
+	if err := proto.Unmarshal(data, &pm); err != nil {
+		return GPUMetric{}, err
+	}
+	metric := GPUMetric{
+		NodeID:                  pm.NodeId,
+		GPUIndex:                int(pm.GpuIndex),
+		TemperatureCelsius:      pm.TemperatureCelsius,
+		PowerWatts:              pm.PowerWatts,
+		MemoryUsedMB:            pm.MemoryUsedMb,
+		MemoryTotalMB:           pm.MemoryTotalMb,
+		UtilizationPercent:      pm.UtilizationPercent,
+		SMClockMHz:              int(pm.SmClockMhz),
+		FanSpeedPercent:         pm.FanSpeedPercent,
+		RowRemapPending:         int(pm.RowRemapPending),
+		CollectedAt:             time.Unix(0, pm.CollectedAt).UTC(),
+		Labels:                  pm.Labels,
+		EnforcedPowerLimitWatts: pm.EnforcedPowerLimitWatts,
+		GPUUUID:                 pm.GpuUuid,
+		Unreported:              pm.Unreported,
+	}
+	if len(pm.Histograms) > 0 {
+		metric.Histograms = make(map[string]Histogram, len(pm.Histograms))
+		for name, ph := range pm.Histograms {
+			h := Histogram{Count: ph.GetCount(), Sum: ph.GetSum()}
+			for _, b := range ph.GetBuckets() {
+				h.Buckets = append(h.Buckets, HistogramBucket{LE: b.GetLe(), Count: b.GetCount()})
+			}
+			metric.Histograms[name] = h
+		}
+	}
+	return metric, nil
+}
+
+// avroSchema returns the fields of a writer schema, fetching it from the
+// registry the first time an ID is seen
+func (ae *AlertEngine) avroSchema(id int) ([]avroField, error) {
+	if fields, ok := ae.avroSchemas[id]; ok {
+		return fields, nil
+	}
+
+	url := fmt.Sprintf("%s/schemas/ids/%d", strings.TrimRight(ae.cfg.SchemaRegistryURL, "/"), id)
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, retriable(fmt.Errorf("schema registry request failed: %w", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("schema registry returned HTTP %d for schema %d", resp.StatusCode, id)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return nil, retriable(err)
+		}
+		return nil, err
+	}
+
+	var result struct {
+		Schema string `json:"schema"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode schema registry response: %w", err)
+	}
+
+	var schema struct {
+		Type   string `json:"type"`
+		Fields []struct {
+			Name string          `json:"name"`
+			Type json.RawMessage `json:"type"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(result.Schema), &schema); err != nil {
+		return nil, fmt.Errorf("invalid schema %d: %w", id, err)
+	}
+	if schema.Type != "record" {
+		return nil, fmt.Errorf("schema %d is not a record", id)
+	}
+
+	fields := make([]avroField, len(schema.Fields))
+	for i, f := range schema.Fields {
+		types, err := parseAvroType(f.Type)
+		if err != nil {
+			return nil, fmt.Errorf("schema %d field %s: %w", id, f.Name, err)
+		}
+		fields[i] = avroField{Name: f.Name, Types: types}
+	}
+
+	if ae.avroSchemas == nil {
+		ae.avroSchemas = make(map[int][]avroField)
+	}
+	ae.avroSchemas[id] = fields
+	log.Printf("Loaded Avro schema %d (%d fields)", id, len(fields))
+	return fields, nil
+}
+
+// avroHistogramMap is the type name parseAvroType gives a map of the
+// collector's Histogram records
+const avroHistogramMap = "map<Histogram>"
+
+// parseAvroType resolves a field type to primitive names. Only primitives,
+// primitives with a logicalType, maps and arrays of strings, maps of
+// Histogram records, and unions of those are supported.
+func parseAvroType(raw json.RawMessage) ([]string, error) {
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		return []string{name}, nil
+	}
+
+	var obj struct {
+		Type   string          `json:"type"`
+		Values json.RawMessage `json:"values"`
+		Items  json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(raw, &obj); err == nil && obj.Type != "" {
+		if obj.Type == "map" && isAvroHistogram(obj.Values) {
+			return []string{avroHistogramMap}, nil
+		}
+		if obj.Type == "map" && string(obj.Values) != `"string"` {
+			return nil, fmt.Errorf("unsupported map values %s", raw)
+		}
+		if obj.Type == "array" && string(obj.Items) != `"string"` {
+			return nil, fmt.Errorf("unsupported array items %s", raw)
+		}
+		return []string{obj.Type}, nil
+	}
+
+	var union []json.RawMessage
+	if err := json.Unmarshal(raw, &union); err != nil {
+		return nil, fmt.Errorf("unsupported type %s", raw)
+	}
+	var types []string
+	for _, branch := range union {
+		t, err := parseAvroType(branch)
+		if err != nil || len(t) != 1 {
+			return nil, fmt.Errorf("unsupported union %s", raw)
+		}
+		types = append(types, t[0])
+	}
+	return types, nil
+}
+
+// avroRecord is a record schema whose fields are named types, such as a
+// primitive name or a nested schema
+type avroRecord struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Fields []struct {
+		Name string          `json:"name"`
+		Type json.RawMessage `json:"type"`
+	} `json:"fields"`
+}
+
+// fieldTypes returns the record's field names and their raw types in order
+func (rec avroRecord) fieldTypes() (names, types []string) {
+	for _, f := range rec.Fields {
+		names = append(names, f.Name)
+		types = append(types, string(f.Type))
+	}
+	return names, types
+}
+
+// isAvroHistogram reports whether raw is the collector's Histogram record:
+// an array of HistogramBucket{le, count} buckets, then count and sum
+func isAvroHistogram(raw json.RawMessage) bool {
+	var hist avroRecord
+	if err := json.Unmarshal(raw, &hist); err != nil || hist.Type != "record" || len(hist.Fields) != 3 {
+		return false
+	}
+	names, types := hist.fieldTypes()
+	if !reflect.DeepEqual(names, []string{"buckets", "count", "sum"}) ||
+		types[1] != `"double"` || types[2] != `"double"` {
+		return false
+	}
+	var buckets struct {
+		Type  string     `json:"type"`
+		Items avroRecord `json:"items"`
+	}
+	if err := json.Unmarshal(hist.Fields[0].Type, &buckets); err != nil || buckets.Type != "array" ||
+		buckets.Items.Type != "record" {
+		return false
+	}
+	names, types = buckets.Items.fieldTypes()
+	return reflect.DeepEqual(names, []string{"le", "count"}) &&
+		reflect.DeepEqual(types, []string{`"double"`, `"double"`})
+}
+
+// avroReader reads Avro binary values from a buffer
+type avroReader struct {
+	buf []byte
+}
+
+// readField reads one field value; unions are prefixed with a branch index
+func (r *avroReader) readField(types []string) (interface{}, error) {
+	t := types[0]
+	if len(types) > 1 {
+		idx, err := r.readLong()
+		if err != nil {
+			return nil, err
+		}
+		if idx < 0 || int(idx) >= len(types) {
+			return nil, fmt.Errorf("union branch %d out of range", idx)
+		}
+		t = types[idx]
+	}
+
+	switch t {
+	case "null":
+		return nil, nil
+	case "boolean":
+		if len(r.buf) < 1 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		v := r.buf[0] != 0
+		r.buf = r.buf[1:]
+		return v, nil
+	case "int", "long":
+		return r.readLong()
+	case "float":
+		if len(r.buf) < 4 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		v := math.Float32frombits(binary.LittleEndian.Uint32(r.buf))
+		r.buf = r.buf[4:]
+		return float64(v), nil
+	case "double":
+		if len(r.buf) < 8 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.buf))
+		r.buf = r.buf[8:]
+		return v, nil
+	case "string", "bytes":
+		n, err := r.readLong()
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 || int64(len(r.buf)) < n {
+			return nil, io.ErrUnexpectedEOF
+		}
+		v := string(r.buf[:n])
+		r.buf = r.buf[n:]
+		return v, nil
+	case "map":
+		return r.readStringMap()
+	case "array":
+		return r.readStringArray()
+	case avroHistogramMap:
+		return r.readHistogramMap()
+	default:
+		return nil, fmt.Errorf("unsupported type %q", t)
+	}
+}
+
+// readStringMap reads an Avro map<string>: blocks of key/value pairs ending
+// with a zero count. A negative count is followed by the block's byte size.
+func (r *avroReader) readStringMap() (map[string]string, error) {
+	m := make(map[string]string)
+	for {
+		count, err := r.readLong()
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return m, nil
+		}
+		if count < 0 {
+			count = -count
+			if _, err := r.readLong(); err != nil {
+				return nil, err
+			}
+		}
+		for i := int64(0); i < count; i++ {
+			k, err := r.readField([]string{"string"})
+			if err != nil {
+				return nil, err
+			}
+			v, err := r.readField([]string{"string"})
+			if err != nil {
+				return nil, err
+			}
+			m[k.(string)] = v.(string)
+		}
+	}
 }
 
-type AlertEngine struct {
-	db          *sql.DB
-	kafkaReader *kafka.Reader
+// readStringArray reads an Avro array<string>, blocked like a map
+func (r *avroReader) readStringArray() ([]string, error) {
+	var items []string
+	for {
+		count, err := r.readLong()
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return items, nil
+		}
+		if count < 0 {
+			count = -count
+			if _, err := r.readLong(); err != nil {
+				return nil, err
+			}
+		}
+		for i := int64(0); i < count; i++ {
+			v, err := r.readField([]string{"string"})
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v.(string))
+		}
+	}
 }
 
-func NewAlertEngine(dbConnStr, kafkaBroker string) (*AlertEngine, error) {
-	db, err := sql.Open("postgres", dbConnStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
+// readBlockCount reads the item count of an array or map block, skipping
+// the byte size that follows a negative count
+func (r *avroReader) readBlockCount() (int64, error) {
+	count, err := r.readLong()
+	if err != nil || count >= 0 {
+		return count, err
 	}
-
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+	if _, err := r.readLong(); err != nil {
+		return 0, err
 	}
-
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:     []string{kafkaBroker},
-		Topic:       "gpu-telemetry",
-		GroupID:     "alert-engine",
-		MinBytes:    1,
-		MaxBytes:    10e6,
-		StartOffset: kafka.LastOffset,
-	})
-
-	return &AlertEngine{
-		db:          db,
-		kafkaReader: reader,
-	}, nil
+	return -count, nil
 }
 
-// EvaluateRules checks metrics against thresholds
-func (ae *AlertEngine) EvaluateRules(metric GPUMetric) []Alert {
-	var alerts []Alert
+// readDouble reads an Avro double
+func (r *avroReader) readDouble() (float64, error) {
+	v, err := r.readField([]string{"double"})
+	if err != nil {
+		return 0, err
+	}
+	return v.(float64), nil
+}
 
-	// Rule 1: High temperature (> 90°C)
-	if metric.TemperatureCelsius > 90.0 {
-		severity := "warning"
-		if metric.TemperatureCelsius > 95.0 {
-			severity = "critical"
+// readHistogramMap reads a map of Histogram records, blocked like a
+// map<string>; each bucket array is blocked the same way
+func (r *avroReader) readHistogramMap() (map[string]Histogram, error) {
+	m := make(map[string]Histogram)
+	for {
+		count, err := r.readBlockCount()
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return m, nil
+		}
+		for i := int64(0); i < count; i++ {
+			k, err := r.readField([]string{"string"})
+			if err != nil {
+				return nil, err
+			}
+			var h Histogram
+			for {
+				buckets, err := r.readBlockCount()
+				if err != nil {
+					return nil, err
+				}
+				if buckets == 0 {
+					break
+				}
+				for j := int64(0); j < buckets; j++ {
+					var b HistogramBucket
+					if b.LE, err = r.readDouble(); err != nil {
+						return nil, err
+					}
+					if b.Count, err = r.readDouble(); err != nil {
+						return nil, err
+					}
+					h.Buckets = append(h.Buckets, b)
+				}
+			}
+			if h.Count, err = r.readDouble(); err != nil {
+				return nil, err
+			}
+			if h.Sum, err = r.readDouble(); err != nil {
+				return nil, err
+			}
+			m[k.(string)] = h
 		}
-
-		alerts = append(alerts, Alert{
-			NodeID:         metric.NodeID,
-			GPUIndex:       metric.GPUIndex,
-			AlertType:      "high_temperature",
-			Severity:       severity,
-			Message:        fmt.Sprintf("GPU temperature is %.1f°C", metric.TemperatureCelsius),
-			ThresholdValue: 90.0,
-			ActualValue:    metric.TemperatureCelsius,
-		})
 	}
+}
 
-	// Rule 2: High power consumption (> 330W)
-	if metric.PowerWatts > 330.0 {
-		alerts = append(alerts, Alert{
-			NodeID:         metric.NodeID,
-			GPUIndex:       metric.GPUIndex,
-			AlertType:      "high_power",
-			Severity:       "warning",
-			Message:        fmt.Sprintf("GPU power consumption is %.1fW", metric.PowerWatts),
-			ThresholdValue: 330.0,
-			ActualValue:    metric.PowerWatts,
-		})
+// readLong reads a zigzag varint, the encoding of Avro int and long
+func (r *avroReader) readLong() (int64, error) {
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		return 0, io.ErrUnexpectedEOF
 	}
+	r.buf = r.buf[n:]
+	return v, nil
+}
 
-	// Rule 3: High memory usage (> 95%)
-	memoryPercent := (metric.MemoryUsedMB / metric.MemoryTotalMB) * 100.0
-	if memoryPercent > 95.0 {
-		alerts = append(alerts, Alert{
-			NodeID:         metric.NodeID,
-			GPUIndex:       metric.GPUIndex,
-			AlertType:      "high_memory",
-			Severity:       "warning",
-			Message:        fmt.Sprintf("GPU memory usage is %.1f%%", memoryPercent),
-			ThresholdValue: 95.0,
-			ActualValue:    memoryPercent,
-		})
+// avroFloat converts a decoded numeric value to float64
+func avroFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	default:
+		return 0
 	}
-
-	return alerts
 }
 
-// StoreMetric saves metric to database
-func (ae *AlertEngine) StoreMetric(metric GPUMetric) error {
-	query := `
-		INSERT INTO gpu_metrics (
-			node_id, gpu_index, temperature_celsius, power_watts,
-			memory_used_mb, memory_total_mb, utilization_percent,
-			sm_clock_mhz, collected_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-	`
+// Backoff applied by the consume loop while fetches or stores keep failing
+const (
+	errorBackoffBase = 100 * time.Millisecond
+	maxErrorBackoff  = 30 * time.Second
+)
 
-	_, err := ae.db.Exec(query,
-		metric.NodeID,
-		metric.GPUIndex,
-		metric.TemperatureCelsius,
-		metric.PowerWatts,
-		metric.MemoryUsedMB,
-		metric.MemoryTotalMB,
-		metric.UtilizationPercent,
-		metric.SMClockMHz,
-		metric.CollectedAt,
-	)
+// backoffOnError records a failed iteration and sleeps for a delay that
+// doubles with each consecutive failure, so an outage doesn't spin the loop
+func (ae *AlertEngine) backoffOnError(ctx context.Context) {
+	ae.errorsTotal.Add(1)
+	streak := ae.errorStreak.Add(1)
 
-	return err
-}
+	delay := maxErrorBackoff
+	if streak <= 10 {
+		delay = min(errorBackoffBase<<(streak-1), maxErrorBackoff)
+	}
+	if streak > 1 {
+		log.Printf("%d consecutive errors, backing off %s", streak, delay)
+	}
 
-// CreateAlert saves alert to database
-func (ae *AlertEngine) CreateAlert(alert Alert) error {
-	query := `
-		INSERT INTO alerts (
-			node_id, gpu_index, alert_type, severity, message,
-			threshold_value, actual_value, status
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, 'active')
-		RETURNING id
-	`
+	select {
+	case <-ctx.Done():
+	case <-time.After(delay):
+	}
+}
 
-	var alertID int
-	err := ae.db.QueryRow(query,
-		alert.NodeID,
-		alert.GPUIndex,
-		alert.AlertType,
-		alert.Severity,
-		alert.Message,
-		alert.ThresholdValue,
-		alert.ActualValue,
-	).Scan(&alertID)
+// checkGroupSize reads the topic's partition count and the consumer group's
+// member count, warning when members exceed partitions since the extra
+// instances sit idle
+func (ae *AlertEngine) checkGroupSize(ctx context.Context) error {
+	client := &kafka.Client{Addr: kafka.TCP(ae.cfg.KafkaBrokers...), Timeout: 10 * time.Second, Transport: ae.transport}
 
+	meta, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{ae.cfg.KafkaTopic}})
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to read topic metadata: %w", err)
 	}
+	if len(meta.Topics) != 1 || meta.Topics[0].Error != nil {
+		return fmt.Errorf("topic %q not found", ae.cfg.KafkaTopic)
+	}
+	partitions := len(meta.Topics[0].Partitions)
 
-	log.Printf("Created alert ID=%d: [%s] %s on %s GPU %d",
-		alertID, alert.Severity, alert.AlertType, alert.NodeID, alert.GPUIndex)
+	groups, err := client.DescribeGroups(ctx, &kafka.DescribeGroupsRequest{GroupIDs: []string{ae.cfg.ConsumerGroup}})
+	if err != nil {
+		return fmt.Errorf("failed to describe consumer group: %w", err)
+	}
+	if len(groups.Groups) != 1 || groups.Groups[0].Error != nil {
+		return fmt.Errorf("consumer group %q not found", ae.cfg.ConsumerGroup)
+	}
+	members := len(groups.Groups[0].Members)
 
-	// Take automated actions based on severity
-	return ae.TakeAction(alertID, alert)
+	if ae.topicPartitions.Swap(int64(partitions)) != int64(partitions) {
+		log.Printf("Topic %q has %d partitions", ae.cfg.KafkaTopic, partitions)
+	}
+	previous := ae.groupMembers.Swap(int64(members))
+	if members > partitions && previous != int64(members) {
+		log.Printf("WARNING: consumer group %q has %d members but topic %q has only %d partitions; %d instances will sit idle",
+			ae.cfg.ConsumerGroup, members, ae.cfg.KafkaTopic, partitions, members-partitions)
+	}
+	return nil
 }
 
-// TakeAction performs automated responses to alerts
-func (ae *AlertEngine) TakeAction(alertID int, alert Alert) error {
-	var actionType string
-	var actionDetails map[string]interface{}
-
-	switch alert.Severity {
-	case "critical":
-		// Critical: mark node as degraded, trigger workload migration
-		actionType = "workload_migration"
-		actionDetails = map[string]interface{}{
-			"action":    "migrate_workloads",
-			"from_node": alert.NodeID,
-			"from_gpu":  alert.GPUIndex,
-			"reason":    alert.Message,
-		}
+// watchGroupSize runs checkGroupSize every GroupCheckInterval until ctx is cancelled
+func (ae *AlertEngine) watchGroupSize(ctx context.Context) {
+	ticker := time.NewTicker(ae.cfg.GroupCheckInterval)
+	defer ticker.Stop()
 
-		// Update node status
-		_, err := ae.db.Exec(
-			"UPDATE gpu_nodes SET status = 'degraded' WHERE node_id = $1",
-			alert.NodeID,
-		)
-		if err != nil {
-			log.Printf("Failed to update node status: %v", err)
+	for {
+		if err := ae.checkGroupSize(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to check consumer group size: %v", err)
 		}
 
-		log.Printf("🚨 CRITICAL ACTION: Initiating workload migration from %s GPU %d",
-			alert.NodeID, alert.GPUIndex)
-
-	case "warning":
-		actionType = "notification"
-		actionDetails = map[string]interface{}{
-			"action":  "send_notification",
-			"channel": "slack",
-			"message": alert.Message,
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
-		log.Printf("⚠️  WARNING: Sending notification for %s on %s GPU %d",
-			alert.AlertType, alert.NodeID, alert.GPUIndex)
 	}
-
-	// Log action to database
-	detailsJSON, _ := json.Marshal(actionDetails)
-	_, err := ae.db.Exec(`
-		INSERT INTO alert_actions (alert_id, action_type, action_status, action_details)
-		VALUES ($1, $2, 'executed', $3)
-	`, alertID, actionType, detailsJSON)
-
-	return err
 }
 
-// Run starts consuming from Kafka
+// Run starts consuming metrics from the configured source, along with the
+// background jobs, until ctx is cancelled
 func (ae *AlertEngine) Run(ctx context.Context) error {
 	log.Println("Alert Engine started, consuming from Kafka...")
 
+	if ae.redactor != nil {
+		if err := ae.refreshRedactions(ctx); err != nil {
+			log.Printf("Failed to load redacted log values: %v", err)
+		}
+		go ae.watchRedactions(ctx)
+	}
+	go ae.runActionRetries(ctx)
+	for i := 0; i < ae.cfg.NotifyConcurrency; i++ {
+		ae.notifyWG.Add(1)
+		go ae.runNotifier(ctx)
+	}
+	if ae.cfg.GroupCheckInterval > 0 && ae.kafkaReader != nil {
+		go ae.watchGroupSize(ctx)
+	}
+	if ae.cfg.RulesReloadInterval > 0 {
+		go ae.watchRules(ctx)
+	}
+	if ae.cfg.BaselineRefreshInterval > 0 {
+		go ae.watchBaselines(ctx)
+	}
+	// Suggestions are writes, so a dry run doesn't compute them
+	if ae.cfg.ThresholdSuggestInterval > 0 && !ae.cfg.DryRun {
+		go ae.watchThresholdSuggestions(ctx)
+	}
+	if ae.cfg.AlertStormThreshold > 0 {
+		go ae.watchAlertStorm(ctx)
+	}
+	if ae.cfg.AutoResolveInterval > 0 {
+		go ae.watchAutoResolve(ctx)
+	}
+	if ae.cfg.ResolutionScanInterval > 0 {
+		go ae.watchResolutions(ctx)
+	}
+	if ae.cfg.ConsumerLagAlertThreshold > 0 && !ae.cfg.DryRun {
+		go ae.watchConsumerLag(ctx)
+	}
+	if ae.cfg.DeadmanAlert && !ae.cfg.DryRun {
+		go ae.watchDeadman(ctx)
+	}
+	if ae.cfg.HeartbeatInterval > 0 && !ae.cfg.DryRun {
+		go ae.watchHeartbeat(ctx)
+	}
+	if ae.cfg.IngestRateInterval > 0 && !ae.cfg.DryRun {
+		go ae.watchIngestRates(ctx)
+	}
+	if ae.cfg.ActionRetention > 0 && !ae.cfg.DryRun {
+		go ae.watchActionRetention(ctx)
+	}
+	if ae.cfg.MetricsRetention > 0 && !ae.cfg.DryRun {
+		go ae.watchMetricsRetention(ctx)
+	}
+	if ae.cfg.MissingGPUWindow > 0 {
+		if err := ae.refreshExpectedGPUs(ctx); err != nil {
+			log.Printf("Failed to load expected GPU counts: %v", err)
+		}
+		go ae.watchExpectedGPUs(ctx)
+	}
+	if ae.cfg.StoreSampleEvery > 1 {
+		if err := ae.refreshAlerting(ctx); err != nil {
+			log.Printf("Failed to load active alerts for storage sampling: %v", err)
+		}
+		go ae.watchAlerting(ctx)
+	}
+	// Work on a fetched message runs under procCtx, which outlives ctx by
+	// up to ShutdownDrainTimeout so the in-flight message is finished and
+	// committed rather than re-read (or, in async mode, lost) on restart
+	procCtx, cancelProc := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelProc()
+	stopDrainTimer := context.AfterFunc(ctx, func() {
+		time.AfterFunc(ae.cfg.ShutdownDrainTimeout, cancelProc)
+	})
+	defer stopDrainTimer()
+
+	// The async writer stops only after the consume loop, so it flushes
+	// the last queued metric too
+	writerCtx, stopWriter := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopWriter()
+	if ae.storeQueue != nil {
+		go ae.runMetricWriter(writerCtx)
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			log.Println("Alert Engine shutting down")
-			ae.kafkaReader.Close()
+			stopWriter()
+			if ae.storeDone != nil {
+				<-ae.storeDone
+			}
+			ae.notifyWG.Wait()
+			ae.source.Close()
+			if ae.alertWriter != nil {
+				ae.alertWriter.Close()
+			}
 			ae.db.Close()
 			return nil
 
 		default:
-			msg, err := ae.kafkaReader.FetchMessage(ctx)
+			msg, err := ae.source.FetchMessage(ctx)
 			if err != nil {
+				if ctx.Err() != nil {
+					continue
+				}
 				log.Printf("Error fetching message: %v", err)
+				ae.backoffOnError(ctx)
 				continue
 			}
-
-			var metric GPUMetric
-			if err := json.Unmarshal(msg.Value, &metric); err != nil {
-				log.Printf("Error unmarshaling metric: %v", err)
-				ae.kafkaReader.CommitMessages(ctx, msg)
-				continue
+			if ae.kafkaReader != nil {
+				ae.recordLag(msg)
 			}
+			ae.processMessage(procCtx, msg)
+		}
+	}
+}
 
-			// Store metric
-			if err := ae.StoreMetric(metric); err != nil {
-				log.Printf("Error storing metric: %v", err)
-			}
+// processMessage stores, evaluates and commits one fetched message. The
+// offset is left uncommitted if ctx ends first.
+func (ae *AlertEngine) processMessage(ctx context.Context, msg kafka.Message) {
+	metrics, err := ae.decodeMetrics(msg)
+	for errors.Is(err, ErrRetriable) && ctx.Err() == nil {
+		// e.g. the schema registry is down; the message itself may be fine
+		log.Printf("Error decoding metric at partition %d offset %d, retrying: %v", msg.Partition, msg.Offset, err)
+		ae.backoffOnError(ctx)
+		metrics, err = ae.decodeMetrics(msg)
+	}
+	if errors.Is(err, ErrRetriable) {
+		// ctx ended while retrying; leave the offset for redelivery
+		return
+	}
+	if err != nil {
+		ae.decodeErrors.Add(1)
+		log.Printf("Error decoding metric at partition %d offset %d, skipping: %v", msg.Partition, msg.Offset, err)
+		ae.commitMessage(ctx, msg)
+		return
+	}
 
-			// Evaluate alert rules
-			alerts := ae.EvaluateRules(metric)
-			for _, alert := range alerts {
-				if err := ae.CreateAlert(alert); err != nil {
-					log.Printf("Error creating alert: %v", err)
-				}
+	for _, metric := range metrics {
+		ae.applyTimeSource(&metric, msg.Time)
+		if !memoryKnown(metric) {
+			ae.incompleteMetrics.Add(1)
+		}
+		ae.processMetric(ctx, metric)
+		if ctx.Err() != nil {
+			log.Printf("Drain timeout reached, leaving offset %d on partition %d uncommitted", msg.Offset, msg.Partition)
+			return
+		}
+	}
+
+	ae.lastProcessed.Store(time.Now().UnixNano())
+
+	// Commit message
+	ae.commitMessage(ctx, msg)
+}
+
+// processMetric stores one decoded metric and creates the alerts it raises.
+// It returns early once ctx is done, leaving the message to be redelivered.
+func (ae *AlertEngine) processMetric(ctx context.Context, metric GPUMetric) {
+	ae.ingest.Add(metric.NodeID, metric.GPUIndex, time.Now())
+	stored := ae.sampleForStorage(metric)
+	if stored {
+		ae.persistMetric(ctx, metric)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+
+	// Evaluate alert rules
+	alerts := ae.EvaluateRules(metric)
+	if !stored {
+		if len(alerts) == 0 {
+			ae.sampledOut.Add(1)
+		} else {
+			// Keep the sample that raised the alert
+			ae.persistMetric(ctx, metric)
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+	warmingUp := ae.inWarmup(ctx, metric)
+	for _, alert := range alerts {
+		if ae.cfg.DryRun {
+			if err := ae.RecordShadowAlert(alert, shadowSourceLive, metric.CollectedAt); err != nil {
+				log.Printf("Error recording shadow alert: %v", err)
+			}
+			continue
+		}
+		err := ae.retryRetriable(ctx, "Creating alert", func() error {
+			if warmingUp {
+				return ae.RecordWarmupAlert(ctx, alert)
 			}
+			return ae.CreateAlert(ctx, alert)
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error creating alert: %v", err)
+		}
+	}
+}
+
+// persistMetric stores metric. Timed-out writes are retried in place so the
+// offset is never committed past a stuck write; in async mode the metric is
+// only queued and may still be lost.
+func (ae *AlertEngine) persistMetric(ctx context.Context, metric GPUMetric) {
+	var err error
+	if ae.storeQueue != nil {
+		err = ae.queueMetric(ctx, metric)
+	} else {
+		err = ae.retryRetriable(ctx, "Storing metric", func() error {
+			return ae.StoreMetric(ctx, metric)
+		})
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("Error storing metric: %v", err)
+		ae.backoffOnError(ctx)
+	} else {
+		ae.errorStreak.Store(0)
+	}
+}
+
+// sampleForStorage reports whether metric should be stored under
+// StoreSampleEvery: a GPU's first sample, every Nth after it, and all of
+// them while the GPU or its node has an active alert
+func (ae *AlertEngine) sampleForStorage(metric GPUMetric) bool {
+	every := ae.cfg.StoreSampleEvery
+	if every <= 1 {
+		return true
+	}
+	hist := ae.gpus[gpuKey{NodeID: metric.NodeID, GPUIndex: metric.GPUIndex}]
+	if hist == nil {
+		return true
+	}
+	if ae.isAlerting(metric.NodeID, metric.GPUIndex) {
+		hist.unstored = 0
+		return true
+	}
+	hist.unstored++
+	if hist.unstored < every {
+		return false
+	}
+	hist.unstored = 0
+	return true
+}
+
+// isAlerting reports whether the GPU or its node has an active alert
+func (ae *AlertEngine) isAlerting(nodeID string, gpuIndex int) bool {
+	ae.alertingMu.RLock()
+	defer ae.alertingMu.RUnlock()
+	return ae.alerting[gpuKey{NodeID: nodeID, GPUIndex: gpuIndex}] ||
+		ae.alerting[gpuKey{NodeID: nodeID, GPUIndex: nodeWideGPU}]
+}
+
+// markAlerting records a newly created active alert, so its GPU is stored
+// in full before the next refreshAlerting
+func (ae *AlertEngine) markAlerting(nodeID string, gpuIndex int) {
+	if ae.cfg.StoreSampleEvery <= 1 {
+		return
+	}
+	ae.alertingMu.Lock()
+	ae.alerting[gpuKey{NodeID: nodeID, GPUIndex: gpuIndex}] = true
+	ae.alertingMu.Unlock()
+}
+
+// alertingRefreshInterval is how often the active-alert set used by
+// StoreSampleEvery is reloaded, picking up alerts resolved elsewhere
+const alertingRefreshInterval = 30 * time.Second
+
+// refreshAlerting reloads the GPUs and nodes with active alerts
+func (ae *AlertEngine) refreshAlerting(ctx context.Context) error {
+	stmtCtx, cancel := ae.statementContext(ctx)
+	defer cancel()
+
+	rows, err := ae.db.QueryContext(stmtCtx,
+		"SELECT DISTINCT node_id, gpu_index FROM alerts WHERE status = 'active' AND gpu_index IS NOT NULL")
+	if err != nil {
+		return dbError(stmtCtx, err)
+	}
+	defer rows.Close()
+
+	alerting := make(map[gpuKey]bool)
+	for rows.Next() {
+		var key gpuKey
+		if err := rows.Scan(&key.NodeID, &key.GPUIndex); err != nil {
+			return err
+		}
+		alerting[key] = true
+	}
+	if err := rows.Err(); err != nil {
+		return dbError(stmtCtx, err)
+	}
+
+	ae.alertingMu.Lock()
+	ae.alerting = alerting
+	ae.alertingMu.Unlock()
+	return nil
+}
 
-			// Commit message
-			ae.kafkaReader.CommitMessages(ctx, msg)
+// watchAlerting runs refreshAlerting every alertingRefreshInterval
+func (ae *AlertEngine) watchAlerting(ctx context.Context) {
+	ticker := time.NewTicker(alertingRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
+		if err := ae.refreshAlerting(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to refresh active alerts for storage sampling: %v", err)
+		}
+	}
+}
+
+// registerPprof exposes the net/http/pprof handlers on mux
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// fetchTotals are cumulative Kafka fetch counts plus the largest fetch
+// seen since the previous scrape
+type fetchTotals struct {
+	fetches  int64
+	messages int64
+	bytes    int64
+	waitTime time.Duration
+	queueLen int64
+	maxSize  int64
+	maxBytes int64
+	maxWait  time.Duration
+}
+
+// fetchStats guards the fetch totals exported on /metrics
+type fetchStats struct {
+	mu     sync.Mutex
+	totals fetchTotals
+}
+
+// collect folds a reader stats snapshot into the totals and returns them
+func (f *fetchStats) collect(stats kafka.ReaderStats) fetchTotals {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &f.totals
+	t.fetches += stats.FetchSize.Count
+	t.messages += stats.FetchSize.Sum
+	t.bytes += stats.FetchBytes.Sum
+	t.waitTime += stats.WaitTime.Sum
+	t.queueLen = stats.QueueLength
+	t.maxSize = stats.FetchSize.Max
+	t.maxBytes = stats.FetchBytes.Max
+	t.maxWait = stats.WaitTime.Max
+	return *t
+}
+
+// EngineHealth is the body of the internal /health endpoint. Collectors
+// poll ConsumerLag to back off when the engine falls behind.
+type EngineHealth struct {
+	Status      string `json:"status"`
+	ConsumerLag int64  `json:"consumer_lag"`
+	ErrorStreak int64  `json:"error_streak"`
+}
+
+// handleHealth reports consumer lag and the consume loop's error streak
+func (ae *AlertEngine) handleHealth(w http.ResponseWriter, r *http.Request) {
+	health := EngineHealth{
+		Status:      "ok",
+		ConsumerLag: ae.consumerLag(),
+		ErrorStreak: ae.errorStreak.Load(),
+	}
+	if health.ErrorStreak > 0 {
+		health.Status = "degraded"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(health)
+}
+
+// Readiness is the body of the internal /readyz endpoint
+type Readiness struct {
+	Status              string     `json:"status"`
+	LastProcessedAt     *time.Time `json:"last_processed_at"`
+	IdleSeconds         float64    `json:"idle_seconds"`
+	DeadmanAfterSeconds float64    `json:"deadman_after_seconds"`
+}
+
+// handleReadyz is the pipeline dead-man switch: it responds 503 once no
+// message has been processed for DeadmanAfter, which covers the collectors,
+// Kafka and the engine itself with one check
+func (ae *AlertEngine) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	idle, processed := ae.idleFor(now)
+	ready := Readiness{
+		Status:              "ready",
+		IdleSeconds:         idle.Seconds(),
+		DeadmanAfterSeconds: ae.cfg.DeadmanAfter.Seconds(),
+	}
+	if processed {
+		last := now.Add(-idle)
+		ready.LastProcessedAt = &last
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if idle >= ae.cfg.DeadmanAfter {
+		ready.Status = "stalled"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(ready)
+}
+
+// handleMetrics serves engine health counters in Prometheus text format
+func (ae *AlertEngine) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintf(w, "# HELP alert_engine_error_streak Consecutive failed fetch/store iterations of the consume loop\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_error_streak gauge\n")
+	fmt.Fprintf(w, "alert_engine_error_streak %d\n", ae.errorStreak.Load())
+	fmt.Fprintf(w, "# HELP alert_engine_errors_total Failed fetch/store iterations of the consume loop\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_errors_total counter\n")
+	fmt.Fprintf(w, "alert_engine_errors_total %d\n", ae.errorsTotal.Load())
+	fmt.Fprintf(w, "# HELP alert_engine_decode_errors_total Messages skipped because they could not be decoded\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_decode_errors_total counter\n")
+	fmt.Fprintf(w, "alert_engine_decode_errors_total %d\n", ae.decodeErrors.Load())
+	fmt.Fprintf(w, "# HELP alert_engine_incomplete_metrics_total Samples stored as partial because memory_total_mb was missing\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_incomplete_metrics_total counter\n")
+	fmt.Fprintf(w, "alert_engine_incomplete_metrics_total %d\n", ae.incompleteMetrics.Load())
+	fmt.Fprintf(w, "# HELP alert_engine_topic_partitions Partitions of the consumed topic\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_topic_partitions gauge\n")
+	fmt.Fprintf(w, "alert_engine_topic_partitions %d\n", ae.topicPartitions.Load())
+	fmt.Fprintf(w, "# HELP alert_engine_group_members Members of the consumer group\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_group_members gauge\n")
+	fmt.Fprintf(w, "alert_engine_group_members %d\n", ae.groupMembers.Load())
+	fmt.Fprintf(w, "# HELP alert_engine_last_processed_timestamp_seconds Unix time the last message was processed; 0 before the first\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_last_processed_timestamp_seconds gauge\n")
+	fmt.Fprintf(w, "alert_engine_last_processed_timestamp_seconds %.3f\n", float64(ae.lastProcessed.Load())/1e9)
+	rates := ae.ingest.Rates(time.Now())
+	fmt.Fprintf(w, "# HELP alert_engine_node_samples_per_minute Samples received from each node over the last minute\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_node_samples_per_minute gauge\n")
+	for _, rate := range rates {
+		fmt.Fprintf(w, "alert_engine_node_samples_per_minute{node_id=%q} %d\n", rate.NodeID, rate.Samples)
+	}
+	fmt.Fprintf(w, "# HELP alert_engine_node_gpus_reporting GPUs each node sent samples for over the last minute\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_node_gpus_reporting gauge\n")
+	for _, rate := range rates {
+		fmt.Fprintf(w, "alert_engine_node_gpus_reporting{node_id=%q} %d\n", rate.NodeID, rate.GPUs)
+	}
+	fmt.Fprintf(w, "# HELP alert_engine_consumer_lag Messages behind the high-water mark across consumed partitions\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_consumer_lag gauge\n")
+	fmt.Fprintf(w, "alert_engine_consumer_lag %d\n", ae.consumerLag())
+
+	var readerStats kafka.ReaderStats
+	if ae.kafkaReader != nil {
+		readerStats = ae.kafkaReader.Stats()
+	}
+	fetch := ae.fetchStats.collect(readerStats)
+	fmt.Fprintf(w, "# HELP alert_engine_kafka_fetch_messages Messages returned per Kafka fetch\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_kafka_fetch_messages summary\n")
+	fmt.Fprintf(w, "alert_engine_kafka_fetch_messages_sum %d\n", fetch.messages)
+	fmt.Fprintf(w, "alert_engine_kafka_fetch_messages_count %d\n", fetch.fetches)
+	fmt.Fprintf(w, "# HELP alert_engine_kafka_fetch_bytes Bytes returned per Kafka fetch\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_kafka_fetch_bytes summary\n")
+	fmt.Fprintf(w, "alert_engine_kafka_fetch_bytes_sum %d\n", fetch.bytes)
+	fmt.Fprintf(w, "alert_engine_kafka_fetch_bytes_count %d\n", fetch.fetches)
+	fmt.Fprintf(w, "# HELP alert_engine_kafka_fetch_wait_seconds Time spent waiting on each Kafka fetch\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_kafka_fetch_wait_seconds summary\n")
+	fmt.Fprintf(w, "alert_engine_kafka_fetch_wait_seconds_sum %g\n", fetch.waitTime.Seconds())
+	fmt.Fprintf(w, "alert_engine_kafka_fetch_wait_seconds_count %d\n", fetch.fetches)
+	fmt.Fprintf(w, "# HELP alert_engine_kafka_fetch_max_messages Largest fetch in messages since the previous scrape\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_kafka_fetch_max_messages gauge\n")
+	fmt.Fprintf(w, "alert_engine_kafka_fetch_max_messages %d\n", fetch.maxSize)
+	fmt.Fprintf(w, "# HELP alert_engine_kafka_fetch_max_bytes Largest fetch in bytes since the previous scrape\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_kafka_fetch_max_bytes gauge\n")
+	fmt.Fprintf(w, "alert_engine_kafka_fetch_max_bytes %d\n", fetch.maxBytes)
+	fmt.Fprintf(w, "# HELP alert_engine_kafka_fetch_max_wait_seconds Longest fetch wait since the previous scrape\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_kafka_fetch_max_wait_seconds gauge\n")
+	fmt.Fprintf(w, "alert_engine_kafka_fetch_max_wait_seconds %g\n", fetch.maxWait.Seconds())
+	fmt.Fprintf(w, "# HELP alert_engine_kafka_queue_length Fetched messages buffered ahead of the consume loop\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_kafka_queue_length gauge\n")
+	fmt.Fprintf(w, "alert_engine_kafka_queue_length %d\n", fetch.queueLen)
+	if ae.storeQueue != nil {
+		fmt.Fprintf(w, "# HELP alert_engine_store_queue_depth Metrics waiting in the async store queue\n")
+		fmt.Fprintf(w, "# TYPE alert_engine_store_queue_depth gauge\n")
+		fmt.Fprintf(w, "alert_engine_store_queue_depth %d\n", len(ae.storeQueue))
+		fmt.Fprintf(w, "# HELP alert_engine_store_dropped_total Buffered metrics lost to failed batch writes\n")
+		fmt.Fprintf(w, "# TYPE alert_engine_store_dropped_total counter\n")
+		fmt.Fprintf(w, "alert_engine_store_dropped_total %d\n", ae.storeDropped.Load())
+	}
+	if ae.notifyQueue != nil {
+		fmt.Fprintf(w, "# HELP alert_engine_notify_queue_depth Notifications waiting for a worker\n")
+		fmt.Fprintf(w, "# TYPE alert_engine_notify_queue_depth gauge\n")
+		fmt.Fprintf(w, "alert_engine_notify_queue_depth %d\n", len(ae.notifyQueue))
+		fmt.Fprintf(w, "# HELP alert_engine_notifications_deferred_total Notifications left to the retry worker because the queue was full\n")
+		fmt.Fprintf(w, "# TYPE alert_engine_notifications_deferred_total counter\n")
+		fmt.Fprintf(w, "alert_engine_notifications_deferred_total %d\n", ae.notifyDeferred.Load())
+	}
+	fmt.Fprintf(w, "# HELP alert_engine_metrics_sampled_out_total Metrics not stored because of STORE_SAMPLE_EVERY\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_metrics_sampled_out_total counter\n")
+	fmt.Fprintf(w, "alert_engine_metrics_sampled_out_total %d\n", ae.sampledOut.Load())
+	fmt.Fprintf(w, "# HELP alert_engine_alerts_not_persisted_total Alerts below ALERT_MIN_PERSIST_SEVERITY, counted instead of stored\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_alerts_not_persisted_total counter\n")
+	for _, sev := range []string{"info", "warning"} {
+		fmt.Fprintf(w, "alert_engine_alerts_not_persisted_total{severity=%q} %d\n", sev, ae.notPersisted[severityRank[sev]].Load())
+	}
+	if ae.archive != nil {
+		fmt.Fprintf(w, "# HELP alert_engine_metrics_archived_total Expired metrics uploaded to ARCHIVE_S3_BUCKET\n")
+		fmt.Fprintf(w, "# TYPE alert_engine_metrics_archived_total counter\n")
+		fmt.Fprintf(w, "alert_engine_metrics_archived_total %d\n", ae.archivedMetrics.Load())
 	}
+	fmt.Fprintf(w, "# HELP alert_engine_alerts_per_minute Alerts created over the last minute\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_alerts_per_minute gauge\n")
+	fmt.Fprintf(w, "alert_engine_alerts_per_minute %d\n", ae.alertRate.Count(time.Now()))
+	storm := 0
+	if ae.inStorm.Load() {
+		storm = 1
+	}
+	fmt.Fprintf(w, "# HELP alert_engine_alert_storm Whether an alert storm is in progress\n")
+	fmt.Fprintf(w, "# TYPE alert_engine_alert_storm gauge\n")
+	fmt.Fprintf(w, "alert_engine_alert_storm %d\n", storm)
+	ae.resolutions.WriteTo(w, "alert_resolution_duration_seconds", "Time from triggering to resolution of alerts resolved since startup, by severity")
+}
+
+// StartInternalServer serves operator-only endpoints on InternalAddr
+func (ae *AlertEngine) StartInternalServer(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", ae.handleMetrics)
+	mux.HandleFunc("/health", ae.handleHealth)
+	mux.HandleFunc("/readyz", ae.handleReadyz)
+	if ae.cfg.PprofEnabled {
+		registerPprof(mux)
+	}
+
+	server := &http.Server{Addr: ae.cfg.InternalAddr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	go func() {
+		log.Printf("Internal server listening on %s (pprof: %t)", ae.cfg.InternalAddr, ae.cfg.PprofEnabled)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Internal server failed: %v", err)
+		}
+	}()
 }
 
 func main() {
-	dbConnStr := "host=localhost port=5432 user=telemetry password=telemetry123 dbname=gpu_telemetry sslmode=disable"
-	kafkaBroker := "localhost:9093"
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	var redactor *logRedactor
+	if len(cfg.LogRedactFields) > 0 {
+		redactor = newLogRedactor(os.Stderr, cfg.LogRedactFields)
+		log.SetOutput(redactor)
+		// Mask the database host in connection errors from the start; Run
+		// adds the rest
+		if host := dbHost(cfg.DBConnStr); host != "" && redactor.redacts("hostname") {
+			redactor.setValues([]string{host})
+		}
+	}
 
-	engine, err := NewAlertEngine(dbConnStr, kafkaBroker)
+	engine, err := NewAlertEngine(cfg)
 	if err != nil {
 		log.Fatalf("Failed to create alert engine: %v", err)
 	}
+	engine.redactor = redactor
+
+	// SIGINT/SIGTERM stop consumption; Run then drains the in-flight message
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if cfg.InternalAddr != "" {
+		engine.StartInternalServer(ctx)
+	}
+	if cfg.BacktestSince > 0 {
+		if err := engine.Backtest(ctx, cfg.BacktestSince); err != nil {
+			log.Fatalf("Backtest failed: %v", err)
+		}
+		return
+	}
+	if cfg.DryRun {
+		log.Println("Dry-run mode: alerts are recorded to shadow_alerts only, no actions are taken")
+	}
 
-	ctx := context.Background()
 	if err := engine.Run(ctx); err != nil {
 		log.Fatalf("Alert engine failed: %v", err)
 	}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"google.golang.org/protobuf/proto"
+
+	"gpu-telemetry/alert-engine/gpupb"
+)
+
+// TestCodecRoundTrip compresses a collector-encoded metric into a record
+// batch with each registered codec and checks the engine decodes it back
+func TestCodecRoundTrip(t *testing.T) {
+	registerCodecs()
+	ae := &AlertEngine{cfg: Config{MessageFormat: formatJSON}}
+	want := GPUMetric{
+		NodeID:             "node-1",
+		GPUIndex:           3,
+		TemperatureCelsius: 71.5,
+		PowerWatts:         312,
+		MemoryUsedMB:       40960,
+		MemoryTotalMB:      81920,
+		UtilizationPercent: 97,
+		SMClockMHz:         1980,
+		FanSpeedPercent:    64,
+		CollectedAt:        time.Unix(1700000000, 0).UTC(),
+		Labels:             map[string]string{"job_id": "train-42"},
+	}
+	value, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, codec := range kafkaCodecs {
+		t.Run(codec.Name(), func(t *testing.T) {
+			written := protocol.RecordSet{
+				Version:    2,
+				Attributes: protocol.Attributes(codec.Code()),
+				Records: protocol.NewRecordReader(protocol.Record{
+					Time:    want.CollectedAt,
+					Key:     protocol.NewBytes([]byte("node-1/3")),
+					Value:   protocol.NewBytes(value),
+					Headers: []protocol.Header{{Key: contentTypeHeader, Value: []byte("application/json")}},
+				}),
+			}
+			var buf bytes.Buffer
+			if _, err := written.WriteTo(&buf); err != nil {
+				t.Fatalf("encode batch: %v", err)
+			}
+
+			var read protocol.RecordSet
+			if _, err := read.ReadFrom(&buf); err != nil {
+				t.Fatalf("decode batch: %v", err)
+			}
+			if got := int8(read.Attributes.Compression()); got != codec.Code() {
+				t.Fatalf("batch compression = %d, want %d", got, codec.Code())
+			}
+			rec, err := read.Records.ReadRecord()
+			if err != nil {
+				t.Fatalf("read record: %v", err)
+			}
+			body, err := io.ReadAll(rec.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			msg := kafka.Message{Value: body}
+			for _, h := range rec.Headers {
+				msg.Headers = append(msg.Headers, kafka.Header{Key: h.Key, Value: h.Value})
+			}
+			metrics, err := ae.decodeMetrics(msg)
+			if err != nil {
+				t.Fatalf("decode metric: %v", err)
+			}
+			if len(metrics) != 1 || !reflect.DeepEqual(metrics[0], want) {
+				t.Fatalf("decoded %+v, want %+v", metrics, want)
+			}
+		})
+	}
+}
+
+// TestRemediationSkipsSelfNode checks critical self-monitoring alerts only
+// notify, while critical alerts on real nodes still migrate workloads
+func TestRemediationSkipsSelfNode(t *testing.T) {
+	ae := &AlertEngine{cfg: Config{SelfNodeID: "alert-engine"}, drainer: noopDrainer{}}
+	tests := []struct {
+		name  string
+		alert Alert
+		want  int
+	}{
+		{"consumer_lag", Alert{NodeID: "alert-engine", GPUIndex: nodeWideGPU, AlertType: "consumer_lag", Severity: "critical"}, 0},
+		{"pipeline_stalled", Alert{NodeID: "alert-engine", GPUIndex: nodeWideGPU, AlertType: "pipeline_stalled", Severity: "critical"}, 0},
+		{"gpu node", Alert{NodeID: "node-1", GPUIndex: 2, AlertType: "temperature", Severity: "critical"}, 1},
+		{"warning", Alert{NodeID: "node-1", GPUIndex: 2, AlertType: "temperature", Severity: "warning"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions := ae.remediationActions(tt.alert)
+			migrations := 0
+			for _, a := range actions {
+				if a.actionType == actionMigration {
+					migrations++
+				}
+			}
+			if migrations != tt.want || len(actions) != tt.want {
+				t.Fatalf("got %d migrations in %d actions, want %d", migrations, len(actions), tt.want)
+			}
+		})
+	}
+}
+
+// TestAlertStormDedupKeys checks a storm's end notice and a later storm are
+// sent under dedup keys distinct from the first storm's start notice
+func TestAlertStormDedupKeys(t *testing.T) {
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		keys = append(keys, payload["dedup_key"])
+	}))
+	defer srv.Close()
+
+	ch := NotifyChannel{Name: "pager", Type: channelWebhook, URL: srv.URL, Severities: []string{"critical"}}
+	if err := ch.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	ae := &AlertEngine{cfg: Config{AlertStormThreshold: 10}, channels: []NotifyChannel{ch}}
+
+	ae.updateAlertStorm(10)
+	ae.updateAlertStorm(12) // still in the storm, no notice
+	ae.updateAlertStorm(3)
+	time.Sleep(2 * time.Millisecond) // a later storm starts in another millisecond
+	ae.updateAlertStorm(11)
+
+	if len(keys) != 3 {
+		t.Fatalf("sent %d storm notices %v, want 3", len(keys), keys)
+	}
+	if keys[1] != keys[0]+"-over" {
+		t.Errorf("end notice key %q, want %q", keys[1], keys[0]+"-over")
+	}
+	if keys[2] == keys[0] {
+		t.Errorf("second storm reused the first storm's key %q", keys[0])
+	}
+}
+
+// TestEscalationCountsReopenedFirings checks a warning that flaps within
+// ALERT_DEDUP_WINDOW still escalates: escalation counts alert_firings, which
+// reopening adds to, rather than alert rows, which reopening reuses
+func TestEscalationCountsReopenedFirings(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ae := &AlertEngine{db: db, cfg: Config{
+		EscalationCount:    3,
+		EscalationWindow:   time.Hour,
+		DedupWindow:        10 * time.Minute,
+		DBStatementTimeout: time.Second,
+	}}
+	alert := Alert{NodeID: "node-1", GPUIndex: 2, AlertType: "temperature", Severity: "warning", Message: "GPU hot"}
+
+	// Third firing: the first two were one row, resolved and reopened
+	mock.ExpectQuery(`SELECT COUNT\(\*\)\s+FROM alert_firings`).
+		WithArgs(alert.DedupKey(), time.Hour.Seconds()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	// Resolved in between, so there is no active alert to raise in place
+	mock.ExpectQuery(`UPDATE alerts a\s+SET severity`).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`INSERT INTO alert_firings \(alert_id, dedup_key\)\s+SELECT id, dedup_key FROM reopened`).
+		WithArgs(alert.DedupKey(), (10 * time.Minute).Seconds(), "critical", sqlmock.AnyArg(), 0.0, 0.0).
+		WillReturnRows(sqlmock.NewRows([]string{"alert_id"}).AddRow(7))
+
+	ctx := context.Background()
+	handled, err := ae.escalate(ctx, &alert)
+	if err != nil || handled {
+		t.Fatalf("escalate = %v, %v; want unhandled with no error", handled, err)
+	}
+	if alert.Severity != "critical" {
+		t.Fatalf("severity = %q, want critical", alert.Severity)
+	}
+	alertID, reopened, err := ae.reopenRecent(ctx, alert)
+	if err != nil || !reopened || alertID != 7 {
+		t.Fatalf("reopenRecent = %d, %v, %v; want 7, true, nil", alertID, reopened, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestStoredMetricRoundTrip checks a sample stored by insertMetricArgs is
+// rebuilt for backtests as live evaluation saw it: unreported fields stored
+// as NULL come back in Unreported, and labels are restored
+func TestStoredMetricRoundTrip(t *testing.T) {
+	want := GPUMetric{
+		NodeID:             "node-1",
+		GPUIndex:           1,
+		TemperatureCelsius: 84,
+		MemoryUsedMB:       1024,
+		MemoryTotalMB:      81920,
+		UtilizationPercent: 12,
+		SMClockMHz:         1410,
+		FanSpeedPercent:    55,
+		CollectedAt:        time.Unix(1700000000, 0).UTC(),
+		Labels:             map[string]string{"job_id": "train-42"},
+		GPUUUID:            "GPU-1234",
+		Unreported:         []string{"power_watts"},
+	}
+	args := insertMetricArgs(want)
+	if power := args[3].(sql.NullFloat64); power.Valid {
+		t.Fatalf("power_watts stored as %v, want NULL", power.Float64)
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// Columns as stored, in backtestColumns order
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{
+		"node_id", "gpu_index", "temperature_celsius", "power_watts", "memory_used_mb",
+		"memory_total_mb", "utilization_percent", "sm_clock_mhz", "fan_speed_percent",
+		"row_remap_pending", "collected_at", "labels", "gpu_uuid",
+	}).AddRow(args[0], args[1], args[2], nil, args[4], args[5], args[6], args[7],
+		args[8].(sql.NullFloat64).Float64, args[9], args[10], args[12], "GPU-1234"))
+
+	rows, err := db.Query("SELECT " + backtestColumns + " FROM gpu_metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatal("no row")
+	}
+	got, err := scanStoredMetric(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+// TestDecodeProtoMetric checks a metric marshaled with the generated gpupb
+// types, as the collector publishes it, decodes back field for field
+func TestDecodeProtoMetric(t *testing.T) {
+	want := GPUMetric{
+		NodeID:                  "node-1",
+		GPUIndex:                3,
+		TemperatureCelsius:      71.5,
+		PowerWatts:              312,
+		MemoryUsedMB:            40960,
+		MemoryTotalMB:           81920,
+		UtilizationPercent:      97,
+		SMClockMHz:              1980,
+		RowRemapPending:         1,
+		CollectedAt:             time.Unix(1700000000, 5).UTC(),
+		Labels:                  map[string]string{"job_id": "train-42"},
+		Histograms:              map[string]Histogram{"sm_occupancy": {Buckets: []HistogramBucket{{LE: 0.5, Count: 3}, {LE: 1, Count: 10}}, Count: 10, Sum: 6.2}},
+		EnforcedPowerLimitWatts: 350,
+		GPUUUID:                 "GPU-1234",
+		Unreported:              []string{"fan_speed_percent"},
+	}
+	data, err := proto.Marshal(&gpupb.GPUMetric{
+		NodeId:                  "node-1",
+		GpuIndex:                3,
+		TemperatureCelsius:      71.5,
+		PowerWatts:              312,
+		MemoryUsedMb:            40960,
+		MemoryTotalMb:           81920,
+		UtilizationPercent:      97,
+		SmClockMhz:              1980,
+		RowRemapPending:         1,
+		CollectedAt:             want.CollectedAt.UnixNano(),
+		Labels:                  map[string]string{"job_id": "train-42"},
+		Histograms:              map[string]*gpupb.Histogram{"sm_occupancy": {Buckets: []*gpupb.HistogramBucket{{Le: 0.5, Count: 3}, {Le: 1, Count: 10}}, Count: 10, Sum: 6.2}},
+		EnforcedPowerLimitWatts: 350,
+		GpuUuid:                 "GPU-1234",
+		Unreported:              []string{"fan_speed_percent"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeProtoMetric(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+// collectorAvroSchema is the collector's gpuMetricAvroSchema, as the schema
+// registry returns it
+const collectorAvroSchema = `{
+  "type": "record",
+  "name": "GPUMetric",
+  "namespace": "gpu_telemetry",
+  "fields": [
+    {"name": "node_id", "type": "string"},
+    {"name": "gpu_index", "type": "int"},
+    {"name": "temperature_celsius", "type": "double"},
+    {"name": "power_watts", "type": "double"},
+    {"name": "memory_used_mb", "type": "double"},
+    {"name": "memory_total_mb", "type": "double"},
+    {"name": "utilization_percent", "type": "double"},
+    {"name": "sm_clock_mhz", "type": "int"},
+    {"name": "fan_speed_percent", "type": "double", "default": 0},
+    {"name": "row_remap_pending", "type": "int", "default": 0},
+    {"name": "collected_at", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "labels", "type": {"type": "map", "values": "string"}, "default": {}},
+    {"name": "enforced_power_limit_watts", "type": "double", "default": 0},
+    {"name": "gpu_uuid", "type": "string", "default": ""},
+    {"name": "unreported", "type": {"type": "array", "items": "string"}, "default": []},
+    {"name": "histograms", "type": ["null", {"type": "map", "values": {
+      "type": "record",
+      "name": "Histogram",
+      "fields": [
+        {"name": "buckets", "type": {"type": "array", "items": {
+          "type": "record",
+          "name": "HistogramBucket",
+          "fields": [
+            {"name": "le", "type": "double"},
+            {"name": "count", "type": "double"}
+          ]
+        }}},
+        {"name": "count", "type": "double"},
+        {"name": "sum", "type": "double"}
+      ]
+    }}], "default": null}
+  ]
+}`
+
+// TestDecodeAvroHistograms decodes the collector's encoding of a metric
+// with histograms (its avroHistogramGolden) with the schema fetched from a
+// registry, and checks every field comes back
+func TestDecodeAvroHistograms(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/schemas/ids/7" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"schema": collectorAvroSchema})
+	}))
+	defer registry.Close()
+
+	value, err := hex.DecodeString("00000000070c6e6f64652d31060000000000e051400000000000807340000000000000e440000000000000f4400000000000405840f81e000000000000000000f6a1abfef962020c6a6f625f696410747261696e2d34320000000000000000000000020218736d5f6f63637570616e637904000000000000e03f0000000000000840000000000000f03f0000000000002440000000000000002440000000000000194000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ae := &AlertEngine{cfg: Config{MessageFormat: formatAvro, SchemaRegistryURL: registry.URL}}
+	got, err := ae.decodeMetric(kafka.Message{Value: value})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := GPUMetric{
+		NodeID:             "node-1",
+		GPUIndex:           3,
+		TemperatureCelsius: 71.5,
+		PowerWatts:         312,
+		MemoryUsedMB:       40960,
+		MemoryTotalMB:      81920,
+		UtilizationPercent: 97,
+		SMClockMHz:         1980,
+		CollectedAt:        got.CollectedAt,
+		Labels:             map[string]string{"job_id": "train-42"},
+		Histograms: map[string]Histogram{"sm_occupancy": {
+			Buckets: []HistogramBucket{{LE: 0.5, Count: 3}, {LE: 1, Count: 10}},
+			Count:   10,
+			Sum:     6.25,
+		}},
+	}
+	if !got.CollectedAt.Equal(time.UnixMilli(1700000000123)) {
+		t.Fatalf("collected_at = %s", got.CollectedAt)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
@@ -1,309 +1,5296 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+// Config holds API server settings, loaded from the environment
+type Config struct {
+	DBConnStr string
+	Port      string
+	// DBConnectAttempts and DBConnectBackoff bound the startup wait for Postgres
+	DBConnectAttempts int
+	DBConnectBackoff  time.Duration
+	// StaleAfter is the default age beyond which a latest metric is flagged stale
+	StaleAfter time.Duration
+	// PipelineMaxLag is how old the freshest metric may be before the
+	// pipeline is reported degraded
+	PipelineMaxLag time.Duration
+	// InternalAddr is the operator-only HTTP listen address (e.g. 127.0.0.1:6060),
+	// separate from the public API port; empty disables it. PprofEnabled
+	// serves /debug/pprof there.
+	InternalAddr string
+	PprofEnabled bool
+	// AdminToken is the bearer token required by admin endpoints; empty
+	// disables them
+	AdminToken string
+	// ScoreWeights weighs the components of the node health score
+	ScoreWeights map[string]float64
+	// ExpensiveQueryLimit caps concurrent aggregate/percentile/export
+	// requests so they cannot starve cheap endpoints of DB connections;
+	// 0 disables the cap
+	ExpensiveQueryLimit int
+	// MaxResponseRows caps the rows of list endpoints without a limit
+	// parameter, and MaxResponseBytes the size of NDJSON exports; capped
+	// responses are marked truncated. 0 disables either cap.
+	MaxResponseRows  int
+	MaxResponseBytes int
+	// LogRedactFields names fields whose values are masked in log output
+	// (e.g. hostname, datacenter) before the logs leave the host
+	LogRedactFields []string
+	// LatestCacheTTL is how long /metrics/latest serves rows from memory
+	// before querying again; 0 disables the cache
+	LatestCacheTTL time.Duration
+	// AttentionAlertThreshold is the active alert count above which a node
+	// is flagged needs_attention
+	AttentionAlertThreshold int
+}
+
+// LoadConfig reads configuration from environment variables, falling back to
+// local development defaults
+func LoadConfig() (Config, error) {
+	cfg := Config{
+		DBConnStr: getEnv("DB_CONN_STR", "host=localhost port=5432 user=telemetry password=telemetry123 dbname=gpu_telemetry sslmode=disable"),
+		Port:      getEnv("PORT", "8080"),
+	}
+
+	var err error
+	if cfg.DBConnectAttempts, err = getEnvInt("DB_CONNECT_ATTEMPTS", 10); err != nil {
+		return cfg, err
+	}
+	if cfg.DBConnectAttempts < 1 {
+		return cfg, fmt.Errorf("DB_CONNECT_ATTEMPTS must be at least 1")
+	}
+	if cfg.DBConnectBackoff, err = getEnvDuration("DB_CONNECT_BACKOFF", time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.StaleAfter, err = getEnvDuration("STALE_AFTER", 2*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.PipelineMaxLag, err = getEnvDuration("PIPELINE_MAX_LAG", 2*time.Minute); err != nil {
+		return cfg, err
+	}
+	cfg.AdminToken = getEnv("ADMIN_TOKEN", "")
+	if cfg.ScoreWeights, err = parseScoreWeights(getEnv("SCORE_WEIGHTS", "temperature=0.35,alerts=0.35,utilization=0.1,freshness=0.2")); err != nil {
+		return cfg, err
+	}
+	if cfg.ExpensiveQueryLimit, err = getEnvInt("EXPENSIVE_QUERY_LIMIT", 4); err != nil {
+		return cfg, err
+	}
+	if cfg.ExpensiveQueryLimit < 0 {
+		return cfg, fmt.Errorf("EXPENSIVE_QUERY_LIMIT must not be negative")
+	}
+	if cfg.MaxResponseRows, err = getEnvInt("MAX_RESPONSE_ROWS", 10000); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxResponseBytes, err = getEnvInt("MAX_RESPONSE_BYTES", 64<<20); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxResponseRows < 0 || cfg.MaxResponseBytes < 0 {
+		return cfg, fmt.Errorf("MAX_RESPONSE_ROWS and MAX_RESPONSE_BYTES must not be negative")
+	}
+	cfg.InternalAddr = getEnv("INTERNAL_ADDR", "")
+	if cfg.PprofEnabled, err = getEnvBool("PPROF_ENABLED", false); err != nil {
+		return cfg, err
+	}
+	if cfg.PprofEnabled && cfg.InternalAddr == "" {
+		return cfg, fmt.Errorf("PPROF_ENABLED requires INTERNAL_ADDR")
+	}
+	cfg.LogRedactFields = splitList(getEnv("LOG_REDACT_FIELDS", ""))
+	if cfg.LatestCacheTTL, err = getEnvDuration("LATEST_CACHE_TTL", 2*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.LatestCacheTTL < 0 {
+		return cfg, fmt.Errorf("LATEST_CACHE_TTL must not be negative")
+	}
+	if cfg.AttentionAlertThreshold, err = getEnvInt("ATTENTION_ALERT_THRESHOLD", 3); err != nil {
+		return cfg, err
+	}
+	if cfg.AttentionAlertThreshold < 0 {
+		return cfg, fmt.Errorf("ATTENTION_ALERT_THRESHOLD must not be negative")
+	}
+	return cfg, nil
+}
+
+// getEnv returns the value of an environment variable or a default
+func getEnv(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
+
+// getEnvInt parses an integer environment variable
+func getEnvInt(key string, fallback int) (int, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fallback, fmt.Errorf("%s: invalid integer %q", key, v)
+	}
+	return n, nil
+}
+
+// getEnvBool parses a boolean environment variable
+func getEnvBool(key string, fallback bool) (bool, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fallback, fmt.Errorf("%s: invalid boolean %q", key, v)
+	}
+	return b, nil
+}
+
+// getEnvDuration parses a Go duration environment variable (e.g. "30s", "24h")
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fallback, fmt.Errorf("%s: invalid duration %q", key, v)
+	}
+	return d, nil
+}
+
+// parseScoreWeights parses SCORE_WEIGHTS, a comma-separated list of
+// component=weight pairs; omitted components weigh zero
+func parseScoreWeights(v string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	total := 0.0
+	for _, pair := range strings.Split(v, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("SCORE_WEIGHTS: expected component=weight, got %q", pair)
+		}
+		if !scoreComponents[name] {
+			return nil, fmt.Errorf("SCORE_WEIGHTS: unknown component %q, expected temperature, alerts, utilization or freshness", name)
+		}
+		w, err := strconv.ParseFloat(value, 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("SCORE_WEIGHTS: invalid weight %q for %s", value, name)
+		}
+		weights[name] = w
+		total += w
+	}
+	if total <= 0 {
+		return nil, fmt.Errorf("SCORE_WEIGHTS must give at least one component a positive weight")
+	}
+	return weights, nil
+}
+
+// splitList parses a comma-separated list, dropping empty entries
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// redactedValue replaces masked values in log output
+const redactedValue = "[REDACTED]"
+
+// logRedactor is the log output when LOG_REDACT_FIELDS is set. It masks the
+// value after each configured field name (field=value, field: value or
+// "field":"value"), and the hostnames and datacenters of registered nodes
+// wherever they appear, since database errors logged for failed requests
+// quote the offending values. Values stored in the database are unaffected.
+type logRedactor struct {
+	out    io.Writer
+	fields []string
+	keyed  *regexp.Regexp
+
+	mu     sync.RWMutex
+	values []string
+}
+
+func newLogRedactor(out io.Writer, fields []string) *logRedactor {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = regexp.QuoteMeta(f)
+	}
+	return &logRedactor{
+		out:    out,
+		fields: fields,
+		keyed:  regexp.MustCompile(`((?:\b|")(?:` + strings.Join(names, "|") + `)"?\s*[=:]\s*)(?:(")[^"]*"|[^\s,;}]+)`),
+	}
+}
+
+// Write masks one log line and passes it on
+func (l *logRedactor) Write(p []byte) (int, error) {
+	line := l.keyed.ReplaceAllString(string(p), "${1}${2}"+redactedValue+"${2}")
+	l.mu.RLock()
+	for _, v := range l.values {
+		line = strings.ReplaceAll(line, v, redactedValue)
+	}
+	l.mu.RUnlock()
+	if _, err := io.WriteString(l.out, line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactColumns are the gpu_nodes columns whose values are masked in logs
+// when LOG_REDACT_FIELDS names them
+var redactColumns = []string{"hostname", "datacenter"}
+
+// redactRefreshInterval is how often the masked gpu_nodes values are re-read
+const redactRefreshInterval = 5 * time.Minute
+
+// redacts reports whether field is one of the masked fields
+func (l *logRedactor) redacts(field string) bool {
+	for _, f := range l.fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// setValues replaces the known values masked wherever they appear
+func (l *logRedactor) setValues(values []string) {
+	// Longest first so a value containing another is masked whole
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	l.mu.Lock()
+	l.values = values
+	l.mu.Unlock()
+}
+
+// refresh sets l's known values to the gpu_nodes values of each redacted
+// column, plus the database host for hostname
+func (l *logRedactor) refresh(db *sql.DB, dbHost string) error {
+	var values []string
+	for _, col := range redactColumns {
+		if !l.redacts(col) {
+			continue
+		}
+		if col == "hostname" && dbHost != "" {
+			values = append(values, dbHost)
+		}
+		// col comes from redactColumns, never from input
+		rows, err := db.Query(fmt.Sprintf("SELECT DISTINCT %[1]s FROM gpu_nodes WHERE %[1]s <> ''", col))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var v string
+			if err := rows.Scan(&v); err != nil {
+				rows.Close()
+				return err
+			}
+			values = append(values, v)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	l.setValues(values)
+	return nil
+}
+
+// watch refreshes l every redactRefreshInterval, so newly registered nodes
+// are masked too
+func (l *logRedactor) watch(db *sql.DB, dbHost string) {
+	for range time.Tick(redactRefreshInterval) {
+		if err := l.refresh(db, dbHost); err != nil {
+			log.Printf("Failed to refresh redacted log values: %v", err)
+		}
+	}
+}
+
+// dbHost returns the host in a Postgres connection string, URL or
+// key=value form
+func dbHost(connStr string) string {
+	if u, err := url.Parse(connStr); err == nil && u.Scheme != "" {
+		return u.Hostname()
+	}
+	for _, kv := range strings.Fields(connStr) {
+		if host, ok := strings.CutPrefix(kv, "host="); ok {
+			return strings.Trim(host, "'")
+		}
+	}
+	return ""
+}
+
+// maxDBBackoff caps the delay between database connection attempts
+const maxDBBackoff = 30 * time.Second
+
+// connectDB opens the database and pings it with bounded exponential backoff,
+// so a service started before Postgres is ready waits instead of crash-looping.
+// Once connected, database/sql's pool transparently replaces dropped connections.
+func connectDB(connStr string, attempts int, backoff time.Duration) (*sql.DB, error) {
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	db.SetConnMaxLifetime(30 * time.Minute)
+
+	for attempt := 1; ; attempt++ {
+		err = db.Ping()
+		if err == nil {
+			return db, nil
+		}
+		if attempt >= attempts {
+			db.Close()
+			return nil, fmt.Errorf("failed to ping database after %d attempts: %w", attempts, err)
+		}
+
+		log.Printf("Database not ready (attempt %d/%d): %v; retrying in %s", attempt, attempts, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxDBBackoff {
+			backoff = maxDBBackoff
+		}
+	}
+}
+
 type APIServer struct {
 	db     *sql.DB
 	router *mux.Router
+	cfg    Config
+	// expensive holds one slot per in-flight expensive query; nil when
+	// EXPENSIVE_QUERY_LIMIT is 0
+	expensive chan struct{}
+	// latestCache holds recent /metrics/latest rows per filter; misses
+	// counts every request that queried the database
+	latestCache latestCache
+}
+
+type NodeHealth struct {
+	NodeID       string    `json:"node_id"`
+	Hostname     string    `json:"hostname"`
+	Status       string    `json:"status"`
+	Datacenter   string    `json:"datacenter"`
+	LastSeen     time.Time `json:"last_seen"`
+	ActiveAlerts int       `json:"active_alerts"`
+	HealthScore  *float64  `json:"health_score,omitempty"`
+	// MutedUntil is set while the node's alerts are muted
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+	// NeedsAttention is set when the node has an active critical alert,
+	// more than ATTENTION_ALERT_THRESHOLD active alerts, or hasn't been
+	// seen within STALE_AFTER
+	NeedsAttention bool `json:"needs_attention"`
+}
+
+// nodeHealthColumns select a NodeHealth from gpu_nodes n joined with its
+// active alerts a, grouped by node; $1 is the staleness cutoff in seconds.
+// Scan them with scanNodeHealth.
+const nodeHealthColumns = `
+		n.node_id, n.hostname, n.status, n.datacenter, n.last_seen,
+		COUNT(a.id) AS active_alerts,
+		CASE WHEN n.muted_until > NOW() THEN n.muted_until END,
+		COUNT(a.id) FILTER (WHERE a.severity = 'critical'),
+		n.last_seen < NOW() - make_interval(secs => $1)`
+
+// scanNodeHealth scans nodeHealthColumns and derives NeedsAttention
+func (s *APIServer) scanNodeHealth(row interface{ Scan(...interface{}) error }) (NodeHealth, error) {
+	var node NodeHealth
+	var critical int
+	var stale bool
+	err := row.Scan(&node.NodeID, &node.Hostname, &node.Status,
+		&node.Datacenter, &node.LastSeen, &node.ActiveAlerts, &node.MutedUntil, &critical, &stale)
+	node.NeedsAttention = critical > 0 || stale || node.ActiveAlerts > s.cfg.AttentionAlertThreshold
+	return node, err
+}
+
+// scoreComponents are the inputs of the node health score, each scored 0-1:
+//   - temperature: headroom of the hottest GPU, 1 at scoreIdleTemp or below,
+//     0 at scoreCriticalTemp or above
+//   - alerts: 1 with no active alerts, minus 0.25 per active warning, 0 with
+//     any active critical
+//   - utilization: headroom, 1 minus the mean GPU utilization
+//   - freshness: 1 when last seen within STALE_AFTER, falling linearly to 0
+//     at 10x STALE_AFTER
+//
+// The score is their weighted mean scaled to 0-100. A node without metrics
+// scores 0 on temperature and utilization.
+var scoreComponents = map[string]bool{
+	"temperature": true, "alerts": true, "utilization": true, "freshness": true,
+}
+
+const (
+	scoreIdleTemp     = 40.0
+	scoreCriticalTemp = 95.0
+)
+
+// NodeScore is a node's 0-100 health score with its component scores
+type NodeScore struct {
+	NodeID     string             `json:"node_id"`
+	Score      float64            `json:"score"`
+	Components map[string]float64 `json:"components"`
+	Weights    map[string]float64 `json:"weights"`
+}
+
+// NodeCoverage describes the time span of a node's stored metrics; the
+// timestamps and gap are null when the node has no metrics
+type NodeCoverage struct {
+	NodeID            string     `json:"node_id"`
+	OldestMetricAt    *time.Time `json:"oldest_metric_at"`
+	NewestMetricAt    *time.Time `json:"newest_metric_at"`
+	MetricCount       int64      `json:"metric_count"`
+	LargestGapSeconds *float64   `json:"largest_gap_seconds"`
+}
+
+// MetricResponse is one GPU sample. The unit and desc tags feed
+// /metrics/schema, so every field must carry a desc.
+type MetricResponse struct {
+	NodeID             string            `json:"node_id" desc:"Node the GPU is in"`
+	GPUIndex           int               `json:"gpu_index" desc:"GPU index on the node"`
+	TemperatureCelsius float64           `json:"temperature_celsius" unit:"°C" desc:"GPU core temperature"`
+	PowerWatts         float64           `json:"power_watts" unit:"W" desc:"Board power draw"`
+	MemoryUsedMB       float64           `json:"memory_used_mb" unit:"MB" desc:"Framebuffer memory in use"`
+	MemoryTotalMB      float64           `json:"memory_total_mb" unit:"MB" desc:"Total framebuffer memory; 0 when the GPU did not report it"`
+	UtilizationPercent float64           `json:"utilization_percent" unit:"%" desc:"GPU utilization"`
+	FanSpeedPercent    float64           `json:"fan_speed_percent" unit:"%" desc:"Fan speed"`
+	SMClockMHz         int               `json:"sm_clock_mhz,omitempty" unit:"MHz" desc:"Streaming multiprocessor clock; omitted when not reported"`
+	GPUUUID            string            `json:"gpu_uuid,omitempty" desc:"Physical card UUID; omitted when not reported"`
+	Labels             map[string]string `json:"labels,omitempty" desc:"Node and per-GPU labels from the collector config"`
+	CollectedAt        time.Time         `json:"collected_at" desc:"When the collector took the sample"`
+	Temperature        float64           `json:"temperature" desc:"GPU core temperature in the requested ?unit="`
+	Unit               string            `json:"unit" desc:"Unit of temperature: C or F"`
+	AgeSeconds         *float64          `json:"age_seconds,omitempty" unit:"s" desc:"Age of the sample; latest metrics only"`
+	Stale              *bool             `json:"stale,omitempty" desc:"Whether the sample is older than ?stale_after=; latest metrics only"`
+}
+
+type AlertResponse struct {
+	ID             int       `json:"id"`
+	NodeID         string    `json:"node_id"`
+	GPUIndex       int       `json:"gpu_index"`
+	AlertType      string    `json:"alert_type"`
+	Severity       string    `json:"severity"`
+	Message        string    `json:"message"`
+	ThresholdValue float64   `json:"threshold_value"`
+	ActualValue    float64   `json:"actual_value"`
+	Status         string    `json:"status"`
+	TriggeredAt    time.Time `json:"triggered_at"`
+	// DedupKey is stable across every alert of one (node, gpu, type), for
+	// correlating with external incident tools
+	DedupKey string `json:"dedup_key"`
+	// Resolution says how a resolved alert was resolved: manual, or timeout
+	// when the engine auto-resolved it
+	Resolution string     `json:"resolution,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	// AcknowledgedAt and AcknowledgedBy are set once on-call has taken
+	// the alert
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty"`
+	// RunbookURL links to remediation steps, from the rule that raised it
+	RunbookURL string `json:"runbook_url,omitempty"`
+}
+
+// Temperature units accepted by the ?unit= query parameter
+const (
+	unitCelsius    = "C"
+	unitFahrenheit = "F"
+)
+
+// parseTemperatureUnit reads the optional ?unit= param (c/f), defaulting to Celsius
+func parseTemperatureUnit(r *http.Request) (string, error) {
+	switch strings.ToLower(r.URL.Query().Get("unit")) {
+	case "", "c", "celsius":
+		return unitCelsius, nil
+	case "f", "fahrenheit":
+		return unitFahrenheit, nil
+	default:
+		return "", fmt.Errorf("invalid unit %q, expected c or f", r.URL.Query().Get("unit"))
+	}
+}
+
+// applyTemperatureUnit fills the display temperature in the requested unit.
+// Stored data is always Celsius; conversion only happens on the way out.
+func applyTemperatureUnit(m *MetricResponse, unit string) {
+	m.Unit = unit
+	m.Temperature = m.TemperatureCelsius
+	if unit == unitFahrenheit {
+		m.Temperature = m.TemperatureCelsius*9.0/5.0 + 32.0
+	}
+}
+
+// metricColumns maps public metric names to gpu_metrics columns. Only
+// columns from this allow-list are ever interpolated into SQL.
+var metricColumns = map[string]string{
+	"temperature": "temperature_celsius",
+	"power":       "power_watts",
+	"memory_used": "memory_used_mb",
+	"utilization": "utilization_percent",
+	"sm_clock":    "sm_clock_mhz",
+	"fan_speed":   "fan_speed_percent",
+}
+
+// MetricField describes one numeric field of a GPU metric sample. Metric is
+// the name accepted by ?metric= on the aggregate endpoints, when it has one.
+type MetricField struct {
+	Field       string `json:"field"`
+	Type        string `json:"type"`
+	Unit        string `json:"unit"`
+	Description string `json:"description"`
+	Metric      string `json:"metric,omitempty"`
+}
+
+// metricFields lists the fields of MetricResponse in response order
+var metricFields = describeMetricFields()
+
+// describeMetricFields builds metricFields from MetricResponse's json, unit
+// and desc tags, so the schema can't drift from what the API returns
+func describeMetricFields() []MetricField {
+	metricNames := make(map[string]string, len(metricColumns))
+	for name, column := range metricColumns {
+		metricNames[column] = name
+	}
+
+	t := reflect.TypeOf(MetricResponse{})
+	fields := make([]MetricField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields = append(fields, MetricField{
+			Field:       name,
+			Type:        jsonTypeName(f.Type),
+			Unit:        f.Tag.Get("unit"),
+			Description: f.Tag.Get("desc"),
+			Metric:      metricNames[name],
+		})
+	}
+	return fields
+}
+
+// jsonTypeName names the JSON type a MetricResponse field encodes as
+func jsonTypeName(t reflect.Type) string {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return "timestamp"
+	}
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return "int"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Map:
+		return "object"
+	default:
+		return "string"
+	}
+}
+
+// getMetricsSchema lists the metric fields with their types and units, so
+// generic dashboards can label axes without hardcoding them
+func (s *APIServer) getMetricsSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metricFields)
+}
+
+// parseMetricColumn resolves the ?metric= param against metricColumns
+func parseMetricColumn(r *http.Request) (string, string, error) {
+	metric := r.URL.Query().Get("metric")
+	if metric == "" {
+		metric = "temperature"
+	}
+	column, ok := metricColumns[metric]
+	if !ok {
+		return "", "", fmt.Errorf("unknown metric %q", metric)
+	}
+	return metric, column, nil
+}
+
+// parseTimeRange reads optional RFC3339 ?start= and ?end= params.
+// Defaults to the trailing window ending now when not provided.
+func parseTimeRange(r *http.Request, window time.Duration) (time.Time, time.Time, error) {
+	end := time.Now()
+	if v := r.URL.Query().Get("end"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid end time: %w", err)
+		}
+		end = t
+	}
+
+	start := end.Add(-window)
+	if v := r.URL.Query().Get("start"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid start time: %w", err)
+		}
+		start = t
+	}
+
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("start must be before end")
+	}
+	return start, end, nil
+}
+
+func NewAPIServer(cfg Config) (*APIServer, error) {
+	db, err := connectDB(cfg.DBConnStr, cfg.DBConnectAttempts, cfg.DBConnectBackoff)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &APIServer{
+		db:          db,
+		router:      mux.NewRouter(),
+		cfg:         cfg,
+		latestCache: latestCache{entries: make(map[string]latestCacheEntry)},
+	}
+	if cfg.ExpensiveQueryLimit > 0 {
+		server.expensive = make(chan struct{}, cfg.ExpensiveQueryLimit)
+	}
+
+	server.setupRoutes()
+	return server, nil
+}
+
+func (s *APIServer) setupRoutes() {
+	s.router.Use(withRequestID)
+	s.router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusNotFound, "Not found")
+	})
+	s.router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	})
+
+	// Health check
+	s.router.HandleFunc("/health", s.healthCheck).Methods("GET")
+	s.router.HandleFunc("/health/schema", s.schemaHealth).Methods("GET")
+
+	// Each API version is mounted under /api/<version>. Routes go on the
+	// root router with the prefix prepended rather than on a mux Subrouter,
+	// whose routes answer 404 instead of 405 for a wrong method.
+	for _, v := range apiVersions {
+		prefix := "/api/" + v.name
+		v.routes(s, func(path string, handler http.HandlerFunc) *mux.Route {
+			return s.router.HandleFunc(prefix+path, handler)
+		})
+	}
+}
+
+// apiRoute registers a handler at a path within one API version
+type apiRoute func(path string, handler http.HandlerFunc) *mux.Route
+
+// apiVersions lists the mounted API versions, oldest first, with the
+// function registering each one's routes. A new version (e.g. v2) gets its
+// own entry and routes function; endpoints whose response shape is
+// unchanged should register the previous version's handlers, and changed
+// ones should share its query code and differ only in the response they
+// encode, so old clients keep working from the same binary.
+var apiVersions = []struct {
+	name   string
+	routes func(s *APIServer, handle apiRoute)
+}{
+	{"v1", (*APIServer).registerV1Routes},
+}
+
+// registerV1Routes mounts the v1 endpoints under /api/v1
+func (s *APIServer) registerV1Routes(handle apiRoute) {
+	// Pipeline health
+	handle("/pipeline/health", s.pipelineHealth).Methods("GET")
+
+	// Fleet summary
+	handle("/summary", s.getSummary).Methods("GET")
+	handle("/summary/stream", s.streamSummary).Methods("GET")
+	handle("/snapshot", s.limitExpensive(s.getSnapshot)).Methods("GET")
+
+	// Node endpoints
+	handle("/nodes", s.getAllNodes).Methods("GET")
+	handle("/nodes", s.registerNodes).Methods("POST")
+	handle("/nodes/{node_id}", s.getNodeHealth).Methods("GET")
+	handle("/nodes/{node_id}/mute", s.muteNode).Methods("POST")
+	handle("/nodes/{node_id}/mute", s.unmuteNode).Methods("DELETE")
+	handle("/nodes/{node_id}/alerts/ack", s.ackNodeAlerts).Methods("POST")
+	handle("/nodes/{node_id}/expected-gpus", s.getExpectedGPUs).Methods("GET")
+	handle("/nodes/{node_id}/expected-gpus", s.setExpectedGPUs).Methods("PUT")
+	handle("/nodes/{node_id}/detail", s.limitExpensive(s.getNodeDetail)).Methods("GET")
+	handle("/nodes/{node_id}/coverage", s.limitExpensive(s.getNodeCoverage)).Methods("GET")
+	handle("/nodes/{node_id}/score", s.limitExpensive(s.getNodeScore)).Methods("GET")
+	handle("/nodes/{node_id}/mttr", s.limitExpensive(s.getNodeMTTR)).Methods("GET")
+	handle("/nodes/{node_id}/tail", s.tailNodeMetrics).Methods("GET")
+	handle("/nodes/{node_id}/ingest-rate", s.getNodeIngestRate).Methods("GET")
+	handle("/nodes/{node_id}/export.ndjson", s.limitExpensive(s.exportNodeMetrics)).Methods("GET")
+	handle("/nodes/{node_id}/metrics", s.getNodeMetrics).Methods("GET")
+	handle("/nodes/{node_id}/metrics/percentiles", s.limitExpensive(s.getMetricPercentiles)).Methods("GET")
+	handle("/nodes/{node_id}/metrics/aggregate/multi", s.limitExpensive(s.getMultiMetricAggregate)).Methods("GET")
+	handle("/nodes/{node_id}/gpus/{gpu_index}/metrics", s.getGPUMetrics).Methods("GET")
+	handle("/nodes/{node_id}/gpus/{gpu_index}/histograms", s.getGPUHistograms).Methods("GET")
+	handle("/nodes/{node_id}/gpus/{gpu_index}/correlation", s.limitExpensive(s.getGPUCorrelation)).Methods("GET")
+	handle("/gpus/{uuid}/metrics", s.getGPUUUIDMetrics).Methods("GET")
+	handle("/nodes/{node_id}/sparklines", s.limitExpensive(s.getSparklines)).Methods("GET")
+
+	// Alert endpoints
+	handle("/alerts", s.getAlerts).Methods("GET")
+	handle("/alerts", s.requireAdmin(s.purgeAlerts)).Methods("DELETE")
+	handle("/alerts/active", s.getActiveAlerts).Methods("GET")
+	handle("/alerts/stats", s.limitExpensive(s.getAlertStats)).Methods("GET")
+	handle("/alerts/distribution", s.limitExpensive(s.getAlertDistribution)).Methods("GET")
+	handle("/alerts/correlated", s.limitExpensive(s.getCorrelatedAlerts)).Methods("GET")
+	handle("/alerts/resolve", s.resolveAlerts).Methods("POST")
+	handle("/alerts/resolve-by", s.resolveAlertsByKey).Methods("POST")
+	handle("/alerts/test", s.requireAdmin(s.testAlert)).Methods("POST")
+	handle("/alerts/{alert_id}/resolve", s.resolveAlert).Methods("POST")
+	handle("/alerts/{alert_id}/notify", s.notifyAlert).Methods("POST")
+
+	// Action audit endpoints
+	handle("/actions", s.getActions).Methods("GET")
+	handle("/actions", s.requireAdmin(s.purgeActions)).Methods("DELETE")
+
+	// Alert rule endpoints
+	handle("/rules", s.getRules).Methods("GET")
+	handle("/rules", s.createRule).Methods("POST")
+	handle("/rules/{rule_id}", s.deleteRule).Methods("DELETE")
+	handle("/rules/suggestions", s.listThresholdSuggestions).Methods("GET")
+	handle("/rules/suggestions/{suggestion_id}/approve", s.approveThresholdSuggestion).Methods("POST")
+	handle("/rules/suggestions/{suggestion_id}/reject", s.rejectThresholdSuggestion).Methods("POST")
+
+	// Suppression rule endpoints
+	handle("/suppressions", s.listSuppressions).Methods("GET")
+	handle("/suppressions", s.createSuppression).Methods("POST")
+	handle("/suppressions/{suppression_id}", s.updateSuppression).Methods("PUT")
+	handle("/suppressions/{suppression_id}", s.deleteSuppression).Methods("DELETE")
+
+	// Metrics endpoints
+	handle("/metrics/latest", s.getLatestMetrics).Methods("GET")
+	handle("/metrics/prometheus", s.getPrometheusMetrics).Methods("GET")
+	handle("/metrics/schema", s.getMetricsSchema).Methods("GET")
+	handle("/metrics/by-gpu-index", s.limitExpensive(s.getMetricsByGPUIndex)).Methods("GET")
+	handle("/metrics/memory/leaderboard", s.limitExpensive(s.getMemoryLeaderboard)).Methods("GET")
+	handle("/gpus/missing", s.getMissingGPUs).Methods("GET")
+	handle("/efficiency", s.limitExpensive(s.getEfficiency)).Methods("GET")
+}
+
+// requestIDKey is the context key holding the request ID
+type requestIDKey struct{}
+
+// withRequestID tags each request with an ID, reusing the caller's
+// X-Request-ID when present, and echoes it in the response
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID assigned by withRequestID, or "-" outside it
+func requestID(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return "-"
+}
+
+// errorCodes maps HTTP statuses to the machine-readable code in error responses
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusServiceUnavailable:  "unavailable",
+	http.StatusInternalServerError: "internal_error",
+}
+
+// ErrorResponse is the JSON envelope returned by every failing request
+type ErrorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// writeError sends a JSON error envelope; message must be safe to show clients
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	var resp ErrorResponse
+	resp.Error.Code = errorCodes[status]
+	if resp.Error.Code == "" {
+		resp.Error.Code = strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	}
+	resp.Error.Message = message
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeInternalError logs err with the request ID and returns a generic 500,
+// so database errors never reach clients
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("request %s: %s %s: %v", requestID(r), r.Method, r.URL.Path, err)
+	writeError(w, r, http.StatusInternalServerError, "Internal server error; reference request ID "+requestID(r))
+}
+
+// healthCheck reports liveness along with the newest API version and every
+// mounted one
+func (s *APIServer) healthCheck(w http.ResponseWriter, r *http.Request) {
+	versions := make([]string, len(apiVersions))
+	for i, v := range apiVersions {
+		versions[i] = v.name
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":       "healthy",
+		"time":         time.Now().Format(time.RFC3339),
+		"version":      versions[len(versions)-1],
+		"api_versions": versions,
+	})
+}
+
+// expectedSchemaVersion is the schema_migrations version this server was
+// built against; bump it with every init.sql change that adds a version
+const expectedSchemaVersion = 7
+
+// SchemaHealth reports the database schema version against the expected one
+type SchemaHealth struct {
+	Status          string    `json:"status"`
+	CurrentVersion  *int      `json:"current_version"`
+	ExpectedVersion int       `json:"expected_version"`
+	CheckedAt       time.Time `json:"checked_at"`
+}
+
+// schemaHealth reports the newest version applied in schema_migrations.
+// Responds 503 unless the database is at least at expectedSchemaVersion, so
+// a deploy can wait for the schema before routing traffic.
+func (s *APIServer) schemaHealth(w http.ResponseWriter, r *http.Request) {
+	health := SchemaHealth{
+		Status:          "ok",
+		ExpectedVersion: expectedSchemaVersion,
+		CheckedAt:       time.Now(),
+	}
+
+	var version sql.NullInt64
+	err := s.db.QueryRowContext(r.Context(), "SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "42P01" {
+		// undefined_table: the schema predates versioning or was never applied
+		err = nil
+	}
+	switch {
+	case err != nil:
+		log.Printf("Schema health: version check failed: %v", err)
+		health.Status = "unknown"
+	case !version.Valid:
+		health.Status = "not_migrated"
+	default:
+		current := int(version.Int64)
+		health.CurrentVersion = &current
+		if current < expectedSchemaVersion {
+			health.Status = "not_migrated"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if health.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
+
+type PipelineHealth struct {
+	Status         string     `json:"status"`
+	DBReachable    bool       `json:"db_reachable"`
+	LatestMetricAt *time.Time `json:"latest_metric_at"`
+	LagSeconds     *float64   `json:"lag_seconds"`
+	MaxLagSeconds  float64    `json:"max_lag_seconds"`
+	CheckedAt      time.Time  `json:"checked_at"`
 }
 
-type NodeHealth struct {
-	NodeID       string    `json:"node_id"`
-	Hostname     string    `json:"hostname"`
-	Status       string    `json:"status"`
-	Datacenter   string    `json:"datacenter"`
-	LastSeen     time.Time `json:"last_seen"`
-	ActiveAlerts int       `json:"active_alerts"`
+// pipelineHealth reports end-to-end pipeline health. The age of the freshest
+// stored metric is a proxy for collector + Kafka + alert engine lag.
+// Responds 503 unless the pipeline is healthy so uptime checkers can use it directly.
+func (s *APIServer) pipelineHealth(w http.ResponseWriter, r *http.Request) {
+	health := PipelineHealth{
+		Status:        "healthy",
+		MaxLagSeconds: s.cfg.PipelineMaxLag.Seconds(),
+		CheckedAt:     time.Now(),
+	}
+
+	var latest sql.NullTime
+	err := s.db.QueryRowContext(r.Context(), "SELECT MAX(collected_at) FROM gpu_metrics").Scan(&latest)
+	switch {
+	case err != nil:
+		log.Printf("Pipeline health: database check failed: %v", err)
+		health.Status = "unhealthy"
+	case !latest.Valid:
+		health.DBReachable = true
+		health.Status = "degraded"
+	default:
+		health.DBReachable = true
+		lag := time.Since(latest.Time)
+		lagSeconds := lag.Seconds()
+		health.LatestMetricAt, health.LagSeconds = &latest.Time, &lagSeconds
+		if lag > s.cfg.PipelineMaxLag {
+			health.Status = "degraded"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if health.Status != "healthy" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
+
+func (s *APIServer) getAllNodes(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT ` + nodeHealthColumns + `
+		FROM gpu_nodes n
+		LEFT JOIN alerts a ON n.node_id = a.node_id AND a.status = 'active'
+		WHERE n.is_gpu
+		GROUP BY n.node_id, n.hostname, n.status, n.datacenter, n.last_seen, n.muted_until
+		ORDER BY n.node_id
+	`
+
+	rows, err := s.db.Query(query, s.cfg.StaleAfter.Seconds())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	var nodes []NodeHealth
+	for rows.Next() {
+		node, err := s.scanNodeHealth(rows)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		nodes = append(nodes, node)
+	}
+
+	scores, err := s.nodeScores(r.Context(), "")
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	for i := range nodes {
+		if score, ok := scores[nodes[i].NodeID]; ok {
+			nodes[i].HealthScore = &score.Score
+		}
+	}
+	// ?sort=score lists the least healthy nodes first
+	if r.URL.Query().Get("sort") == "score" {
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return scoreOf(nodes[i]) < scoreOf(nodes[j])
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nodes)
+}
+
+// scoreOf returns a node's health score, or 0 when it has none
+func scoreOf(n NodeHealth) float64 {
+	if n.HealthScore == nil {
+		return 0
+	}
+	return *n.HealthScore
+}
+
+// NodeRegistration is a node as submitted to, and returned by, POST /api/v1/nodes
+type NodeRegistration struct {
+	NodeID     string    `json:"node_id"`
+	Hostname   string    `json:"hostname"`
+	Datacenter string    `json:"datacenter"`
+	Status     string    `json:"status,omitempty"`
+	CreatedAt  time.Time `json:"created_at,omitempty"`
+	// ExpectedGPUCount is how many GPUs the node should report (indexes
+	// 0..N-1); omitting it on re-registration keeps the stored value
+	ExpectedGPUCount *int `json:"expected_gpu_count,omitempty"`
+}
+
+// maxExpectedGPUs bounds expected_gpu_count
+const maxExpectedGPUs = 64
+
+// maxRegistrationBatch caps how many nodes one request may register
+const maxRegistrationBatch = 500
+
+// decodeNodeRegistrations accepts either a single node object or an array
+func decodeNodeRegistrations(r *http.Request) ([]NodeRegistration, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON body: %w", err)
+	}
+
+	var nodes []NodeRegistration
+	trimmed := strings.TrimSpace(string(raw))
+	if strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(raw, &nodes); err != nil {
+			return nil, fmt.Errorf("invalid node array: %w", err)
+		}
+	} else {
+		var node NodeRegistration
+		if err := json.Unmarshal(raw, &node); err != nil {
+			return nil, fmt.Errorf("invalid node object: %w", err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no nodes provided")
+	}
+	if len(nodes) > maxRegistrationBatch {
+		return nil, fmt.Errorf("at most %d nodes per request", maxRegistrationBatch)
+	}
+	for i, n := range nodes {
+		if n.NodeID == "" || n.Hostname == "" || n.Datacenter == "" {
+			return nil, fmt.Errorf("node %d: node_id, hostname and datacenter are required", i)
+		}
+		id, err := normalizeNodeID(n.NodeID)
+		if err != nil {
+			return nil, fmt.Errorf("node %d: %w", i, err)
+		}
+		nodes[i].NodeID = id
+		if c := n.ExpectedGPUCount; c != nil && (*c < 0 || *c > maxExpectedGPUs) {
+			return nil, fmt.Errorf("node %d: expected_gpu_count must be between 0 and %d", i, maxExpectedGPUs)
+		}
+	}
+	return nodes, nil
+}
+
+// maxNodeIDLength matches the gpu_nodes.node_id column
+const maxNodeIDLength = 50
+
+// normalizeNodeID lowercases and trims a node ID and checks it is 1-50
+// characters of a-z, 0-9, '.', '_' and '-', starting with a letter or digit.
+// The collector applies the same rules.
+func normalizeNodeID(id string) (string, error) {
+	norm := strings.ToLower(strings.TrimSpace(id))
+	if norm == "" || len(norm) > maxNodeIDLength {
+		return "", fmt.Errorf("node_id %q must be 1-%d characters", id, maxNodeIDLength)
+	}
+	for i, c := range norm {
+		alnum := (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+		if !alnum && (i == 0 || (c != '.' && c != '_' && c != '-')) {
+			return "", fmt.Errorf("node_id %q may only contain a-z, 0-9, '.', '_' and '-', and must start with a letter or digit", id)
+		}
+	}
+	return norm, nil
+}
+
+// registerNodes upserts one or more nodes into gpu_nodes in a single transaction
+func (s *APIServer) registerNodes(w http.ResponseWriter, r *http.Request) {
+	nodes, err := decodeNodeRegistrations(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO gpu_nodes (node_id, hostname, datacenter, expected_gpu_count)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (node_id) DO UPDATE
+		SET hostname = EXCLUDED.hostname, datacenter = EXCLUDED.datacenter,
+		    expected_gpu_count = COALESCE(EXCLUDED.expected_gpu_count, gpu_nodes.expected_gpu_count)
+		RETURNING node_id, hostname, datacenter, status, created_at, expected_gpu_count
+	`
+
+	registered := make([]NodeRegistration, 0, len(nodes))
+	for _, n := range nodes {
+		var out NodeRegistration
+		if err := tx.QueryRow(query, n.NodeID, n.Hostname, n.Datacenter, n.ExpectedGPUCount).Scan(
+			&out.NodeID, &out.Hostname, &out.Datacenter, &out.Status, &out.CreatedAt, &out.ExpectedGPUCount,
+		); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		registered = append(registered, out)
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(registered)
+}
+
+func (s *APIServer) getNodeHealth(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	nodeID := vars["node_id"]
+
+	query := `
+		SELECT ` + nodeHealthColumns + `
+		FROM gpu_nodes n
+		LEFT JOIN alerts a ON n.node_id = a.node_id AND a.status = 'active'
+		WHERE n.node_id = $2
+		GROUP BY n.node_id, n.hostname, n.status, n.datacenter, n.last_seen, n.muted_until
+	`
+
+	node, err := s.scanNodeHealth(s.db.QueryRow(query, s.cfg.StaleAfter.Seconds(), nodeID))
+
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "Node not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node)
+}
+
+// MuteRequest is the body of POST /api/v1/nodes/{node_id}/mute
+type MuteRequest struct {
+	// Duration is a Go duration such as "2h"
+	Duration string `json:"duration"`
+}
+
+// maxMuteDuration bounds a mute so a forgotten one cannot silence a node for good
+const maxMuteDuration = 7 * 24 * time.Hour
+
+// muteNode stops the alert engine paging for a node's alerts until the
+// given duration has passed. Metrics and alerts are still recorded.
+func (s *APIServer) muteNode(w http.ResponseWriter, r *http.Request) {
+	nodeID := mux.Vars(r)["node_id"]
+
+	var req MuteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil || duration <= 0 || duration > maxMuteDuration {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("duration must be a positive duration up to %s", maxMuteDuration))
+		return
+	}
+
+	var mutedUntil time.Time
+	err = s.db.QueryRow(`
+		UPDATE gpu_nodes
+		SET muted_until = NOW() + $2 * INTERVAL '1 second'
+		WHERE node_id = $1
+		RETURNING muted_until
+	`, nodeID, duration.Seconds()).Scan(&mutedUntil)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "Node not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"node_id":     nodeID,
+		"muted_until": mutedUntil,
+	})
+}
+
+// unmuteNode ends a node's mute early
+func (s *APIServer) unmuteNode(w http.ResponseWriter, r *http.Request) {
+	nodeID := mux.Vars(r)["node_id"]
+
+	res, err := s.db.Exec("UPDATE gpu_nodes SET muted_until = NULL WHERE node_id = $1", nodeID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, r, http.StatusNotFound, "Node not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Node unmuted",
+		"node_id": nodeID,
+	})
+}
+
+// ExpectedGPUs is the body and response of /api/v1/nodes/{node_id}/expected-gpus;
+// a null count means the node's GPU count isn't known
+type ExpectedGPUs struct {
+	NodeID           string `json:"node_id"`
+	ExpectedGPUCount *int   `json:"expected_gpu_count"`
+}
+
+// getExpectedGPUs returns how many GPUs a node should report
+func (s *APIServer) getExpectedGPUs(w http.ResponseWriter, r *http.Request) {
+	resp := ExpectedGPUs{NodeID: mux.Vars(r)["node_id"]}
+	err := s.db.QueryRow(
+		"SELECT expected_gpu_count FROM gpu_nodes WHERE node_id = $1", resp.NodeID,
+	).Scan(&resp.ExpectedGPUCount)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "Node not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// setExpectedGPUs sets, or with null clears, how many GPUs a node should
+// report. The alert engine picks it up for gpu_missing within a minute.
+func (s *APIServer) setExpectedGPUs(w http.ResponseWriter, r *http.Request) {
+	var req ExpectedGPUs
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if c := req.ExpectedGPUCount; c != nil && (*c < 0 || *c > maxExpectedGPUs) {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("expected_gpu_count must be between 0 and %d, or null", maxExpectedGPUs))
+		return
+	}
+
+	resp := ExpectedGPUs{NodeID: mux.Vars(r)["node_id"]}
+	err := s.db.QueryRow(`
+		UPDATE gpu_nodes SET expected_gpu_count = $2
+		WHERE node_id = $1
+		RETURNING expected_gpu_count
+	`, resp.NodeID, req.ExpectedGPUCount).Scan(&resp.ExpectedGPUCount)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "Node not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// AckRequest is the body of POST /api/v1/nodes/{node_id}/alerts/ack
+type AckRequest struct {
+	// AcknowledgedBy names who is taking the alerts, e.g. the on-call engineer
+	AcknowledgedBy string `json:"acknowledged_by"`
+}
+
+// maxAckByLength matches the alerts.acknowledged_by column
+const maxAckByLength = 100
+
+// ackNodeAlerts acknowledges every unacknowledged active alert of a node in
+// one transaction, recording who acknowledged them and when
+func (s *APIServer) ackNodeAlerts(w http.ResponseWriter, r *http.Request) {
+	nodeID := mux.Vars(r)["node_id"]
+
+	var req AckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.AcknowledgedBy = strings.TrimSpace(req.AcknowledgedBy)
+	if req.AcknowledgedBy == "" || len(req.AcknowledgedBy) > maxAckByLength {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("acknowledged_by is required, up to %d characters", maxAckByLength))
+		return
+	}
+
+	tx, err := s.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM gpu_nodes WHERE node_id = $1)", nodeID).Scan(&exists); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !exists {
+		writeError(w, r, http.StatusNotFound, "Node not found")
+		return
+	}
+
+	rows, err := tx.Query(`
+		UPDATE alerts
+		SET acknowledged_at = NOW(), acknowledged_by = $2
+		WHERE node_id = $1 AND status = 'active' AND acknowledged_at IS NULL
+		RETURNING id
+	`, nodeID, req.AcknowledgedBy)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			writeInternalError(w, r, err)
+			return
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	log.Printf("%s acknowledged %d active alerts on %s", req.AcknowledgedBy, len(ids), nodeID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":      "Alerts acknowledged",
+		"node_id":      nodeID,
+		"acknowledged": len(ids),
+		"alert_ids":    ids,
+	})
+}
+
+// NodeDetail combines everything the node detail page shows
+type NodeDetail struct {
+	Node          NodeHealth       `json:"node"`
+	LatestMetrics []MetricResponse `json:"latest_metrics"`
+	ActiveAlerts  []AlertResponse  `json:"active_alerts"`
+}
+
+// clamp01 limits v to [0, 1]
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// nodeScores computes the health score of one node, or of every node when
+// nodeID is empty
+func (s *APIServer) nodeScores(ctx context.Context, nodeID string) (map[string]NodeScore, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT n.node_id, n.last_seen, m.max_temp, m.avg_util,
+		       COALESCE(a.warnings, 0), COALESCE(a.criticals, 0)
+		FROM gpu_nodes n
+		LEFT JOIN (
+			SELECT node_id, MAX(temperature_celsius) AS max_temp, AVG(utilization_percent) AS avg_util
+			FROM latest_gpu_metrics
+			GROUP BY node_id
+		) m ON m.node_id = n.node_id
+		LEFT JOIN (
+			SELECT node_id,
+			       COUNT(*) FILTER (WHERE severity = 'warning') AS warnings,
+			       COUNT(*) FILTER (WHERE severity = 'critical') AS criticals
+			FROM alerts
+			WHERE status = 'active'
+			GROUP BY node_id
+		) a ON a.node_id = n.node_id
+		WHERE ($1 = '' AND n.is_gpu) OR n.node_id = $1
+	`, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	scores := make(map[string]NodeScore)
+	for rows.Next() {
+		var id string
+		var lastSeen time.Time
+		var maxTemp, avgUtil sql.NullFloat64
+		var warnings, criticals int
+		if err := rows.Scan(&id, &lastSeen, &maxTemp, &avgUtil, &warnings, &criticals); err != nil {
+			return nil, err
+		}
+
+		components := map[string]float64{"alerts": clamp01(1 - 0.25*float64(warnings))}
+		if criticals > 0 {
+			components["alerts"] = 0
+		}
+		if maxTemp.Valid {
+			components["temperature"] = clamp01((scoreCriticalTemp - maxTemp.Float64) / (scoreCriticalTemp - scoreIdleTemp))
+		} else {
+			components["temperature"] = 0
+		}
+		if avgUtil.Valid {
+			components["utilization"] = clamp01(1 - avgUtil.Float64/100)
+		} else {
+			components["utilization"] = 0
+		}
+		stale := s.cfg.StaleAfter.Seconds()
+		components["freshness"] = clamp01(1 - (now.Sub(lastSeen).Seconds()-stale)/(9*stale))
+
+		var weighted, total float64
+		for name, w := range s.cfg.ScoreWeights {
+			weighted += w * components[name]
+			total += w
+		}
+		scores[id] = NodeScore{
+			NodeID:     id,
+			Score:      math.Round(weighted/total*1000) / 10,
+			Components: components,
+			Weights:    s.cfg.ScoreWeights,
+		}
+	}
+	return scores, rows.Err()
+}
+
+// getNodeScore returns a node's health score and how it was computed
+func (s *APIServer) getNodeScore(w http.ResponseWriter, r *http.Request) {
+	nodeID := mux.Vars(r)["node_id"]
+
+	scores, err := s.nodeScores(r.Context(), nodeID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	score, ok := scores[nodeID]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "Node not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(score)
+}
+
+// getNodeCoverage reports the oldest and newest stored metric of a node, how
+// many there are, and the longest gap between consecutive samples of a GPU
+func (s *APIServer) getNodeCoverage(w http.ResponseWriter, r *http.Request) {
+	nodeID := mux.Vars(r)["node_id"]
+
+	query := `
+		SELECT n.node_id, MIN(m.collected_at), MAX(m.collected_at),
+		       COUNT(m.collected_at), EXTRACT(EPOCH FROM MAX(m.gap))
+		FROM gpu_nodes n
+		LEFT JOIN (
+			SELECT node_id, collected_at,
+			       collected_at - LAG(collected_at) OVER (PARTITION BY gpu_index ORDER BY collected_at) AS gap
+			FROM gpu_metrics
+			WHERE node_id = $1
+		) m ON m.node_id = n.node_id
+		WHERE n.node_id = $1
+		GROUP BY n.node_id
+	`
+
+	var coverage NodeCoverage
+	err := s.db.QueryRowContext(r.Context(), query, nodeID).Scan(
+		&coverage.NodeID, &coverage.OldestMetricAt, &coverage.NewestMetricAt,
+		&coverage.MetricCount, &coverage.LargestGapSeconds,
+	)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "Node not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(coverage)
+}
+
+const (
+	defaultMTTRWeeks = 8
+	maxMTTRWeeks     = 104
+)
+
+// MTTRWeek is the mean time to resolve of a node's alerts triggered in one
+// week; MeanTimeToResolveSeconds is null for weeks without resolved alerts
+type MTTRWeek struct {
+	WeekStart                time.Time `json:"week_start"`
+	ResolvedCount            int       `json:"resolved_count"`
+	MeanTimeToResolveSeconds *float64  `json:"mttr_seconds"`
+}
+
+type NodeMTTRResponse struct {
+	NodeID string     `json:"node_id"`
+	Weeks  []MTTRWeek `json:"weeks"`
+}
+
+// getNodeMTTR returns a node's weekly alert MTTR over the last ?weeks=
+// (default 8) weeks, oldest first, the current week included
+func (s *APIServer) getNodeMTTR(w http.ResponseWriter, r *http.Request) {
+	nodeID := mux.Vars(r)["node_id"]
+
+	weeks := defaultMTTRWeeks
+	if v := r.URL.Query().Get("weeks"); v != "" {
+		var err error
+		weeks, err = strconv.Atoi(v)
+		if err != nil || weeks <= 0 || weeks > maxMTTRWeeks {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("weeks must be between 1 and %d", maxMTTRWeeks))
+			return
+		}
+	}
+
+	var exists bool
+	if err := s.db.QueryRowContext(r.Context(),
+		"SELECT EXISTS (SELECT 1 FROM gpu_nodes WHERE node_id = $1)", nodeID,
+	).Scan(&exists); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !exists {
+		writeError(w, r, http.StatusNotFound, "Node not found")
+		return
+	}
+
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT w.week_start, COUNT(a.resolved_at),
+		       AVG(EXTRACT(EPOCH FROM (a.resolved_at - a.triggered_at)))
+		FROM generate_series(
+			date_trunc('week', NOW()) - ($2::int - 1) * INTERVAL '1 week',
+			date_trunc('week', NOW()),
+			INTERVAL '1 week'
+		) AS w(week_start)
+		LEFT JOIN alerts a ON a.node_id = $1
+		  AND a.status = 'resolved' AND a.resolved_at IS NOT NULL
+		  AND a.triggered_at >= w.week_start
+		  AND a.triggered_at < w.week_start + INTERVAL '1 week'
+		GROUP BY w.week_start
+		ORDER BY w.week_start
+	`, nodeID, weeks)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	resp := NodeMTTRResponse{NodeID: nodeID, Weeks: make([]MTTRWeek, 0, weeks)}
+	for rows.Next() {
+		var week MTTRWeek
+		var mttr sql.NullFloat64
+		if err := rows.Scan(&week.WeekStart, &week.ResolvedCount, &mttr); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		if mttr.Valid {
+			week.MeanTimeToResolveSeconds = &mttr.Float64
+		}
+		resp.Weeks = append(resp.Weeks, week)
+	}
+	if err := rows.Err(); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// MetricExportRow is one gpu_metrics row as written by the NDJSON export;
+// nullable columns are null when unset
+type MetricExportRow struct {
+	NodeID             string          `json:"node_id"`
+	GPUIndex           int             `json:"gpu_index"`
+	TemperatureCelsius *float64        `json:"temperature_celsius"`
+	PowerWatts         *float64        `json:"power_watts"`
+	MemoryUsedMB       *float64        `json:"memory_used_mb"`
+	MemoryTotalMB      *float64        `json:"memory_total_mb"`
+	UtilizationPercent *float64        `json:"utilization_percent"`
+	SMClockMHz         *int            `json:"sm_clock_mhz"`
+	FanSpeedPercent    *float64        `json:"fan_speed_percent"`
+	RowRemapPending    *int            `json:"row_remap_pending"`
+	CollectedAt        time.Time       `json:"collected_at"`
+	ClockSkewSeconds   *float64        `json:"clock_skew_seconds"`
+	Labels             json.RawMessage `json:"labels"`
+	Histograms         json.RawMessage `json:"histograms"`
+	// Partial is set on samples stored without memory_total_mb
+	Partial bool `json:"partial"`
+}
+
+// metricExportColumns selects the gpu_metrics columns of a MetricExportRow,
+// in scanMetricExportRow order
+const metricExportColumns = `node_id, gpu_index, temperature_celsius, power_watts,
+		       memory_used_mb, memory_total_mb, utilization_percent, sm_clock_mhz,
+		       fan_speed_percent, row_remap_pending, collected_at, clock_skew_seconds,
+		       COALESCE(labels, 'null'::jsonb), COALESCE(histograms, 'null'::jsonb),
+		       COALESCE(partial, FALSE)`
+
+// scanMetricExportRow scans metricExportColumns, followed by any extra
+// columns into extra
+func scanMetricExportRow(rows *sql.Rows, extra ...interface{}) (MetricExportRow, error) {
+	var row MetricExportRow
+	var labels, histograms []byte
+	dest := []interface{}{&row.NodeID, &row.GPUIndex, &row.TemperatureCelsius, &row.PowerWatts,
+		&row.MemoryUsedMB, &row.MemoryTotalMB, &row.UtilizationPercent, &row.SMClockMHz,
+		&row.FanSpeedPercent, &row.RowRemapPending, &row.CollectedAt, &row.ClockSkewSeconds,
+		&labels, &histograms, &row.Partial}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return row, err
+	}
+	row.Labels, row.Histograms = labels, histograms
+	return row, nil
+}
+
+// exportNodeMetrics streams a node's stored metrics, oldest first, as
+// newline-delimited JSON. Without start/end it exports the full history.
+// The query is tied to the request context, so it stops when the client
+// disconnects.
+func (s *APIServer) exportNodeMetrics(w http.ResponseWriter, r *http.Request) {
+	nodeID := mux.Vars(r)["node_id"]
+
+	var start time.Time
+	end := time.Now()
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"start", &start}, {"end", &end}} {
+		if v := r.URL.Query().Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid %s time", p.name))
+				return
+			}
+			*p.dst = t
+		}
+	}
+	if !start.Before(end) {
+		writeError(w, r, http.StatusBadRequest, "start must be before end")
+		return
+	}
+
+	var exists bool
+	if err := s.db.QueryRowContext(r.Context(),
+		"SELECT EXISTS (SELECT 1 FROM gpu_nodes WHERE node_id = $1)", nodeID,
+	).Scan(&exists); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !exists {
+		writeError(w, r, http.StatusNotFound, "Node not found")
+		return
+	}
+
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT `+metricExportColumns+`
+		FROM gpu_metrics
+		WHERE node_id = $1 AND collected_at >= $2 AND collected_at < $3
+		ORDER BY collected_at, gpu_index
+	`, nodeID, start, end)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.ndjson"`, nodeID))
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	// Headers are sent with the first row, so errors past this point can
+	// only be logged; the client sees a truncated stream
+	written, size := 0, 0
+	var lastAt time.Time
+	for rows.Next() {
+		row, err := scanMetricExportRow(rows)
+		if err != nil {
+			log.Printf("request %s: export of %s failed: %v", requestID(r), nodeID, err)
+			return
+		}
+		// Past a cap, finish the current timestamp so resuming from the
+		// next one neither repeats nor skips rows
+		overBytes := s.cfg.MaxResponseBytes > 0 && size >= s.cfg.MaxResponseBytes
+		if (s.rowCapReached(written) || overBytes) && !row.CollectedAt.Equal(lastAt) {
+			enc.Encode(ExportTruncation{Truncated: true, Next: row.CollectedAt.Format(time.RFC3339Nano)})
+			return
+		}
+		line, err := json.Marshal(row)
+		if err != nil {
+			log.Printf("request %s: export of %s failed: %v", requestID(r), nodeID, err)
+			return
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			// Client went away
+			return
+		}
+		written++
+		size += len(line) + 1
+		lastAt = row.CollectedAt
+		if flusher != nil && written%1000 == 0 {
+			flusher.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil && r.Context().Err() == nil {
+		log.Printf("request %s: export of %s failed: %v", requestID(r), nodeID, err)
+	}
+}
+
+// FleetSummary is the fleet-wide header for dashboards. Nodes are up, and
+// GPUs reporting, when seen within STALE_AFTER; temperature and power are
+// over each reporting GPU's latest sample.
+type FleetSummary struct {
+	Nodes         int `json:"nodes"`
+	NodesUp       int `json:"nodes_up"`
+	GPUsReporting int `json:"gpus_reporting"`
+	// ActiveAlerts counts active alerts by severity
+	ActiveAlerts          map[string]int `json:"active_alerts"`
+	AvgTemperatureCelsius *float64       `json:"avg_temperature_celsius"`
+	TotalPowerWatts       float64        `json:"total_power_watts"`
+	At                    time.Time      `json:"at"`
+}
+
+// querySummary computes the current FleetSummary
+func (s *APIServer) querySummary(ctx context.Context) (FleetSummary, error) {
+	summary := FleetSummary{ActiveAlerts: make(map[string]int), At: time.Now().UTC()}
+	stale := s.cfg.StaleAfter.Seconds()
+
+	var avgTemp sql.NullFloat64
+	err := s.db.QueryRowContext(ctx, `
+		WITH latest AS (
+			SELECT DISTINCT ON (node_id, gpu_index) temperature_celsius, power_watts
+			FROM gpu_metrics
+			WHERE collected_at > NOW() - make_interval(secs => $1)
+			ORDER BY node_id, gpu_index, collected_at DESC
+		)
+		SELECT (SELECT COUNT(*) FROM gpu_nodes WHERE is_gpu),
+		       (SELECT COUNT(*) FROM gpu_nodes WHERE is_gpu AND last_seen > NOW() - make_interval(secs => $1)),
+		       (SELECT COUNT(*) FROM latest),
+		       (SELECT AVG(temperature_celsius) FROM latest),
+		       (SELECT COALESCE(SUM(power_watts), 0) FROM latest)
+	`, stale).Scan(&summary.Nodes, &summary.NodesUp, &summary.GPUsReporting, &avgTemp, &summary.TotalPowerWatts)
+	if err != nil {
+		return summary, err
+	}
+	if avgTemp.Valid {
+		summary.AvgTemperatureCelsius = &avgTemp.Float64
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT severity, COUNT(*) FROM alerts WHERE status = 'active' GROUP BY severity
+	`)
+	if err != nil {
+		return summary, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var severity string
+		var n int
+		if err := rows.Scan(&severity, &n); err != nil {
+			return summary, err
+		}
+		summary.ActiveAlerts[severity] = n
+	}
+	return summary, rows.Err()
+}
+
+// getSummary returns the current fleet summary
+func (s *APIServer) getSummary(w http.ResponseWriter, r *http.Request) {
+	summary, err := s.querySummary(r.Context())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// Push interval bounds for the summary stream's ?interval=
+const (
+	defaultSummaryInterval = 5 * time.Second
+	minSummaryInterval     = time.Second
+)
+
+// streamSummary pushes the fleet summary as Server-Sent Events, one
+// "summary" event immediately and then every ?interval= (default 5s),
+// until the client disconnects. A failed query ends the stream; EventSource
+// clients reconnect after the advertised retry delay.
+func (s *APIServer) streamSummary(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	interval := defaultSummaryInterval
+	if v := r.URL.Query().Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < minSummaryInterval {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("interval must be a duration of at least %s", minSummaryInterval))
+			return
+		}
+		interval = d
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, "Streaming unsupported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", interval.Milliseconds())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		summary, err := s.querySummary(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("request %s: summary stream failed: %v", requestID(r), err)
+			}
+			return
+		}
+		data, err := json.Marshal(summary)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: summary\ndata: %s\n\n", data); err != nil {
+			// Client went away
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll interval bounds for the tail endpoint's ?interval=
+const (
+	defaultTailInterval = 2 * time.Second
+	minTailInterval     = 500 * time.Millisecond
+)
+
+// tailLookback is how far before the newest collected_at sent each tail
+// poll looks again, so rows committed out of order, or collected a little
+// earlier by another GPU, are still sent; tailPollLimit caps the rows one
+// poll returns, the rest following on the next
+const (
+	tailLookback  = 30 * time.Second
+	tailPollLimit = 1000
+)
+
+// tailNodeMetrics streams a node's metrics as they are stored, as NDJSON,
+// until the client disconnects. It polls gpu_metrics every ?interval=
+// (default 2s) for rows collected after the newest one sent, less
+// tailLookback, skipping rows already sent; it starts after the newest row
+// at request time. Each poll takes an EXPENSIVE_QUERY_LIMIT slot only while
+// its query runs, so open tails don't starve other expensive requests; a
+// poll finding none free is skipped and caught up by the next.
+func (s *APIServer) tailNodeMetrics(w http.ResponseWriter, r *http.Request) {
+	nodeID := mux.Vars(r)["node_id"]
+	ctx := r.Context()
+
+	interval := defaultTailInterval
+	if v := r.URL.Query().Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < minTailInterval {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("interval must be a duration of at least %s", minTailInterval))
+			return
+		}
+		interval = d
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, "Streaming unsupported")
+		return
+	}
+
+	var exists bool
+	var start time.Time
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM gpu_nodes WHERE node_id = $1),
+		       COALESCE((SELECT MAX(collected_at) FROM gpu_metrics WHERE node_id = $1), 'epoch'::timestamp)
+	`, nodeID).Scan(&exists, &start)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !exists {
+		writeError(w, r, http.StatusNotFound, "Node not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	enc := json.NewEncoder(w)
+
+	// newest is the newest collected_at sent; sent holds the IDs sent
+	// within the lookback before it, which the next poll sees again
+	newest := start
+	sent := make(map[int64]time.Time)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		since := newest.Add(-tailLookback)
+		if since.Before(start) {
+			since = start
+		}
+		skip := make([]int64, 0, len(sent))
+		for id, collectedAt := range sent {
+			if collectedAt.After(since) {
+				skip = append(skip, id)
+			} else {
+				delete(sent, id)
+			}
+		}
+
+		release, ok := s.acquireExpensive()
+		if !ok {
+			continue
+		}
+		err := s.pollTail(ctx, enc, nodeID, since, skip, func(id int64, collectedAt time.Time) {
+			sent[id] = collectedAt
+			if collectedAt.After(newest) {
+				newest = collectedAt
+			}
+		})
+		release()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("request %s: tail of %s failed: %v", requestID(r), nodeID, err)
+			}
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// pollTail writes one tail poll's rows to enc, oldest first, calling sent
+// for each row written
+func (s *APIServer) pollTail(ctx context.Context, enc *json.Encoder, nodeID string, since time.Time,
+	skip []int64, sent func(id int64, collectedAt time.Time)) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+metricExportColumns+`, id
+		FROM gpu_metrics
+		WHERE node_id = $1 AND collected_at > $2 AND NOT (id = ANY($3))
+		ORDER BY collected_at, id
+		LIMIT $4
+	`, nodeID, since, pq.Array(skip), tailPollLimit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		row, err := scanMetricExportRow(rows, &id)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(row); err != nil {
+			// The client went away
+			return err
+		}
+		sent(id, row.CollectedAt)
+	}
+	return rows.Err()
+}
+
+// getNodeDetail returns a node's health, latest per-GPU metrics and active
+// alerts in one response. The queries share a repeatable-read transaction so
+// all three parts come from the same snapshot.
+func (s *APIServer) getNodeDetail(w http.ResponseWriter, r *http.Request) {
+	nodeID := mux.Vars(r)["node_id"]
+
+	unit, err := parseTemperatureUnit(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx, err := s.db.BeginTx(r.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	detail := NodeDetail{
+		LatestMetrics: []MetricResponse{},
+		ActiveAlerts:  []AlertResponse{},
+	}
+
+	err = tx.QueryRow(`
+		SELECT n.node_id, n.hostname, n.status, n.datacenter, n.last_seen,
+		       COALESCE(COUNT(a.id), 0) as active_alerts,
+		       CASE WHEN n.muted_until > NOW() THEN n.muted_until END
+		FROM gpu_nodes n
+		LEFT JOIN alerts a ON n.node_id = a.node_id AND a.status = 'active'
+		WHERE n.node_id = $1
+		GROUP BY n.node_id, n.hostname, n.status, n.datacenter, n.last_seen, n.muted_until
+	`, nodeID).Scan(
+		&detail.Node.NodeID, &detail.Node.Hostname, &detail.Node.Status,
+		&detail.Node.Datacenter, &detail.Node.LastSeen, &detail.Node.ActiveAlerts, &detail.Node.MutedUntil,
+	)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "Node not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	metricRows, err := tx.Query(`
+		SELECT node_id, gpu_index, temperature_celsius, COALESCE(power_watts, 0),
+		       memory_used_mb, memory_total_mb, utilization_percent,
+		       COALESCE(fan_speed_percent, 0), collected_at
+		FROM latest_gpu_metrics
+		WHERE node_id = $1
+		ORDER BY gpu_index
+	`, nodeID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	for metricRows.Next() {
+		var m MetricResponse
+		if err := metricRows.Scan(&m.NodeID, &m.GPUIndex, &m.TemperatureCelsius,
+			&m.PowerWatts, &m.MemoryUsedMB, &m.MemoryTotalMB,
+			&m.UtilizationPercent, &m.FanSpeedPercent, &m.CollectedAt); err != nil {
+			metricRows.Close()
+			writeInternalError(w, r, err)
+			return
+		}
+		applyTemperatureUnit(&m, unit)
+		detail.LatestMetrics = append(detail.LatestMetrics, m)
+	}
+	metricRows.Close()
+
+	alertRows, err := tx.Query(`
+		SELECT id, node_id, gpu_index, alert_type, severity, message,
+		       threshold_value, actual_value, status, triggered_at,
+		       COALESCE(dedup_key, ''), COALESCE(resolution, ''),
+		       acknowledged_at, COALESCE(acknowledged_by, ''), COALESCE(runbook_url, '')
+		FROM alerts
+		WHERE node_id = $1 AND status = 'active'
+		ORDER BY severity DESC, triggered_at DESC
+	`, nodeID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer alertRows.Close()
+
+	for alertRows.Next() {
+		var a AlertResponse
+		if err := alertRows.Scan(&a.ID, &a.NodeID, &a.GPUIndex, &a.AlertType,
+			&a.Severity, &a.Message, &a.ThresholdValue, &a.ActualValue,
+			&a.Status, &a.TriggeredAt, &a.DedupKey, &a.Resolution,
+			&a.AcknowledgedAt, &a.AcknowledgedBy, &a.RunbookURL); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		detail.ActiveAlerts = append(detail.ActiveAlerts, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
+
+// FleetSnapshot is a point-in-time dump of the fleet for archiving
+type FleetSnapshot struct {
+	TakenAt       time.Time        `json:"taken_at"`
+	Nodes         []NodeHealth     `json:"nodes"`
+	LatestMetrics []MetricResponse `json:"latest_metrics"`
+	ActiveAlerts  []AlertResponse  `json:"active_alerts"`
+}
+
+// getSnapshot returns every node, each GPU's latest metrics and all active
+// alerts. Like getNodeDetail it reads them in one repeatable-read
+// transaction, so the parts agree with each other and with taken_at.
+func (s *APIServer) getSnapshot(w http.ResponseWriter, r *http.Request) {
+	tx, err := s.db.BeginTx(r.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	snap := FleetSnapshot{
+		Nodes:         []NodeHealth{},
+		LatestMetrics: []MetricResponse{},
+		ActiveAlerts:  []AlertResponse{},
+	}
+	// NOW() is the transaction's start time, which the snapshot is as of
+	if err := tx.QueryRow("SELECT NOW()").Scan(&snap.TakenAt); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	nodeRows, err := tx.Query(`
+		SELECT `+nodeHealthColumns+`
+		FROM gpu_nodes n
+		LEFT JOIN alerts a ON n.node_id = a.node_id AND a.status = 'active'
+		WHERE n.is_gpu
+		GROUP BY n.node_id, n.hostname, n.status, n.datacenter, n.last_seen, n.muted_until
+		ORDER BY n.node_id
+	`, s.cfg.StaleAfter.Seconds())
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	for nodeRows.Next() {
+		node, err := s.scanNodeHealth(nodeRows)
+		if err != nil {
+			nodeRows.Close()
+			writeInternalError(w, r, err)
+			return
+		}
+		snap.Nodes = append(snap.Nodes, node)
+	}
+	nodeRows.Close()
+
+	metricRows, err := tx.Query(`
+		SELECT node_id, gpu_index, temperature_celsius, COALESCE(power_watts, 0),
+		       memory_used_mb, memory_total_mb, utilization_percent,
+		       COALESCE(fan_speed_percent, 0), collected_at
+		FROM latest_gpu_metrics
+		ORDER BY node_id, gpu_index
+	`)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	for metricRows.Next() {
+		var m MetricResponse
+		if err := metricRows.Scan(&m.NodeID, &m.GPUIndex, &m.TemperatureCelsius,
+			&m.PowerWatts, &m.MemoryUsedMB, &m.MemoryTotalMB,
+			&m.UtilizationPercent, &m.FanSpeedPercent, &m.CollectedAt); err != nil {
+			metricRows.Close()
+			writeInternalError(w, r, err)
+			return
+		}
+		snap.LatestMetrics = append(snap.LatestMetrics, m)
+	}
+	metricRows.Close()
+
+	alertRows, err := tx.Query(`
+		SELECT id, node_id, gpu_index, alert_type, severity, message,
+		       threshold_value, actual_value, status, triggered_at,
+		       COALESCE(dedup_key, ''), COALESCE(resolution, ''),
+		       acknowledged_at, COALESCE(acknowledged_by, ''), COALESCE(runbook_url, '')
+		FROM alerts
+		WHERE status = 'active'
+		ORDER BY node_id, gpu_index, triggered_at DESC
+	`)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer alertRows.Close()
+
+	for alertRows.Next() {
+		var a AlertResponse
+		if err := alertRows.Scan(&a.ID, &a.NodeID, &a.GPUIndex, &a.AlertType,
+			&a.Severity, &a.Message, &a.ThresholdValue, &a.ActualValue,
+			&a.Status, &a.TriggeredAt, &a.DedupKey, &a.Resolution,
+			&a.AcknowledgedAt, &a.AcknowledgedBy, &a.RunbookURL); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		snap.ActiveAlerts = append(snap.ActiveAlerts, a)
+	}
+	if err := alertRows.Err(); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="fleet-snapshot-%s.json"`, snap.TakenAt.UTC().Format("20060102T150405Z")))
+	json.NewEncoder(w).Encode(snap)
+}
+
+// Row limits for metric series queries
+const (
+	defaultMetricsLimit = 100
+	maxMetricsLimit     = 1000
+)
+
+// metricsQuery holds the shared time-range/limit params of the metric series endpoints
+type metricsQuery struct {
+	limit  int
+	start  *time.Time
+	end    *time.Time
+	unit   string
+	labels []byte          // JSON label selector, nil when unfiltered
+	fields map[string]bool // JSON keys to return, nil for all
+}
+
+// parseLabelSelector reads repeated ?label=key:value params into a JSON
+// object for a JSONB containment (@>) match; nil when none are given
+func parseLabelSelector(r *http.Request) ([]byte, error) {
+	params := r.URL.Query()["label"]
+	if len(params) == 0 {
+		return nil, nil
+	}
+
+	selector := make(map[string]string, len(params))
+	for _, p := range params {
+		k, v, ok := strings.Cut(p, ":")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key:value", p)
+		}
+		selector[k] = v
+	}
+	return json.Marshal(selector)
+}
+
+// scanLabels decodes a nullable JSONB labels column into m
+func scanLabels(raw []byte, m *MetricResponse) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, &m.Labels)
+}
+
+// metricFieldKeys maps public ?fields= names to the MetricResponse JSON keys
+// they select (allow-list)
+var metricFieldKeys = map[string][]string{
+	"temperature":  {"temperature_celsius", "temperature", "unit"},
+	"power":        {"power_watts"},
+	"memory_used":  {"memory_used_mb"},
+	"memory_total": {"memory_total_mb"},
+	"utilization":  {"utilization_percent"},
+	"fan_speed":    {"fan_speed_percent"},
+	"labels":       {"labels"},
+}
+
+// metricIdentityKeys are returned regardless of ?fields=
+var metricIdentityKeys = []string{"node_id", "gpu_index", "gpu_uuid", "collected_at", "age_seconds", "stale"}
+
+// parseFields reads ?fields=a,b against metricFieldKeys; nil means all fields
+func parseFields(r *http.Request) (map[string]bool, error) {
+	v := r.URL.Query().Get("fields")
+	if v == "" {
+		return nil, nil
+	}
+
+	keys := make(map[string]bool)
+	for _, k := range metricIdentityKeys {
+		keys[k] = true
+	}
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		jsonKeys, ok := metricFieldKeys[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		for _, k := range jsonKeys {
+			keys[k] = true
+		}
+	}
+	return keys, nil
+}
+
+// projectMetrics restricts each metric to the requested JSON keys. With no
+// projection the metrics are returned unchanged.
+func projectMetrics(metrics []MetricResponse, fields map[string]bool) interface{} {
+	if fields == nil {
+		return metrics
+	}
+
+	projected := make([]map[string]json.RawMessage, 0, len(metrics))
+	for _, m := range metrics {
+		data, _ := json.Marshal(m)
+		var full map[string]json.RawMessage
+		json.Unmarshal(data, &full)
+		for k := range full {
+			if !fields[k] {
+				delete(full, k)
+			}
+		}
+		projected = append(projected, full)
+	}
+	return projected
+}
+
+// parseMetricsQuery reads ?limit=, optional RFC3339 ?start=/?end=, ?unit=, ?label= and ?fields=
+func parseMetricsQuery(r *http.Request) (metricsQuery, error) {
+	q := metricsQuery{limit: defaultMetricsLimit}
+
+	unit, err := parseTemperatureUnit(r)
+	if err != nil {
+		return q, err
+	}
+	q.unit = unit
+
+	if q.labels, err = parseLabelSelector(r); err != nil {
+		return q, err
+	}
+	if q.fields, err = parseFields(r); err != nil {
+		return q, err
+	}
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return q, fmt.Errorf("invalid limit %q", v)
+		}
+		if limit > maxMetricsLimit {
+			limit = maxMetricsLimit
+		}
+		q.limit = limit
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{{"start", &q.start}, {"end", &q.end}} {
+		v := r.URL.Query().Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, fmt.Errorf("invalid %s time: %w", p.name, err)
+		}
+		*p.dst = &t
+	}
+
+	return q, nil
+}
+
+// queryMetricSeries loads a node's metric rows newest first, optionally scoped to one GPU
+func (s *APIServer) queryMetricSeries(nodeID string, gpuIndex *int, q metricsQuery) ([]MetricResponse, error) {
+	where := []string{"node_id = $1"}
+	args := []interface{}{nodeID}
+
+	if gpuIndex != nil {
+		args = append(args, *gpuIndex)
+		where = append(where, fmt.Sprintf("gpu_index = $%d", len(args)))
+	}
+	return s.queryMetricRows(where, args, q)
+}
+
+// queryMetricRows loads the metric rows matching where, plus q's time range
+// and label filters, newest first
+func (s *APIServer) queryMetricRows(where []string, args []interface{}, q metricsQuery) ([]MetricResponse, error) {
+	if q.start != nil {
+		args = append(args, *q.start)
+		where = append(where, fmt.Sprintf("collected_at >= $%d", len(args)))
+	}
+	if q.end != nil {
+		args = append(args, *q.end)
+		where = append(where, fmt.Sprintf("collected_at < $%d", len(args)))
+	}
+	if q.labels != nil {
+		args = append(args, string(q.labels))
+		where = append(where, fmt.Sprintf("labels @> $%d::jsonb", len(args)))
+	}
+	args = append(args, q.limit)
+
+	query := fmt.Sprintf(`
+		SELECT node_id, gpu_index, temperature_celsius, COALESCE(power_watts, 0),
+		       memory_used_mb, memory_total_mb, utilization_percent,
+		       COALESCE(fan_speed_percent, 0), COALESCE(gpu_uuid, ''),
+		       collected_at, labels
+		FROM gpu_metrics
+		WHERE %s
+		ORDER BY collected_at DESC
+		LIMIT $%d
+	`, strings.Join(where, " AND "), len(args))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []MetricResponse
+	for rows.Next() {
+		var m MetricResponse
+		var labels []byte
+		if err := rows.Scan(&m.NodeID, &m.GPUIndex, &m.TemperatureCelsius,
+			&m.PowerWatts, &m.MemoryUsedMB, &m.MemoryTotalMB,
+			&m.UtilizationPercent, &m.FanSpeedPercent, &m.GPUUUID,
+			&m.CollectedAt, &labels); err != nil {
+			return nil, err
+		}
+		if err := scanLabels(labels, &m); err != nil {
+			return nil, err
+		}
+		applyTemperatureUnit(&m, q.unit)
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
+
+func (s *APIServer) getNodeMetrics(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	nodeID := vars["node_id"]
+
+	q, err := parseMetricsQuery(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	metrics, err := s.queryMetricSeries(nodeID, nil, q)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(projectMetrics(metrics, q.fields))
+}
+
+// getGPUMetrics returns the metric series of a single GPU on a node
+func (s *APIServer) getGPUMetrics(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	nodeID := vars["node_id"]
+
+	gpuIndex, err := strconv.Atoi(vars["gpu_index"])
+	if err != nil || gpuIndex < 0 {
+		writeError(w, r, http.StatusBadRequest, "Invalid GPU index")
+		return
+	}
+
+	q, err := parseMetricsQuery(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	metrics, err := s.queryMetricSeries(nodeID, &gpuIndex, q)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// An empty window is fine, but a GPU that never reported is a 404
+	if len(metrics) == 0 {
+		var exists bool
+		err := s.db.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM gpu_metrics WHERE node_id = $1 AND gpu_index = $2)",
+			nodeID, gpuIndex,
+		).Scan(&exists)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		if !exists {
+			writeError(w, r, http.StatusNotFound, "GPU not found")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(projectMetrics(metrics, q.fields))
+}
+
+// getGPUUUIDMetrics returns the metric series of one physical card by its
+// UUID, across every node and index it has reported from, so a card can be
+// followed through reseating or RMA
+func (s *APIServer) getGPUUUIDMetrics(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+
+	q, err := parseMetricsQuery(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	metrics, err := s.queryMetricRows([]string{"gpu_uuid = $1"}, []interface{}{uuid}, q)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// An empty window is fine, but a card that never reported is a 404
+	if len(metrics) == 0 {
+		var exists bool
+		err := s.db.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM gpu_metrics WHERE gpu_uuid = $1)", uuid,
+		).Scan(&exists)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		if !exists {
+			writeError(w, r, http.StatusNotFound, "GPU not found")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(projectMetrics(metrics, q.fields))
+}
+
+// HistogramSample is one GPU sample's histograms, keyed by name; each is
+// {"buckets":[{"le","count"}...],"count","sum"} with cumulative buckets
+type HistogramSample struct {
+	CollectedAt time.Time       `json:"collected_at"`
+	Histograms  json.RawMessage `json:"histograms"`
+}
+
+// getGPUHistograms returns a GPU's histogram fields over a time range
+// (default the last hour), oldest first. ?name= narrows to one histogram.
+func (s *APIServer) getGPUHistograms(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	nodeID := vars["node_id"]
+
+	gpuIndex, err := strconv.Atoi(vars["gpu_index"])
+	if err != nil || gpuIndex < 0 {
+		writeError(w, r, http.StatusBadRequest, "Invalid GPU index")
+		return
+	}
+
+	start, end, err := parseTimeRange(r, time.Hour)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// With ?name= only that key is returned, and samples without it are skipped
+	name := r.URL.Query().Get("name")
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT collected_at,
+		       CASE WHEN $5 = '' THEN histograms
+		            ELSE jsonb_build_object($5::text, histograms -> $5::text) END
+		FROM gpu_metrics
+		WHERE node_id = $1 AND gpu_index = $2
+		  AND collected_at >= $3 AND collected_at <= $4
+		  AND histograms IS NOT NULL
+		  AND ($5 = '' OR histograms ? $5::text)
+		ORDER BY collected_at
+	`, nodeID, gpuIndex, start, end, name)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	samples := []HistogramSample{}
+	truncated, next := false, ""
+	for rows.Next() {
+		var sample HistogramSample
+		var histograms []byte
+		if err := rows.Scan(&sample.CollectedAt, &histograms); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		if s.rowCapReached(len(samples)) {
+			truncated, next = true, sample.CollectedAt.Format(time.RFC3339Nano)
+			break
+		}
+		sample.Histograms = histograms
+		samples = append(samples, sample)
+	}
+	if err := rows.Err(); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeList(w, r, samples, truncated, next)
+}
+
+type PercentileResponse struct {
+	NodeID      string    `json:"node_id"`
+	Metric      string    `json:"metric"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	SampleCount int       `json:"sample_count"`
+	P50         *float64  `json:"p50"`
+	P90         *float64  `json:"p90"`
+	P95         *float64  `json:"p95"`
+	P99         *float64  `json:"p99"`
+}
+
+// getMetricPercentiles returns tail percentiles for one metric of a node over a time range
+func (s *APIServer) getMetricPercentiles(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	nodeID := vars["node_id"]
+
+	metric, column, err := parseMetricColumn(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	start, end, err := parseTimeRange(r, time.Hour)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := fmt.Sprintf(`
+		SELECT COUNT(%[1]s),
+		       percentile_cont(0.50) WITHIN GROUP (ORDER BY %[1]s),
+		       percentile_cont(0.90) WITHIN GROUP (ORDER BY %[1]s),
+		       percentile_cont(0.95) WITHIN GROUP (ORDER BY %[1]s),
+		       percentile_cont(0.99) WITHIN GROUP (ORDER BY %[1]s)
+		FROM gpu_metrics
+		WHERE node_id = $1 AND collected_at >= $2 AND collected_at < $3
+	`, column)
+
+	resp := PercentileResponse{NodeID: nodeID, Metric: metric, Start: start, End: end}
+	var p50, p90, p95, p99 sql.NullFloat64
+	err = s.db.QueryRow(query, nodeID, start, end).Scan(
+		&resp.SampleCount, &p50, &p90, &p95, &p99,
+	)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// Percentiles stay null when the window has no samples
+	if p50.Valid {
+		resp.P50, resp.P90, resp.P95, resp.P99 = &p50.Float64, &p90.Float64, &p95.Float64, &p99.Float64
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ingestRateMaxAge is how recently an engine instance must have written a
+// node's rate for it to count; older rows are left by stopped instances
+const ingestRateMaxAge = 5 * time.Minute
+
+type IngestRate struct {
+	NodeID           string `json:"node_id"`
+	SamplesPerMinute int64  `json:"samples_per_minute"`
+	GPUsReporting    int    `json:"gpus_reporting"`
+	ExpectedGPUCount *int   `json:"expected_gpu_count"`
+	// Expected rate for ?poll_interval=, from the expected GPU count or,
+	// when the node has none, the GPUs reporting
+	PollIntervalSeconds      *float64   `json:"poll_interval_seconds,omitempty"`
+	ExpectedSamplesPerMinute *float64   `json:"expected_samples_per_minute,omitempty"`
+	Ratio                    *float64   `json:"ratio,omitempty"`
+	UpdatedAt                *time.Time `json:"updated_at"`
+}
+
+// getNodeIngestRate returns the samples per minute the alert engine has
+// received from a node, summed over engine instances. With ?poll_interval=
+// (the collector's POLL_INTERVAL) it also returns the expected rate,
+// gpu_count × 60 / poll_interval, and the observed share of it.
+func (s *APIServer) getNodeIngestRate(w http.ResponseWriter, r *http.Request) {
+	nodeID := mux.Vars(r)["node_id"]
+
+	var poll time.Duration
+	if v := r.URL.Query().Get("poll_interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, r, http.StatusBadRequest, "poll_interval must be a positive duration")
+			return
+		}
+		poll = d
+	}
+
+	rate := IngestRate{NodeID: nodeID}
+	var updatedAt sql.NullTime
+	err := s.db.QueryRowContext(r.Context(), `
+		SELECT n.expected_gpu_count, COALESCE(SUM(r.samples_per_minute), 0), COALESCE(SUM(r.gpus), 0), MAX(r.updated_at)
+		FROM gpu_nodes n
+		LEFT JOIN node_ingest_rates r
+		       ON r.node_id = n.node_id AND r.updated_at > NOW() - make_interval(secs => $2)
+		WHERE n.node_id = $1
+		GROUP BY n.node_id, n.expected_gpu_count
+	`, nodeID, ingestRateMaxAge.Seconds()).Scan(&rate.ExpectedGPUCount, &rate.SamplesPerMinute, &rate.GPUsReporting, &updatedAt)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "Node not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if updatedAt.Valid {
+		rate.UpdatedAt = &updatedAt.Time
+	}
+
+	if poll > 0 {
+		gpus := rate.GPUsReporting
+		if rate.ExpectedGPUCount != nil {
+			gpus = *rate.ExpectedGPUCount
+		}
+		pollSeconds := poll.Seconds()
+		expected := float64(gpus) * 60 / pollSeconds
+		rate.PollIntervalSeconds, rate.ExpectedSamplesPerMinute = &pollSeconds, &expected
+		if expected > 0 {
+			ratio := float64(rate.SamplesPerMinute) / expected
+			rate.Ratio = &ratio
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rate)
+}
+
+type CorrelationResponse struct {
+	NodeID      string    `json:"node_id"`
+	GPUIndex    int       `json:"gpu_index"`
+	X           string    `json:"x"`
+	Y           string    `json:"y"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	SampleCount int       `json:"sample_count"`
+	Correlation *float64  `json:"correlation"`
+}
+
+// getGPUCorrelation returns the Pearson correlation between two metrics
+// (?x= and ?y=, names from metricColumns) of one GPU over a time range
+func (s *APIServer) getGPUCorrelation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	nodeID := vars["node_id"]
+
+	gpuIndex, err := strconv.Atoi(vars["gpu_index"])
+	if err != nil || gpuIndex < 0 {
+		writeError(w, r, http.StatusBadRequest, "Invalid GPU index")
+		return
+	}
+
+	query := r.URL.Query()
+	x, y := query.Get("x"), query.Get("y")
+	if x == "" || y == "" {
+		writeError(w, r, http.StatusBadRequest, "x and y metrics are required")
+		return
+	}
+	xColumn, ok := metricColumns[x]
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("unknown metric %q", x))
+		return
+	}
+	yColumn, ok := metricColumns[y]
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("unknown metric %q", y))
+		return
+	}
+
+	start, end, err := parseTimeRange(r, time.Hour)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// regr_count counts the pairs corr() used, i.e. rows with both values
+	sqlQuery := fmt.Sprintf(`
+		SELECT regr_count(%[2]s, %[1]s), corr(%[2]s, %[1]s)
+		FROM gpu_metrics
+		WHERE node_id = $1 AND gpu_index = $2 AND collected_at >= $3 AND collected_at < $4
+	`, xColumn, yColumn)
+
+	resp := CorrelationResponse{NodeID: nodeID, GPUIndex: gpuIndex, X: x, Y: y, Start: start, End: end}
+	var correlation sql.NullFloat64
+	err = s.db.QueryRowContext(r.Context(), sqlQuery, nodeID, gpuIndex, start, end).Scan(&resp.SampleCount, &correlation)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// Null with fewer than two samples, or when either metric is constant
+	if correlation.Valid {
+		resp.Correlation = &correlation.Float64
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Sparkline point counts
+const (
+	defaultSparklinePoints = 30
+	maxSparklinePoints     = 500
+)
+
+type SparklineResponse struct {
+	NodeID string                `json:"node_id"`
+	Metric string                `json:"metric"`
+	Start  time.Time             `json:"start"`
+	End    time.Time             `json:"end"`
+	Points int                   `json:"points"`
+	GPUs   map[string][]*float64 `json:"gpus"`
+}
+
+// getSparklines returns, per GPU of a node, the metric averaged into ?points=
+// equal time buckets over the window (default last hour). Empty buckets are null.
+func (s *APIServer) getSparklines(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	nodeID := vars["node_id"]
+
+	metric, column, err := parseMetricColumn(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	start, end, err := parseTimeRange(r, time.Hour)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	points := defaultSparklinePoints
+	if v := r.URL.Query().Get("points"); v != "" {
+		points, err = strconv.Atoi(v)
+		if err != nil || points <= 0 || points > maxSparklinePoints {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("points must be between 1 and %d", maxSparklinePoints))
+			return
+		}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT gpu_index,
+		       width_bucket(EXTRACT(EPOCH FROM collected_at), $2, $3, $4) AS bucket,
+		       AVG(%s)
+		FROM gpu_metrics
+		WHERE node_id = $1 AND collected_at >= $5 AND collected_at < $6
+		GROUP BY gpu_index, bucket
+		ORDER BY gpu_index, bucket
+	`, column)
+
+	rows, err := s.db.Query(query, nodeID,
+		float64(start.Unix()), float64(end.Unix()), points, start, end)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	resp := SparklineResponse{
+		NodeID: nodeID,
+		Metric: metric,
+		Start:  start,
+		End:    end,
+		Points: points,
+		GPUs:   make(map[string][]*float64),
+	}
+	for rows.Next() {
+		var gpuIndex, bucket int
+		var value sql.NullFloat64
+		if err := rows.Scan(&gpuIndex, &bucket, &value); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+
+		key := strconv.Itoa(gpuIndex)
+		series, ok := resp.GPUs[key]
+		if !ok {
+			series = make([]*float64, points)
+			resp.GPUs[key] = series
+		}
+		// width_bucket is 1-based; values at the upper edge land in points+1
+		if bucket >= 1 && bucket <= points && value.Valid {
+			v := value.Float64
+			series[bucket-1] = &v
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *APIServer) getAlerts(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT id, node_id, gpu_index, alert_type, severity, message,
+		       threshold_value, actual_value, status, triggered_at,
+		       COALESCE(dedup_key, ''), COALESCE(resolution, ''),
+		       acknowledged_at, COALESCE(acknowledged_by, ''), COALESCE(runbook_url, '')
+		FROM alerts
+		ORDER BY triggered_at DESC
+		LIMIT 100
+	`
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	var alerts []AlertResponse
+	for rows.Next() {
+		var a AlertResponse
+		if err := rows.Scan(&a.ID, &a.NodeID, &a.GPUIndex, &a.AlertType,
+			&a.Severity, &a.Message, &a.ThresholdValue, &a.ActualValue,
+			&a.Status, &a.TriggeredAt, &a.DedupKey, &a.Resolution,
+			&a.AcknowledgedAt, &a.AcknowledgedBy, &a.RunbookURL); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		alerts = append(alerts, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alerts)
+}
+
+// getActiveAlerts lists active alerts, most severe first. With
+// ?include_resolved_since=<duration> it also lists alerts resolved within
+// that lookback, after the active ones, for incident review.
+func (s *APIServer) getActiveAlerts(w http.ResponseWriter, r *http.Request) {
+	// Lookback in seconds for resolved alerts; nil lists active ones only
+	var resolvedSince *float64
+	if v := r.URL.Query().Get("include_resolved_since"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, r, http.StatusBadRequest, "include_resolved_since must be a positive duration such as 2h")
+			return
+		}
+		seconds := d.Seconds()
+		resolvedSince = &seconds
+	}
+	offset, err := parseOffset(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := `
+		SELECT id, node_id, gpu_index, alert_type, severity, message,
+		       threshold_value, actual_value, status, triggered_at,
+		       COALESCE(dedup_key, ''), COALESCE(resolution, ''), resolved_at,
+		       acknowledged_at, COALESCE(acknowledged_by, ''), COALESCE(runbook_url, '')
+		FROM alerts
+		WHERE status = 'active'
+		   OR ($1::float8 IS NOT NULL AND status = 'resolved'
+		       AND resolved_at >= NOW() - $1::float8 * INTERVAL '1 second')
+		ORDER BY status = 'active' DESC, severity DESC, triggered_at DESC, id
+		OFFSET $2
+	`
+
+	rows, err := s.db.Query(query, resolvedSince, offset)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	var alerts []AlertResponse
+	truncated := false
+	for rows.Next() {
+		var a AlertResponse
+		if err := rows.Scan(&a.ID, &a.NodeID, &a.GPUIndex, &a.AlertType,
+			&a.Severity, &a.Message, &a.ThresholdValue, &a.ActualValue,
+			&a.Status, &a.TriggeredAt, &a.DedupKey, &a.Resolution, &a.ResolvedAt,
+			&a.AcknowledgedAt, &a.AcknowledgedBy, &a.RunbookURL); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		if s.rowCapReached(len(alerts)) {
+			truncated = true
+			break
+		}
+		alerts = append(alerts, a)
+	}
+
+	writeList(w, r, alerts, truncated, strconv.Itoa(offset+len(alerts)))
+}
+
+// Correlation window bounds for /alerts/correlated
+const (
+	defaultCorrelationWindow = 60 * time.Second
+	maxCorrelationWindow     = time.Hour
+)
+
+// AlertCluster is a run of alerts on several nodes, each triggered within
+// the correlation window of the one before it
+type AlertCluster struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	AlertCount  int       `json:"alert_count"`
+	NodeCount   int       `json:"node_count"`
+	Nodes       []string  `json:"nodes"`
+	Datacenters []string  `json:"datacenters"`
+	AlertTypes  []string  `json:"alert_types"`
+	AlertIDs    []int64   `json:"alert_ids"`
+}
+
+// getCorrelatedAlerts groups alerts triggered over ?start=&end= (default last
+// 24h) into clusters: an alert joins the current cluster when it triggered
+// within ?window= (default 60s) of the previous alert. Clusters spanning at
+// least ?min_nodes= (default 2) nodes are returned, newest first, to point at
+// shared infrastructure such as a rack PDU or switch.
+func (s *APIServer) getCorrelatedAlerts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	window := defaultCorrelationWindow
+	if v := query.Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxCorrelationWindow {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("window must be a positive duration up to %s", maxCorrelationWindow))
+			return
+		}
+		window = d
+	}
+
+	minNodes := 2
+	if v := query.Get("min_nodes"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 2 {
+			writeError(w, r, http.StatusBadRequest, "min_nodes must be an integer of at least 2")
+			return
+		}
+		minNodes = n
+	}
+
+	start, end, err := parseTimeRange(r, 24*time.Hour)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Gaps and islands: a gap longer than the window starts a new cluster,
+	// and the running sum of starts numbers the clusters
+	rows, err := s.db.Query(`
+		WITH ordered AS (
+			SELECT a.id, a.node_id, a.alert_type, a.triggered_at, n.datacenter,
+			       CASE WHEN a.triggered_at - LAG(a.triggered_at) OVER (ORDER BY a.triggered_at, a.id)
+			                 <= make_interval(secs => $3)
+			            THEN 0 ELSE 1 END AS starts
+			FROM alerts a
+			LEFT JOIN gpu_nodes n ON n.node_id = a.node_id
+			WHERE a.triggered_at >= $1 AND a.triggered_at < $2
+		), clustered AS (
+			SELECT *, SUM(starts) OVER (ORDER BY triggered_at, id) AS cluster
+			FROM ordered
+		)
+		SELECT MIN(triggered_at), MAX(triggered_at), COUNT(*), COUNT(DISTINCT node_id),
+		       array_agg(DISTINCT node_id ORDER BY node_id),
+		       array_remove(array_agg(DISTINCT datacenter ORDER BY datacenter), NULL),
+		       array_agg(DISTINCT alert_type ORDER BY alert_type),
+		       array_agg(id ORDER BY triggered_at, id)
+		FROM clustered
+		GROUP BY cluster
+		HAVING COUNT(DISTINCT node_id) >= $4
+		ORDER BY MIN(triggered_at) DESC
+	`, start, end, window.Seconds(), minNodes)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	clusters := []AlertCluster{}
+	truncated, next := false, ""
+	for rows.Next() {
+		var c AlertCluster
+		if err := rows.Scan(&c.Start, &c.End, &c.AlertCount, &c.NodeCount,
+			pq.Array(&c.Nodes), pq.Array(&c.Datacenters), pq.Array(&c.AlertTypes), pq.Array(&c.AlertIDs)); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		// Clusters come newest first, so the rest end no later than this
+		// one; the window end is exclusive, hence the microsecond past it
+		if s.rowCapReached(len(clusters)) {
+			truncated, next = true, c.End.Add(time.Microsecond).Format(time.RFC3339Nano)
+			break
+		}
+		clusters = append(clusters, c)
+	}
+	if err := rows.Err(); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeList(w, r, clusters, truncated, next)
+}
+
+type AlertStatsResponse struct {
+	Start                    time.Time      `json:"start"`
+	End                      time.Time      `json:"end"`
+	Total                    int            `json:"total"`
+	ByType                   map[string]int `json:"by_type"`
+	BySeverity               map[string]int `json:"by_severity"`
+	ResolvedCount            int            `json:"resolved_count"`
+	MeanTimeToResolveSeconds *float64       `json:"mean_time_to_resolve_seconds"`
+}
+
+// getAlertStats summarizes alerts triggered in a window (default last 7 days)
+func (s *APIServer) getAlertStats(w http.ResponseWriter, r *http.Request) {
+	start, end, err := parseTimeRange(r, 7*24*time.Hour)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT alert_type, severity, COUNT(*)
+		FROM alerts
+		WHERE triggered_at >= $1 AND triggered_at < $2
+		GROUP BY alert_type, severity
+	`, start, end)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	stats := AlertStatsResponse{
+		Start:      start,
+		End:        end,
+		ByType:     make(map[string]int),
+		BySeverity: make(map[string]int),
+	}
+	for rows.Next() {
+		var alertType, severity string
+		var count int
+		if err := rows.Scan(&alertType, &severity, &count); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		stats.Total += count
+		stats.ByType[alertType] += count
+		stats.BySeverity[severity] += count
+	}
+
+	var mttr sql.NullFloat64
+	err = s.db.QueryRow(`
+		SELECT COUNT(*), AVG(EXTRACT(EPOCH FROM (resolved_at - triggered_at)))
+		FROM alerts
+		WHERE triggered_at >= $1 AND triggered_at < $2
+		  AND status = 'resolved' AND resolved_at IS NOT NULL
+	`, start, end).Scan(&stats.ResolvedCount, &mttr)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if mttr.Valid {
+		stats.MeanTimeToResolveSeconds = &mttr.Float64
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// AlertTypeCount is how often one alert_type fired at one severity, and
+// how those alerts were resolved. Automatic resolutions are timeouts and
+// recoveries seen by the engine.
+type AlertTypeCount struct {
+	AlertType        string `json:"alert_type"`
+	Severity         string `json:"severity"`
+	Count            int    `json:"count"`
+	Resolved         int    `json:"resolved"`
+	AutoResolved     int    `json:"auto_resolved"`
+	ManuallyResolved int    `json:"manually_resolved"`
+	// AutoResolvedFraction is AutoResolved over Resolved; nil when none
+	// were resolved
+	AutoResolvedFraction *float64 `json:"auto_resolved_fraction"`
+}
+
+type AlertDistributionResponse struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Total int       `json:"total"`
+	// Types is ordered by count, noisiest first
+	Types                []AlertTypeCount `json:"types"`
+	Resolved             int              `json:"resolved"`
+	AutoResolved         int              `json:"auto_resolved"`
+	ManuallyResolved     int              `json:"manually_resolved"`
+	AutoResolvedFraction *float64         `json:"auto_resolved_fraction"`
+}
+
+// resolvedFraction returns part over resolved, or nil when nothing resolved
+func resolvedFraction(part, resolved int) *float64 {
+	if resolved == 0 {
+		return nil
+	}
+	f := float64(part) / float64(resolved)
+	return &f
+}
+
+// getAlertDistribution counts alerts triggered in a window (default last
+// 7 days) by alert_type and severity, with how they were resolved, to find
+// noisy rules
+func (s *APIServer) getAlertDistribution(w http.ResponseWriter, r *http.Request) {
+	start, end, err := parseTimeRange(r, 7*24*time.Hour)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT alert_type, severity, COUNT(*),
+		       COUNT(*) FILTER (WHERE status = 'resolved'),
+		       COUNT(*) FILTER (WHERE status = 'resolved' AND resolution IN ('timeout', 'recovered')),
+		       COUNT(*) FILTER (WHERE status = 'resolved' AND resolution = 'manual')
+		FROM alerts
+		WHERE triggered_at >= $1 AND triggered_at < $2
+		GROUP BY alert_type, severity
+		ORDER BY COUNT(*) DESC, alert_type, severity
+	`, start, end)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	resp := AlertDistributionResponse{Start: start, End: end, Types: []AlertTypeCount{}}
+	for rows.Next() {
+		var c AlertTypeCount
+		if err := rows.Scan(&c.AlertType, &c.Severity, &c.Count,
+			&c.Resolved, &c.AutoResolved, &c.ManuallyResolved); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		c.AutoResolvedFraction = resolvedFraction(c.AutoResolved, c.Resolved)
+		resp.Total += c.Count
+		resp.Resolved += c.Resolved
+		resp.AutoResolved += c.AutoResolved
+		resp.ManuallyResolved += c.ManuallyResolved
+		resp.Types = append(resp.Types, c)
+	}
+	if err := rows.Err(); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	resp.AutoResolvedFraction = resolvedFraction(resp.AutoResolved, resp.Resolved)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// aggregateFuncs maps the ?fn= param to SQL aggregate templates (allow-list);
+// %s is replaced with a column from metricColumns
+var aggregateFuncs = map[string]string{
+	"avg": "AVG(%s)",
+	"min": "MIN(%s)",
+	"max": "MAX(%s)",
+	"p95": "percentile_cont(0.95) WITHIN GROUP (ORDER BY %s)",
+}
+
+// GPUIndexAggregate is one metric aggregate for a GPU slot across the fleet
+type GPUIndexAggregate struct {
+	GPUIndex int     `json:"gpu_index"`
+	Value    float64 `json:"value"`
+	Nodes    int     `json:"nodes"`
+	Samples  int     `json:"samples"`
+}
+
+type GPUIndexResponse struct {
+	Metric  string              `json:"metric"`
+	Fn      string              `json:"fn"`
+	Start   time.Time           `json:"start"`
+	End     time.Time           `json:"end"`
+	Indexes []GPUIndexAggregate `json:"indexes"`
+}
+
+// getMetricsByGPUIndex aggregates a metric per gpu_index across all nodes
+// over a window (default last hour), to spot slot-specific problems
+func (s *APIServer) getMetricsByGPUIndex(w http.ResponseWriter, r *http.Request) {
+	metric, column, err := parseMetricColumn(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	fn := r.URL.Query().Get("fn")
+	if fn == "" {
+		fn = "avg"
+	}
+	agg, ok := aggregateFuncs[fn]
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("unknown fn %q, expected avg, min, max or p95", fn))
+		return
+	}
+	agg = fmt.Sprintf(agg, column)
+
+	start, end, err := parseTimeRange(r, time.Hour)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT gpu_index, %s, COUNT(DISTINCT node_id), COUNT(%s)
+		FROM gpu_metrics
+		WHERE collected_at >= $1 AND collected_at < $2 AND %s IS NOT NULL
+		GROUP BY gpu_index
+		ORDER BY gpu_index
+	`, agg, column, column), start, end)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	resp := GPUIndexResponse{Metric: metric, Fn: fn, Start: start, End: end, Indexes: []GPUIndexAggregate{}}
+	for rows.Next() {
+		var a GPUIndexAggregate
+		if err := rows.Scan(&a.GPUIndex, &a.Value, &a.Nodes, &a.Samples); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		resp.Indexes = append(resp.Indexes, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Default and largest ?n= of the memory leaderboard
+const (
+	defaultLeaderboardSize = 20
+	maxLeaderboardSize     = 500
+)
+
+// MemoryLeader is one GPU's average memory pressure over the window
+type MemoryLeader struct {
+	NodeID           string  `json:"node_id"`
+	GPUIndex         int     `json:"gpu_index"`
+	AvgMemoryPercent float64 `json:"avg_memory_percent"`
+	Samples          int     `json:"samples"`
+}
+
+type MemoryLeaderboardResponse struct {
+	Start time.Time      `json:"start"`
+	End   time.Time      `json:"end"`
+	GPUs  []MemoryLeader `json:"gpus"`
+}
+
+// getMemoryLeaderboard ranks the fleet's GPUs by average memory_used_mb /
+// memory_total_mb over a window (default last hour) and returns the top ?n=.
+// Samples without a memory total are left out.
+func (s *APIServer) getMemoryLeaderboard(w http.ResponseWriter, r *http.Request) {
+	start, end, err := parseTimeRange(r, time.Hour)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	n := defaultLeaderboardSize
+	if v := r.URL.Query().Get("n"); v != "" {
+		n, err = strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxLeaderboardSize {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("n must be between 1 and %d", maxLeaderboardSize))
+			return
+		}
+	}
+
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT node_id, gpu_index, AVG(memory_used_mb / memory_total_mb) * 100, COUNT(*)
+		FROM gpu_metrics
+		WHERE collected_at >= $1 AND collected_at < $2
+		  AND memory_total_mb > 0 AND memory_used_mb IS NOT NULL
+		GROUP BY node_id, gpu_index
+		ORDER BY 3 DESC, node_id, gpu_index
+		LIMIT $3
+	`, start, end, n)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	resp := MemoryLeaderboardResponse{Start: start, End: end, GPUs: []MemoryLeader{}}
+	for rows.Next() {
+		var l MemoryLeader
+		if err := rows.Scan(&l.NodeID, &l.GPUIndex, &l.AvgMemoryPercent, &l.Samples); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		resp.GPUs = append(resp.GPUs, l)
+	}
+	if err := rows.Err(); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// maxAggregateBuckets bounds the buckets per series of a multi-metric aggregate
+const maxAggregateBuckets = 2000
+
+// AggregatePoint is one time bucket of an aggregated series; Value is null
+// when the bucket's samples have no reading for the metric
+type AggregatePoint struct {
+	Time  time.Time `json:"time"`
+	Value *float64  `json:"value"`
+}
+
+type MultiAggregateResponse struct {
+	NodeID        string                      `json:"node_id"`
+	Fn            string                      `json:"fn"`
+	BucketSeconds float64                     `json:"bucket_seconds"`
+	Start         time.Time                   `json:"start"`
+	End           time.Time                   `json:"end"`
+	Series        map[string][]AggregatePoint `json:"series"`
+}
+
+// getMultiMetricAggregate aggregates several metrics of a node's GPUs into
+// ?bucket= time buckets (default 5m, aligned to the epoch) over the window
+// (default last hour), computing every series in one grouped query. Buckets
+// without samples are omitted.
+func (s *APIServer) getMultiMetricAggregate(w http.ResponseWriter, r *http.Request) {
+	nodeID := mux.Vars(r)["node_id"]
+	query := r.URL.Query()
+
+	var metrics, columns []string
+	seen := make(map[string]bool)
+	for _, metric := range strings.Split(query.Get("metrics"), ",") {
+		metric = strings.TrimSpace(metric)
+		if metric == "" || seen[metric] {
+			continue
+		}
+		column, ok := metricColumns[metric]
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("unknown metric %q", metric))
+			return
+		}
+		seen[metric] = true
+		metrics = append(metrics, metric)
+		columns = append(columns, column)
+	}
+	if len(metrics) == 0 {
+		writeError(w, r, http.StatusBadRequest, "metrics is required, e.g. metrics=temperature,power,utilization")
+		return
+	}
+
+	fn := query.Get("fn")
+	if fn == "" {
+		fn = "avg"
+	}
+	agg, ok := aggregateFuncs[fn]
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("unknown fn %q, expected avg, min, max or p95", fn))
+		return
+	}
+
+	start, end, err := parseTimeRange(r, time.Hour)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	bucket := 5 * time.Minute
+	if v := query.Get("bucket"); v != "" {
+		bucket, err = time.ParseDuration(v)
+		if err != nil || bucket < time.Second {
+			writeError(w, r, http.StatusBadRequest, "bucket must be a duration of at least 1s, e.g. 5m")
+			return
+		}
+	}
+	if end.Sub(start)/bucket > maxAggregateBuckets {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("window spans more than %d buckets; widen bucket or narrow start/end", maxAggregateBuckets))
+		return
+	}
+
+	selects := make([]string, len(columns))
+	for i, column := range columns {
+		selects[i] = fmt.Sprintf(agg, column)
+	}
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT floor(EXTRACT(EPOCH FROM collected_at) / $2)::bigint AS bucket, %s
+		FROM gpu_metrics
+		WHERE node_id = $1 AND collected_at >= $3 AND collected_at < $4
+		GROUP BY bucket
+		ORDER BY bucket
+	`, strings.Join(selects, ", ")), nodeID, bucket.Seconds(), start, end)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	resp := MultiAggregateResponse{
+		NodeID:        nodeID,
+		Fn:            fn,
+		BucketSeconds: bucket.Seconds(),
+		Start:         start,
+		End:           end,
+		Series:        make(map[string][]AggregatePoint, len(metrics)),
+	}
+	for _, metric := range metrics {
+		resp.Series[metric] = []AggregatePoint{}
+	}
+
+	values := make([]sql.NullFloat64, len(metrics))
+	dest := make([]interface{}, len(metrics)+1)
+	var bucketIndex int64
+	dest[0] = &bucketIndex
+	for i := range values {
+		dest[i+1] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		t := time.Unix(0, bucketIndex*int64(bucket)).UTC()
+		for i, metric := range metrics {
+			point := AggregatePoint{Time: t}
+			if values[i].Valid {
+				v := values[i].Float64
+				point.Value = &v
+			}
+			resp.Series[metric] = append(resp.Series[metric], point)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// EfficiencyEntry is utilization per watt for one node or datacenter
+type EfficiencyEntry struct {
+	NodeID                string  `json:"node_id,omitempty"`
+	Datacenter            string  `json:"datacenter"`
+	AvgUtilizationPercent float64 `json:"avg_utilization_percent"`
+	AvgPowerWatts         float64 `json:"avg_power_watts"`
+	UtilizationPerWatt    float64 `json:"utilization_per_watt"`
+	Samples               int     `json:"samples"`
+}
+
+type EfficiencyResponse struct {
+	Start       time.Time         `json:"start"`
+	End         time.Time         `json:"end"`
+	Nodes       []EfficiencyEntry `json:"nodes"`
+	Datacenters []EfficiencyEntry `json:"datacenters"`
+}
+
+// getEfficiency ranks nodes and datacenters by average utilization per watt
+// over a window (default last 24h), least efficient first. Samples without
+// a positive power reading are ignored.
+func (s *APIServer) getEfficiency(w http.ResponseWriter, r *http.Request) {
+	start, end, err := parseTimeRange(r, 24*time.Hour)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp := EfficiencyResponse{
+		Start:       start,
+		End:         end,
+		Nodes:       []EfficiencyEntry{},
+		Datacenters: []EfficiencyEntry{},
+	}
+
+	for _, level := range []struct {
+		nodeCol string
+		groupBy string
+		dst     *[]EfficiencyEntry
+	}{
+		{"m.node_id", "m.node_id, n.datacenter", &resp.Nodes},
+		{"''", "n.datacenter", &resp.Datacenters},
+	} {
+		rows, err := s.db.Query(fmt.Sprintf(`
+			SELECT %s, COALESCE(n.datacenter, ''),
+			       AVG(m.utilization_percent), AVG(m.power_watts),
+			       AVG(m.utilization_percent) / AVG(m.power_watts),
+			       COUNT(*)
+			FROM gpu_metrics m
+			LEFT JOIN gpu_nodes n ON n.node_id = m.node_id
+			WHERE m.collected_at >= $1 AND m.collected_at < $2
+			  AND m.power_watts > 0
+			GROUP BY %s
+			ORDER BY 5 ASC
+		`, level.nodeCol, level.groupBy), start, end)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+
+		for rows.Next() {
+			var e EfficiencyEntry
+			if err := rows.Scan(&e.NodeID, &e.Datacenter, &e.AvgUtilizationPercent,
+				&e.AvgPowerWatts, &e.UtilizationPerWatt, &e.Samples); err != nil {
+				rows.Close()
+				writeInternalError(w, r, err)
+				return
+			}
+			*level.dst = append(*level.dst, e)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// MissingGPU is a GPU a node was registered with that has never reported
+type MissingGPU struct {
+	NodeID       string    `json:"node_id"`
+	Hostname     string    `json:"hostname"`
+	Datacenter   string    `json:"datacenter"`
+	GPUIndex     int       `json:"gpu_index"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+// getMissingGPUs lists GPU indexes below each node's expected_gpu_count
+// with no rows in gpu_metrics, for catching cabling or driver problems on
+// new hardware. Nodes without an expected count are skipped.
+func (s *APIServer) getMissingGPUs(w http.ResponseWriter, r *http.Request) {
+	offset, err := parseOffset(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT n.node_id, COALESCE(n.hostname, ''), COALESCE(n.datacenter, ''),
+		       g.gpu_index, n.created_at
+		FROM gpu_nodes n
+		CROSS JOIN LATERAL generate_series(0, n.expected_gpu_count - 1) AS g(gpu_index)
+		WHERE n.expected_gpu_count > 0
+		  AND NOT EXISTS (
+		      SELECT 1 FROM gpu_metrics m
+		      WHERE m.node_id = n.node_id AND m.gpu_index = g.gpu_index
+		  )
+		ORDER BY n.node_id, g.gpu_index
+		OFFSET $1
+	`, offset)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	missing := []MissingGPU{}
+	truncated := false
+	for rows.Next() {
+		var gpu MissingGPU
+		if err := rows.Scan(&gpu.NodeID, &gpu.Hostname, &gpu.Datacenter,
+			&gpu.GPUIndex, &gpu.RegisteredAt); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		if s.rowCapReached(len(missing)) {
+			truncated = true
+			break
+		}
+		missing = append(missing, gpu)
+	}
+	if err := rows.Err(); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeList(w, r, missing, truncated, strconv.Itoa(offset+len(missing)))
+}
+
+// rowCapReached reports whether a response already holds MaxResponseRows
+// rows; handlers check it before appending each row, so a true result
+// means at least one row is being left out
+func (s *APIServer) rowCapReached(n int) bool {
+	return s.cfg.MaxResponseRows > 0 && n >= s.cfg.MaxResponseRows
+}
+
+// ListPage is the body of a capped list endpoint requested with
+// ?envelope=true. Next is the cursor that resumes a truncated list, empty
+// when the endpoint can't resume and the filter has to be narrowed instead.
+type ListPage struct {
+	Items     interface{} `json:"items"`
+	Truncated bool        `json:"truncated"`
+	Next      string      `json:"next,omitempty"`
+}
+
+// writeList writes a capped list response. A truncated one carries
+// X-Truncated and X-Next-Cursor; with ?envelope=true the body is a
+// ListPage with the same fields, otherwise the bare array.
+func writeList(w http.ResponseWriter, r *http.Request, items interface{}, truncated bool, next string) {
+	if truncated {
+		w.Header().Set("X-Truncated", "true")
+		if next != "" {
+			w.Header().Set("X-Next-Cursor", next)
+		}
+	} else {
+		next = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("envelope") == "true" {
+		json.NewEncoder(w).Encode(ListPage{Items: items, Truncated: truncated, Next: next})
+		return
+	}
+	json.NewEncoder(w).Encode(items)
+}
+
+// parseOffset reads the ?offset= a capped list resumes from
+func parseOffset(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("offset")
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid offset %q", v)
+	}
+	return n, nil
+}
+
+// ExportTruncation is the last line of an NDJSON export cut short by
+// MAX_RESPONSE_ROWS or MAX_RESPONSE_BYTES; Next is the ?start= that resumes it
+type ExportTruncation struct {
+	Truncated bool   `json:"truncated"`
+	Next      string `json:"next"`
+}
+
+// requireAdmin guards admin endpoints with the configured bearer token.
+// Without ADMIN_TOKEN set, admin endpoints are disabled.
+func (s *APIServer) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.AdminToken == "" {
+			writeError(w, r, http.StatusForbidden, "Admin endpoints are disabled")
+			return
+		}
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(s.cfg.AdminToken)) != 1 {
+			writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// limitExpensive wraps an aggregate/percentile/export handler so at most
+// EXPENSIVE_QUERY_LIMIT run at once. Requests beyond that get 503 straight
+// away rather than queueing for a DB connection.
+func (s *APIServer) limitExpensive(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		release, ok := s.acquireExpensive()
+		if !ok {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, r, http.StatusServiceUnavailable, "Too many expensive queries in flight; retry shortly")
+			return
+		}
+		defer release()
+		next(w, r)
+	}
+}
+
+// acquireExpensive takes an EXPENSIVE_QUERY_LIMIT slot without waiting,
+// returning false when none is free; release gives it back
+func (s *APIServer) acquireExpensive() (release func(), ok bool) {
+	if s.expensive == nil {
+		return func() {}, true
+	}
+	select {
+	case s.expensive <- struct{}{}:
+		return func() { <-s.expensive }, true
+	default:
+		return nil, false
+	}
+}
+
+// purgeAlerts deletes resolved alerts resolved before ?before= (RFC3339).
+// Their alert_actions are removed by the ON DELETE CASCADE foreign key.
+func (s *APIServer) purgeAlerts(w http.ResponseWriter, r *http.Request) {
+	if status := r.URL.Query().Get("status"); status != "resolved" {
+		writeError(w, r, http.StatusBadRequest, "status=resolved is required")
+		return
+	}
+	v := r.URL.Query().Get("before")
+	if v == "" {
+		writeError(w, r, http.StatusBadRequest, "before is required")
+		return
+	}
+	before, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid before time: %v", err))
+		return
+	}
+
+	result, err := s.db.Exec(`
+		DELETE FROM alerts
+		WHERE status = 'resolved'
+		  AND COALESCE(resolved_at, triggered_at) < $1
+	`, before)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	deleted, _ := result.RowsAffected()
+
+	log.Printf("Purged %d resolved alerts older than %s", deleted, before.Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deleted": deleted,
+		"before":  before,
+	})
+}
+
+// purgeActions deletes finished alert_actions executed before ?before=
+// (RFC3339), keeping their alerts. Pending actions are never deleted.
+func (s *APIServer) purgeActions(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query().Get("before")
+	if v == "" {
+		writeError(w, r, http.StatusBadRequest, "before is required")
+		return
+	}
+	before, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid before time: %v", err))
+		return
+	}
+
+	result, err := s.db.Exec(`
+		DELETE FROM alert_actions
+		WHERE action_status <> 'pending' AND executed_at < $1
+	`, before)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	deleted, _ := result.RowsAffected()
+
+	log.Printf("Purged %d alert actions older than %s", deleted, before.Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deleted": deleted,
+		"before":  before,
+	})
+}
+
+// resolveAlert manually resolves an alert. Resolving one that is already
+// resolved is a 409 so clients can tell it apart from a missing alert.
+func (s *APIServer) resolveAlert(w http.ResponseWriter, r *http.Request) {
+	alertID, err := strconv.Atoi(mux.Vars(r)["alert_id"])
+	if err != nil || alertID <= 0 {
+		writeError(w, r, http.StatusBadRequest, "alert_id must be a positive integer")
+		return
+	}
+
+	failure, err := resolveAlertID(r.Context(), s.db, alertID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if failure != nil {
+		writeError(w, r, failure.Status, failure.Error)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Alert resolved",
+		"alert_id": alertID,
+	})
+}
+
+// maxBulkResolve caps how many alerts one bulk resolve may name
+const maxBulkResolve = 1000
+
+// Bulk resolve modes
+const (
+	resolveModeAtomic     = "atomic"
+	resolveModeBestEffort = "best_effort"
+)
+
+// BulkResolveRequest is the body of POST /api/v1/alerts/resolve
+type BulkResolveRequest struct {
+	AlertIDs []int `json:"alert_ids"`
+}
+
+// BulkResolveFailure is one alert a bulk resolve could not resolve, with
+// the status resolving it alone would have returned
+type BulkResolveFailure struct {
+	AlertID int    `json:"alert_id"`
+	Status  int    `json:"status"`
+	Error   string `json:"error"`
+}
+
+type BulkResolveResponse struct {
+	Mode     string               `json:"mode"`
+	Resolved []int                `json:"resolved"`
+	Failed   []BulkResolveFailure `json:"failed"`
+}
+
+// rowQuerier is satisfied by both *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// resolveAlertID manually resolves one alert, returning a failure instead
+// when it is missing or already resolved. Both resolve endpoints use it.
+func resolveAlertID(ctx context.Context, q rowQuerier, alertID int) (*BulkResolveFailure, error) {
+	var id int
+	err := q.QueryRowContext(ctx, `
+		UPDATE alerts
+		SET status = 'resolved', resolved_at = NOW(), resolution = 'manual'
+		WHERE id = $1 AND status <> 'resolved'
+		RETURNING id
+	`, alertID).Scan(&id)
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	var exists bool
+	if err := q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM alerts WHERE id = $1)`, alertID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists {
+		return &BulkResolveFailure{AlertID: alertID, Status: http.StatusConflict, Error: "Alert is already resolved"}, nil
+	}
+	return &BulkResolveFailure{AlertID: alertID, Status: http.StatusNotFound, Error: "Alert not found"}, nil
+}
+
+// resolveAlerts manually resolves a list of alerts. With ?mode=atomic (the
+// default) they are resolved in one transaction, and any missing or already
+// resolved alert rolls back the rest with a 409 listing the failures. With
+// ?mode=best_effort each is resolved on its own and the response is 207
+// Multi-Status whenever some failed, listing both resolved and failed IDs.
+func (s *APIServer) resolveAlerts(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = resolveModeAtomic
+	}
+	if mode != resolveModeAtomic && mode != resolveModeBestEffort {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("mode must be %s or %s", resolveModeAtomic, resolveModeBestEffort))
+		return
+	}
+
+	var req BulkResolveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.AlertIDs) == 0 || len(req.AlertIDs) > maxBulkResolve {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("alert_ids must list 1-%d alerts", maxBulkResolve))
+		return
+	}
+	seen := make(map[int]bool, len(req.AlertIDs))
+	ids := make([]int, 0, len(req.AlertIDs))
+	for _, id := range req.AlertIDs {
+		if id <= 0 {
+			writeError(w, r, http.StatusBadRequest, "alert_ids must be positive integers")
+			return
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	resp := BulkResolveResponse{Mode: mode, Resolved: []int{}, Failed: []BulkResolveFailure{}}
+	if mode == resolveModeAtomic {
+		tx, err := s.db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		for _, id := range ids {
+			failure, err := resolveAlertID(r.Context(), tx, id)
+			if err != nil {
+				writeInternalError(w, r, err)
+				return
+			}
+			if failure != nil {
+				resp.Failed = append(resp.Failed, *failure)
+			} else {
+				resp.Resolved = append(resp.Resolved, id)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if len(resp.Failed) > 0 {
+			// Nothing was resolved; the IDs that would have been are
+			// still listed so the caller can retry without the failures
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	for _, id := range ids {
+		failure, err := resolveAlertID(r.Context(), s.db, id)
+		if err != nil {
+			log.Printf("request %s: %s %s: alert %d: %v", requestID(r), r.Method, r.URL.Path, id, err)
+			failure = &BulkResolveFailure{AlertID: id, Status: http.StatusInternalServerError,
+				Error: "Internal server error; reference request ID " + requestID(r)}
+		}
+		if failure != nil {
+			resp.Failed = append(resp.Failed, *failure)
+		} else {
+			resp.Resolved = append(resp.Resolved, id)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(resp.Failed) > 0 {
+		w.WriteHeader(http.StatusMultiStatus)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// notifyAlert queues a re-delivery of an existing alert's notifications; the
+// alert engine picks up the renotify action and sends to every subscribed
+// channel, recording each delivery in alert_actions
+func (s *APIServer) notifyAlert(w http.ResponseWriter, r *http.Request) {
+	alertID, err := strconv.Atoi(mux.Vars(r)["alert_id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid alert_id")
+		return
+	}
+
+	var status string
+	err = s.db.QueryRow(`SELECT status FROM alerts WHERE id = $1`, alertID).Scan(&status)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "Alert not found")
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if status == "suppressed" || status == "warmup" {
+		writeError(w, r, http.StatusConflict, "Alerts with status "+status+" are never notified")
+		return
+	}
+
+	details, _ := json.Marshal(AlertRefDetails{Action: "renotify", AlertID: alertID})
+	var actionID int
+	err = s.db.QueryRow(`
+		INSERT INTO alert_actions (alert_id, action_type, action_status, action_details, next_attempt_at)
+		VALUES ($1, 'renotify', 'pending', $2, NOW())
+		RETURNING id
+	`, alertID, details).Scan(&actionID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":   "Notification queued",
+		"alert_id":  alertID,
+		"action_id": actionID,
+	})
+}
+
+// TestAlertRequest is the body of POST /api/v1/alerts/test
+type TestAlertRequest struct {
+	NodeID       string `json:"node_id"`
+	Severity     string `json:"severity"`      // default critical, so every channel is exercised
+	ResolveAfter string `json:"resolve_after"` // default 5m
+}
+
+const (
+	defaultTestAlertResolve = 5 * time.Minute
+	maxTestAlertResolve     = time.Hour
+)
+
+// testAlert creates a synthetic test_alert on a node and queues it through
+// the alert engine's notification channels, plus a second action that
+// auto-resolves it after resolve_after.
+func (s *APIServer) testAlert(w http.ResponseWriter, r *http.Request) {
+	var req TestAlertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.NodeID == "" {
+		writeError(w, r, http.StatusBadRequest, "node_id is required")
+		return
+	}
+	if req.Severity == "" {
+		req.Severity = "critical"
+	}
+	if !validSeverities[req.Severity] {
+		writeError(w, r, http.StatusBadRequest, "severity must be info, warning or critical")
+		return
+	}
+	resolveAfter := defaultTestAlertResolve
+	if req.ResolveAfter != "" {
+		d, err := time.ParseDuration(req.ResolveAfter)
+		if err != nil || d <= 0 || d > maxTestAlertResolve {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("resolve_after must be a positive duration up to %s", maxTestAlertResolve))
+			return
+		}
+		resolveAfter = d
+	}
+
+	var exists bool
+	if err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM gpu_nodes WHERE node_id = $1)", req.NodeID).Scan(&exists); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !exists {
+		writeError(w, r, http.StatusNotFound, "Node not found")
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	message := fmt.Sprintf("[TEST] Synthetic alert on %s to verify notification delivery; no action needed (auto-resolves in %s)",
+		req.NodeID, resolveAfter)
+	var alertID int
+	err = tx.QueryRow(`
+		INSERT INTO alerts (node_id, gpu_index, alert_type, severity, message)
+		VALUES ($1, -1, 'test_alert', $2, $3)
+		RETURNING id
+	`, req.NodeID, req.Severity, message).Scan(&alertID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	details, _ := json.Marshal(AlertRefDetails{Action: "renotify", AlertID: alertID})
+	if _, err := tx.Exec(`
+		INSERT INTO alert_actions (alert_id, action_type, action_status, action_details, next_attempt_at)
+		VALUES ($1, 'renotify', 'pending', $2, NOW())
+	`, alertID, details); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	details, _ = json.Marshal(AlertRefDetails{Action: "resolve", AlertID: alertID})
+	if _, err := tx.Exec(`
+		INSERT INTO alert_actions (alert_id, action_type, action_status, action_details, next_attempt_at)
+		VALUES ($1, 'resolve', 'pending', $2, NOW() + $3 * INTERVAL '1 second')
+	`, alertID, details, resolveAfter.Seconds()); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":     "Test alert created",
+		"alert_id":    alertID,
+		"resolves_at": time.Now().Add(resolveAfter).UTC().Format(time.RFC3339),
+	})
+}
+
+// AlertKey identifies alerts by what they are about rather than by DB ID
+type AlertKey struct {
+	NodeID    string `json:"node_id"`
+	GPUIndex  *int   `json:"gpu_index"`
+	AlertType string `json:"alert_type"`
+	// DedupKey may be given instead of the other three
+	DedupKey string `json:"dedup_key"`
+}
+
+// resolveAlertsByKey resolves every active alert matching (node, gpu, type)
+// or its dedup_key
+func (s *APIServer) resolveAlertsByKey(w http.ResponseWriter, r *http.Request) {
+	var key AlertKey
+	if err := json.NewDecoder(r.Body).Decode(&key); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if key.DedupKey == "" && (key.NodeID == "" || key.GPUIndex == nil || key.AlertType == "") {
+		writeError(w, r, http.StatusBadRequest, "dedup_key, or node_id, gpu_index and alert_type, are required")
+		return
+	}
+
+	var rows *sql.Rows
+	var err error
+	if key.DedupKey != "" {
+		rows, err = s.db.Query(`
+			UPDATE alerts
+			SET status = 'resolved', resolved_at = NOW(), resolution = 'manual'
+			WHERE dedup_key = $1 AND status = 'active'
+			RETURNING id
+		`, key.DedupKey)
+	} else {
+		rows, err = s.db.Query(`
+			UPDATE alerts
+			SET status = 'resolved', resolved_at = NOW(), resolution = 'manual'
+			WHERE node_id = $1 AND gpu_index = $2 AND alert_type = $3 AND status = 'active'
+			RETURNING id
+		`, key.NodeID, *key.GPUIndex, key.AlertType)
+	}
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	if len(ids) == 0 {
+		writeError(w, r, http.StatusNotFound, "No matching active alerts")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":   "Alerts resolved",
+		"alert_ids": ids,
+	})
+}
+
+type ActionResponse struct {
+	ID           int    `json:"id"`
+	AlertID      int    `json:"alert_id"`
+	NodeID       string `json:"node_id"`
+	GPUIndex     int    `json:"gpu_index"`
+	AlertType    string `json:"alert_type"`
+	Severity     string `json:"severity"`
+	ActionType   string `json:"action_type"`
+	ActionStatus string `json:"action_status"`
+	// ActionDetails is one of the *Details types for known action types,
+	// or the stored JSON as-is for others
+	ActionDetails interface{} `json:"action_details"`
+	ExecutedAt    time.Time   `json:"executed_at"`
+}
+
+// MigrationDetails are the action_details of a workload_migration action.
+// The action details types mirror the alert engine's, which writes them.
+type MigrationDetails struct {
+	Action   string `json:"action"`
+	FromNode string `json:"from_node"`
+	FromGPU  int    `json:"from_gpu"`
+	Reason   string `json:"reason"`
+	Drainer  string `json:"drainer"`
+}
+
+// NotificationDetails are the action_details of a notification action
+type NotificationDetails struct {
+	Action    string `json:"action"`
+	Channel   string `json:"channel"`
+	Message   string `json:"message"`
+	AlertType string `json:"alert_type"`
+	NodeID    string `json:"node_id"`
+	GPUIndex  int    `json:"gpu_index"`
+	DedupKey  string `json:"dedup_key"`
+	AlertID   int    `json:"alert_id,omitempty"`
+}
+
+// AlertRefDetails are the action_details of renotify and resolve actions,
+// which this server queues against an existing alert
+type AlertRefDetails struct {
+	Action  string `json:"action"`
+	AlertID int    `json:"alert_id"`
+}
+
+// decodeActionDetails decodes stored action_details into the type for
+// actionType. Unknown types, and details that don't decode, are returned
+// as raw JSON so nothing recorded is hidden.
+func decodeActionDetails(actionType string, data []byte) interface{} {
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+	var v interface{}
+	switch actionType {
+	case "workload_migration":
+		v = &MigrationDetails{}
+	case "notification":
+		v = &NotificationDetails{}
+	case "renotify", "resolve":
+		v = &AlertRefDetails{}
+	default:
+		return json.RawMessage(data)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return json.RawMessage(data)
+	}
+	return v
+}
+
+// parsePagination reads ?limit= (clamped to maxMetricsLimit) and ?offset=
+func parsePagination(r *http.Request) (int, int, error) {
+	limit, offset := defaultMetricsLimit, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("invalid limit %q", v)
+		}
+		limit = min(n, maxMetricsLimit)
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %q", v)
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+// getActions lists alert_actions fleet-wide, newest first, joined with the
+// alert they responded to. Filters: action_type, status, start, end.
+func (s *APIServer) getActions(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	where := []string{"TRUE"}
+	var args []interface{}
+	addFilter := func(clause string, value interface{}) {
+		args = append(args, value)
+		where = append(where, fmt.Sprintf(clause, len(args)))
+	}
+
+	q := r.URL.Query()
+	if v := q.Get("action_type"); v != "" {
+		addFilter("aa.action_type = $%d", v)
+	}
+	if v := q.Get("status"); v != "" {
+		addFilter("aa.action_status = $%d", v)
+	}
+	for _, p := range []struct{ name, clause string }{
+		{"start", "aa.executed_at >= $%d"},
+		{"end", "aa.executed_at < $%d"},
+	} {
+		if v := q.Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid %s time", p.name))
+				return
+			}
+			addFilter(p.clause, t)
+		}
+	}
+	args = append(args, limit, offset)
+
+	query := fmt.Sprintf(`
+		SELECT aa.id, aa.alert_id, a.node_id, COALESCE(a.gpu_index, -1), a.alert_type,
+		       a.severity, aa.action_type, aa.action_status,
+		       COALESCE(aa.action_details, 'null'::jsonb), aa.executed_at
+		FROM alert_actions aa
+		JOIN alerts a ON a.id = aa.alert_id
+		WHERE %s
+		ORDER BY aa.executed_at DESC, aa.id DESC
+		LIMIT $%d OFFSET $%d
+	`, strings.Join(where, " AND "), len(args)-1, len(args))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	actions := []ActionResponse{}
+	for rows.Next() {
+		var a ActionResponse
+		var details []byte
+		if err := rows.Scan(&a.ID, &a.AlertID, &a.NodeID, &a.GPUIndex, &a.AlertType,
+			&a.Severity, &a.ActionType, &a.ActionStatus, &details, &a.ExecutedAt); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		a.ActionDetails = decodeActionDetails(a.ActionType, details)
+		actions = append(actions, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(actions)
+}
+
+// RuleThreshold is one severity band of an alert rule, flattened so each
+// entry reads "fire <severity> when <metric> <operator> <threshold>"
+type RuleThreshold struct {
+	RuleID    int     `json:"rule_id"`
+	AlertType string  `json:"alert_type"`
+	Metric    string  `json:"metric"`
+	Operator  string  `json:"operator"`
+	Threshold float64 `json:"threshold"`
+	Severity  string  `json:"severity"`
+	// Scope is the set of GPUs the rule applies to: "fleet"; "model" for a
+	// rule scoped to GPUModel that replaces the fleet rules of its alert
+	// type on GPUs of that model; or "gpu" for a rule scoped to
+	// NodeID/GPUIndex that replaces both on that GPU
+	Scope    string  `json:"scope"`
+	NodeID   *string `json:"node_id,omitempty"`
+	GPUIndex *int    `json:"gpu_index,omitempty"`
+	GPUModel *string `json:"gpu_model,omitempty"`
+	// AutoResolveAfterSeconds is how old the rule's active alerts get before
+	// the engine resolves them; omitted when they never time out
+	AutoResolveAfterSeconds *float64 `json:"auto_resolve_after_seconds,omitempty"`
+	RunbookURL              string   `json:"runbook_url,omitempty"`
+	// NotifySchedule is the rule's notify_schedule as stored; omitted when
+	// its alerts notify at any hour
+	NotifySchedule json.RawMessage `json:"notify_schedule,omitempty"`
+}
+
+// getRules lists the enabled alert rules, one entry per severity band
+func (s *APIServer) getRules(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.Query(`
+		SELECT id, alert_type, metric, operator, severity_bands,
+		       EXTRACT(EPOCH FROM auto_resolve_after)::float8, node_id, gpu_index,
+		       COALESCE(runbook_url, ''), notify_schedule, gpu_model
+		FROM alert_rules
+		WHERE enabled
+		ORDER BY id
+	`)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	rules := []RuleThreshold{}
+	for rows.Next() {
+		var rule RuleThreshold
+		var rawBands, schedule []byte
+		if err := rows.Scan(&rule.RuleID, &rule.AlertType, &rule.Metric, &rule.Operator, &rawBands,
+			&rule.AutoResolveAfterSeconds, &rule.NodeID, &rule.GPUIndex, &rule.RunbookURL, &schedule, &rule.GPUModel); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		rule.NotifySchedule = schedule
+
+		var bands []struct {
+			Threshold float64 `json:"threshold"`
+			Severity  string  `json:"severity"`
+		}
+		if err := json.Unmarshal(rawBands, &bands); err != nil {
+			writeInternalError(w, r, fmt.Errorf("rule %d: invalid severity_bands: %w", rule.RuleID, err))
+			return
+		}
+		rule.Scope = "fleet"
+		if rule.NodeID != nil {
+			rule.Scope = "gpu"
+		} else if rule.GPUModel != nil {
+			rule.Scope = "model"
+		}
+		for _, band := range bands {
+			rule.Threshold, rule.Severity = band.Threshold, band.Severity
+			rules = append(rules, rule)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// ruleMetricNames are the metrics the alert engine can evaluate rules on
+var ruleMetricNames = map[string]bool{
+	"temperature_celsius": true,
+	"power_watts":         true,
+	"memory_percent":      true,
+	"memory_used_mb":      true,
+	"memory_free_mb":      true,
+	"utilization_percent": true,
+	"sm_clock_mhz":        true,
+	"fan_speed_percent":   true,
+	"row_remap_pending":   true,
+}
+
+// validSeverities are the severities a rule band may fire at
+var validSeverities = map[string]bool{"info": true, "warning": true, "critical": true}
+
+// RuleRequest is the body of POST /api/v1/rules, a rule scoped to one GPU
+type RuleRequest struct {
+	AlertType     string `json:"alert_type"`
+	Metric        string `json:"metric"`
+	Operator      string `json:"operator"`
+	SeverityBands []struct {
+		Threshold float64 `json:"threshold"`
+		Severity  string  `json:"severity"`
+	} `json:"severity_bands"`
+	NodeID   string `json:"node_id"`
+	GPUIndex *int   `json:"gpu_index"`
+	// AutoResolveAfter is an optional Go duration such as "6h"
+	AutoResolveAfter string `json:"auto_resolve_after"`
+	// RunbookURL is an optional http(s) link carried by the rule's alerts
+	RunbookURL string `json:"runbook_url"`
+	// NotifySchedule optionally limits the hours its alerts notify in
+	NotifySchedule *NotifySchedule `json:"notify_schedule"`
+}
+
+// NotifySchedule is a rule's notify_schedule as the alert engine reads it:
+// the local business hours in which its non-critical alerts notify, and
+// whether notifications outside them are deferred or suppressed
+type NotifySchedule struct {
+	Days     []string `json:"days,omitempty"`     // mon..sun; empty means every day
+	Start    string   `json:"start"`              // HH:MM, inclusive
+	End      string   `json:"end"`                // HH:MM, exclusive
+	Timezone string   `json:"timezone,omitempty"` // IANA name; empty means UTC
+	Outside  string   `json:"outside,omitempty"`  // defer or suppress
+}
+
+// scheduleDays are the notify_schedule day names
+var scheduleDays = map[string]bool{
+	"sun": true, "mon": true, "tue": true, "wed": true, "thu": true, "fri": true, "sat": true,
+}
+
+// parseClock parses an HH:MM time of day into minutes after midnight
+func parseClock(v string) (int, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", v)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Validate checks the schedule as the alert engine does, which skips rules
+// whose schedule fails it
+func (s NotifySchedule) Validate() error {
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("notify_schedule: unknown timezone %q", s.Timezone)
+	}
+	start, err := parseClock(s.Start)
+	if err != nil {
+		return fmt.Errorf("notify_schedule: start: %w", err)
+	}
+	end, err := parseClock(s.End)
+	if err != nil {
+		return fmt.Errorf("notify_schedule: end: %w", err)
+	}
+	if start >= end {
+		return fmt.Errorf("notify_schedule: start %s must be before end %s", s.Start, s.End)
+	}
+	for _, d := range s.Days {
+		if !scheduleDays[strings.ToLower(d)] {
+			return fmt.Errorf("notify_schedule: unknown day %q", d)
+		}
+	}
+	if s.Outside != "" && s.Outside != "defer" && s.Outside != "suppress" {
+		return fmt.Errorf("notify_schedule: outside must be defer or suppress, got %q", s.Outside)
+	}
+	return nil
+}
+
+// decodeRuleRequest reads and validates a GPU-scoped rule
+func decodeRuleRequest(r *http.Request) (RuleRequest, error) {
+	var req RuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, fmt.Errorf("invalid JSON body")
+	}
+	if req.AlertType == "" {
+		return req, fmt.Errorf("alert_type is required")
+	}
+	if !ruleMetricNames[req.Metric] {
+		return req, fmt.Errorf("unknown metric %q", req.Metric)
+	}
+	if req.Operator == "" {
+		req.Operator = ">"
+	}
+	if req.Operator != ">" && req.Operator != "<" {
+		return req, fmt.Errorf("operator must be > or <")
+	}
+	if len(req.SeverityBands) == 0 {
+		return req, fmt.Errorf("severity_bands must list at least one band")
+	}
+	for _, band := range req.SeverityBands {
+		if !validSeverities[band.Severity] {
+			return req, fmt.Errorf("invalid severity %q", band.Severity)
+		}
+	}
+	if req.NodeID == "" || req.GPUIndex == nil {
+		return req, fmt.Errorf("node_id and gpu_index are required")
+	}
+	if *req.GPUIndex < 0 {
+		return req, fmt.Errorf("gpu_index must not be negative")
+	}
+	if req.AutoResolveAfter != "" {
+		d, err := time.ParseDuration(req.AutoResolveAfter)
+		if err != nil || d <= 0 {
+			return req, fmt.Errorf("auto_resolve_after must be a positive duration")
+		}
+	}
+	if req.RunbookURL != "" {
+		u, err := url.Parse(req.RunbookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return req, fmt.Errorf("runbook_url must be an http or https URL")
+		}
+	}
+	if req.NotifySchedule != nil {
+		if err := req.NotifySchedule.Validate(); err != nil {
+			return req, err
+		}
+	}
+	return req, nil
+}
+
+// createRule adds an alert rule scoped to one GPU. The engine applies it
+// in place of the fleet rules of the same alert type on its next rule
+// reload (RULES_RELOAD_INTERVAL).
+func (s *APIServer) createRule(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeRuleRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var exists bool
+	if err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM gpu_nodes WHERE node_id = $1)", req.NodeID).Scan(&exists); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !exists {
+		writeError(w, r, http.StatusNotFound, "Node not found")
+		return
+	}
+
+	bands, _ := json.Marshal(req.SeverityBands)
+	var autoResolve *string
+	if req.AutoResolveAfter != "" {
+		autoResolve = &req.AutoResolveAfter
+	}
+	var schedule *string
+	if req.NotifySchedule != nil {
+		raw, _ := json.Marshal(req.NotifySchedule)
+		encoded := string(raw)
+		schedule = &encoded
+	}
+
+	var ruleID int
+	err = s.db.QueryRow(`
+		INSERT INTO alert_rules (alert_type, metric, operator, severity_bands, node_id, gpu_index, auto_resolve_after, runbook_url, notify_schedule)
+		VALUES ($1, $2, $3, $4, $5, $6, $7::interval, NULLIF($8, ''), $9)
+		RETURNING id
+	`, req.AlertType, req.Metric, req.Operator, bands, req.NodeID, *req.GPUIndex, autoResolve, req.RunbookURL, schedule).Scan(&ruleID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Rule created",
+		"rule_id": ruleID,
+	})
+}
+
+// deleteRule removes a GPU- or model-scoped rule; fleet-wide rules are
+// managed in the database and cannot be deleted here
+func (s *APIServer) deleteRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["rule_id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid rule ID")
+		return
+	}
+
+	res, err := s.db.Exec("DELETE FROM alert_rules WHERE id = $1 AND (node_id IS NOT NULL OR gpu_model IS NOT NULL)", id)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, r, http.StatusNotFound, "GPU- or model-scoped rule not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Rule deleted",
+		"rule_id": id,
+	})
+}
+
+// ThresholdSuggestion is a percentile-based threshold the alert engine
+// computed for one GPU model from a fleet rule. SeverityBands are the bands
+// the model's rule gets when the suggestion is approved: the rule in force
+// for the model with its least severe threshold replaced.
+type ThresholdSuggestion struct {
+	ID                 int             `json:"id"`
+	RuleID             int             `json:"rule_id"`
+	AlertType          string          `json:"alert_type"`
+	Metric             string          `json:"metric"`
+	Operator           string          `json:"operator"`
+	GPUModel           string          `json:"gpu_model"`
+	Percentile         float64         `json:"percentile"`
+	Samples            int             `json:"samples"`
+	CurrentThreshold   float64         `json:"current_threshold"`
+	SuggestedThreshold float64         `json:"suggested_threshold"`
+	SeverityBands      json.RawMessage `json:"severity_bands"`
+	Status             string          `json:"status"`
+	AppliedRuleID      *int            `json:"applied_rule_id,omitempty"`
+	ComputedAt         time.Time       `json:"computed_at"`
+	DecidedAt          *time.Time      `json:"decided_at,omitempty"`
+	DecidedBy          *string         `json:"decided_by,omitempty"`
+}
+
+// validSuggestionStatuses are the ?status= values of the suggestions list
+var validSuggestionStatuses = map[string]bool{"pending": true, "applied": true, "rejected": true}
+
+// listThresholdSuggestions lists threshold suggestions with ?status=
+// (default pending), newest first
+func (s *APIServer) listThresholdSuggestions(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "pending"
+	}
+	if !validSuggestionStatuses[status] {
+		writeError(w, r, http.StatusBadRequest, "status must be pending, applied or rejected")
+		return
+	}
+
+	rows, err := s.db.QueryContext(r.Context(), `
+		SELECT t.id, t.rule_id, r.alert_type, r.metric, r.operator, t.gpu_model, t.percentile,
+		       t.samples, t.current_threshold, t.suggested_threshold, t.severity_bands,
+		       t.status, t.applied_rule_id, t.computed_at, t.decided_at, t.decided_by
+		FROM threshold_suggestions t
+		JOIN alert_rules r ON r.id = t.rule_id
+		WHERE t.status = $1
+		ORDER BY t.computed_at DESC, t.id DESC
+	`, status)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	suggestions := []ThresholdSuggestion{}
+	for rows.Next() {
+		var t ThresholdSuggestion
+		var bands []byte
+		if err := rows.Scan(&t.ID, &t.RuleID, &t.AlertType, &t.Metric, &t.Operator, &t.GPUModel, &t.Percentile,
+			&t.Samples, &t.CurrentThreshold, &t.SuggestedThreshold, &bands,
+			&t.Status, &t.AppliedRuleID, &t.ComputedAt, &t.DecidedAt, &t.DecidedBy); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		t.SeverityBands = bands
+		suggestions = append(suggestions, t)
+	}
+	if err := rows.Err(); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suggestions)
 }
 
-type MetricResponse struct {
-	NodeID             string    `json:"node_id"`
-	GPUIndex           int       `json:"gpu_index"`
-	TemperatureCelsius float64   `json:"temperature_celsius"`
-	PowerWatts         float64   `json:"power_watts"`
-	MemoryUsedMB       float64   `json:"memory_used_mb"`
-	MemoryTotalMB      float64   `json:"memory_total_mb"`
-	UtilizationPercent float64   `json:"utilization_percent"`
-	CollectedAt        time.Time `json:"collected_at"`
+// SuggestionDecision is the body of the approve and reject endpoints
+type SuggestionDecision struct {
+	DecidedBy string `json:"decided_by"`
 }
 
-type AlertResponse struct {
-	ID             int       `json:"id"`
-	NodeID         string    `json:"node_id"`
-	GPUIndex       int       `json:"gpu_index"`
-	AlertType      string    `json:"alert_type"`
-	Severity       string    `json:"severity"`
-	Message        string    `json:"message"`
-	ThresholdValue float64   `json:"threshold_value"`
-	ActualValue    float64   `json:"actual_value"`
-	Status         string    `json:"status"`
-	TriggeredAt    time.Time `json:"triggered_at"`
+// decideThresholdSuggestion reads the suggestion ID and decision body,
+// writing the error response itself when either is invalid
+func decideThresholdSuggestion(w http.ResponseWriter, r *http.Request) (int, string, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["suggestion_id"])
+	if err != nil || id <= 0 {
+		writeError(w, r, http.StatusBadRequest, "Invalid suggestion ID")
+		return 0, "", false
+	}
+	var req SuggestionDecision
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return 0, "", false
+	}
+	req.DecidedBy = strings.TrimSpace(req.DecidedBy)
+	if req.DecidedBy == "" || len(req.DecidedBy) > maxAckByLength {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("decided_by is required, up to %d characters", maxAckByLength))
+		return 0, "", false
+	}
+	return id, req.DecidedBy, true
 }
 
-func NewAPIServer(dbConnStr string) (*APIServer, error) {
-	db, err := sql.Open("postgres", dbConnStr)
+// suggestionNotPending writes a 404 or 409 for a suggestion that could not
+// be decided because it is missing or no longer pending
+func (s *APIServer) suggestionNotPending(w http.ResponseWriter, r *http.Request, q rowQuerier, id int) {
+	var status string
+	err := q.QueryRowContext(r.Context(), "SELECT status FROM threshold_suggestions WHERE id = $1", id).Scan(&status)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "Suggestion not found")
+		return
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
+		writeInternalError(w, r, err)
+		return
 	}
+	writeError(w, r, http.StatusConflict, "Suggestion is already "+status)
+}
 
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+// approveThresholdSuggestion applies a pending suggestion as its GPU model's
+// rule: the model-scoped rule of the alert type is updated (and enabled),
+// or created from the fleet rule. The engine picks it up on its next rule
+// reload (RULES_RELOAD_INTERVAL).
+func (s *APIServer) approveThresholdSuggestion(w http.ResponseWriter, r *http.Request) {
+	id, decidedBy, ok := decideThresholdSuggestion(w, r)
+	if !ok {
+		return
 	}
 
-	server := &APIServer{
-		db:     db,
-		router: mux.NewRouter(),
+	// apply_threshold_suggestion (init.sql) is shared with the engine's
+	// THRESHOLD_AUTO_APPLY; NULL means the suggestion isn't pending
+	var appliedID sql.NullInt64
+	err := s.db.QueryRowContext(r.Context(),
+		"SELECT apply_threshold_suggestion($1, $2)", id, decidedBy,
+	).Scan(&appliedID)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if !appliedID.Valid {
+		s.suggestionNotPending(w, r, s.db, id)
+		return
 	}
 
-	server.setupRoutes()
-	return server, nil
+	log.Printf("%s applied threshold suggestion %d to rule %d", decidedBy, id, appliedID.Int64)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":       "Suggestion applied",
+		"suggestion_id": id,
+		"rule_id":       appliedID.Int64,
+	})
 }
 
-func (s *APIServer) setupRoutes() {
-	// Health check
-	s.router.HandleFunc("/health", s.healthCheck).Methods("GET")
-
-	// Node endpoints
-	s.router.HandleFunc("/api/v1/nodes", s.getAllNodes).Methods("GET")
-	s.router.HandleFunc("/api/v1/nodes/{node_id}", s.getNodeHealth).Methods("GET")
-	s.router.HandleFunc("/api/v1/nodes/{node_id}/metrics", s.getNodeMetrics).Methods("GET")
-
-	// Alert endpoints
-	s.router.HandleFunc("/api/v1/alerts", s.getAlerts).Methods("GET")
-	s.router.HandleFunc("/api/v1/alerts/active", s.getActiveAlerts).Methods("GET")
-	s.router.HandleFunc("/api/v1/alerts/{alert_id}/resolve", s.resolveAlert).Methods("POST")
+// rejectThresholdSuggestion marks a pending suggestion rejected. The engine
+// suggests again for the model at its next run if the distribution still
+// differs from the threshold.
+func (s *APIServer) rejectThresholdSuggestion(w http.ResponseWriter, r *http.Request) {
+	id, decidedBy, ok := decideThresholdSuggestion(w, r)
+	if !ok {
+		return
+	}
 
-	// Metrics endpoints
-	s.router.HandleFunc("/api/v1/metrics/latest", s.getLatestMetrics).Methods("GET")
-}
+	res, err := s.db.ExecContext(r.Context(), `
+		UPDATE threshold_suggestions
+		SET status = 'rejected', decided_at = NOW(), decided_by = $2
+		WHERE id = $1 AND status = 'pending'
+	`, id, decidedBy)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s.suggestionNotPending(w, r, s.db, id)
+		return
+	}
 
-func (s *APIServer) healthCheck(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": "healthy",
-		"time":   time.Now().Format(time.RFC3339),
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":       "Suggestion rejected",
+		"suggestion_id": id,
 	})
 }
 
-func (s *APIServer) getAllNodes(w http.ResponseWriter, r *http.Request) {
-	query := `
-		SELECT n.node_id, n.hostname, n.status, n.datacenter, n.last_seen,
-		       COALESCE(COUNT(a.id), 0) as active_alerts
-		FROM gpu_nodes n
-		LEFT JOIN alerts a ON n.node_id = a.node_id AND a.status = 'active'
-		GROUP BY n.node_id, n.hostname, n.status, n.datacenter, n.last_seen
-		ORDER BY n.node_id
-	`
+// SuppressionRule silences alerts matching alert_type (and optionally
+// node_id and datacenter). alert_type and node_id accept * wildcards.
+type SuppressionRule struct {
+	ID         int       `json:"id"`
+	AlertType  string    `json:"alert_type"`
+	NodeID     *string   `json:"node_id"`
+	Datacenter *string   `json:"datacenter"`
+	Reason     string    `json:"reason"`
+	CreatedBy  string    `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
 
-	rows, err := s.db.Query(query)
+// decodeSuppressionRule reads and validates a suppression rule body
+func decodeSuppressionRule(r *http.Request) (SuppressionRule, error) {
+	var rule SuppressionRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		return rule, fmt.Errorf("invalid JSON body")
+	}
+	if rule.AlertType == "" {
+		return rule, fmt.Errorf("alert_type is required (use * to match all types)")
+	}
+	if rule.NodeID == nil && rule.Datacenter == nil && rule.AlertType == "*" {
+		return rule, fmt.Errorf("refusing to suppress every alert fleet-wide; scope by node_id or datacenter")
+	}
+	return rule, nil
+}
+
+func (s *APIServer) listSuppressions(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.Query(`
+		SELECT id, alert_type, node_id, datacenter, COALESCE(reason, ''),
+		       COALESCE(created_by, ''), created_at
+		FROM suppression_rules
+		ORDER BY id
+	`)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
 	defer rows.Close()
 
-	var nodes []NodeHealth
+	rules := []SuppressionRule{}
 	for rows.Next() {
-		var node NodeHealth
-		if err := rows.Scan(&node.NodeID, &node.Hostname, &node.Status,
-			&node.Datacenter, &node.LastSeen, &node.ActiveAlerts); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		var rule SuppressionRule
+		if err := rows.Scan(&rule.ID, &rule.AlertType, &rule.NodeID, &rule.Datacenter,
+			&rule.Reason, &rule.CreatedBy, &rule.CreatedAt); err != nil {
+			writeInternalError(w, r, err)
 			return
 		}
-		nodes = append(nodes, node)
+		rules = append(rules, rule)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(nodes)
+	json.NewEncoder(w).Encode(rules)
 }
 
-func (s *APIServer) getNodeHealth(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	nodeID := vars["node_id"]
+func (s *APIServer) createSuppression(w http.ResponseWriter, r *http.Request) {
+	rule, err := decodeSuppressionRule(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	query := `
-		SELECT n.node_id, n.hostname, n.status, n.datacenter, n.last_seen,
-		       COALESCE(COUNT(a.id), 0) as active_alerts
-		FROM gpu_nodes n
-		LEFT JOIN alerts a ON n.node_id = a.node_id AND a.status = 'active'
-		WHERE n.node_id = $1
-		GROUP BY n.node_id, n.hostname, n.status, n.datacenter, n.last_seen
-	`
+	err = s.db.QueryRow(`
+		INSERT INTO suppression_rules (alert_type, node_id, datacenter, reason, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, rule.AlertType, rule.NodeID, rule.Datacenter, rule.Reason, rule.CreatedBy,
+	).Scan(&rule.ID, &rule.CreatedAt)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
 
-	var node NodeHealth
-	err := s.db.QueryRow(query, nodeID).Scan(
-		&node.NodeID, &node.Hostname, &node.Status,
-		&node.Datacenter, &node.LastSeen, &node.ActiveAlerts,
-	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
 
+func (s *APIServer) updateSuppression(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["suppression_id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid suppression ID")
+		return
+	}
+
+	rule, err := decodeSuppressionRule(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = s.db.QueryRow(`
+		UPDATE suppression_rules
+		SET alert_type = $2, node_id = $3, datacenter = $4, reason = $5
+		WHERE id = $1
+		RETURNING id, COALESCE(created_by, ''), created_at
+	`, id, rule.AlertType, rule.NodeID, rule.Datacenter, rule.Reason,
+	).Scan(&rule.ID, &rule.CreatedBy, &rule.CreatedAt)
 	if err == sql.ErrNoRows {
-		http.Error(w, "Node not found", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, "Suppression rule not found")
 		return
 	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(node)
+	json.NewEncoder(w).Encode(rule)
 }
 
-func (s *APIServer) getNodeMetrics(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	nodeID := vars["node_id"]
-
-	// Get query parameters for time range
-	limit := r.URL.Query().Get("limit")
-	if limit == "" {
-		limit = "100"
+func (s *APIServer) deleteSuppression(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["suppression_id"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid suppression ID")
+		return
 	}
 
-	query := `
-		SELECT node_id, gpu_index, temperature_celsius, power_watts,
-		       memory_used_mb, memory_total_mb, utilization_percent, collected_at
-		FROM gpu_metrics
-		WHERE node_id = $1
-		ORDER BY collected_at DESC
-		LIMIT ` + limit
-
-	rows, err := s.db.Query(query, nodeID)
+	res, err := s.db.Exec("DELETE FROM suppression_rules WHERE id = $1", id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
-	defer rows.Close()
-
-	var metrics []MetricResponse
-	for rows.Next() {
-		var m MetricResponse
-		if err := rows.Scan(&m.NodeID, &m.GPUIndex, &m.TemperatureCelsius,
-			&m.PowerWatts, &m.MemoryUsedMB, &m.MemoryTotalMB,
-			&m.UtilizationPercent, &m.CollectedAt); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		metrics = append(metrics, m)
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeError(w, r, http.StatusNotFound, "Suppression rule not found")
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(metrics)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "Suppression rule deleted",
+		"suppression_id": id,
+	})
 }
 
-func (s *APIServer) getAlerts(w http.ResponseWriter, r *http.Request) {
-	query := `
-		SELECT id, node_id, gpu_index, alert_type, severity, message,
-		       threshold_value, actual_value, status, triggered_at
-		FROM alerts
-		ORDER BY triggered_at DESC
-		LIMIT 100
-	`
+// maxNodesFilter caps the ?nodes= list of the latest metrics endpoint
+const maxNodesFilter = 500
 
-	rows, err := s.db.Query(query)
+// getLatestMetrics returns the newest sample per GPU, with its age and a
+// stale flag when older than ?stale_after= (default STALE_AFTER)
+func (s *APIServer) getLatestMetrics(w http.ResponseWriter, r *http.Request) {
+	unit, err := parseTemperatureUnit(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-	defer rows.Close()
 
-	var alerts []AlertResponse
-	for rows.Next() {
-		var a AlertResponse
-		if err := rows.Scan(&a.ID, &a.NodeID, &a.GPUIndex, &a.AlertType,
-			&a.Severity, &a.Message, &a.ThresholdValue, &a.ActualValue,
-			&a.Status, &a.TriggeredAt); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	staleAfter := s.cfg.StaleAfter
+	if v := r.URL.Query().Get("stale_after"); v != "" {
+		if staleAfter, err = time.ParseDuration(v); err != nil || staleAfter <= 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid stale_after duration")
 			return
 		}
-		alerts = append(alerts, a)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(alerts)
-}
-
-func (s *APIServer) getActiveAlerts(w http.ResponseWriter, r *http.Request) {
-	query := `
-		SELECT id, node_id, gpu_index, alert_type, severity, message,
-		       threshold_value, actual_value, status, triggered_at
-		FROM alerts
-		WHERE status = 'active'
-		ORDER BY severity DESC, triggered_at DESC
-	`
+	// ?nodes=a,b,c restricts the result to those nodes, grouped by node
+	var nodeIDs []string
+	if v := r.URL.Query().Get("nodes"); v != "" {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				nodeIDs = append(nodeIDs, id)
+			}
+		}
+		if len(nodeIDs) == 0 {
+			writeError(w, r, http.StatusBadRequest, "nodes must list at least one node_id")
+			return
+		}
+		if len(nodeIDs) > maxNodesFilter {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("nodes accepts at most %d node_ids", maxNodesFilter))
+			return
+		}
+	}
 
-	rows, err := s.db.Query(query)
+	labelSelector, err := parseLabelSelector(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	fields, err := parseFields(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-	defer rows.Close()
 
-	var alerts []AlertResponse
-	for rows.Next() {
-		var a AlertResponse
-		if err := rows.Scan(&a.ID, &a.NodeID, &a.GPUIndex, &a.AlertType,
-			&a.Severity, &a.Message, &a.ThresholdValue, &a.ActualValue,
-			&a.Status, &a.TriggeredAt); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	key := strings.Join(nodeIDs, ",") + "|" + string(labelSelector)
+	var entry latestCacheEntry
+	hit := false
+	if r.URL.Query().Get("nocache") != "1" {
+		entry, hit = s.latestCache.get(key, s.cfg.LatestCacheTTL)
+	}
+	if !hit {
+		s.latestCache.misses.Add(1)
+		entry, err = s.queryLatestMetrics(r.Context(), nodeIDs, labelSelector)
+		if err != nil {
+			writeInternalError(w, r, err)
 			return
 		}
-		alerts = append(alerts, a)
+		if s.cfg.LatestCacheTTL > 0 {
+			s.latestCache.put(key, entry, s.cfg.LatestCacheTTL)
+		}
+	}
+	if hit {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(alerts)
+	// Cached rows are shared, so each request works on its own copy
+	metrics := make([]MetricResponse, len(entry.rows))
+	copy(metrics, entry.rows)
+	now := time.Now()
+	for i := range metrics {
+		m := &metrics[i]
+		applyTemperatureUnit(m, unit)
+
+		age := now.Sub(m.CollectedAt)
+		ageSeconds := age.Seconds()
+		stale := age > staleAfter
+		m.AgeSeconds, m.Stale = &ageSeconds, &stale
+	}
+
+	// The latest rows can't be resumed, so a truncated list has no cursor:
+	// narrow it with ?nodes= or a label selector instead
+	if nodeIDs == nil {
+		writeList(w, r, projectMetrics(metrics, fields), entry.truncated, "")
+		return
+	}
+
+	// Every requested node gets an entry, empty if it has never reported
+	grouped := make(map[string][]MetricResponse, len(nodeIDs))
+	for _, id := range nodeIDs {
+		grouped[id] = []MetricResponse{}
+	}
+	for _, m := range metrics {
+		grouped[m.NodeID] = append(grouped[m.NodeID], m)
+	}
+	projected := make(map[string]interface{}, len(grouped))
+	for id, group := range grouped {
+		projected[id] = projectMetrics(group, fields)
+	}
+	writeList(w, r, projected, entry.truncated, "")
 }
 
-func (s *APIServer) resolveAlert(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	alertID := vars["alert_id"]
+// latestCacheEntry is one cached /metrics/latest query result
+type latestCacheEntry struct {
+	rows      []MetricResponse
+	truncated bool
+	fetchedAt time.Time
+}
 
-	query := `
-		UPDATE alerts
-		SET status = 'resolved', resolved_at = NOW()
-		WHERE id = $1
-		RETURNING id
-	`
+// latestCache keeps /metrics/latest rows for a short TTL, keyed by the
+// node and label filters, so dashboards polling every few seconds don't
+// each hit the database
+type latestCache struct {
+	mu      sync.Mutex
+	entries map[string]latestCacheEntry
+	hits    atomic.Int64
+	misses  atomic.Int64
+}
 
-	var id int
-	err := s.db.QueryRow(query, alertID).Scan(&id)
-	if err == sql.ErrNoRows {
-		http.Error(w, "Alert not found", http.StatusNotFound)
-		return
+// get returns the entry for key if it is younger than ttl
+func (c *latestCache) get(key string, ttl time.Duration) (latestCacheEntry, bool) {
+	if ttl <= 0 {
+		return latestCacheEntry{}, false
 	}
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+
+	if ok && time.Since(entry.fetchedAt) < ttl {
+		c.hits.Add(1)
+		return entry, true
 	}
+	return latestCacheEntry{}, false
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message":  "Alert resolved",
-		"alert_id": id,
-	})
+// put stores entry under key, dropping entries older than ttl so one-off
+// filters don't accumulate
+func (c *latestCache) put(key string, entry latestCacheEntry, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if time.Since(e.fetchedAt) >= ttl {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = entry
 }
 
-func (s *APIServer) getLatestMetrics(w http.ResponseWriter, r *http.Request) {
+// queryLatestMetrics reads the latest row of each GPU, optionally limited
+// to nodeIDs and to GPUs whose labels contain labelSelector
+func (s *APIServer) queryLatestMetrics(ctx context.Context, nodeIDs []string, labelSelector []byte) (latestCacheEntry, error) {
 	query := `
-		SELECT node_id, gpu_index, temperature_celsius, power_watts,
-		       memory_used_mb, memory_total_mb, utilization_percent, collected_at
+		SELECT node_id, gpu_index, temperature_celsius, COALESCE(power_watts, 0),
+		       memory_used_mb, memory_total_mb, utilization_percent,
+		       COALESCE(fan_speed_percent, 0), collected_at, labels
 		FROM latest_gpu_metrics
+		WHERE ($1::text[] IS NULL OR node_id = ANY($1))
+		  AND ($2::jsonb IS NULL OR labels @> $2)
 		ORDER BY node_id, gpu_index
 	`
 
-	rows, err := s.db.Query(query)
+	var nodeFilter, labelFilter interface{}
+	if nodeIDs != nil {
+		nodeFilter = pq.Array(nodeIDs)
+	}
+	if labelSelector != nil {
+		labelFilter = string(labelSelector)
+	}
+
+	entry := latestCacheEntry{fetchedAt: time.Now()}
+	rows, err := s.db.QueryContext(ctx, query, nodeFilter, labelFilter)
+	if err != nil {
+		return entry, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m MetricResponse
+		var labels []byte
+		if err := rows.Scan(&m.NodeID, &m.GPUIndex, &m.TemperatureCelsius,
+			&m.PowerWatts, &m.MemoryUsedMB, &m.MemoryTotalMB,
+			&m.UtilizationPercent, &m.FanSpeedPercent, &m.CollectedAt, &labels); err != nil {
+			return entry, err
+		}
+		if err := scanLabels(labels, &m); err != nil {
+			return entry, err
+		}
+
+		if s.rowCapReached(len(entry.rows)) {
+			entry.truncated = true
+			break
+		}
+		entry.rows = append(entry.rows, m)
+	}
+	return entry, rows.Err()
+}
+
+// prometheusGauges lists the exported gauges in output order
+var prometheusGauges = []struct {
+	name  string
+	help  string
+	value func(m MetricResponse) float64
+}{
+	{"gpu_temperature_celsius", "GPU temperature in degrees Celsius", func(m MetricResponse) float64 { return m.TemperatureCelsius }},
+	{"gpu_power_watts", "GPU power draw in watts", func(m MetricResponse) float64 { return m.PowerWatts }},
+	{"gpu_memory_used_mb", "GPU framebuffer memory used in MB", func(m MetricResponse) float64 { return m.MemoryUsedMB }},
+	{"gpu_memory_total_mb", "GPU framebuffer memory total in MB", func(m MetricResponse) float64 { return m.MemoryTotalMB }},
+	{"gpu_utilization_percent", "GPU utilization percentage", func(m MetricResponse) float64 { return m.UtilizationPercent }},
+	{"gpu_sm_clock_mhz", "GPU SM clock in MHz", func(m MetricResponse) float64 { return float64(m.SMClockMHz) }},
+	{"gpu_fan_speed_percent", "GPU fan speed percentage", func(m MetricResponse) float64 { return m.FanSpeedPercent }},
+	{"gpu_metrics_age_seconds", "Seconds since the GPU last reported", func(m MetricResponse) float64 { return *m.AgeSeconds }},
+}
+
+// prometheusLabelEscaper escapes label values per the text exposition format
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// getPrometheusMetrics renders the latest per-GPU values in Prometheus text
+// exposition format so Prometheus can scrape the API directly
+func (s *APIServer) getPrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.Query(`
+		SELECT node_id, gpu_index, temperature_celsius, COALESCE(power_watts, 0),
+		       memory_used_mb, memory_total_mb, utilization_percent,
+		       COALESCE(sm_clock_mhz, 0), COALESCE(fan_speed_percent, 0), collected_at
+		FROM latest_gpu_metrics
+		ORDER BY node_id, gpu_index
+	`)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
 	defer rows.Close()
@@ -313,15 +5300,27 @@ func (s *APIServer) getLatestMetrics(w http.ResponseWriter, r *http.Request) {
 		var m MetricResponse
 		if err := rows.Scan(&m.NodeID, &m.GPUIndex, &m.TemperatureCelsius,
 			&m.PowerWatts, &m.MemoryUsedMB, &m.MemoryTotalMB,
-			&m.UtilizationPercent, &m.CollectedAt); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			&m.UtilizationPercent, &m.SMClockMHz, &m.FanSpeedPercent, &m.CollectedAt); err != nil {
+			writeInternalError(w, r, err)
 			return
 		}
+		age := time.Since(m.CollectedAt).Seconds()
+		m.AgeSeconds = &age
 		metrics = append(metrics, m)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(metrics)
+	var b strings.Builder
+	for _, g := range prometheusGauges {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+		for _, m := range metrics {
+			fmt.Fprintf(&b, "%s{node=\"%s\",gpu=\"%d\"} %s\n",
+				g.name, prometheusLabelEscaper.Replace(m.NodeID), m.GPUIndex,
+				strconv.FormatFloat(g.value(m), 'g', -1, 64))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
 }
 
 func (s *APIServer) Start(port string) error {
@@ -329,26 +5328,137 @@ func (s *APIServer) Start(port string) error {
 	return http.ListenAndServe(":"+port, s.router)
 }
 
+// registerPprof exposes the net/http/pprof handlers on mux
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// serveInternalMetrics exposes the API server's own counters in Prometheus
+// text format
+func (s *APIServer) serveInternalMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP api_latest_cache_hits_total /metrics/latest requests served from the cache\n")
+	fmt.Fprintf(w, "# TYPE api_latest_cache_hits_total counter\n")
+	fmt.Fprintf(w, "api_latest_cache_hits_total %d\n", s.latestCache.hits.Load())
+	fmt.Fprintf(w, "# HELP api_latest_cache_misses_total /metrics/latest requests that queried the database\n")
+	fmt.Fprintf(w, "# TYPE api_latest_cache_misses_total counter\n")
+	fmt.Fprintf(w, "api_latest_cache_misses_total %d\n", s.latestCache.misses.Load())
+}
+
+// StartInternalServer serves operator-only endpoints on InternalAddr, kept
+// off the public router
+func (s *APIServer) StartInternalServer() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.serveInternalMetrics)
+	if s.cfg.PprofEnabled {
+		registerPprof(mux)
+	}
+
+	go func() {
+		log.Printf("Internal server listening on %s (pprof: %t)", s.cfg.InternalAddr, s.cfg.PprofEnabled)
+		if err := http.ListenAndServe(s.cfg.InternalAddr, mux); err != nil {
+			log.Printf("Internal server failed: %v", err)
+		}
+	}()
+}
+
 func main() {
-	dbConnStr := "host=localhost port=5432 user=telemetry password=telemetry123 dbname=gpu_telemetry sslmode=disable"
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	var redactor *logRedactor
+	if len(cfg.LogRedactFields) > 0 {
+		redactor = newLogRedactor(os.Stderr, cfg.LogRedactFields)
+		log.SetOutput(redactor)
+		// Mask the database host in connection errors from the start
+		if host := dbHost(cfg.DBConnStr); host != "" && redactor.redacts("hostname") {
+			redactor.setValues([]string{host})
+		}
+	}
 
-	server, err := NewAPIServer(dbConnStr)
+	server, err := NewAPIServer(cfg)
 	if err != nil {
 		log.Fatalf("Failed to create API server: %v", err)
 	}
+	if redactor != nil {
+		if err := redactor.refresh(server.db, dbHost(cfg.DBConnStr)); err != nil {
+			log.Printf("Failed to load redacted log values: %v", err)
+		}
+		go redactor.watch(server.db, dbHost(cfg.DBConnStr))
+	}
 
 	log.Println("API Server started successfully")
 	log.Println("Available endpoints:")
 	log.Println("  GET  /health")
+	log.Println("  GET  /health/schema")
+	log.Println("  GET  /api/v1/pipeline/health")
 	log.Println("  GET  /api/v1/nodes")
+	log.Println("  POST /api/v1/nodes")
 	log.Println("  GET  /api/v1/nodes/{node_id}")
+	log.Println("  POST /api/v1/nodes/{node_id}/mute")
+	log.Println("  DELETE /api/v1/nodes/{node_id}/mute")
+	log.Println("  GET  /api/v1/nodes/{node_id}/expected-gpus")
+	log.Println("  PUT  /api/v1/nodes/{node_id}/expected-gpus")
+	log.Println("  POST /api/v1/nodes/{node_id}/alerts/ack")
+	log.Println("  GET  /api/v1/nodes/{node_id}/detail")
+	log.Println("  GET  /api/v1/nodes/{node_id}/coverage")
+	log.Println("  GET  /api/v1/nodes/{node_id}/score")
+	log.Println("  GET  /api/v1/nodes/{node_id}/mttr")
+	log.Println("  GET  /api/v1/nodes/{node_id}/tail")
+	log.Println("  GET  /api/v1/nodes/{node_id}/ingest-rate")
+	log.Println("  GET  /api/v1/summary")
+	log.Println("  GET  /api/v1/summary/stream")
+	log.Println("  GET  /api/v1/snapshot")
+	log.Println("  GET  /api/v1/nodes/{node_id}/export.ndjson")
 	log.Println("  GET  /api/v1/nodes/{node_id}/metrics")
+	log.Println("  GET  /api/v1/nodes/{node_id}/metrics/percentiles")
+	log.Println("  GET  /api/v1/nodes/{node_id}/metrics/aggregate/multi")
+	log.Println("  GET  /api/v1/nodes/{node_id}/gpus/{gpu_index}/metrics")
+	log.Println("  GET  /api/v1/nodes/{node_id}/gpus/{gpu_index}/histograms")
+	log.Println("  GET  /api/v1/nodes/{node_id}/gpus/{gpu_index}/correlation")
+	log.Println("  GET  /api/v1/gpus/{uuid}/metrics")
+	log.Println("  GET  /api/v1/nodes/{node_id}/sparklines")
 	log.Println("  GET  /api/v1/alerts")
+	log.Println("  DELETE /api/v1/alerts?status=resolved&before=<RFC3339> (admin)")
 	log.Println("  GET  /api/v1/alerts/active")
+	log.Println("  GET  /api/v1/alerts/stats")
+	log.Println("  GET  /api/v1/alerts/distribution")
+	log.Println("  GET  /api/v1/alerts/correlated")
+	log.Println("  POST /api/v1/alerts/resolve")
+	log.Println("  POST /api/v1/alerts/resolve-by")
 	log.Println("  POST /api/v1/alerts/{alert_id}/resolve")
+	log.Println("  POST /api/v1/alerts/{alert_id}/notify")
+	log.Println("  POST /api/v1/alerts/test")
+	log.Println("  GET  /api/v1/actions")
+	log.Println("  DELETE /api/v1/actions")
+	log.Println("  GET  /api/v1/rules")
+	log.Println("  POST /api/v1/rules")
+	log.Println("  DELETE /api/v1/rules/{rule_id}")
+	log.Println("  GET  /api/v1/rules/suggestions")
+	log.Println("  POST /api/v1/rules/suggestions/{suggestion_id}/approve")
+	log.Println("  POST /api/v1/rules/suggestions/{suggestion_id}/reject")
+	log.Println("  GET  /api/v1/suppressions")
+	log.Println("  POST /api/v1/suppressions")
+	log.Println("  PUT  /api/v1/suppressions/{suppression_id}")
+	log.Println("  DELETE /api/v1/suppressions/{suppression_id}")
 	log.Println("  GET  /api/v1/metrics/latest")
+	log.Println("  GET  /api/v1/metrics/prometheus")
+	log.Println("  GET  /api/v1/metrics/schema")
+	log.Println("  GET  /api/v1/metrics/by-gpu-index")
+	log.Println("  GET  /api/v1/metrics/memory/leaderboard")
+	log.Println("  GET  /api/v1/efficiency")
+	log.Println("  GET  /api/v1/gpus/missing")
+
+	if cfg.InternalAddr != "" {
+		server.StartInternalServer()
+	}
 
-	if err := server.Start("8080"); err != nil {
+	if err := server.Start(cfg.Port); err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
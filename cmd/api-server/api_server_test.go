@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectResolve expects resolveAlertID's queries for one alert: resolved
+// when resolved is set, else missing or already resolved per exists
+func expectResolve(mock sqlmock.Sqlmock, alertID int, resolved, exists bool) {
+	update := mock.ExpectQuery(`UPDATE alerts\s+SET status = 'resolved'`).WithArgs(alertID)
+	if resolved {
+		update.WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(alertID))
+		return
+	}
+	update.WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM alerts WHERE id = \$1\)`).WithArgs(alertID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(exists))
+}
+
+// TestResolveAlerts checks atomic bulk resolves commit only when every alert
+// resolves, and best_effort ones resolve what they can and report the rest
+func TestResolveAlerts(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		expect     func(mock sqlmock.Sqlmock)
+		wantStatus int
+		want       BulkResolveResponse
+	}{
+		{
+			name: "atomic",
+			mode: resolveModeAtomic,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectResolve(mock, 1, true, true)
+				expectResolve(mock, 2, true, true)
+				mock.ExpectCommit()
+			},
+			wantStatus: http.StatusOK,
+			want:       BulkResolveResponse{Mode: resolveModeAtomic, Resolved: []int{1, 2}, Failed: []BulkResolveFailure{}},
+		},
+		{
+			name: "atomic rolls back on a failure",
+			mode: resolveModeAtomic,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectResolve(mock, 1, true, true)
+				expectResolve(mock, 2, false, true)
+				mock.ExpectRollback()
+			},
+			wantStatus: http.StatusConflict,
+			want: BulkResolveResponse{Mode: resolveModeAtomic, Resolved: []int{1}, Failed: []BulkResolveFailure{
+				{AlertID: 2, Status: http.StatusConflict, Error: "Alert is already resolved"},
+			}},
+		},
+		{
+			name: "best_effort",
+			mode: resolveModeBestEffort,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectResolve(mock, 1, true, true)
+				expectResolve(mock, 2, false, false)
+			},
+			wantStatus: http.StatusMultiStatus,
+			want: BulkResolveResponse{Mode: resolveModeBestEffort, Resolved: []int{1}, Failed: []BulkResolveFailure{
+				{AlertID: 2, Status: http.StatusNotFound, Error: "Alert not found"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			tt.expect(mock)
+			s := &APIServer{db: db}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/alerts/resolve?mode="+tt.mode,
+				strings.NewReader(`{"alert_ids": [1, 2, 1]}`))
+			rec := httptest.NewRecorder()
+			s.resolveAlerts(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			var got BulkResolveResponse
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("response %+v, want %+v", got, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestLimitExpensive checks a request beyond EXPENSIVE_QUERY_LIMIT gets 503
+// with Retry-After while the slot is held, and the slot is freed afterwards
+func TestLimitExpensive(t *testing.T) {
+	s := &APIServer{expensive: make(chan struct{}, 1)}
+	var handler http.HandlerFunc
+	var inner *httptest.ResponseRecorder
+	handler = s.limitExpensive(func(w http.ResponseWriter, r *http.Request) {
+		// A second expensive request while this one holds the only slot
+		if inner == nil {
+			inner = httptest.NewRecorder()
+			handler(inner, r)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/metrics/aggregate", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first request: status %d, want 200", rec.Code)
+	}
+	if inner.Code != http.StatusServiceUnavailable || inner.Header().Get("Retry-After") == "" {
+		t.Fatalf("concurrent request: status %d, Retry-After %q; want 503 with Retry-After",
+			inner.Code, inner.Header().Get("Retry-After"))
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/metrics/aggregate", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("request after release: status %d, want 200", rec.Code)
+	}
+}
+
+// TestLatestMetricsCache checks repeated /metrics/latest requests within
+// LATEST_CACHE_TTL are served from memory, ?nocache=1 bypasses the cache,
+// and converting a cached row for one request doesn't change it for the next
+func TestLatestMetricsCache(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := &APIServer{
+		db:          db,
+		cfg:         Config{LatestCacheTTL: time.Minute, StaleAfter: time.Minute},
+		latestCache: latestCache{entries: make(map[string]latestCacheEntry)},
+	}
+
+	columns := []string{"node_id", "gpu_index", "temperature_celsius", "power_watts", "memory_used_mb",
+		"memory_total_mb", "utilization_percent", "fan_speed_percent", "collected_at", "labels"}
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`FROM latest_gpu_metrics`).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("node-1", 0, 70.0, 250.0, 1024.0, 81920.0, 50.0, 30.0, time.Now(), nil))
+	}
+
+	get := func(query string) (string, []MetricResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.getLatestMetrics(rec, httptest.NewRequest(http.MethodGet, "/api/v1/metrics/latest"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", query, rec.Code, rec.Body)
+		}
+		var metrics []MetricResponse
+		if err := json.NewDecoder(rec.Body).Decode(&metrics); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Header().Get("X-Cache"), metrics
+	}
+
+	if cache, _ := get(""); cache != "MISS" {
+		t.Errorf("first request X-Cache %q, want MISS", cache)
+	}
+	cache, metrics := get("?unit=f")
+	if cache != "HIT" {
+		t.Errorf("second request X-Cache %q, want HIT", cache)
+	}
+	if len(metrics) != 1 || metrics[0].Temperature != 158 {
+		t.Errorf("cached metrics in Fahrenheit = %+v, want one row at 158", metrics)
+	}
+	cache, metrics = get("")
+	if cache != "HIT" || len(metrics) != 1 || metrics[0].Temperature != 70 || metrics[0].Unit != unitCelsius {
+		t.Errorf("third request X-Cache %q, metrics %+v; want a HIT at 70 C", cache, metrics)
+	}
+	if cache, _ := get("?nocache=1"); cache != "MISS" {
+		t.Errorf("nocache request X-Cache %q, want MISS", cache)
+	}
+
+	if hits, misses := s.latestCache.hits.Load(), s.latestCache.misses.Load(); hits != 2 || misses != 2 {
+		t.Errorf("cache hits/misses = %d/%d, want 2/2", hits, misses)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,6 +3,7 @@ module gpu-telemetry/api-server
 go 1.24.2
 
 require (
-	github.com/gorilla/mux v1.8.1 // indirect
-	github.com/lib/pq v1.10.9 // indirect
+	github.com/DATA-DOG/go-sqlmock v1.5.2
+	github.com/gorilla/mux v1.8.1
+	github.com/lib/pq v1.10.9
 )
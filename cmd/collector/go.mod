@@ -2,8 +2,12 @@ module gpu-telemetry/collector
 
 go 1.24.2
 
+require (
+	github.com/segmentio/kafka-go v0.4.49
+	google.golang.org/protobuf v1.36.12
+)
+
 require (
 	github.com/klauspost/compress v1.15.9 // indirect
 	github.com/pierrec/lz4/v4 v4.1.15 // indirect
-	github.com/segmentio/kafka-go v0.4.49 // indirect
 )
@@ -0,0 +1,408 @@
+// GPUMetric as published to the gpu-telemetry topic with MESSAGE_FORMAT=protobuf.
+// Messages carry the Kafka header content-type: application/x-protobuf.
+// The collector and alert engine use the Go types generated from this file
+// into their gpupb packages; regenerate them with `make proto` after a change.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: gpu_metric.proto
+
+package gpupb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GPUMetric struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	NodeId             string                 `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	GpuIndex           int32                  `protobuf:"varint,2,opt,name=gpu_index,json=gpuIndex,proto3" json:"gpu_index,omitempty"`
+	TemperatureCelsius float64                `protobuf:"fixed64,3,opt,name=temperature_celsius,json=temperatureCelsius,proto3" json:"temperature_celsius,omitempty"`
+	PowerWatts         float64                `protobuf:"fixed64,4,opt,name=power_watts,json=powerWatts,proto3" json:"power_watts,omitempty"`
+	MemoryUsedMb       float64                `protobuf:"fixed64,5,opt,name=memory_used_mb,json=memoryUsedMb,proto3" json:"memory_used_mb,omitempty"`
+	MemoryTotalMb      float64                `protobuf:"fixed64,6,opt,name=memory_total_mb,json=memoryTotalMb,proto3" json:"memory_total_mb,omitempty"`
+	UtilizationPercent float64                `protobuf:"fixed64,7,opt,name=utilization_percent,json=utilizationPercent,proto3" json:"utilization_percent,omitempty"`
+	SmClockMhz         int32                  `protobuf:"varint,8,opt,name=sm_clock_mhz,json=smClockMhz,proto3" json:"sm_clock_mhz,omitempty"`
+	FanSpeedPercent    float64                `protobuf:"fixed64,9,opt,name=fan_speed_percent,json=fanSpeedPercent,proto3" json:"fan_speed_percent,omitempty"`
+	RowRemapPending    int32                  `protobuf:"varint,10,opt,name=row_remap_pending,json=rowRemapPending,proto3" json:"row_remap_pending,omitempty"`
+	// Unix time in nanoseconds
+	CollectedAt int64                 `protobuf:"varint,11,opt,name=collected_at,json=collectedAt,proto3" json:"collected_at,omitempty"`
+	Labels      map[string]string     `protobuf:"bytes,12,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Histograms  map[string]*Histogram `protobuf:"bytes,13,rep,name=histograms,proto3" json:"histograms,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Power cap enforced by the driver; 0 when not reported
+	EnforcedPowerLimitWatts float64 `protobuf:"fixed64,14,opt,name=enforced_power_limit_watts,json=enforcedPowerLimitWatts,proto3" json:"enforced_power_limit_watts,omitempty"`
+	// Card UUID (GPU-...), stable across node and index changes; empty when unknown
+	GpuUuid string `protobuf:"bytes,15,opt,name=gpu_uuid,json=gpuUuid,proto3" json:"gpu_uuid,omitempty"`
+	// Optional fields the source didn't report and sent as 0, e.g.
+	// fan_speed_percent on passively cooled GPUs or power_watts
+	Unreported    []string `protobuf:"bytes,16,rep,name=unreported,proto3" json:"unreported,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GPUMetric) Reset() {
+	*x = GPUMetric{}
+	mi := &file_gpu_metric_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GPUMetric) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GPUMetric) ProtoMessage() {}
+
+func (x *GPUMetric) ProtoReflect() protoreflect.Message {
+	mi := &file_gpu_metric_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GPUMetric.ProtoReflect.Descriptor instead.
+func (*GPUMetric) Descriptor() ([]byte, []int) {
+	return file_gpu_metric_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GPUMetric) GetNodeId() string {
+	if x != nil {
+		return x.NodeId
+	}
+	return ""
+}
+
+func (x *GPUMetric) GetGpuIndex() int32 {
+	if x != nil {
+		return x.GpuIndex
+	}
+	return 0
+}
+
+func (x *GPUMetric) GetTemperatureCelsius() float64 {
+	if x != nil {
+		return x.TemperatureCelsius
+	}
+	return 0
+}
+
+func (x *GPUMetric) GetPowerWatts() float64 {
+	if x != nil {
+		return x.PowerWatts
+	}
+	return 0
+}
+
+func (x *GPUMetric) GetMemoryUsedMb() float64 {
+	if x != nil {
+		return x.MemoryUsedMb
+	}
+	return 0
+}
+
+func (x *GPUMetric) GetMemoryTotalMb() float64 {
+	if x != nil {
+		return x.MemoryTotalMb
+	}
+	return 0
+}
+
+func (x *GPUMetric) GetUtilizationPercent() float64 {
+	if x != nil {
+		return x.UtilizationPercent
+	}
+	return 0
+}
+
+func (x *GPUMetric) GetSmClockMhz() int32 {
+	if x != nil {
+		return x.SmClockMhz
+	}
+	return 0
+}
+
+func (x *GPUMetric) GetFanSpeedPercent() float64 {
+	if x != nil {
+		return x.FanSpeedPercent
+	}
+	return 0
+}
+
+func (x *GPUMetric) GetRowRemapPending() int32 {
+	if x != nil {
+		return x.RowRemapPending
+	}
+	return 0
+}
+
+func (x *GPUMetric) GetCollectedAt() int64 {
+	if x != nil {
+		return x.CollectedAt
+	}
+	return 0
+}
+
+func (x *GPUMetric) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *GPUMetric) GetHistograms() map[string]*Histogram {
+	if x != nil {
+		return x.Histograms
+	}
+	return nil
+}
+
+func (x *GPUMetric) GetEnforcedPowerLimitWatts() float64 {
+	if x != nil {
+		return x.EnforcedPowerLimitWatts
+	}
+	return 0
+}
+
+func (x *GPUMetric) GetGpuUuid() string {
+	if x != nil {
+		return x.GpuUuid
+	}
+	return ""
+}
+
+func (x *GPUMetric) GetUnreported() []string {
+	if x != nil {
+		return x.Unreported
+	}
+	return nil
+}
+
+// Histogram has cumulative buckets; count includes the +Inf bucket
+type Histogram struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Buckets       []*HistogramBucket     `protobuf:"bytes,1,rep,name=buckets,proto3" json:"buckets,omitempty"`
+	Count         float64                `protobuf:"fixed64,2,opt,name=count,proto3" json:"count,omitempty"`
+	Sum           float64                `protobuf:"fixed64,3,opt,name=sum,proto3" json:"sum,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Histogram) Reset() {
+	*x = Histogram{}
+	mi := &file_gpu_metric_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Histogram) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Histogram) ProtoMessage() {}
+
+func (x *Histogram) ProtoReflect() protoreflect.Message {
+	mi := &file_gpu_metric_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Histogram.ProtoReflect.Descriptor instead.
+func (*Histogram) Descriptor() ([]byte, []int) {
+	return file_gpu_metric_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Histogram) GetBuckets() []*HistogramBucket {
+	if x != nil {
+		return x.Buckets
+	}
+	return nil
+}
+
+func (x *Histogram) GetCount() float64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *Histogram) GetSum() float64 {
+	if x != nil {
+		return x.Sum
+	}
+	return 0
+}
+
+type HistogramBucket struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Le            float64                `protobuf:"fixed64,1,opt,name=le,proto3" json:"le,omitempty"`
+	Count         float64                `protobuf:"fixed64,2,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HistogramBucket) Reset() {
+	*x = HistogramBucket{}
+	mi := &file_gpu_metric_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HistogramBucket) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HistogramBucket) ProtoMessage() {}
+
+func (x *HistogramBucket) ProtoReflect() protoreflect.Message {
+	mi := &file_gpu_metric_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HistogramBucket.ProtoReflect.Descriptor instead.
+func (*HistogramBucket) Descriptor() ([]byte, []int) {
+	return file_gpu_metric_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *HistogramBucket) GetLe() float64 {
+	if x != nil {
+		return x.Le
+	}
+	return 0
+}
+
+func (x *HistogramBucket) GetCount() float64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+var File_gpu_metric_proto protoreflect.FileDescriptor
+
+const file_gpu_metric_proto_rawDesc = "" +
+	"\n" +
+	"\x10gpu_metric.proto\x12\rgpu_telemetry\"\xc3\x06\n" +
+	"\tGPUMetric\x12\x17\n" +
+	"\anode_id\x18\x01 \x01(\tR\x06nodeId\x12\x1b\n" +
+	"\tgpu_index\x18\x02 \x01(\x05R\bgpuIndex\x12/\n" +
+	"\x13temperature_celsius\x18\x03 \x01(\x01R\x12temperatureCelsius\x12\x1f\n" +
+	"\vpower_watts\x18\x04 \x01(\x01R\n" +
+	"powerWatts\x12$\n" +
+	"\x0ememory_used_mb\x18\x05 \x01(\x01R\fmemoryUsedMb\x12&\n" +
+	"\x0fmemory_total_mb\x18\x06 \x01(\x01R\rmemoryTotalMb\x12/\n" +
+	"\x13utilization_percent\x18\a \x01(\x01R\x12utilizationPercent\x12 \n" +
+	"\fsm_clock_mhz\x18\b \x01(\x05R\n" +
+	"smClockMhz\x12*\n" +
+	"\x11fan_speed_percent\x18\t \x01(\x01R\x0ffanSpeedPercent\x12*\n" +
+	"\x11row_remap_pending\x18\n" +
+	" \x01(\x05R\x0frowRemapPending\x12!\n" +
+	"\fcollected_at\x18\v \x01(\x03R\vcollectedAt\x12<\n" +
+	"\x06labels\x18\f \x03(\v2$.gpu_telemetry.GPUMetric.LabelsEntryR\x06labels\x12H\n" +
+	"\n" +
+	"histograms\x18\r \x03(\v2(.gpu_telemetry.GPUMetric.HistogramsEntryR\n" +
+	"histograms\x12;\n" +
+	"\x1aenforced_power_limit_watts\x18\x0e \x01(\x01R\x17enforcedPowerLimitWatts\x12\x19\n" +
+	"\bgpu_uuid\x18\x0f \x01(\tR\agpuUuid\x12\x1e\n" +
+	"\n" +
+	"unreported\x18\x10 \x03(\tR\n" +
+	"unreported\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1aW\n" +
+	"\x0fHistogramsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12.\n" +
+	"\x05value\x18\x02 \x01(\v2\x18.gpu_telemetry.HistogramR\x05value:\x028\x01\"m\n" +
+	"\tHistogram\x128\n" +
+	"\abuckets\x18\x01 \x03(\v2\x1e.gpu_telemetry.HistogramBucketR\abuckets\x12\x14\n" +
+	"\x05count\x18\x02 \x01(\x01R\x05count\x12\x10\n" +
+	"\x03sum\x18\x03 \x01(\x01R\x03sum\"7\n" +
+	"\x0fHistogramBucket\x12\x0e\n" +
+	"\x02le\x18\x01 \x01(\x01R\x02le\x12\x14\n" +
+	"\x05count\x18\x02 \x01(\x01R\x05countb\x06proto3"
+
+var (
+	file_gpu_metric_proto_rawDescOnce sync.Once
+	file_gpu_metric_proto_rawDescData []byte
+)
+
+func file_gpu_metric_proto_rawDescGZIP() []byte {
+	file_gpu_metric_proto_rawDescOnce.Do(func() {
+		file_gpu_metric_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_gpu_metric_proto_rawDesc), len(file_gpu_metric_proto_rawDesc)))
+	})
+	return file_gpu_metric_proto_rawDescData
+}
+
+var file_gpu_metric_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_gpu_metric_proto_goTypes = []any{
+	(*GPUMetric)(nil),       // 0: gpu_telemetry.GPUMetric
+	(*Histogram)(nil),       // 1: gpu_telemetry.Histogram
+	(*HistogramBucket)(nil), // 2: gpu_telemetry.HistogramBucket
+	nil,                     // 3: gpu_telemetry.GPUMetric.LabelsEntry
+	nil,                     // 4: gpu_telemetry.GPUMetric.HistogramsEntry
+}
+var file_gpu_metric_proto_depIdxs = []int32{
+	3, // 0: gpu_telemetry.GPUMetric.labels:type_name -> gpu_telemetry.GPUMetric.LabelsEntry
+	4, // 1: gpu_telemetry.GPUMetric.histograms:type_name -> gpu_telemetry.GPUMetric.HistogramsEntry
+	2, // 2: gpu_telemetry.Histogram.buckets:type_name -> gpu_telemetry.HistogramBucket
+	1, // 3: gpu_telemetry.GPUMetric.HistogramsEntry.value:type_name -> gpu_telemetry.Histogram
+	4, // [4:4] is the sub-list for method output_type
+	4, // [4:4] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_gpu_metric_proto_init() }
+func file_gpu_metric_proto_init() {
+	if File_gpu_metric_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_gpu_metric_proto_rawDesc), len(file_gpu_metric_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_gpu_metric_proto_goTypes,
+		DependencyIndexes: file_gpu_metric_proto_depIdxs,
+		MessageInfos:      file_gpu_metric_proto_msgTypes,
+	}.Build()
+	File_gpu_metric_proto = out.File
+	file_gpu_metric_proto_goTypes = nil
+	file_gpu_metric_proto_depIdxs = nil
+}
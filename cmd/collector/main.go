@@ -1,12 +1,36 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/subtle"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/proto"
+	"gpu-telemetry/collector/gpupb"
+	"hash/fnv"
+	"io"
 	"log"
+	"math"
 	"math/rand"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -20,114 +44,3108 @@ type GPUMetric struct {
 	MemoryTotalMB      float64   `json:"memory_total_mb"`
 	UtilizationPercent float64   `json:"utilization_percent"`
 	SMClockMHz         int       `json:"sm_clock_mhz"`
+	FanSpeedPercent    float64   `json:"fan_speed_percent"`
+	RowRemapPending    int       `json:"row_remap_pending"` // rows awaiting remap; nonzero needs a GPU reset
 	CollectedAt        time.Time `json:"collected_at"`
+	// EnforcedPowerLimitWatts is the power cap the driver enforces; zero
+	// when the source doesn't report it
+	EnforcedPowerLimitWatts float64 `json:"enforced_power_limit_watts"`
+	// GPUUUID identifies the card itself, which keeps it across moves to
+	// another node or index; empty when the source doesn't report it
+	GPUUUID string `json:"gpu_uuid,omitempty"`
+	// Labels attribute the sample, e.g. to a job or team
+	Labels map[string]string `json:"labels,omitempty"`
+	// Histograms carry distribution-shaped fields, keyed by name (e.g.
+	// sm_occupancy); only set when the source reports them
+	Histograms map[string]Histogram `json:"histograms,omitempty"`
+	// Unreported lists the optional fields (dcgmOptionalFields) the source
+	// didn't report, which are sent as zero, so a zero reading can be told
+	// apart from a missing one
+	Unreported []string `json:"unreported,omitempty"`
+}
+
+// Histogram is a Prometheus-style distribution: Buckets are cumulative
+// counts of observations <= LE, and Count includes the +Inf bucket
+type Histogram struct {
+	Buckets []HistogramBucket `json:"buckets"`
+	Count   float64           `json:"count"`
+	Sum     float64           `json:"sum"`
+}
+
+// HistogramBucket is one cumulative histogram bucket
+type HistogramBucket struct {
+	LE    float64 `json:"le"`
+	Count float64 `json:"count"`
+}
+
+// NodeConfig describes a node the collector polls
+type NodeConfig struct {
+	NodeID string `json:"node_id"`
+	// DCGMURL is the node's DCGM exporter metrics URL; empty means simulate
+	DCGMURL string `json:"dcgm_url,omitempty"`
+	// DCGMFields lists the DCGM fields to map for this node's hardware; empty
+	// maps every supported field. Unselected GPUMetric fields stay zero.
+	DCGMFields []string `json:"dcgm_fields,omitempty"`
+	// Labels are attached to every metric from the node. GPULabels, keyed
+	// by GPU index, add to or override them for single GPUs.
+	Labels    map[string]string            `json:"labels,omitempty"`
+	GPULabels map[string]map[string]string `json:"gpu_labels,omitempty"`
+	// SimulationProfile names the profile a simulated node's readings are
+	// drawn from; empty uses SIMULATION_PROFILE
+	SimulationProfile string `json:"simulation_profile,omitempty"`
+
+	// expectedGPUs is the node's expected_gpu_count from ExpectedGPUsURL,
+	// set for each collection; zero when unknown
+	expectedGPUs int
+}
+
+// applyLabels attaches the node's configured labels to its metrics
+func (n NodeConfig) applyLabels(metrics []GPUMetric) {
+	if len(n.Labels) == 0 && len(n.GPULabels) == 0 {
+		return
+	}
+	for i := range metrics {
+		gpuLabels := n.GPULabels[strconv.Itoa(metrics[i].GPUIndex)]
+		if len(n.Labels) == 0 && len(gpuLabels) == 0 {
+			continue
+		}
+		labels := make(map[string]string, len(n.Labels)+len(gpuLabels))
+		for k, v := range n.Labels {
+			labels[k] = v
+		}
+		for k, v := range gpuLabels {
+			labels[k] = v
+		}
+		metrics[i].Labels = labels
+	}
+}
+
+// dcgmGPU accumulates one GPU's fields over a DCGM scrape. Framebuffer
+// used and free are kept apart until the scrape is parsed, since the
+// total is their sum and the exporter lists them in any order.
+type dcgmGPU struct {
+	GPUMetric
+	fbUsed, fbFree       float64
+	hasFBUsed, hasFBFree bool
+	// seen holds the DCGM fields the scrape reported for the GPU
+	seen map[string]bool
+}
+
+// metric returns the GPU's metric, with memory total set when both
+// framebuffer fields were reported and Unreported listing the optional
+// fields that weren't
+func (g *dcgmGPU) metric() GPUMetric {
+	m := g.GPUMetric
+	if g.hasFBUsed {
+		m.MemoryUsedMB = g.fbUsed
+		if g.hasFBFree {
+			m.MemoryTotalMB = g.fbUsed + g.fbFree
+		}
+	}
+	for _, f := range dcgmOptionalFields {
+		if !g.seen[f.dcgm] {
+			m.Unreported = append(m.Unreported, f.field)
+		}
+	}
+	return m
+}
+
+// dcgmOptionalFields are the DCGM fields whose absence is carried in
+// GPUMetric.Unreported, since a zero reading means something: a stopped
+// fan, or a GPU drawing no power. Passively cooled GPUs report no fan speed
+// at all, and some boards no power usage.
+var dcgmOptionalFields = []struct{ dcgm, field string }{
+	{"DCGM_FI_DEV_FAN_SPEED", "fan_speed_percent"},
+	{"DCGM_FI_DEV_POWER_USAGE", "power_watts"},
+}
+
+// dcgmFieldSetters maps supported DCGM exporter fields onto GPUMetric
+var dcgmFieldSetters = map[string]func(g *dcgmGPU, v float64){
+	"DCGM_FI_DEV_GPU_TEMP":             func(g *dcgmGPU, v float64) { g.TemperatureCelsius = v },
+	"DCGM_FI_DEV_POWER_USAGE":          func(g *dcgmGPU, v float64) { g.PowerWatts = v },
+	"DCGM_FI_DEV_FB_USED":              func(g *dcgmGPU, v float64) { g.fbUsed, g.hasFBUsed = v, true },
+	"DCGM_FI_DEV_FB_FREE":              func(g *dcgmGPU, v float64) { g.fbFree, g.hasFBFree = v, true },
+	"DCGM_FI_DEV_GPU_UTIL":             func(g *dcgmGPU, v float64) { g.UtilizationPercent = v },
+	"DCGM_FI_DEV_SM_CLOCK":             func(g *dcgmGPU, v float64) { g.SMClockMHz = int(v) },
+	"DCGM_FI_DEV_FAN_SPEED":            func(g *dcgmGPU, v float64) { g.FanSpeedPercent = v },
+	"DCGM_FI_DEV_ROW_REMAP_PENDING":    func(g *dcgmGPU, v float64) { g.RowRemapPending = int(v) },
+	"DCGM_FI_DEV_ENFORCED_POWER_LIMIT": func(g *dcgmGPU, v float64) { g.EnforcedPowerLimitWatts = v },
+}
+
+// dcgmHistograms maps DCGM fields exposed as Prometheus histograms (their
+// _bucket/_count/_sum series) onto GPUMetric.Histograms keys
+var dcgmHistograms = map[string]string{
+	"DCGM_FI_PROF_SM_OCCUPANCY": "sm_occupancy",
+}
+
+// defaultNodes is used when no NODES_CONFIG file is given
+var defaultNodes = []NodeConfig{{NodeID: "node-1"}, {NodeID: "node-2"}}
+
+// maxNodeIDLength matches the gpu_nodes.node_id column
+const maxNodeIDLength = 50
+
+// normalizeNodeID lowercases and trims a node ID and checks it is 1-50
+// characters of a-z, 0-9, '.', '_' and '-', starting with a letter or digit.
+// The API server applies the same rules at node registration.
+func normalizeNodeID(id string) (string, error) {
+	norm := strings.ToLower(strings.TrimSpace(id))
+	if norm == "" || len(norm) > maxNodeIDLength {
+		return "", fmt.Errorf("node_id %q must be 1-%d characters", id, maxNodeIDLength)
+	}
+	for i, c := range norm {
+		alnum := (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+		if !alnum && (i == 0 || (c != '.' && c != '_' && c != '-')) {
+			return "", fmt.Errorf("node_id %q may only contain a-z, 0-9, '.', '_' and '-', and must start with a letter or digit", id)
+		}
+	}
+	return norm, nil
+}
+
+// loadNodeConfig reads the node list from a JSON file
+func loadNodeConfig(path string) ([]NodeConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read node config: %w", err)
+	}
+
+	var nodes []NodeConfig
+	if err := json.Unmarshal(data, &nodes); err != nil {
+		return nil, fmt.Errorf("failed to parse node config: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for i, n := range nodes {
+		if n.NodeID == "" {
+			return nil, fmt.Errorf("node config entry missing node_id")
+		}
+		id, err := normalizeNodeID(n.NodeID)
+		if err != nil {
+			return nil, err
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate node_id %q", id)
+		}
+		seen[id] = true
+		nodes[i].NodeID = id
+
+		for _, f := range n.DCGMFields {
+			_, scalar := dcgmFieldSetters[f]
+			_, histogram := dcgmHistograms[f]
+			if !scalar && !histogram {
+				return nil, fmt.Errorf("node %s: unsupported DCGM field %q", n.NodeID, f)
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// Config holds collector settings, loaded from the environment
+type Config struct {
+	NodesFile    string // JSON node config; empty uses the built-in simulated nodes
+	KafkaBrokers []string
+	PartitionKey string // message key strategy: gpu, node or none
+	Balancer     string // partition balancer: least_bytes, hash or round_robin
+	// Writer batching, compression and durability. A larger
+	// BatchTimeout/BatchSize trades latency for throughput; RequiredAcks=all
+	// trades latency for durability. Compression zero is uncompressed.
+	BatchTimeout time.Duration
+	BatchSize    int
+	RequiredAcks kafka.RequiredAcks
+	Compression  kafka.Compression
+	// KafkaWriters shards publishing over this many independently batching
+	// writers, each message going to the writer picked by its key's hash
+	KafkaWriters int
+	ControlAddr  string // control HTTP server listen address; empty disables it
+	ControlToken string // bearer token required by control endpoints when set
+	// ControlInsecure allows ControlAddr without ControlToken, leaving the
+	// control endpoints unauthenticated
+	ControlInsecure bool
+	// MaxMessageBytes is the largest message published; bigger ones are
+	// dropped so they can't fail the rest of the batch. Keep it at or below
+	// the topic's max.message.bytes.
+	MaxMessageBytes int
+	// MessageFormat is json, avro or protobuf; avro writes Confluent-framed
+	// records using a schema registered at SchemaRegistryURL, protobuf
+	// follows proto/gpu_metric.proto
+	MessageFormat     string
+	SchemaRegistryURL string
+	// MessageBatching is gpu (one message per GPU sample) or node (a
+	// node's whole metric set as one JSON array, which compresses far
+	// better); node requires MESSAGE_FORMAT=json
+	MessageBatching string
+	// KafkaClientID identifies this instance to the brokers, in their
+	// request logs and quotas; defaults to gpu-collector-<hostname>
+	KafkaClientID string
+	// LogSampleInterval limits routine per-cycle log lines to one per
+	// interval per message type; zero logs every line
+	LogSampleInterval time.Duration
+	// LogRedactFields names fields whose values are masked in log output
+	// (e.g. hostname, datacenter) before the logs leave the host
+	LogRedactFields []string
+	// ScrapeTimeout bounds each DCGM exporter request. The scrape client's
+	// pool keeps up to ScrapeMaxIdleConnsPerHost connections per exporter,
+	// ScrapeMaxIdleConns in total, open for reuse across cycles.
+	ScrapeTimeout             time.Duration
+	ScrapeMaxIdleConns        int
+	ScrapeMaxIdleConnsPerHost int
+	ScrapeIdleConnTimeout     time.Duration
+	// CollectOnStartup collects from every node as soon as Run starts rather
+	// than waiting for the first tick
+	CollectOnStartup bool
+	// StartupWarmup is how long Run waits, before its first collection, for
+	// every DCGM exporter to return a complete scrape, probing every
+	// StartupProbeInterval; exporters still not ready are collected anyway.
+	// Zero skips the wait.
+	StartupWarmup        time.Duration
+	StartupProbeInterval time.Duration
+	// DedupeWindow skips publishing a GPU sample whose readings match the
+	// last one published for that GPU less than this long ago; zero disables it
+	DedupeWindow time.Duration
+	// SimulationSeed seeds the per-node generators behind simulated nodes so
+	// runs are reproducible; zero seeds from the clock
+	SimulationSeed int64
+	// SimulationProfile is the profile simulated nodes use unless their
+	// node config names another. SimulationProfilesFile is a JSON object
+	// of profiles that add to or override the built-in ones.
+	SimulationProfile      string
+	SimulationProfilesFile string
+	// SpoolDir holds batches that failed to reach Kafka until they can be
+	// replayed; empty disables spooling. SpoolMaxBytes bounds it, dropping
+	// the oldest batches first.
+	SpoolDir           string
+	SpoolMaxBytes      int64
+	SpoolFlushInterval time.Duration
+	// InternalAddr is the operator-only HTTP listen address (e.g. 127.0.0.1:6060);
+	// empty disables it. PprofEnabled serves /debug/pprof there.
+	InternalAddr string
+	PprofEnabled bool
+	// EngineHealthURL is the alert engine's internal /health endpoint; when
+	// set, the poll interval doubles (up to BackpressureMaxFactor times the
+	// base) while its consumer lag is at or above BackpressureLagHigh and
+	// halves back once lag falls to BackpressureLagLow. Empty disables it.
+	EngineHealthURL       string
+	BackpressureLagHigh   int
+	BackpressureLagLow    int
+	BackpressureMaxFactor int
+	// ExpectedGPUsURL is the API base, e.g. http://api-server:8080/api/v1,
+	// each node's expected_gpu_count is read from every minute: simulated
+	// nodes get that many GPUs and DCGM scrapes returning fewer are counted
+	// as short. Empty disables it.
+	ExpectedGPUsURL string
+	// Sink is where metrics are published: kafka, file, or both. The file
+	// sink appends one JSON metric per line to SinkFile, whatever the
+	// MessageFormat, renaming it to SinkFile.1 once it would pass
+	// SinkFileMaxBytes.
+	Sink             string
+	SinkFile         string
+	SinkFileMaxBytes int64
+	// TopicCheck verifies at startup that the topic exists with at least
+	// TopicPartitions partitions (any count when zero), failing startup
+	// otherwise, since the writer never auto-creates it. TopicAutoCreate
+	// turns the check on and creates a missing topic with TopicPartitions
+	// and TopicReplicationFactor instead.
+	TopicCheck             bool
+	TopicPartitions        int
+	TopicAutoCreate        bool
+	TopicReplicationFactor int
+}
+
+// LoadConfig reads configuration from environment variables, falling back to
+// local development defaults
+func LoadConfig() (Config, error) {
+	cfg := Config{
+		NodesFile:    getEnv("NODES_CONFIG", ""),
+		KafkaBrokers: splitList(getEnv("KAFKA_BROKERS", "localhost:9093")),
+		PartitionKey: getEnv("KAFKA_PARTITION_KEY", keyByGPU),
+		Balancer:     getEnv("KAFKA_BALANCER", "least_bytes"),
+		ControlAddr:  getEnv("CONTROL_ADDR", ""),
+		ControlToken: getEnv("CONTROL_TOKEN", ""),
+
+		KafkaClientID: getEnv("KAFKA_CLIENT_ID", defaultClientID("gpu-collector")),
+
+		MessageFormat:     getEnv("MESSAGE_FORMAT", formatJSON),
+		SchemaRegistryURL: getEnv("SCHEMA_REGISTRY_URL", ""),
+		MessageBatching:   getEnv("MESSAGE_BATCHING", batchByGPU),
+
+		Sink:     getEnv("SINK", sinkKafka),
+		SinkFile: getEnv("SINK_FILE", ""),
+	}
+
+	if len(cfg.KafkaBrokers) == 0 {
+		return cfg, fmt.Errorf("KAFKA_BROKERS must list at least one broker")
+	}
+
+	var err error
+	if cfg.BatchTimeout, err = getEnvDuration("KAFKA_BATCH_TIMEOUT", 10*time.Millisecond); err != nil {
+		return cfg, err
+	}
+	if cfg.BatchSize, err = getEnvInt("KAFKA_BATCH_SIZE", 100); err != nil {
+		return cfg, err
+	}
+	if cfg.BatchSize <= 0 {
+		return cfg, fmt.Errorf("KAFKA_BATCH_SIZE must be positive")
+	}
+	if cfg.KafkaWriters, err = getEnvInt("KAFKA_WRITERS", 1); err != nil {
+		return cfg, err
+	}
+	if cfg.KafkaWriters < 1 || cfg.KafkaWriters > maxKafkaWriters {
+		return cfg, fmt.Errorf("KAFKA_WRITERS must be 1-%d", maxKafkaWriters)
+	}
+	if cfg.RequiredAcks, err = parseRequiredAcks(getEnv("KAFKA_REQUIRED_ACKS", "one")); err != nil {
+		return cfg, err
+	}
+	if cfg.Compression, err = parseCompression(getEnv("KAFKA_COMPRESSION", "none")); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxMessageBytes, err = getEnvInt("KAFKA_MAX_MESSAGE_BYTES", 1048576); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxMessageBytes <= 0 {
+		return cfg, fmt.Errorf("KAFKA_MAX_MESSAGE_BYTES must be positive")
+	}
+	if cfg.LogSampleInterval, err = getEnvDuration("LOG_SAMPLE_INTERVAL", 0); err != nil {
+		return cfg, err
+	}
+	cfg.LogRedactFields = splitList(getEnv("LOG_REDACT_FIELDS", ""))
+	if cfg.LogSampleInterval < 0 {
+		return cfg, fmt.Errorf("LOG_SAMPLE_INTERVAL must not be negative")
+	}
+	if cfg.ScrapeTimeout, err = getEnvDuration("SCRAPE_TIMEOUT", 5*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.ScrapeMaxIdleConns, err = getEnvInt("SCRAPE_MAX_IDLE_CONNS", 256); err != nil {
+		return cfg, err
+	}
+	if cfg.ScrapeMaxIdleConnsPerHost, err = getEnvInt("SCRAPE_MAX_IDLE_CONNS_PER_HOST", 2); err != nil {
+		return cfg, err
+	}
+	if cfg.ScrapeIdleConnTimeout, err = getEnvDuration("SCRAPE_IDLE_CONN_TIMEOUT", 90*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.ScrapeTimeout <= 0 || cfg.ScrapeIdleConnTimeout <= 0 {
+		return cfg, fmt.Errorf("SCRAPE_TIMEOUT and SCRAPE_IDLE_CONN_TIMEOUT must be positive")
+	}
+	if cfg.ScrapeMaxIdleConns <= 0 || cfg.ScrapeMaxIdleConnsPerHost <= 0 {
+		return cfg, fmt.Errorf("SCRAPE_MAX_IDLE_CONNS and SCRAPE_MAX_IDLE_CONNS_PER_HOST must be positive")
+	}
+	if cfg.CollectOnStartup, err = getEnvBool("COLLECT_ON_STARTUP", true); err != nil {
+		return cfg, err
+	}
+	if cfg.StartupWarmup, err = getEnvDuration("STARTUP_WARMUP", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.StartupProbeInterval, err = getEnvDuration("STARTUP_PROBE_INTERVAL", 2*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.StartupWarmup < 0 {
+		return cfg, fmt.Errorf("STARTUP_WARMUP must not be negative")
+	}
+	if cfg.StartupProbeInterval <= 0 {
+		return cfg, fmt.Errorf("STARTUP_PROBE_INTERVAL must be positive")
+	}
+	if cfg.DedupeWindow, err = getEnvDuration("DEDUPE_WINDOW", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.DedupeWindow < 0 {
+		return cfg, fmt.Errorf("DEDUPE_WINDOW must not be negative")
+	}
+	seed, err := getEnvInt("SIMULATION_SEED", 0)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.SimulationSeed = int64(seed)
+	cfg.SimulationProfile = getEnv("SIMULATION_PROFILE", "uniform")
+	cfg.SimulationProfilesFile = getEnv("SIMULATION_PROFILES", "")
+	cfg.SpoolDir = getEnv("SPOOL_DIR", "")
+	spoolMaxMB, err := getEnvInt("SPOOL_MAX_MB", 100)
+	if err != nil {
+		return cfg, err
+	}
+	if spoolMaxMB <= 0 {
+		return cfg, fmt.Errorf("SPOOL_MAX_MB must be positive")
+	}
+	cfg.SpoolMaxBytes = int64(spoolMaxMB) << 20
+	if cfg.SpoolFlushInterval, err = getEnvDuration("SPOOL_FLUSH_INTERVAL", 5*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.SpoolFlushInterval <= 0 {
+		return cfg, fmt.Errorf("SPOOL_FLUSH_INTERVAL must be positive")
+	}
+	cfg.InternalAddr = getEnv("INTERNAL_ADDR", "")
+	if cfg.PprofEnabled, err = getEnvBool("PPROF_ENABLED", false); err != nil {
+		return cfg, err
+	}
+	if cfg.PprofEnabled && cfg.InternalAddr == "" {
+		return cfg, fmt.Errorf("PPROF_ENABLED requires INTERNAL_ADDR")
+	}
+	cfg.EngineHealthURL = getEnv("ENGINE_HEALTH_URL", "")
+	cfg.ExpectedGPUsURL = getEnv("EXPECTED_GPUS_URL", "")
+	if cfg.BackpressureLagHigh, err = getEnvInt("BACKPRESSURE_LAG_HIGH", 10000); err != nil {
+		return cfg, err
+	}
+	if cfg.BackpressureLagLow, err = getEnvInt("BACKPRESSURE_LAG_LOW", 1000); err != nil {
+		return cfg, err
+	}
+	if cfg.BackpressureLagLow < 0 || cfg.BackpressureLagLow >= cfg.BackpressureLagHigh {
+		return cfg, fmt.Errorf("BACKPRESSURE_LAG_LOW must be non-negative and below BACKPRESSURE_LAG_HIGH")
+	}
+	if cfg.BackpressureMaxFactor, err = getEnvInt("BACKPRESSURE_MAX_FACTOR", 8); err != nil {
+		return cfg, err
+	}
+	if cfg.BackpressureMaxFactor < 1 {
+		return cfg, fmt.Errorf("BACKPRESSURE_MAX_FACTOR must be at least 1")
+	}
+	sinkMaxMB, err := getEnvInt("SINK_FILE_MAX_MB", 100)
+	if err != nil {
+		return cfg, err
+	}
+	if sinkMaxMB <= 0 {
+		return cfg, fmt.Errorf("SINK_FILE_MAX_MB must be positive")
+	}
+	cfg.SinkFileMaxBytes = int64(sinkMaxMB) << 20
+
+	switch cfg.PartitionKey {
+	case keyByGPU, keyByNode, keyNone:
+	default:
+		return cfg, fmt.Errorf("KAFKA_PARTITION_KEY must be one of gpu, node, none; got %q", cfg.PartitionKey)
+	}
+	if _, err := newBalancer(cfg.Balancer); err != nil {
+		return cfg, err
+	}
+	switch cfg.MessageFormat {
+	case formatJSON, formatProtobuf:
+	case formatAvro:
+		if cfg.SchemaRegistryURL == "" {
+			return cfg, fmt.Errorf("SCHEMA_REGISTRY_URL is required when MESSAGE_FORMAT=avro")
+		}
+	default:
+		return cfg, fmt.Errorf("MESSAGE_FORMAT must be one of json, avro, protobuf; got %q", cfg.MessageFormat)
+	}
+	switch cfg.MessageBatching {
+	case batchByGPU:
+	case batchByNode:
+		if cfg.MessageFormat != formatJSON {
+			return cfg, fmt.Errorf("MESSAGE_BATCHING=node requires MESSAGE_FORMAT=json")
+		}
+	default:
+		return cfg, fmt.Errorf("MESSAGE_BATCHING must be one of gpu, node; got %q", cfg.MessageBatching)
+	}
+	if cfg.TopicCheck, err = getEnvBool("KAFKA_TOPIC_CHECK", false); err != nil {
+		return cfg, err
+	}
+	if cfg.TopicPartitions, err = getEnvInt("KAFKA_TOPIC_PARTITIONS", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.TopicAutoCreate, err = getEnvBool("KAFKA_TOPIC_AUTO_CREATE", false); err != nil {
+		return cfg, err
+	}
+	if cfg.TopicReplicationFactor, err = getEnvInt("KAFKA_TOPIC_REPLICATION_FACTOR", 1); err != nil {
+		return cfg, err
+	}
+	if cfg.TopicPartitions < 0 || cfg.TopicReplicationFactor < 1 {
+		return cfg, fmt.Errorf("KAFKA_TOPIC_PARTITIONS must not be negative and KAFKA_TOPIC_REPLICATION_FACTOR must be at least 1")
+	}
+	if cfg.TopicAutoCreate {
+		if cfg.TopicPartitions == 0 {
+			return cfg, fmt.Errorf("KAFKA_TOPIC_AUTO_CREATE requires KAFKA_TOPIC_PARTITIONS")
+		}
+		cfg.TopicCheck = true
+	}
+	switch cfg.Sink {
+	case sinkKafka:
+	case sinkFile, sinkBoth:
+		if cfg.SinkFile == "" {
+			return cfg, fmt.Errorf("SINK_FILE is required when SINK=%s", cfg.Sink)
+		}
+	default:
+		return cfg, fmt.Errorf("SINK must be one of kafka, file, both; got %q", cfg.Sink)
+	}
+	if cfg.ControlInsecure, err = getEnvBool("CONTROL_INSECURE", false); err != nil {
+		return cfg, err
+	}
+	if cfg.ControlAddr != "" && cfg.ControlToken == "" {
+		if !cfg.ControlInsecure {
+			return cfg, fmt.Errorf("CONTROL_ADDR requires CONTROL_TOKEN; set CONTROL_INSECURE=true to serve the control endpoints unauthenticated")
+		}
+		log.Println("WARNING: control server enabled without CONTROL_TOKEN, endpoints are unauthenticated")
+	}
+	return cfg, nil
+}
+
+// Message key strategies. Keys only preserve ordering when paired with the
+// hash balancer: all messages with the same key land on the same partition.
+const (
+	keyByGPU  = "gpu"  // one key per GPU, ordered per GPU
+	keyByNode = "node" // one key per node, ordered across a node's GPUs
+	keyNone   = "none" // no key, spread freely across partitions
+)
+
+// Message batching modes
+const (
+	batchByGPU  = "gpu"  // one message per GPU sample
+	batchByNode = "node" // one JSON array message per node per cycle
+)
+
+// Metric sinks
+const (
+	sinkKafka = "kafka" // publish to the gpu-telemetry topic
+	sinkFile  = "file"  // append NDJSON to SinkFile only
+	sinkBoth  = "both"  // both of the above
+)
+
+// Message value formats
+const (
+	formatJSON     = "json"
+	formatAvro     = "avro"
+	formatProtobuf = "protobuf"
+)
+
+// contentTypeHeader names the Kafka header that tells consumers how a
+// message value is encoded, so producers can switch formats one at a time
+const contentTypeHeader = "content-type"
+
+// nodeIDHeader names the Kafka header carrying the node a message's metrics
+// came from, so a replayed spool segment can be attributed without decoding
+// values or relying on the partition key
+const nodeIDHeader = "node-id"
+
+// contentTypes maps message formats to their content-type header values
+var contentTypes = map[string]string{
+	formatJSON:     "application/json",
+	formatAvro:     "application/vnd.apache.avro+binary",
+	formatProtobuf: "application/x-protobuf",
+}
+
+// gpuMetricAvroSchema is the Avro schema for GPUMetric. Field order defines
+// the binary layout written by encodeAvroMetric.
+const gpuMetricAvroSchema = `{
+  "type": "record",
+  "name": "GPUMetric",
+  "namespace": "gpu_telemetry",
+  "fields": [
+    {"name": "node_id", "type": "string"},
+    {"name": "gpu_index", "type": "int"},
+    {"name": "temperature_celsius", "type": "double"},
+    {"name": "power_watts", "type": "double"},
+    {"name": "memory_used_mb", "type": "double"},
+    {"name": "memory_total_mb", "type": "double"},
+    {"name": "utilization_percent", "type": "double"},
+    {"name": "sm_clock_mhz", "type": "int"},
+    {"name": "fan_speed_percent", "type": "double", "default": 0},
+    {"name": "row_remap_pending", "type": "int", "default": 0},
+    {"name": "collected_at", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "labels", "type": {"type": "map", "values": "string"}, "default": {}},
+    {"name": "enforced_power_limit_watts", "type": "double", "default": 0},
+    {"name": "gpu_uuid", "type": "string", "default": ""},
+    {"name": "unreported", "type": {"type": "array", "items": "string"}, "default": []},
+    {"name": "histograms", "type": ["null", {"type": "map", "values": {
+      "type": "record",
+      "name": "Histogram",
+      "fields": [
+        {"name": "buckets", "type": {"type": "array", "items": {
+          "type": "record",
+          "name": "HistogramBucket",
+          "fields": [
+            {"name": "le", "type": "double"},
+            {"name": "count", "type": "double"}
+          ]
+        }}},
+        {"name": "count", "type": "double"},
+        {"name": "sum", "type": "double"}
+      ]
+    }}], "default": null}
+  ]
+}`
+
+// registerSchema registers the GPUMetric schema under subject and returns its
+// registry ID. Registering an identical schema again returns the existing ID.
+func registerSchema(registryURL, subject string) (int, error) {
+	body, _ := json.Marshal(map[string]string{"schema": gpuMetricAvroSchema})
+	url := strings.TrimRight(registryURL, "/") + "/subjects/" + subject + "/versions"
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/vnd.schemaregistry.v1+json", bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("schema registry request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("schema registry returned HTTP %d for subject %s", resp.StatusCode, subject)
+	}
+
+	var result struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to decode schema registry response: %w", err)
+	}
+	return result.ID, nil
+}
+
+// encodeAvroMetric writes metric as a Confluent-framed Avro record: a zero
+// magic byte, the 4-byte big-endian schema ID, then the Avro binary body.
+func encodeAvroMetric(schemaID int, metric GPUMetric) []byte {
+	buf := make([]byte, 5, 128)
+	binary.BigEndian.PutUint32(buf[1:5], uint32(schemaID))
+
+	buf = appendAvroString(buf, metric.NodeID)
+	buf = binary.AppendVarint(buf, int64(metric.GPUIndex))
+	buf = appendAvroDouble(buf, metric.TemperatureCelsius)
+	buf = appendAvroDouble(buf, metric.PowerWatts)
+	buf = appendAvroDouble(buf, metric.MemoryUsedMB)
+	buf = appendAvroDouble(buf, metric.MemoryTotalMB)
+	buf = appendAvroDouble(buf, metric.UtilizationPercent)
+	buf = binary.AppendVarint(buf, int64(metric.SMClockMHz))
+	buf = appendAvroDouble(buf, metric.FanSpeedPercent)
+	buf = binary.AppendVarint(buf, int64(metric.RowRemapPending))
+	buf = binary.AppendVarint(buf, metric.CollectedAt.UnixMilli())
+	buf = appendAvroStringMap(buf, metric.Labels)
+	buf = appendAvroDouble(buf, metric.EnforcedPowerLimitWatts)
+	buf = appendAvroString(buf, metric.GPUUUID)
+	buf = appendAvroStringArray(buf, metric.Unreported)
+	buf = appendAvroHistograms(buf, metric.Histograms)
+	return buf
+}
+
+// appendAvroHistograms appends the nullable map of Histogram records: the
+// null branch when there are none, else the map as a single block
+func appendAvroHistograms(buf []byte, histograms map[string]Histogram) []byte {
+	if len(histograms) == 0 {
+		return binary.AppendVarint(buf, 0)
+	}
+	buf = binary.AppendVarint(buf, 1)
+	keys := make([]string, 0, len(histograms))
+	for k := range histograms {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf = binary.AppendVarint(buf, int64(len(keys)))
+	for _, k := range keys {
+		h := histograms[k]
+		buf = appendAvroString(buf, k)
+		if len(h.Buckets) > 0 {
+			buf = binary.AppendVarint(buf, int64(len(h.Buckets)))
+			for _, b := range h.Buckets {
+				buf = appendAvroDouble(buf, b.LE)
+				buf = appendAvroDouble(buf, b.Count)
+			}
+		}
+		buf = binary.AppendVarint(buf, 0)
+		buf = appendAvroDouble(buf, h.Count)
+		buf = appendAvroDouble(buf, h.Sum)
+	}
+	return binary.AppendVarint(buf, 0)
+}
+
+// appendAvroStringArray appends an Avro array<string> as a single block
+// followed by the zero-count terminator
+func appendAvroStringArray(buf []byte, items []string) []byte {
+	if len(items) > 0 {
+		buf = binary.AppendVarint(buf, int64(len(items)))
+		for _, item := range items {
+			buf = appendAvroString(buf, item)
+		}
+	}
+	return binary.AppendVarint(buf, 0)
+}
+
+// appendAvroStringMap appends an Avro map<string> as a single block of
+// key/value pairs followed by the zero-count terminator
+func appendAvroStringMap(buf []byte, m map[string]string) []byte {
+	if len(m) > 0 {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf = binary.AppendVarint(buf, int64(len(m)))
+		for _, k := range keys {
+			buf = appendAvroString(buf, k)
+			buf = appendAvroString(buf, m[k])
+		}
+	}
+	return binary.AppendVarint(buf, 0)
+}
+
+// appendAvroString appends an Avro string: zigzag length then UTF-8 bytes
+func appendAvroString(buf []byte, s string) []byte {
+	buf = binary.AppendVarint(buf, int64(len(s)))
+	return append(buf, s...)
+}
+
+// appendAvroDouble appends an Avro double: 8 bytes little-endian
+func appendAvroDouble(buf []byte, f float64) []byte {
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(f))
+}
+
+// protoMetric converts metric to the gpupb.GPUMetric generated from
+// proto/gpu_metric.proto
+func protoMetric(metric GPUMetric) *gpupb.GPUMetric {
+	pm := &gpupb.GPUMetric{
+		NodeId:                  metric.NodeID,
+		GpuIndex:                int32(metric.GPUIndex),
+		TemperatureCelsius:      metric.TemperatureCelsius,
+		PowerWatts:              metric.PowerWatts,
+		MemoryUsedMb:            metric.MemoryUsedMB,
+		MemoryTotalMb:           metric.MemoryTotalMB,
+		UtilizationPercent:      metric.UtilizationPercent,
+		SmClockMhz:              int32(metric.SMClockMHz),
+		FanSpeedPercent:         metric.FanSpeedPercent,
+		RowRemapPending:         int32(metric.RowRemapPending),
+		CollectedAt:             metric.CollectedAt.UnixNano(),
+		Labels:                  metric.Labels,
+		EnforcedPowerLimitWatts: metric.EnforcedPowerLimitWatts,
+		GpuUuid:                 metric.GPUUUID,
+		Unreported:              metric.Unreported,
+	}
+	if len(metric.Histograms) > 0 {
+		pm.Histograms = make(map[string]*gpupb.Histogram, len(metric.Histograms))
+		for name, h := range metric.Histograms {
+			ph := &gpupb.Histogram{Count: h.Count, Sum: h.Sum}
+			for _, b := range h.Buckets {
+				ph.Buckets = append(ph.Buckets, &gpupb.HistogramBucket{Le: b.LE, Count: b.Count})
+			}
+			pm.Histograms[name] = ph
+		}
+	}
+	return pm
+}
+
+// encodeProtoMetric writes metric as a proto/gpu_metric.proto GPUMetric,
+// with map entries in key order so equal metrics encode identically
+func encodeProtoMetric(metric GPUMetric) ([]byte, error) {
+	return proto.MarshalOptions{Deterministic: true}.Marshal(protoMetric(metric))
+}
+
+// newBalancer maps a balancer name to a kafka-go Balancer
+func newBalancer(name string) (kafka.Balancer, error) {
+	switch name {
+	case "least_bytes":
+		return &kafka.LeastBytes{}, nil
+	case "hash":
+		return &kafka.Hash{}, nil
+	case "round_robin":
+		return &kafka.RoundRobin{}, nil
+	default:
+		return nil, fmt.Errorf("KAFKA_BALANCER must be one of least_bytes, hash, round_robin; got %q", name)
+	}
+}
+
+// parseRequiredAcks maps none/one/all to kafka-go RequiredAcks
+func parseRequiredAcks(v string) (kafka.RequiredAcks, error) {
+	switch v {
+	case "none":
+		return kafka.RequireNone, nil
+	case "one":
+		return kafka.RequireOne, nil
+	case "all":
+		return kafka.RequireAll, nil
+	default:
+		return 0, fmt.Errorf("KAFKA_REQUIRED_ACKS must be one of none, one, all; got %q", v)
+	}
+}
+
+// parseCompression maps none/gzip/snappy/lz4/zstd to a kafka-go codec
+func parseCompression(v string) (kafka.Compression, error) {
+	switch v {
+	case "none", "gzip", "snappy", "lz4", "zstd":
+	default:
+		return 0, fmt.Errorf("KAFKA_COMPRESSION must be one of none, gzip, snappy, lz4, zstd; got %q", v)
+	}
+	var c kafka.Compression
+	err := c.UnmarshalText([]byte(v))
+	return c, err
+}
+
+// getEnv returns the value of an environment variable or a default
+func getEnv(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
+
+// getEnvInt parses an integer environment variable
+func getEnvInt(key string, fallback int) (int, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fallback, fmt.Errorf("%s: invalid integer %q", key, v)
+	}
+	return n, nil
+}
+
+// getEnvBool parses a boolean environment variable
+func getEnvBool(key string, fallback bool) (bool, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fallback, fmt.Errorf("%s: invalid boolean %q", key, v)
+	}
+	return b, nil
+}
+
+// getEnvDuration parses a Go duration environment variable (e.g. "30s", "24h")
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fallback, fmt.Errorf("%s: invalid duration %q", key, v)
+	}
+	return d, nil
+}
+
+// defaultClientID names a Kafka client after the service and host, so each
+// instance is distinct on the brokers
+func defaultClientID(service string) string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return service
+	}
+	return service + "-" + hostname
+}
+
+// kafkaErrorLogger routes kafka-go's internal errors (failed dials, metadata
+// refreshes, retried writes) to the service log
+func kafkaErrorLogger(component string) kafka.Logger {
+	return kafka.LoggerFunc(func(msg string, args ...interface{}) {
+		log.Printf("kafka %s: %s", component, fmt.Sprintf(msg, args...))
+	})
+}
+
+// splitList parses a comma-separated list, dropping empty entries
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// logSampler rate-limits repetitive log lines. Each key logs at most once
+// per interval; the next line that gets through reports how many were dropped.
+type logSampler struct {
+	interval time.Duration
+
+	mu         sync.Mutex
+	last       map[string]time.Time
+	suppressed map[string]int
+}
+
+func newLogSampler(interval time.Duration) *logSampler {
+	return &logSampler{
+		interval:   interval,
+		last:       make(map[string]time.Time),
+		suppressed: make(map[string]int),
+	}
+}
+
+// Printf logs the message unless another line with the same key was logged
+// within the sampling interval
+func (l *logSampler) Printf(key, format string, args ...interface{}) {
+	if l.interval <= 0 {
+		log.Printf(format, args...)
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if now.Sub(l.last[key]) < l.interval {
+		l.suppressed[key]++
+		l.mu.Unlock()
+		return
+	}
+	dropped := l.suppressed[key]
+	l.last[key] = now
+	l.suppressed[key] = 0
+	l.mu.Unlock()
+
+	msg := fmt.Sprintf(format, args...)
+	if dropped > 0 {
+		msg += fmt.Sprintf(" (%d similar lines suppressed)", dropped)
+	}
+	log.Print(msg)
+}
+
+// redactedValue replaces masked values in log output
+const redactedValue = "[REDACTED]"
+
+// logRedactor is the log output when LOG_REDACT_FIELDS is set. It masks the
+// value after each configured field name (field=value, field: value or
+// "field":"value"), and every known value of those fields taken from the
+// node config, since scrape errors quote exporter URLs verbatim.
+type logRedactor struct {
+	out    io.Writer
+	fields []string
+	keyed  *regexp.Regexp
+
+	mu     sync.RWMutex
+	values []string
+}
+
+func newLogRedactor(out io.Writer, fields []string) *logRedactor {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = regexp.QuoteMeta(f)
+	}
+	return &logRedactor{
+		out:    out,
+		fields: fields,
+		keyed:  regexp.MustCompile(`((?:\b|")(?:` + strings.Join(names, "|") + `)"?\s*[=:]\s*)(?:(")[^"]*"|[^\s,;}]+)`),
+	}
+}
+
+// setNodes replaces the known values with those in the node config: the
+// exporter host for hostname, and node/GPU label values for other fields
+func (l *logRedactor) setNodes(nodes []NodeConfig) {
+	var values []string
+	for _, n := range nodes {
+		for _, f := range l.fields {
+			if f == "hostname" && n.DCGMURL != "" {
+				if u, err := url.Parse(n.DCGMURL); err == nil && u.Hostname() != "" {
+					values = append(values, u.Hostname())
+				}
+			}
+			if v := n.Labels[f]; v != "" {
+				values = append(values, v)
+			}
+			for _, labels := range n.GPULabels {
+				if v := labels[f]; v != "" {
+					values = append(values, v)
+				}
+			}
+		}
+	}
+	// Longest first so a value containing another is masked whole
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	l.mu.Lock()
+	l.values = values
+	l.mu.Unlock()
+}
+
+// Write masks one log line and passes it on
+func (l *logRedactor) Write(p []byte) (int, error) {
+	line := l.keyed.ReplaceAllString(string(p), "${1}${2}"+redactedValue+"${2}")
+	l.mu.RLock()
+	for _, v := range l.values {
+		line = strings.ReplaceAll(line, v, redactedValue)
+	}
+	l.mu.RUnlock()
+	if _, err := io.WriteString(l.out, line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// spool is an on-disk queue of Kafka batches that could not be written. Each
+// batch is one append-only segment file, replayed and removed oldest first.
+type spool struct {
+	dir      string
+	maxBytes int64
+
+	mu       sync.Mutex
+	seq      uint64
+	segments []spoolSegment // oldest first
+	size     int64
+
+	spooled atomic.Int64 // messages written to the spool
+	dropped atomic.Int64 // messages discarded because the spool was full
+}
+
+// spoolSegment is one spooled batch on disk
+type spoolSegment struct {
+	path     string
+	size     int64
+	messages int
+}
+
+// openSpool opens dir as a spool, picking up segments left by a previous run
+func openSpool(dir string, maxBytes int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spool dir: %w", err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.spool"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	s := &spool{dir: dir, maxBytes: maxBytes}
+	for _, path := range paths {
+		messages, err := readSpoolSegment(path)
+		if err != nil {
+			log.Printf("Discarding unreadable spool segment %s: %v", path, err)
+			os.Remove(path)
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, spoolSegment{path: path, size: info.Size(), messages: len(messages)})
+		s.size += info.Size()
+
+		seq, _ := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), ".spool"), 10, 64)
+		if seq >= s.seq {
+			s.seq = seq + 1
+		}
+	}
+
+	if len(s.segments) > 0 {
+		log.Printf("Spool %s holds %d batches (%d bytes) from a previous run", dir, len(s.segments), s.size)
+	}
+	return s, nil
+}
+
+// Append writes messages as a new segment, dropping the oldest segments
+// when the spool would exceed maxBytes
+func (s *spool) Append(messages []kafka.Message) error {
+	buf := encodeSpoolSegment(messages)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.segments) > 0 && s.size+int64(len(buf)) > s.maxBytes {
+		oldest := s.segments[0]
+		os.Remove(oldest.path)
+		s.segments = s.segments[1:]
+		s.size -= oldest.size
+		s.dropped.Add(int64(oldest.messages))
+		log.Printf("Spool full, dropped oldest batch of %d metrics", oldest.messages)
+	}
+
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d.spool", s.seq))
+	s.seq++
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		return fmt.Errorf("failed to write spool segment: %w", err)
+	}
+	s.segments = append(s.segments, spoolSegment{path: path, size: int64(len(buf)), messages: len(messages)})
+	s.size += int64(len(buf))
+	s.spooled.Add(int64(len(messages)))
+	return nil
+}
+
+// Replace rewrites a segment to hold only messages, e.g. those a partial
+// replay failed to deliver. It is a no-op if Append already dropped the
+// segment to make room.
+func (s *spool) Replace(seg spoolSegment, messages []kafka.Message) error {
+	buf := encodeSpoolSegment(messages)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, cur := range s.segments {
+		if cur.path != seg.path {
+			continue
+		}
+		// Write aside and rename so a crash can't leave a torn segment
+		tmp := seg.path + ".tmp"
+		if err := os.WriteFile(tmp, buf, 0o644); err != nil {
+			return fmt.Errorf("failed to rewrite spool segment: %w", err)
+		}
+		if err := os.Rename(tmp, seg.path); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("failed to rewrite spool segment: %w", err)
+		}
+		s.size += int64(len(buf)) - cur.size
+		s.segments[i].size = int64(len(buf))
+		s.segments[i].messages = len(messages)
+		return nil
+	}
+	return nil
+}
+
+// spoolMagic starts every segment, followed by its format version
+var spoolMagic = []byte{0xff, 'S', 'P', 'L'}
+
+// spoolVersionHeaders is the segment format version carrying each
+// message's headers
+const spoolVersionHeaders = 2
+
+// encodeSpoolSegment serializes messages in the layout readSpoolSegment
+// reads: spoolMagic and a version byte, then each message's key, value,
+// time and headers
+func encodeSpoolSegment(messages []kafka.Message) []byte {
+	buf := append([]byte{}, spoolMagic...)
+	buf = append(buf, spoolVersionHeaders)
+	for _, m := range messages {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(m.Key)))
+		buf = append(buf, m.Key...)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(m.Value)))
+		buf = append(buf, m.Value...)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(m.Time.UnixNano()))
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(m.Headers)))
+		for _, h := range m.Headers {
+			buf = binary.BigEndian.AppendUint32(buf, uint32(len(h.Key)))
+			buf = append(buf, h.Key...)
+			buf = binary.BigEndian.AppendUint32(buf, uint32(len(h.Value)))
+			buf = append(buf, h.Value...)
+		}
+	}
+	return buf
+}
+
+// Oldest returns the oldest spooled segment, if any
+func (s *spool) Oldest() (spoolSegment, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.segments) == 0 {
+		return spoolSegment{}, false
+	}
+	return s.segments[0], true
+}
+
+// Remove deletes a segment once it has been replayed. It is a no-op if
+// Append already dropped the segment to make room.
+func (s *spool) Remove(seg spoolSegment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, cur := range s.segments {
+		if cur.path == seg.path {
+			os.Remove(seg.path)
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			s.size -= seg.size
+			return
+		}
+	}
+}
+
+// Size returns the spool's total size in bytes
+func (s *spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// readSpoolSegment decodes the messages of one segment file
+func readSpoolSegment(path string) ([]kafka.Message, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(data, spoolMagic) || len(data) < len(spoolMagic)+1 {
+		return nil, fmt.Errorf("missing segment header")
+	}
+	if version := data[len(spoolMagic)]; version != spoolVersionHeaders {
+		return nil, fmt.Errorf("unsupported segment version %d", version)
+	}
+	data = data[len(spoolMagic)+1:]
+
+	var messages []kafka.Message
+	for len(data) > 0 {
+		var m kafka.Message
+		if m.Key, data, err = readSpoolBytes(data); err != nil {
+			return nil, err
+		}
+		if m.Value, data, err = readSpoolBytes(data); err != nil {
+			return nil, err
+		}
+		if len(data) < 8 {
+			return nil, fmt.Errorf("truncated segment")
+		}
+		m.Time = time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+		data = data[8:]
+
+		if len(data) < 4 {
+			return nil, fmt.Errorf("truncated segment")
+		}
+		headers := int(binary.BigEndian.Uint32(data))
+		data = data[4:]
+		for j := 0; j < headers; j++ {
+			var key, value []byte
+			if key, data, err = readSpoolBytes(data); err != nil {
+				return nil, err
+			}
+			if value, data, err = readSpoolBytes(data); err != nil {
+				return nil, err
+			}
+			m.Headers = append(m.Headers, kafka.Header{Key: string(key), Value: value})
+		}
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
+
+// readSpoolBytes reads one length-prefixed field
+func readSpoolBytes(data []byte) ([]byte, []byte, error) {
+	if len(data) < 4 {
+		return nil, nil, fmt.Errorf("truncated segment")
+	}
+	n := int(binary.BigEndian.Uint32(data))
+	data = data[4:]
+	if len(data) < n {
+		return nil, nil, fmt.Errorf("truncated segment")
+	}
+	if n == 0 {
+		return nil, data, nil
+	}
+	return data[:n], data[n:], nil
+}
+
+// fileSink appends metrics to a local file as newline-delimited JSON, for
+// hosts without Kafka or to keep a copy next to it. The file is rotated to
+// <path>.1, replacing any previous one, once a write would pass maxBytes.
+type fileSink struct {
+	path     string
+	maxBytes int64
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+
+	written atomic.Int64 // metrics written to the file
+}
+
+// openFileSink opens path for appending, creating it and its directory
+func openFileSink(path string, maxBytes int64) (*fileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create sink file dir: %w", err)
+	}
+	s := &fileSink{path: path, maxBytes: maxBytes}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open (re)opens the sink file; the caller holds mu or owns s
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open sink file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.size = info.Size()
+	return nil
+}
+
+// Write appends one JSON line per metric. A batch is never split across
+// a rotation, so readers see whole lines in either file.
+func (s *fileSink) Write(metrics []GPUMetric) error {
+	var buf []byte
+	for _, m := range metrics {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("failed to marshal metric: %w", err)
+		}
+		buf = append(buf, data...)
+		buf = append(buf, '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size > 0 && s.size+int64(len(buf)) > s.maxBytes {
+		s.f.Close()
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			log.Printf("Failed to rotate sink file %s: %v", s.path, err)
+		}
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.f.Write(buf)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write sink file: %w", err)
+	}
+	s.written.Add(int64(len(metrics)))
+	return nil
+}
+
+// Close closes the sink file
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
 }
 
 // CollectorService handles polling and publishing metrics
 type CollectorService struct {
-	nodes        []string
-	kafkaWriter  *kafka.Writer
+	// nodesMu guards nodes, which are swapped wholesale on reload
+	nodesMu      sync.RWMutex
+	nodes        []NodeConfig
+	kafkaWriters []*kafka.Writer // KafkaWriters shards; see writeMessages
 	pollInterval time.Duration
+	cfg          Config
+	schemaID     int // registry ID of the Avro schema when MessageFormat is avro
+	sampledLog   *logSampler
+	// redactor masks LOG_REDACT_FIELDS in log output; nil when unset. It
+	// learns each reloaded node config's values.
+	redactor *logRedactor
+
+	// dedupeMu guards lastPublished, the last sample published per GPU when
+	// DedupeWindow is set
+	dedupeMu      sync.Mutex
+	lastPublished map[gpuKey]publishedSample
+
+	// spool buffers batches while Kafka is unreachable; nil when SpoolDir is unset
+	spool *spool
+
+	// fileSink receives metrics when Sink is file or both; nil otherwise
+	fileSink *fileSink
+
+	// sources produce each node's metrics; the first that handles a node
+	// is used, with the simulated source last as the fallback
+	sources   []Source
+	simulated *simulatedSource
+
+	// oversized counts messages dropped for exceeding MaxMessageBytes
+	oversized atomic.Int64
+
+	// publishErrors counts batches that failed to reach Kafka (or the spool).
+	// lastPublish is when a write to Kafka last succeeded, in Unix
+	// nanoseconds, and publishMu guards nodePublished, the same per node.
+	// Nodes or a collector that never published count from startedAt.
+	publishErrors atomic.Int64
+	lastPublish   atomic.Int64
+	publishMu     sync.Mutex
+	nodePublished map[string]time.Time
+	startedAt     time.Time
+
+	// currentInterval is the poll interval in effect, in nanoseconds; it
+	// exceeds pollInterval while the alert engine reports backpressure
+	currentInterval atomic.Int64
+
+	// expectedMu guards expectedGPUs, each node's expected_gpu_count read
+	// from ExpectedGPUsURL. shortScrapes counts collections that returned
+	// fewer GPUs than that.
+	expectedMu   sync.RWMutex
+	expectedGPUs map[string]int
+	shortScrapes atomic.Int64
+
+	// writeStats accumulates each Kafka writer's statistics, which kafka-go
+	// resets on every Stats call
+	writeStats []writeStats
+}
+
+// checkTopic verifies topic exists with at least TopicPartitions
+// partitions, creating it when missing if TopicAutoCreate is set. Brokers
+// that can't be reached only log a warning: publishing retries (and
+// spools) as it would for any outage.
+func checkTopic(ctx context.Context, cfg Config, topic string, transport kafka.RoundTripper) error {
+	client := &kafka.Client{Addr: kafka.TCP(cfg.KafkaBrokers...), Timeout: 10 * time.Second, Transport: transport}
+
+	meta, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		log.Printf("WARNING: could not read metadata for topic %q, skipping the topic check: %v", topic, err)
+		return nil
+	}
+	if len(meta.Topics) == 1 && meta.Topics[0].Error == nil {
+		partitions := len(meta.Topics[0].Partitions)
+		if partitions < cfg.TopicPartitions {
+			return fmt.Errorf("topic %q has %d partitions, expected at least %d (KAFKA_TOPIC_PARTITIONS)", topic, partitions, cfg.TopicPartitions)
+		}
+		log.Printf("Topic %q has %d partitions", topic, partitions)
+		return nil
+	}
+	if len(meta.Topics) == 1 && !errors.Is(meta.Topics[0].Error, kafka.UnknownTopicOrPartition) {
+		return fmt.Errorf("failed to read metadata for topic %q: %w", topic, meta.Topics[0].Error)
+	}
+
+	if !cfg.TopicAutoCreate {
+		return fmt.Errorf("topic %q does not exist; create it, or set KAFKA_TOPIC_AUTO_CREATE=true with KAFKA_TOPIC_PARTITIONS", topic)
+	}
+	resp, err := client.CreateTopics(ctx, &kafka.CreateTopicsRequest{
+		Topics: []kafka.TopicConfig{{
+			Topic:             topic,
+			NumPartitions:     cfg.TopicPartitions,
+			ReplicationFactor: cfg.TopicReplicationFactor,
+		}},
+	})
+	if err == nil {
+		err = resp.Errors[topic]
+	}
+	if errors.Is(err, kafka.TopicAlreadyExists) {
+		// Another collector starting at the same time won the race
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create topic %q: %w", topic, err)
+	}
+	log.Printf("Created topic %q with %d partitions (replication factor %d)", topic, cfg.TopicPartitions, cfg.TopicReplicationFactor)
+	return nil
+}
+
+// newScrapeClient builds the pooled HTTP client used for DCGM scraping
+func newScrapeClient(cfg Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = cfg.ScrapeMaxIdleConns
+	transport.MaxIdleConnsPerHost = cfg.ScrapeMaxIdleConnsPerHost
+	transport.IdleConnTimeout = cfg.ScrapeIdleConnTimeout
+	transport.ResponseHeaderTimeout = cfg.ScrapeTimeout
+
+	return &http.Client{Transport: transport, Timeout: cfg.ScrapeTimeout}
+}
+
+// gpuKey identifies a single GPU in the fleet
+type gpuKey struct {
+	nodeID   string
+	gpuIndex int
+}
+
+// publishedSample is a GPU's last published metric and when it was sent
+type publishedSample struct {
+	metric GPUMetric
+	at     time.Time
+}
+
+// sameReadings reports whether two samples carry exactly the same readings
+func sameReadings(a, b GPUMetric) bool {
+	return a.TemperatureCelsius == b.TemperatureCelsius &&
+		a.PowerWatts == b.PowerWatts &&
+		a.MemoryUsedMB == b.MemoryUsedMB &&
+		a.MemoryTotalMB == b.MemoryTotalMB &&
+		a.UtilizationPercent == b.UtilizationPercent &&
+		a.SMClockMHz == b.SMClockMHz &&
+		a.FanSpeedPercent == b.FanSpeedPercent &&
+		a.RowRemapPending == b.RowRemapPending
+}
+
+func NewCollectorService(nodes []NodeConfig, cfg Config) (*CollectorService, error) {
+	if cfg.PartitionKey != keyNone && cfg.Balancer != "hash" {
+		log.Printf("WARNING: partition key %q without the hash balancer does not guarantee per-key ordering", cfg.PartitionKey)
+	}
+
+	// Each writer gets its own balancer and transport, so the shards share
+	// no batching state or connections
+	writers := make([]*kafka.Writer, cfg.KafkaWriters)
+	for i := range writers {
+		balancer, err := newBalancer(cfg.Balancer)
+		if err != nil {
+			return nil, err
+		}
+		name := "writer"
+		if len(writers) > 1 {
+			name = fmt.Sprintf("writer %d", i)
+		}
+		writers[i] = &kafka.Writer{
+			Addr:         kafka.TCP(cfg.KafkaBrokers...),
+			Topic:        "gpu-telemetry",
+			Balancer:     balancer,
+			BatchTimeout: cfg.BatchTimeout,
+			BatchSize:    cfg.BatchSize,
+			RequiredAcks: cfg.RequiredAcks,
+			Compression:  cfg.Compression,
+			// Requests are split to stay under the broker's size limit
+			BatchBytes:  int64(cfg.MaxMessageBytes),
+			Transport:   &kafka.Transport{ClientID: cfg.KafkaClientID},
+			ErrorLogger: kafkaErrorLogger(name),
+		}
+	}
+	writer := writers[0]
+	if len(writers) > 1 {
+		log.Printf("Publishing through %d Kafka writers", len(writers))
+	}
+
+	c := &CollectorService{
+		nodes:        nodes,
+		kafkaWriters: writers,
+		writeStats:   make([]writeStats, len(writers)),
+		pollInterval: 30 * time.Second,
+		cfg:          cfg,
+		sampledLog:   newLogSampler(cfg.LogSampleInterval),
+
+		lastPublished: make(map[gpuKey]publishedSample),
+		nodePublished: make(map[string]time.Time),
+		startedAt:     time.Now(),
+	}
+	c.currentInterval.Store(int64(c.pollInterval))
+
+	seed := cfg.SimulationSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	} else {
+		log.Printf("Simulating metrics with fixed seed %d", seed)
+	}
+	profiles, err := loadSimulationProfiles(cfg.SimulationProfilesFile)
+	if err != nil {
+		return nil, err
+	}
+	sim := &simulatedSource{
+		seed:           seed,
+		nodes:          make(map[string]*nodeRand),
+		profiles:       profiles,
+		defaultProfile: cfg.SimulationProfile,
+	}
+	if _, ok := profiles[cfg.SimulationProfile]; !ok {
+		return nil, fmt.Errorf("SIMULATION_PROFILE %q is not a known profile", cfg.SimulationProfile)
+	}
+	if err := sim.validateNodes(nodes); err != nil {
+		return nil, err
+	}
+	c.simulated = sim
+	c.sources = []Source{
+		&dcgmSource{client: newScrapeClient(cfg)},
+		sim,
+	}
+
+	if cfg.TopicCheck && cfg.Sink != sinkFile {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := checkTopic(ctx, cfg, writer.Topic, writer.Transport)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.MessageFormat == formatAvro && cfg.Sink != sinkFile {
+		subject := writer.Topic + "-value"
+		if c.schemaID, err = registerSchema(cfg.SchemaRegistryURL, subject); err != nil {
+			return nil, err
+		}
+		log.Printf("Publishing Avro messages with schema ID %d (subject %s)", c.schemaID, subject)
+	}
+
+	if cfg.SpoolDir != "" {
+		if c.spool, err = openSpool(cfg.SpoolDir, cfg.SpoolMaxBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Sink != sinkKafka {
+		if c.fileSink, err = openFileSink(cfg.SinkFile, cfg.SinkFileMaxBytes); err != nil {
+			return nil, err
+		}
+		log.Printf("Writing metrics to %s (sink %s)", cfg.SinkFile, cfg.Sink)
+	}
+	return c, nil
+}
+
+// currentNodes returns the node list in effect
+func (c *CollectorService) currentNodes() []NodeConfig {
+	c.nodesMu.RLock()
+	defer c.nodesMu.RUnlock()
+	return c.nodes
+}
+
+// ReloadNodes re-reads NODES_CONFIG and swaps in the new node list, checked
+// as at startup. On error the current list is kept. Returns the number of nodes now configured.
+func (c *CollectorService) ReloadNodes() (int, error) {
+	if c.cfg.NodesFile == "" {
+		return len(c.currentNodes()), fmt.Errorf("NODES_CONFIG is not set")
+	}
+
+	nodes, err := loadNodeConfig(c.cfg.NodesFile)
+	if err != nil {
+		return len(c.currentNodes()), err
+	}
+	if err := c.simulated.validateNodes(nodes); err != nil {
+		return len(c.currentNodes()), err
+	}
+
+	c.nodesMu.Lock()
+	c.nodes = nodes
+	c.nodesMu.Unlock()
+	if c.redactor != nil {
+		c.redactor.setNodes(nodes)
+	}
+
+	log.Printf("Reloaded node config from %s: %d nodes", c.cfg.NodesFile, len(nodes))
+	return len(nodes), nil
+}
+
+// watchReloadSignal reloads the node config on every SIGHUP until ctx is cancelled
+func (c *CollectorService) watchReloadSignal(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if _, err := c.ReloadNodes(); err != nil {
+				log.Printf("Failed to reload node config, keeping current nodes: %v", err)
+			}
+		}
+	}
+}
+
+// nodeConfig looks up a configured node by ID
+func (c *CollectorService) nodeConfig(nodeID string) (NodeConfig, bool) {
+	for _, n := range c.currentNodes() {
+		if n.NodeID == nodeID {
+			return n, true
+		}
+	}
+	return NodeConfig{}, false
+}
+
+// CollectMetrics collects metrics from a node's DCGM exporter, or simulates
+// them when the node has no exporter URL configured
+func (c *CollectorService) CollectMetrics(ctx context.Context, nodeID string) ([]GPUMetric, error) {
+	node, ok := c.nodeConfig(nodeID)
+	if !ok {
+		return nil, fmt.Errorf("unknown node %s", nodeID)
+	}
+	node.expectedGPUs = c.expectedGPUCount(nodeID)
+	for _, src := range c.sources {
+		if !src.Handles(node) {
+			continue
+		}
+		metrics, err := src.Collect(ctx, node)
+		if err != nil {
+			return nil, fmt.Errorf("%s source: %w", src.Name(), err)
+		}
+		if len(metrics) < node.expectedGPUs {
+			c.shortScrapes.Add(1)
+			c.sampledLog.Printf("short-scrape/"+nodeID, "WARNING: %s reported %d of its %d expected GPUs",
+				nodeID, len(metrics), node.expectedGPUs)
+		}
+		node.applyLabels(metrics)
+		return metrics, nil
+	}
+	return nil, fmt.Errorf("no source handles node %s", nodeID)
+}
+
+// Source produces GPU metrics for the nodes it handles. DCGM exporters and
+// simulation are built in; another scraper is added to CollectorService.sources
+// in NewCollectorService, ahead of the simulated fallback.
+type Source interface {
+	// Name identifies the source in logs and errors
+	Name() string
+	// Handles reports whether the source collects for node
+	Handles(node NodeConfig) bool
+	// Collect returns one metric per GPU on node
+	Collect(ctx context.Context, node NodeConfig) ([]GPUMetric, error)
+}
+
+// dcgmSource scrapes nodes with a DCGM exporter URL
+type dcgmSource struct {
+	// client is shared by all scrapes so connections are reused
+	client *http.Client
+}
+
+func (d *dcgmSource) Name() string { return "dcgm" }
+
+func (d *dcgmSource) Handles(node NodeConfig) bool { return node.DCGMURL != "" }
+
+// Collect fetches a DCGM exporter's Prometheus text output and maps the
+// node's selected fields onto one GPUMetric per GPU
+func (d *dcgmSource) Collect(ctx context.Context, node NodeConfig) ([]GPUMetric, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, node.DCGMURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DCGM exporter URL: %w", err)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scrape DCGM exporter: %w", err)
+	}
+	defer func() {
+		// Drain the body so the connection goes back to the pool
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DCGM exporter returned HTTP %d", resp.StatusCode)
+	}
+
+	selected := make(map[string]bool)
+	for _, f := range node.DCGMFields {
+		selected[f] = true
+	}
+
+	now := time.Now()
+	byGPU := make(map[int]*dcgmGPU)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		name, gpu, uuid, le, value, ok := parseDCGMLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		field, series := histogramSeries(name)
+		setter, supported := dcgmFieldSetters[name]
+		if !supported && series == "" {
+			continue
+		}
+		if series != "" {
+			name = field
+		}
+		if len(selected) > 0 && !selected[name] {
+			continue
+		}
+
+		m, exists := byGPU[gpu]
+		if !exists {
+			m = &dcgmGPU{GPUMetric: GPUMetric{NodeID: node.NodeID, GPUIndex: gpu, CollectedAt: now}, seen: make(map[string]bool)}
+			byGPU[gpu] = m
+		}
+		if m.GPUUUID == "" {
+			m.GPUUUID = uuid
+		}
+		if series != "" {
+			addHistogramSample(&m.GPUMetric, dcgmHistograms[field], series, le, value)
+		} else {
+			setter(m, value)
+			m.seen[name] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read DCGM response: %w", err)
+	}
+
+	metrics := make([]GPUMetric, 0, len(byGPU))
+	for _, g := range byGPU {
+		m := g.metric()
+		for name, h := range m.Histograms {
+			sort.Slice(h.Buckets, func(i, j int) bool { return h.Buckets[i].LE < h.Buckets[j].LE })
+			m.Histograms[name] = h
+		}
+		metrics = append(metrics, m)
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].GPUIndex < metrics[j].GPUIndex })
+	return metrics, nil
+}
+
+// histogramSeries splits a histogram series name such as
+// DCGM_FI_PROF_SM_OCCUPANCY_bucket into its supported field and series
+// suffix; series is empty when name is not a supported histogram series
+func histogramSeries(name string) (field, series string) {
+	for _, suffix := range []string{"_bucket", "_count", "_sum"} {
+		if f, found := strings.CutSuffix(name, suffix); found {
+			if _, ok := dcgmHistograms[f]; ok {
+				return f, suffix
+			}
+		}
+	}
+	return "", ""
+}
+
+// addHistogramSample records one histogram series sample on m
+func addHistogramSample(m *GPUMetric, key, series, le string, value float64) {
+	if m.Histograms == nil {
+		m.Histograms = make(map[string]Histogram)
+	}
+	h := m.Histograms[key]
+	switch series {
+	case "_count":
+		h.Count = value
+	case "_sum":
+		h.Sum = value
+	case "_bucket":
+		// +Inf equals _count, so only finite bounds are kept
+		bound, err := strconv.ParseFloat(le, 64)
+		if err != nil || math.IsInf(bound, 1) {
+			return
+		}
+		h.Buckets = append(h.Buckets, HistogramBucket{LE: bound, Count: value})
+	}
+	m.Histograms[key] = h
+}
+
+// parseDCGMLine parses a Prometheus exposition sample such as
+// `DCGM_FI_DEV_GPU_TEMP{gpu="0",UUID="GPU-..."} 65`; uuid is empty when the
+// exporter omits it, and le is the bucket bound of histogram _bucket samples
+// and empty otherwise
+func parseDCGMLine(line string) (name string, gpu int, uuid, le string, value float64, ok bool) {
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", 0, "", "", 0, false
+	}
+
+	open := strings.IndexByte(line, '{')
+	closing := strings.LastIndexByte(line, '}')
+	if open < 0 || closing < open {
+		return "", 0, "", "", 0, false
+	}
+	name = line[:open]
+
+	fields := strings.Fields(line[closing+1:])
+	if len(fields) == 0 {
+		return "", 0, "", "", 0, false
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", 0, "", "", 0, false
+	}
+
+	gpu = -1
+	for _, label := range strings.Split(line[open+1:closing], ",") {
+		k, v, found := strings.Cut(label, "=")
+		if !found {
+			continue
+		}
+		switch strings.TrimSpace(k) {
+		case "gpu":
+			gpu, err = strconv.Atoi(strings.Trim(v, `"`))
+			if err != nil {
+				return "", 0, "", "", 0, false
+			}
+		case "UUID":
+			uuid = strings.Trim(v, `"`)
+		case "le":
+			le = strings.Trim(v, `"`)
+		}
+	}
+	if gpu < 0 {
+		return "", 0, "", "", 0, false
+	}
+	return name, gpu, uuid, le, value, true
+}
+
+// Simulated value distributions
+const (
+	distUniform = "uniform" // evenly between min and max
+	distNormal  = "normal"  // around mean with stddev, clamped to min and max
+)
+
+// metricDistribution is how one simulated reading is drawn. With
+// probability SpikeProbability, SpikeOffset is added to the draw, giving
+// the occasional tail excursion real GPUs show (thermal spikes, stalls).
+type metricDistribution struct {
+	Distribution     string  `json:"distribution"`
+	Mean             float64 `json:"mean"`
+	StdDev           float64 `json:"stddev"`
+	Min              float64 `json:"min"`
+	Max              float64 `json:"max"`
+	SpikeProbability float64 `json:"spike_probability"`
+	SpikeOffset      float64 `json:"spike_offset"`
+}
+
+// sample draws one value
+func (d metricDistribution) sample(rng *rand.Rand) float64 {
+	var v float64
+	if d.Distribution == distNormal {
+		v = d.Mean + rng.NormFloat64()*d.StdDev
+	} else {
+		v = d.Min + rng.Float64()*(d.Max-d.Min)
+	}
+	if d.SpikeProbability > 0 && rng.Float64() < d.SpikeProbability {
+		v += d.SpikeOffset
+	}
+	return math.Max(d.Min, math.Min(d.Max, v))
+}
+
+// validate checks the distribution's parameters
+func (d metricDistribution) validate() error {
+	if d.Distribution != distUniform && d.Distribution != distNormal {
+		return fmt.Errorf("distribution must be uniform or normal, got %q", d.Distribution)
+	}
+	if d.Min > d.Max {
+		return fmt.Errorf("min must not exceed max")
+	}
+	if d.StdDev < 0 {
+		return fmt.Errorf("stddev must not be negative")
+	}
+	if d.SpikeProbability < 0 || d.SpikeProbability > 1 {
+		return fmt.Errorf("spike_probability must be between 0 and 1")
+	}
+	return nil
+}
+
+// simulationProfile holds the distributions a simulated GPU's readings are
+// drawn from. Fan speed follows temperature, and memory is a percentage of
+// the 80GB total.
+type simulationProfile struct {
+	Temperature   metricDistribution `json:"temperature_celsius"`
+	Power         metricDistribution `json:"power_watts"`
+	MemoryUsedPct metricDistribution `json:"memory_used_percent"`
+	Utilization   metricDistribution `json:"utilization_percent"`
+	SMClock       metricDistribution `json:"sm_clock_mhz"`
+}
+
+// validate checks each of the profile's distributions
+func (p simulationProfile) validate() error {
+	for name, d := range map[string]metricDistribution{
+		"temperature_celsius": p.Temperature,
+		"power_watts":         p.Power,
+		"memory_used_percent": p.MemoryUsedPct,
+		"utilization_percent": p.Utilization,
+		"sm_clock_mhz":        p.SMClock,
+	} {
+		if err := d.validate(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// builtinProfiles are always available. uniform is the original flat
+// simulation; the others approximate an A100's behavior under a workload.
+var builtinProfiles = map[string]simulationProfile{
+	"uniform": {
+		Temperature:   metricDistribution{Distribution: distUniform, Min: 65, Max: 95},
+		Power:         metricDistribution{Distribution: distUniform, Min: 250, Max: 350},
+		MemoryUsedPct: metricDistribution{Distribution: distUniform, Min: 30, Max: 90},
+		Utilization:   metricDistribution{Distribution: distUniform, Min: 0, Max: 100},
+		SMClock:       metricDistribution{Distribution: distUniform, Min: 1410, Max: 1610},
+	},
+	"training": {
+		Temperature:   metricDistribution{Distribution: distNormal, Mean: 74, StdDev: 3, Min: 30, Max: 100, SpikeProbability: 0.005, SpikeOffset: 15},
+		Power:         metricDistribution{Distribution: distNormal, Mean: 360, StdDev: 20, Min: 60, Max: 400},
+		MemoryUsedPct: metricDistribution{Distribution: distNormal, Mean: 88, StdDev: 4, Min: 0, Max: 100},
+		// Data loader stalls briefly drop utilization
+		Utilization: metricDistribution{Distribution: distNormal, Mean: 96, StdDev: 3, Min: 0, Max: 100, SpikeProbability: 0.02, SpikeOffset: -60},
+		SMClock:     metricDistribution{Distribution: distNormal, Mean: 1380, StdDev: 25, Min: 210, Max: 1410},
+	},
+	"inference": {
+		Temperature:   metricDistribution{Distribution: distNormal, Mean: 60, StdDev: 5, Min: 30, Max: 100, SpikeProbability: 0.002, SpikeOffset: 20},
+		Power:         metricDistribution{Distribution: distNormal, Mean: 200, StdDev: 50, Min: 60, Max: 400, SpikeProbability: 0.01, SpikeOffset: 120},
+		MemoryUsedPct: metricDistribution{Distribution: distNormal, Mean: 55, StdDev: 8, Min: 0, Max: 100},
+		Utilization:   metricDistribution{Distribution: distNormal, Mean: 45, StdDev: 20, Min: 0, Max: 100},
+		SMClock:       metricDistribution{Distribution: distNormal, Mean: 1350, StdDev: 60, Min: 210, Max: 1410},
+	},
+	"idle": {
+		Temperature:   metricDistribution{Distribution: distNormal, Mean: 35, StdDev: 2, Min: 20, Max: 100},
+		Power:         metricDistribution{Distribution: distNormal, Mean: 55, StdDev: 4, Min: 30, Max: 400},
+		MemoryUsedPct: metricDistribution{Distribution: distNormal, Mean: 1, StdDev: 0.5, Min: 0, Max: 100},
+		Utilization:   metricDistribution{Distribution: distNormal, Mean: 0, StdDev: 1, Min: 0, Max: 100},
+		SMClock:       metricDistribution{Distribution: distNormal, Mean: 210, StdDev: 0, Min: 210, Max: 1410},
+	},
+}
+
+// loadSimulationProfiles returns the built-in profiles plus those in path,
+// if set. A profile in the file starts from the built-in of the same name,
+// or uniform, so it only needs the readings it changes.
+func loadSimulationProfiles(path string) (map[string]simulationProfile, error) {
+	profiles := make(map[string]simulationProfile, len(builtinProfiles))
+	for name, p := range builtinProfiles {
+		profiles[name] = p
+	}
+	if path == "" {
+		return profiles, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read simulation profiles: %w", err)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse simulation profiles: %w", err)
+	}
+	for name, msg := range raw {
+		p, ok := profiles[name]
+		if !ok {
+			p = builtinProfiles["uniform"]
+		}
+		if err := json.Unmarshal(msg, &p); err != nil {
+			return nil, fmt.Errorf("simulation profile %s: %w", name, err)
+		}
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("simulation profile %s: %w", name, err)
+		}
+		profiles[name] = p
+	}
+	return profiles, nil
+}
+
+// simulatedSource generates realistic GPU metrics for any node; it is the
+// fallback for nodes no other source handles. Each node has its own
+// generator, so nodes can be simulated concurrently without contending for
+// one lock, and a fixed seed reproduces each node's sequence whatever order
+// nodes are collected in.
+type simulatedSource struct {
+	seed int64 // from SIMULATION_SEED
+	// mu guards nodes
+	mu    sync.Mutex
+	nodes map[string]*nodeRand
+
+	// profiles are read-only after construction; defaultProfile is used
+	// for nodes that don't name one
+	profiles       map[string]simulationProfile
+	defaultProfile string
+}
+
+// profile returns the simulation profile for node
+func (s *simulatedSource) profile(node NodeConfig) (simulationProfile, error) {
+	name := node.SimulationProfile
+	if name == "" {
+		name = s.defaultProfile
+	}
+	p, ok := s.profiles[name]
+	if !ok {
+		return p, fmt.Errorf("node %s: unknown simulation profile %q", node.NodeID, name)
+	}
+	return p, nil
+}
+
+// validateNodes checks every node without a DCGM exporter names a known
+// simulation profile, or has the default
+func (s *simulatedSource) validateNodes(nodes []NodeConfig) error {
+	for _, n := range nodes {
+		if _, err := s.profile(n); err != nil && n.DCGMURL == "" {
+			return err
+		}
+	}
+	return nil
+}
+
+// nodeRand is one simulated node's generator; rand.Rand isn't safe for
+// concurrent use, so mu guards rng
+type nodeRand struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// generator returns nodeID's generator, seeding it from the source seed
+// and the node ID on first use
+func (s *simulatedSource) generator(nodeID string) *nodeRand {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.nodes[nodeID]
+	if !ok {
+		h := fnv.New64a()
+		h.Write([]byte(nodeID))
+		g = &nodeRand{rng: rand.New(rand.NewSource(s.seed ^ int64(h.Sum64())))}
+		s.nodes[nodeID] = g
+	}
+	return g
+}
+
+func (s *simulatedSource) Name() string { return "simulated" }
+
+func (s *simulatedSource) Handles(node NodeConfig) bool { return true }
+
+// defaultSimulatedGPUs is how many GPUs a simulated node has without an
+// expected_gpu_count; a DGX typically has 8
+const defaultSimulatedGPUs = 8
+
+// Collect simulates a node of expected_gpu_count GPUs (defaultSimulatedGPUs
+// when unknown) from its simulation profile
+func (s *simulatedSource) Collect(_ context.Context, node NodeConfig) ([]GPUMetric, error) {
+	nodeID := node.NodeID
+	numGPUs := node.expectedGPUs
+	if numGPUs == 0 {
+		numGPUs = defaultSimulatedGPUs
+	}
+	metrics := make([]GPUMetric, numGPUs)
+
+	profile, err := s.profile(node)
+	if err != nil {
+		return nil, err
+	}
+
+	g := s.generator(nodeID)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	rng := g.rng
+
+	for i := 0; i < numGPUs; i++ {
+		baseTemp := profile.Temperature.sample(rng)
+		basePower := profile.Power.sample(rng)
+		memTotal := 80000.0 // 80GB for A100
+		memUsed := memTotal * profile.MemoryUsedPct.sample(rng) / 100.0
+		// Fan speed tracks temperature, 30-100%
+		fanSpeed := math.Max(30.0, math.Min(100.0, (baseTemp-65.0)*2.0+30.0+rng.Float64()*10.0))
+		rowRemapPending := 0
+		if rng.Float64() < 0.001 { // rare uncorrectable ECC error awaiting remap
+			rowRemapPending = 1
+		}
+
+		metrics[i] = GPUMetric{
+			NodeID:             nodeID,
+			GPUIndex:           i,
+			TemperatureCelsius: baseTemp,
+			PowerWatts:         basePower,
+			MemoryUsedMB:       memUsed,
+			MemoryTotalMB:      memTotal,
+			UtilizationPercent: profile.Utilization.sample(rng),
+			SMClockMHz:         int(profile.SMClock.sample(rng)),
+			FanSpeedPercent:    fanSpeed,
+			RowRemapPending:    rowRemapPending,
+			CollectedAt:        time.Now(),
+			Histograms:         map[string]Histogram{"sm_occupancy": simulateOccupancy(rng)},
+
+			EnforcedPowerLimitWatts: 400, // A100 SXM default
+			GPUUUID:                 simulatedUUID(nodeID, i),
+		}
+	}
+
+	return metrics, nil
+}
+
+// simulatedUUID derives a stable GPU-xxxxxxxx-... UUID for a simulated GPU
+func simulatedUUID(nodeID string, gpuIndex int) string {
+	h := fnv.New128a()
+	fmt.Fprintf(h, "%s/%d", nodeID, gpuIndex)
+	sum := h.Sum(nil)
+	return fmt.Sprintf("GPU-%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// simulateOccupancy draws 100 SM occupancy observations (0-1) into quarter
+// buckets
+func simulateOccupancy(rng *rand.Rand) Histogram {
+	bounds := []float64{0.25, 0.5, 0.75, 1}
+	h := Histogram{Buckets: make([]HistogramBucket, len(bounds))}
+	for i, b := range bounds {
+		h.Buckets[i].LE = b
+	}
+	for n := 0; n < 100; n++ {
+		v := rng.Float64()
+		h.Count++
+		h.Sum += v
+		for i, b := range bounds {
+			if v <= b {
+				h.Buckets[i].Count++
+			}
+		}
+	}
+	return h
+}
+
+// messageKey returns the Kafka key for a metric under the configured strategy
+func (c *CollectorService) messageKey(metric GPUMetric) []byte {
+	switch c.cfg.PartitionKey {
+	case keyByNode:
+		return []byte(metric.NodeID)
+	case keyNone:
+		return nil
+	default:
+		return []byte(fmt.Sprintf("%s-gpu-%d", metric.NodeID, metric.GPUIndex))
+	}
+}
+
+// metricMessages encodes one message per metric in the configured format
+func (c *CollectorService) metricMessages(metrics []GPUMetric) ([]kafka.Message, error) {
+	messages := make([]kafka.Message, len(metrics))
+
+	for i, metric := range metrics {
+		var data []byte
+		switch c.cfg.MessageFormat {
+		case formatAvro:
+			data = encodeAvroMetric(c.schemaID, metric)
+		case formatProtobuf:
+			var err error
+			if data, err = encodeProtoMetric(metric); err != nil {
+				return nil, fatal(fmt.Errorf("failed to marshal metric: %w", err))
+			}
+		default:
+			var err error
+			if data, err = json.Marshal(metric); err != nil {
+				return nil, fatal(fmt.Errorf("failed to marshal metric: %w", err))
+			}
+		}
+
+		messages[i] = kafka.Message{
+			Key:   c.messageKey(metric),
+			Value: data,
+			Time:  metric.CollectedAt,
+			Headers: []kafka.Header{
+				{Key: contentTypeHeader, Value: []byte(contentTypes[c.cfg.MessageFormat])},
+				{Key: nodeIDHeader, Value: []byte(metric.NodeID)},
+			},
+		}
+	}
+	return messages, nil
+}
+
+// nodeMessages encodes each node's metrics as a single JSON array message,
+// keyed by node ID so a node's batches stay ordered on one partition
+func (c *CollectorService) nodeMessages(metrics []GPUMetric) ([]kafka.Message, error) {
+	var order []string
+	byNode := make(map[string][]GPUMetric)
+	for _, m := range metrics {
+		if _, seen := byNode[m.NodeID]; !seen {
+			order = append(order, m.NodeID)
+		}
+		byNode[m.NodeID] = append(byNode[m.NodeID], m)
+	}
+
+	messages := make([]kafka.Message, 0, len(order))
+	for _, nodeID := range order {
+		batch := byNode[nodeID]
+		data, err := json.Marshal(batch)
+		if err != nil {
+			return nil, fatal(fmt.Errorf("failed to marshal metrics for %s: %w", nodeID, err))
+		}
+
+		var key []byte
+		if c.cfg.PartitionKey != keyNone {
+			key = []byte(nodeID)
+		}
+		messages = append(messages, kafka.Message{
+			Key:   key,
+			Value: data,
+			Time:  batch[0].CollectedAt,
+			Headers: []kafka.Header{
+				{Key: contentTypeHeader, Value: []byte(contentTypes[formatJSON])},
+				{Key: nodeIDHeader, Value: []byte(nodeID)},
+			},
+		})
+	}
+	return messages, nil
+}
+
+// PublishToKafka sends metrics to Kafka and, when configured, the file
+// sink. With SINK=both a failed file write is logged and Kafka still gets
+// the batch.
+func (c *CollectorService) PublishToKafka(ctx context.Context, metrics []GPUMetric) error {
+	metrics = c.dropDuplicates(metrics)
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	if c.fileSink != nil {
+		err := c.fileSink.Write(metrics)
+		if c.cfg.Sink == sinkFile {
+			if err != nil {
+				c.publishErrors.Add(1)
+				return err
+			}
+			c.rememberPublished(metrics)
+			c.markPublished(metrics)
+			c.sampledLog.Printf("published", "Wrote %d metrics to %s", len(metrics), c.cfg.SinkFile)
+			return nil
+		}
+		if err != nil {
+			log.Printf("Failed to write %d metrics to sink file: %v", len(metrics), err)
+		}
+	}
+
+	var messages []kafka.Message
+	var err error
+	if c.cfg.MessageBatching == batchByNode {
+		messages, err = c.nodeMessages(metrics)
+	} else {
+		messages, err = c.metricMessages(metrics)
+	}
+	if err != nil {
+		return err
+	}
+
+	messages = c.dropOversized(messages)
+	if len(messages) == 0 {
+		return fatal(fmt.Errorf("all %d metrics exceed KAFKA_MAX_MESSAGE_BYTES", len(metrics)))
+	}
+
+	// Queue behind anything already spooled so replay keeps batches in order
+	if c.spool != nil {
+		if _, pending := c.spool.Oldest(); pending {
+			if err := c.spool.Append(messages); err != nil {
+				return err
+			}
+			c.rememberPublished(metrics)
+			c.sampledLog.Printf("spooled", "Spooled %d metrics behind pending batches", len(metrics))
+			return nil
+		}
+	}
+
+	err = c.writeMessages(ctx, messages)
+	if retry, permanent, ok := failedMessages(messages, err); ok {
+		// The rest were delivered; only the retriable failures may be
+		// spooled, or the replay would duplicate them
+		failed := slices.Concat(retry, permanent)
+		err = fmt.Errorf("%d of %d messages failed (%s): %w", len(failed), len(messages), describeMessages(failed), err)
+		if len(permanent) > 0 {
+			log.Printf("Kafka rejected %d messages permanently, dropping them: %s", len(permanent), describeMessages(permanent))
+		}
+		messages = retry
+		if len(retry) > 0 {
+			err = retriable(err)
+		} else {
+			err = fatal(err)
+		}
+	} else if err != nil {
+		// A request-level failure, e.g. Kafka down or an ACL or credential
+		// mistake, says nothing against the messages themselves
+		err = retriable(err)
+	}
+	// Permanent failures aren't spooled: replay would fail the same way and
+	// hold up every batch behind them
+	if errors.Is(err, ErrRetriable) && c.spool != nil && ctx.Err() == nil {
+		log.Printf("Failed to write to kafka, spooling %d messages: %v", len(messages), err)
+		err = c.spool.Append(messages)
+	}
+	if err != nil {
+		c.publishErrors.Add(1)
+		return fmt.Errorf("failed to write to kafka: %w", err)
+	}
+
+	c.rememberPublished(metrics)
+	c.markPublished(metrics)
+	c.sampledLog.Printf("published", "Published %d metrics to Kafka", len(metrics))
+	return nil
 }
 
-func NewCollectorService(nodes []string, kafkaBroker string) *CollectorService {
-	writer := &kafka.Writer{
-		Addr:         kafka.TCP(kafkaBroker),
-		Topic:        "gpu-telemetry",
-		Balancer:     &kafka.LeastBytes{},
-		BatchTimeout: 10 * time.Millisecond,
-		RequiredAcks: kafka.RequireOne,
+// maxKafkaWriters bounds KAFKA_WRITERS
+const maxKafkaWriters = 64
+
+// writeMessages writes messages through the Kafka writers. With several,
+// each message goes to the writer chosen by its key's hash, so a key (node,
+// or node and GPU) always shares one writer and stays in order; unkeyed
+// messages are spread evenly. The shards are written concurrently, and a
+// failure in some of them is reported as kafka.WriteErrors over messages
+// so the caller can tell which were delivered.
+func (c *CollectorService) writeMessages(ctx context.Context, messages []kafka.Message) error {
+	if len(c.kafkaWriters) == 1 {
+		return c.kafkaWriters[0].WriteMessages(ctx, messages...)
 	}
 
-	return &CollectorService{
-		nodes:        nodes,
-		kafkaWriter:  writer,
-		pollInterval: 30 * time.Second,
+	shards := make([][]int, len(c.kafkaWriters))
+	for i, m := range messages {
+		w := i % len(c.kafkaWriters)
+		if len(m.Key) > 0 {
+			h := fnv.New32a()
+			h.Write(m.Key)
+			w = int(h.Sum32() % uint32(len(c.kafkaWriters)))
+		}
+		shards[w] = append(shards[w], i)
+	}
+
+	errs := make([]error, len(c.kafkaWriters))
+	var wg sync.WaitGroup
+	for w, idx := range shards {
+		if len(idx) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			batch := make([]kafka.Message, len(idx))
+			for j, i := range idx {
+				batch[j] = messages[i]
+			}
+			errs[w] = c.kafkaWriters[w].WriteMessages(ctx, batch...)
+		}()
 	}
+	wg.Wait()
+
+	var writeErrs kafka.WriteErrors
+	failedShards := 0
+	for w, err := range errs {
+		if err == nil {
+			continue
+		}
+		failedShards++
+		c.sampledLog.Printf("writer-error", "Kafka writer %d failed to write %d messages: %v", w, len(shards[w]), err)
+		if writeErrs == nil {
+			writeErrs = make(kafka.WriteErrors, len(messages))
+		}
+		var shardErrs kafka.WriteErrors
+		if !errors.As(err, &shardErrs) || len(shardErrs) != len(shards[w]) {
+			shardErrs = nil
+		}
+		for j, i := range shards[w] {
+			if shardErrs != nil {
+				writeErrs[i] = shardErrs[j]
+			} else {
+				// Flattened with %v so the shard's request-level error isn't
+				// taken for a per-message rejection by failedMessages
+				writeErrs[i] = fmt.Errorf("kafka writer %d: %v", w, err)
+			}
+		}
+	}
+	if failedShards == 0 {
+		return nil
+	}
+	if writeErrs.Count() == len(messages) {
+		// Nothing was delivered; report the cause as a single writer would
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return writeErrs
 }
 
-// CollectMetrics simulates collecting metrics from DCGM exporters
-func (c *CollectorService) CollectMetrics(nodeID string) ([]GPUMetric, error) {
-	// In production, this would HTTP GET from DCGM exporter endpoint
-	// For now, we'll simulate realistic GPU metrics
+// markPublished records a successful Kafka write for the metrics' nodes.
+// Spooled batches don't count until they are replayed.
+func (c *CollectorService) markPublished(metrics []GPUMetric) {
+	if c.spool != nil {
+		if _, pending := c.spool.Oldest(); pending {
+			return
+		}
+	}
+	now := time.Now()
+	c.lastPublish.Store(now.UnixNano())
 
-	numGPUs := 8 // DGX typically has 8 GPUs
-	metrics := make([]GPUMetric, numGPUs)
+	c.publishMu.Lock()
+	defer c.publishMu.Unlock()
+	for _, m := range metrics {
+		c.nodePublished[m.NodeID] = now
+	}
+}
 
-	for i := 0; i < numGPUs; i++ {
-		// Simulate realistic GPU metrics with some variation
-		baseTemp := 65.0 + rand.Float64()*30.0           // 65-95°C
-		basePower := 250.0 + rand.Float64()*100.0        // 250-350W
-		memTotal := 80000.0                              // 80GB for A100
-		memUsed := memTotal * (0.3 + rand.Float64()*0.6) // 30-90% usage
+// markReplayed records a replayed spool segment's delivery for the nodes
+// named by its messages' node-id headers
+func (c *CollectorService) markReplayed(messages []kafka.Message) {
+	now := time.Now()
+	c.lastPublish.Store(now.UnixNano())
 
-		metrics[i] = GPUMetric{
-			NodeID:             nodeID,
-			GPUIndex:           i,
-			TemperatureCelsius: baseTemp,
-			PowerWatts:         basePower,
-			MemoryUsedMB:       memUsed,
-			MemoryTotalMB:      memTotal,
-			UtilizationPercent: rand.Float64() * 100.0,
-			SMClockMHz:         1410 + rand.Intn(200), // 1410-1610 MHz
-			CollectedAt:        time.Now(),
+	c.publishMu.Lock()
+	defer c.publishMu.Unlock()
+	for _, m := range messages {
+		for _, h := range m.Headers {
+			if h.Key == nodeIDHeader {
+				c.nodePublished[string(h.Value)] = now
+			}
 		}
 	}
+}
 
-	return metrics, nil
+// sinceLastPublish returns how long ago t was, or since startup when t is zero
+func (c *CollectorService) sinceLastPublish(t time.Time) float64 {
+	if t.IsZero() {
+		t = c.startedAt
+	}
+	return time.Since(t).Seconds()
 }
 
-// PublishToKafka sends metrics to Kafka
-func (c *CollectorService) PublishToKafka(ctx context.Context, metrics []GPUMetric) error {
-	messages := make([]kafka.Message, len(metrics))
+// dropOversized removes messages larger than MaxMessageBytes, which the
+// broker would reject along with the rest of the write
+func (c *CollectorService) dropOversized(messages []kafka.Message) []kafka.Message {
+	kept := messages[:0]
+	for _, m := range messages {
+		if size := len(m.Key) + len(m.Value); size > c.cfg.MaxMessageBytes {
+			c.oversized.Add(1)
+			log.Printf("Dropping %d-byte metric message for key %s: exceeds KAFKA_MAX_MESSAGE_BYTES (%d)",
+				size, m.Key, c.cfg.MaxMessageBytes)
+			continue
+		}
+		kept = append(kept, m)
+	}
+	return kept
+}
 
-	for i, metric := range metrics {
-		data, err := json.Marshal(metric)
-		if err != nil {
-			return fmt.Errorf("failed to marshal metric: %w", err)
+// Error kinds. Errors are tagged with one so spooling and replay branch on
+// errors.Is rather than on messages.
+var (
+	// ErrRetriable marks a transient failure, such as an unreachable or
+	// overloaded broker, that may succeed if the same write is retried
+	ErrRetriable = errors.New("retriable")
+	// ErrFatal marks a failure retrying can't fix, such as a message the
+	// broker rejects outright or one that can't be encoded
+	ErrFatal = errors.New("fatal")
+)
+
+// kindError tags err with an error kind without changing its message
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string   { return e.err.Error() }
+func (e *kindError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// retriable tags err with ErrRetriable
+func retriable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind: ErrRetriable, err: err}
+}
+
+// fatal tags err with ErrFatal
+func fatal(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind: ErrFatal, err: err}
+}
+
+// messageRejections are the broker errors that condemn a message itself,
+// so resending it can only fail the same way. Anything else, including
+// authentication and authorization failures, is about the request or the
+// cluster and clears once Kafka or its ACLs are fixed.
+var messageRejections = map[kafka.Error]bool{
+	kafka.InvalidMessage:      true,
+	kafka.InvalidMessageSize:  true,
+	kafka.MessageSizeTooLarge: true,
+	kafka.InvalidTimestamp:    true,
+	kafka.InvalidRecord:       true,
+}
+
+// isMessageRejected reports whether a per-message write error is one of
+// messageRejections
+func isMessageRejected(err error) bool {
+	var kerr kafka.Error
+	return errors.As(err, &kerr) && messageRejections[kerr]
+}
+
+// failedMessages splits the messages a partially failed write didn't
+// deliver into those worth retrying and those the broker rejected for
+// their content. ok is false unless err carries kafka-go's per-message
+// WriteErrors; a request-level error is always retriable.
+func failedMessages(messages []kafka.Message, err error) (retry, permanent []kafka.Message, ok bool) {
+	var writeErrs kafka.WriteErrors
+	if !errors.As(err, &writeErrs) || len(writeErrs) != len(messages) {
+		return nil, nil, false
+	}
+	for i, e := range writeErrs {
+		switch {
+		case e == nil:
+		case isMessageRejected(e):
+			permanent = append(permanent, messages[i])
+		default:
+			retry = append(retry, messages[i])
 		}
+	}
+	return retry, permanent, true
+}
 
-		messages[i] = kafka.Message{
-			Key:   []byte(fmt.Sprintf("%s-gpu-%d", metric.NodeID, metric.GPUIndex)),
-			Value: data,
-			Time:  metric.CollectedAt,
+// maxDescribedMessages bounds how many messages describeMessages names
+const maxDescribedMessages = 10
+
+// describeMessages names the node/GPU behind each message for logs: its key
+// (node, or node-gpu-N), or for unkeyed JSON the node_id and gpu_index in it
+func describeMessages(messages []kafka.Message) string {
+	names := make([]string, 0, min(len(messages), maxDescribedMessages))
+	for _, m := range messages[:min(len(messages), maxDescribedMessages)] {
+		var v struct {
+			NodeID   string `json:"node_id"`
+			GPUIndex *int   `json:"gpu_index"`
 		}
+		switch {
+		case len(m.Key) > 0:
+			names = append(names, string(m.Key))
+		case json.Unmarshal(m.Value, &v) == nil && v.NodeID != "" && v.GPUIndex != nil:
+			names = append(names, fmt.Sprintf("%s-gpu-%d", v.NodeID, *v.GPUIndex))
+		default:
+			names = append(names, "unkeyed")
+		}
+	}
+	if len(messages) > maxDescribedMessages {
+		names = append(names, fmt.Sprintf("and %d more", len(messages)-maxDescribedMessages))
 	}
+	return strings.Join(names, ", ")
+}
 
-	err := c.kafkaWriter.WriteMessages(ctx, messages...)
-	if err != nil {
-		return fmt.Errorf("failed to write to kafka: %w", err)
+// dropDuplicates filters out samples identical to their GPU's last published
+// sample within DedupeWindow. Once the window passes the sample is published
+// again, so idle GPUs still report periodically.
+func (c *CollectorService) dropDuplicates(metrics []GPUMetric) []GPUMetric {
+	if c.cfg.DedupeWindow <= 0 {
+		return metrics
 	}
 
-	log.Printf("Published %d metrics to Kafka", len(metrics))
-	return nil
+	c.dedupeMu.Lock()
+	defer c.dedupeMu.Unlock()
+
+	now := time.Now()
+	kept := metrics[:0:0]
+	for _, m := range metrics {
+		last, ok := c.lastPublished[gpuKey{m.NodeID, m.GPUIndex}]
+		if ok && now.Sub(last.at) < c.cfg.DedupeWindow && sameReadings(last.metric, m) {
+			continue
+		}
+		kept = append(kept, m)
+	}
+
+	if skipped := len(metrics) - len(kept); skipped > 0 {
+		c.sampledLog.Printf("deduplicated", "Skipped %d duplicate metrics", skipped)
+	}
+	return kept
+}
+
+// rememberPublished records metrics as the last published sample of their GPUs
+func (c *CollectorService) rememberPublished(metrics []GPUMetric) {
+	if c.cfg.DedupeWindow <= 0 {
+		return
+	}
+
+	c.dedupeMu.Lock()
+	defer c.dedupeMu.Unlock()
+
+	now := time.Now()
+	for _, m := range metrics {
+		c.lastPublished[gpuKey{m.NodeID, m.GPUIndex}] = publishedSample{metric: m, at: now}
+	}
+}
+
+// flushSpool replays spooled batches to Kafka, oldest first, every
+// SpoolFlushInterval until ctx is cancelled. A failed write leaves the batch
+// in place for the next attempt; only messages the broker rejected for their
+// content are dropped from it.
+func (c *CollectorService) flushSpool(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.SpoolFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for ctx.Err() == nil {
+			seg, ok := c.spool.Oldest()
+			if !ok {
+				break
+			}
+			messages, err := readSpoolSegment(seg.path)
+			if err != nil {
+				log.Printf("Discarding unreadable spool segment %s: %v", seg.path, err)
+				c.spool.Remove(seg)
+				continue
+			}
+			if err := c.writeMessages(ctx, messages); err != nil {
+				if retry, permanent, ok := failedMessages(messages, err); ok {
+					if len(permanent) > 0 {
+						log.Printf("Kafka rejected %d spooled messages permanently, dropping them: %s", len(permanent), describeMessages(permanent))
+					}
+					if len(retry) == 0 {
+						c.spool.Remove(seg)
+						continue
+					}
+					// Keep only what wasn't delivered so the retry doesn't
+					// duplicate the rest
+					if rerr := c.spool.Replace(seg, retry); rerr != nil {
+						log.Printf("Failed to trim spool segment %s: %v", seg.path, rerr)
+					}
+					err = fmt.Errorf("%d of %d messages failed (%s): %w", len(retry), len(messages), describeMessages(retry), err)
+				}
+				c.sampledLog.Printf("spool-flush", "Spool replay failed, will retry: %v", err)
+				break
+			}
+			c.spool.Remove(seg)
+			c.markReplayed(messages)
+			log.Printf("Replayed %d spooled metrics to Kafka", len(messages))
+		}
+	}
+}
+
+// writeTotals are the Kafka writer statistics accumulated across scrapes;
+// the max fields cover the interval since the previous scrape only
+type writeTotals struct {
+	writes       int64
+	messages     int64
+	bytes        int64
+	errors       int64
+	retries      int64
+	batches      int64
+	batchTime    time.Duration
+	writeCount   int64
+	writeTime    time.Duration
+	maxBatchSize int64
+	maxBatchTime time.Duration
+	maxWriteTime time.Duration
+}
+
+// writeStats guards the write totals exported on /metrics
+type writeStats struct {
+	mu     sync.Mutex
+	totals writeTotals
+}
+
+// add sums two writers' totals, keeping the larger of each max
+func (t writeTotals) add(o writeTotals) writeTotals {
+	t.writes += o.writes
+	t.messages += o.messages
+	t.bytes += o.bytes
+	t.errors += o.errors
+	t.retries += o.retries
+	t.batches += o.batches
+	t.batchTime += o.batchTime
+	t.writeCount += o.writeCount
+	t.writeTime += o.writeTime
+	t.maxBatchSize = max(t.maxBatchSize, o.maxBatchSize)
+	t.maxBatchTime = max(t.maxBatchTime, o.maxBatchTime)
+	t.maxWriteTime = max(t.maxWriteTime, o.maxWriteTime)
+	return t
+}
+
+// collect folds a writer stats snapshot into the totals and returns them
+func (s *writeStats) collect(stats kafka.WriterStats) writeTotals {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := &s.totals
+	t.writes += stats.Writes
+	t.messages += stats.Messages
+	t.bytes += stats.Bytes
+	t.errors += stats.Errors
+	t.retries += stats.Retries
+	t.batches += stats.BatchTime.Count
+	t.batchTime += stats.BatchTime.Sum
+	t.writeCount += stats.WriteTime.Count
+	t.writeTime += stats.WriteTime.Sum
+	t.maxBatchSize = stats.BatchSize.Max
+	t.maxBatchTime = stats.BatchTime.Max
+	t.maxWriteTime = stats.WriteTime.Max
+	return *t
+}
+
+// handleMetrics exposes collector counters in Prometheus text format
+func (c *CollectorService) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintf(w, "# HELP collector_oversized_messages_total Metric messages dropped for exceeding KAFKA_MAX_MESSAGE_BYTES\n")
+	fmt.Fprintf(w, "# TYPE collector_oversized_messages_total counter\n")
+	fmt.Fprintf(w, "collector_oversized_messages_total %d\n", c.oversized.Load())
+	fmt.Fprintf(w, "# HELP collector_publish_errors_total Metric batches that failed to publish\n")
+	fmt.Fprintf(w, "# TYPE collector_publish_errors_total counter\n")
+	fmt.Fprintf(w, "collector_publish_errors_total %d\n", c.publishErrors.Load())
+
+	var last time.Time
+	if ns := c.lastPublish.Load(); ns != 0 {
+		last = time.Unix(0, ns)
+	}
+	fmt.Fprintf(w, "# HELP collector_seconds_since_last_publish Seconds since a write to Kafka last succeeded (since startup if none has)\n")
+	fmt.Fprintf(w, "# TYPE collector_seconds_since_last_publish gauge\n")
+	fmt.Fprintf(w, "collector_seconds_since_last_publish %g\n", c.sinceLastPublish(last))
+	fmt.Fprintf(w, "# HELP collector_node_seconds_since_last_publish Seconds since a node's metrics were last written to Kafka\n")
+	fmt.Fprintf(w, "# TYPE collector_node_seconds_since_last_publish gauge\n")
+	c.publishMu.Lock()
+	for _, node := range c.currentNodes() {
+		fmt.Fprintf(w, "collector_node_seconds_since_last_publish{node_id=%q} %g\n",
+			node.NodeID, c.sinceLastPublish(c.nodePublished[node.NodeID]))
+	}
+	c.publishMu.Unlock()
+	fmt.Fprintf(w, "# HELP collector_short_scrapes_total Collections that returned fewer GPUs than the node's expected_gpu_count\n")
+	fmt.Fprintf(w, "# TYPE collector_short_scrapes_total counter\n")
+	fmt.Fprintf(w, "collector_short_scrapes_total %d\n", c.shortScrapes.Load())
+	fmt.Fprintf(w, "# HELP collector_poll_interval_seconds Poll interval in effect, raised while the alert engine is lagging\n")
+	fmt.Fprintf(w, "# TYPE collector_poll_interval_seconds gauge\n")
+	fmt.Fprintf(w, "collector_poll_interval_seconds %g\n", time.Duration(c.currentInterval.Load()).Seconds())
+
+	perWriter := make([]writeTotals, len(c.kafkaWriters))
+	var kw writeTotals
+	for i, writer := range c.kafkaWriters {
+		perWriter[i] = c.writeStats[i].collect(writer.Stats())
+		kw = kw.add(perWriter[i])
+	}
+	fmt.Fprintf(w, "# HELP collector_kafka_writes_total Produce requests sent to Kafka\n")
+	fmt.Fprintf(w, "# TYPE collector_kafka_writes_total counter\n")
+	fmt.Fprintf(w, "collector_kafka_writes_total %d\n", kw.writes)
+	fmt.Fprintf(w, "# HELP collector_kafka_messages_total Messages written to Kafka\n")
+	fmt.Fprintf(w, "# TYPE collector_kafka_messages_total counter\n")
+	fmt.Fprintf(w, "collector_kafka_messages_total %d\n", kw.messages)
+	fmt.Fprintf(w, "# HELP collector_kafka_bytes_total Message bytes written to Kafka\n")
+	fmt.Fprintf(w, "# TYPE collector_kafka_bytes_total counter\n")
+	fmt.Fprintf(w, "collector_kafka_bytes_total %d\n", kw.bytes)
+	fmt.Fprintf(w, "# HELP collector_kafka_write_errors_total Kafka write errors reported by the writer\n")
+	fmt.Fprintf(w, "# TYPE collector_kafka_write_errors_total counter\n")
+	fmt.Fprintf(w, "collector_kafka_write_errors_total %d\n", kw.errors)
+	fmt.Fprintf(w, "# HELP collector_kafka_retries_total Kafka write attempts retried by the writer\n")
+	fmt.Fprintf(w, "# TYPE collector_kafka_retries_total counter\n")
+	fmt.Fprintf(w, "collector_kafka_retries_total %d\n", kw.retries)
+	fmt.Fprintf(w, "# HELP collector_kafka_batch_seconds Time to fill each batch before it is written\n")
+	fmt.Fprintf(w, "# TYPE collector_kafka_batch_seconds summary\n")
+	fmt.Fprintf(w, "collector_kafka_batch_seconds_sum %g\n", kw.batchTime.Seconds())
+	fmt.Fprintf(w, "collector_kafka_batch_seconds_count %d\n", kw.batches)
+	fmt.Fprintf(w, "# HELP collector_kafka_write_seconds Time taken by each produce request\n")
+	fmt.Fprintf(w, "# TYPE collector_kafka_write_seconds summary\n")
+	fmt.Fprintf(w, "collector_kafka_write_seconds_sum %g\n", kw.writeTime.Seconds())
+	fmt.Fprintf(w, "collector_kafka_write_seconds_count %d\n", kw.writeCount)
+	fmt.Fprintf(w, "# HELP collector_kafka_batch_max_messages Largest batch in messages since the previous scrape\n")
+	fmt.Fprintf(w, "# TYPE collector_kafka_batch_max_messages gauge\n")
+	fmt.Fprintf(w, "collector_kafka_batch_max_messages %d\n", kw.maxBatchSize)
+	fmt.Fprintf(w, "# HELP collector_kafka_batch_max_seconds Longest batch fill time since the previous scrape\n")
+	fmt.Fprintf(w, "# TYPE collector_kafka_batch_max_seconds gauge\n")
+	fmt.Fprintf(w, "collector_kafka_batch_max_seconds %g\n", kw.maxBatchTime.Seconds())
+	fmt.Fprintf(w, "# HELP collector_kafka_write_max_seconds Slowest produce request since the previous scrape\n")
+	fmt.Fprintf(w, "# TYPE collector_kafka_write_max_seconds gauge\n")
+	fmt.Fprintf(w, "collector_kafka_write_max_seconds %g\n", kw.maxWriteTime.Seconds())
+	if len(perWriter) > 1 {
+		fmt.Fprintf(w, "# HELP collector_kafka_writer_messages_total Messages written to Kafka, by writer\n")
+		fmt.Fprintf(w, "# TYPE collector_kafka_writer_messages_total counter\n")
+		for i, t := range perWriter {
+			fmt.Fprintf(w, "collector_kafka_writer_messages_total{writer=\"%d\"} %d\n", i, t.messages)
+		}
+		fmt.Fprintf(w, "# HELP collector_kafka_writer_bytes_total Message bytes written to Kafka, by writer\n")
+		fmt.Fprintf(w, "# TYPE collector_kafka_writer_bytes_total counter\n")
+		for i, t := range perWriter {
+			fmt.Fprintf(w, "collector_kafka_writer_bytes_total{writer=\"%d\"} %d\n", i, t.bytes)
+		}
+		fmt.Fprintf(w, "# HELP collector_kafka_writer_errors_total Kafka write errors, by writer\n")
+		fmt.Fprintf(w, "# TYPE collector_kafka_writer_errors_total counter\n")
+		for i, t := range perWriter {
+			fmt.Fprintf(w, "collector_kafka_writer_errors_total{writer=\"%d\"} %d\n", i, t.errors)
+		}
+		fmt.Fprintf(w, "# HELP collector_kafka_writer_write_seconds Time taken by each produce request, by writer\n")
+		fmt.Fprintf(w, "# TYPE collector_kafka_writer_write_seconds summary\n")
+		for i, t := range perWriter {
+			fmt.Fprintf(w, "collector_kafka_writer_write_seconds_sum{writer=\"%d\"} %g\n", i, t.writeTime.Seconds())
+			fmt.Fprintf(w, "collector_kafka_writer_write_seconds_count{writer=\"%d\"} %d\n", i, t.writeCount)
+		}
+	}
+
+	if c.fileSink != nil {
+		fmt.Fprintf(w, "# HELP collector_sink_file_metrics_total Metrics written to the file sink\n")
+		fmt.Fprintf(w, "# TYPE collector_sink_file_metrics_total counter\n")
+		fmt.Fprintf(w, "collector_sink_file_metrics_total %d\n", c.fileSink.written.Load())
+	}
+
+	if c.spool == nil {
+		return
+	}
+	fmt.Fprintf(w, "# HELP collector_spooled_total Metrics written to the spool while Kafka was unreachable\n")
+	fmt.Fprintf(w, "# TYPE collector_spooled_total counter\n")
+	fmt.Fprintf(w, "collector_spooled_total %d\n", c.spool.spooled.Load())
+	fmt.Fprintf(w, "# HELP collector_spool_dropped_total Spooled metrics discarded because the spool was full\n")
+	fmt.Fprintf(w, "# TYPE collector_spool_dropped_total counter\n")
+	fmt.Fprintf(w, "collector_spool_dropped_total %d\n", c.spool.dropped.Load())
+	fmt.Fprintf(w, "# HELP collector_spool_bytes Current size of the spool\n")
+	fmt.Fprintf(w, "# TYPE collector_spool_bytes gauge\n")
+	fmt.Fprintf(w, "collector_spool_bytes %d\n", c.spool.Size())
 }
 
 // Run starts the collection loop
 func (c *CollectorService) Run(ctx context.Context) error {
+	if c.cfg.ExpectedGPUsURL != "" {
+		if err := c.refreshExpectedGPUs(ctx); err != nil {
+			log.Printf("Failed to load expected GPU counts: %v", err)
+		}
+		go c.watchExpectedGPUs(ctx)
+	}
+	if c.cfg.StartupWarmup > 0 {
+		c.waitForDCGM(ctx)
+	}
+
 	ticker := time.NewTicker(c.pollInterval)
 	defer ticker.Stop()
 
 	log.Printf("Starting collector service, polling %d nodes every %s",
-		len(c.nodes), c.pollInterval)
+		len(c.currentNodes()), c.pollInterval)
 
-	// Collect immediately on startup
-	c.collectFromAllNodes(ctx)
+	if c.spool != nil {
+		go c.flushSpool(ctx)
+	}
+
+	if c.cfg.CollectOnStartup {
+		c.collectFromAllNodes(ctx)
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			log.Println("Collector service shutting down")
-			return c.kafkaWriter.Close()
+			if c.fileSink != nil {
+				c.fileSink.Close()
+			}
+			var err error
+			for _, writer := range c.kafkaWriters {
+				if cerr := writer.Close(); cerr != nil && err == nil {
+					err = cerr
+				}
+			}
+			return err
 		case <-ticker.C:
 			c.collectFromAllNodes(ctx)
 		}
+		if c.cfg.EngineHealthURL != "" {
+			c.adjustForBackpressure(ctx, ticker)
+		}
+	}
+}
+
+// waitForDCGM probes the DCGM nodes until each returns a complete scrape,
+// StartupWarmup passes or ctx ends, so a just-booted exporter's partial
+// data isn't published. Probe scrapes are discarded.
+func (c *CollectorService) waitForDCGM(ctx context.Context) {
+	var pending []NodeConfig
+	for _, node := range c.currentNodes() {
+		if node.DCGMURL != "" {
+			pending = append(pending, node)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	log.Printf("Waiting up to %s for %d DCGM exporters to be ready", c.cfg.StartupWarmup, len(pending))
+	deadline := time.Now().Add(c.cfg.StartupWarmup)
+	for {
+		waiting := pending[:0]
+		for _, node := range pending {
+			metrics, err := c.CollectMetrics(ctx, node.NodeID)
+			node.expectedGPUs = c.expectedGPUCount(node.NodeID)
+			if err != nil || !dcgmComplete(node, metrics) {
+				waiting = append(waiting, node)
+			}
+		}
+		pending = waiting
+		if len(pending) == 0 {
+			log.Println("All DCGM exporters ready")
+			return
+		}
+
+		if time.Now().After(deadline) {
+			ids := make([]string, len(pending))
+			for i, node := range pending {
+				ids[i] = node.NodeID
+			}
+			log.Printf("DCGM exporters not ready after %s, collecting anyway: %s",
+				c.cfg.StartupWarmup, strings.Join(ids, ", "))
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.cfg.StartupProbeInterval):
+		}
+	}
+}
+
+// dcgmComplete reports whether a scrape looks fully populated: at least one
+// GPU (the node's expected_gpu_count when known), and memory totals on
+// every GPU when DCGM_FI_DEV_FB_FREE is mapped
+func dcgmComplete(node NodeConfig, metrics []GPUMetric) bool {
+	if len(metrics) == 0 || len(metrics) < node.expectedGPUs {
+		return false
+	}
+	if len(node.DCGMFields) > 0 && !slices.Contains(node.DCGMFields, "DCGM_FI_DEV_FB_FREE") {
+		return true
+	}
+	for _, m := range metrics {
+		if m.MemoryTotalMB <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// expectedGPUsRefreshInterval is how often expected_gpu_count is reloaded
+const expectedGPUsRefreshInterval = time.Minute
+
+// expectedGPUCount returns the node's expected_gpu_count, or 0 when unknown
+func (c *CollectorService) expectedGPUCount(nodeID string) int {
+	c.expectedMu.RLock()
+	defer c.expectedMu.RUnlock()
+	return c.expectedGPUs[nodeID]
+}
+
+// fetchExpectedGPUs reads one node's expected_gpu_count from the API,
+// returning 0 when it is unset or the node isn't registered yet
+func (c *CollectorService) fetchExpectedGPUs(ctx context.Context, nodeID string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	u := strings.TrimRight(c.cfg.ExpectedGPUsURL, "/") + "/nodes/" + url.PathEscape(nodeID) + "/expected-gpus"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("expected-gpus for %s returned %s", nodeID, resp.Status)
+	}
+	var body struct {
+		ExpectedGPUCount *int `json:"expected_gpu_count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+	if body.ExpectedGPUCount == nil {
+		return 0, nil
+	}
+	return *body.ExpectedGPUCount, nil
+}
+
+// refreshExpectedGPUs reloads every configured node's expected_gpu_count.
+// On any error the previous counts are kept.
+func (c *CollectorService) refreshExpectedGPUs(ctx context.Context) error {
+	counts := make(map[string]int)
+	for _, node := range c.currentNodes() {
+		n, err := c.fetchExpectedGPUs(ctx, node.NodeID)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			counts[node.NodeID] = n
+		}
+	}
+
+	c.expectedMu.Lock()
+	c.expectedGPUs = counts
+	c.expectedMu.Unlock()
+	return nil
+}
+
+// watchExpectedGPUs runs refreshExpectedGPUs every expectedGPUsRefreshInterval
+func (c *CollectorService) watchExpectedGPUs(ctx context.Context) {
+	ticker := time.NewTicker(expectedGPUsRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := c.refreshExpectedGPUs(ctx); err != nil && ctx.Err() == nil {
+			c.sampledLog.Printf("expected-gpus", "Failed to refresh expected GPU counts: %v", err)
+		}
+	}
+}
+
+// engineLag fetches the alert engine's consumer lag from its health endpoint
+func (c *CollectorService) engineLag(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.EngineHealthURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("engine health returned %s", resp.Status)
+	}
+	var health struct {
+		ConsumerLag int64 `json:"consumer_lag"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return 0, err
 	}
+	return health.ConsumerLag, nil
+}
+
+// adjustForBackpressure stretches or restores the poll interval based on
+// the alert engine's consumer lag. An unreachable engine leaves the interval
+// unchanged so a health endpoint outage never stalls collection.
+func (c *CollectorService) adjustForBackpressure(ctx context.Context, ticker *time.Ticker) {
+	lag, err := c.engineLag(ctx)
+	if err != nil {
+		c.sampledLog.Printf("backpressure", "Error checking alert engine lag: %v", err)
+		return
+	}
+
+	current := time.Duration(c.currentInterval.Load())
+	next := current
+	switch {
+	case lag >= int64(c.cfg.BackpressureLagHigh):
+		next = min(current*2, c.pollInterval*time.Duration(c.cfg.BackpressureMaxFactor))
+	case lag <= int64(c.cfg.BackpressureLagLow):
+		next = max(current/2, c.pollInterval)
+	}
+	if next == current {
+		return
+	}
+
+	c.currentInterval.Store(int64(next))
+	ticker.Reset(next)
+	log.Printf("Alert engine consumer lag is %d; poll interval now %s", lag, next)
 }
 
 func (c *CollectorService) collectFromAllNodes(ctx context.Context) {
-	for _, nodeID := range c.nodes {
-		metrics, err := c.CollectMetrics(nodeID)
+	for _, node := range c.currentNodes() {
+		nodeID := node.NodeID
+		metrics, err := c.CollectMetrics(ctx, nodeID)
 		if err != nil {
 			log.Printf("Error collecting from %s: %v", nodeID, err)
 			continue
@@ -136,18 +3154,168 @@ func (c *CollectorService) collectFromAllNodes(ctx context.Context) {
 		if err := c.PublishToKafka(ctx, metrics); err != nil {
 			log.Printf("Error publishing metrics from %s: %v", nodeID, err)
 		} else {
-			log.Printf("Successfully collected and published metrics from %s", nodeID)
+			c.sampledLog.Printf("collected", "Successfully collected and published metrics from %s", nodeID)
+		}
+	}
+}
+
+// requireToken guards control endpoints with the configured bearer token
+func (c *CollectorService) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c.cfg.ControlToken != "" {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(c.cfg.ControlToken)) != 1 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// handleCollect runs an on-demand collection for a single node
+func (c *CollectorService) handleCollect(w http.ResponseWriter, r *http.Request) {
+	nodeID := r.URL.Query().Get("node")
+	if nodeID == "" {
+		http.Error(w, "node query parameter is required", http.StatusBadRequest)
+		return
+	}
+	nodeID, err := normalizeNodeID(nodeID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := c.nodeConfig(nodeID); !ok {
+		http.Error(w, "Unknown node", http.StatusNotFound)
+		return
+	}
+
+	metrics, err := c.CollectMetrics(r.Context(), nodeID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if err := c.PublishToKafka(r.Context(), metrics); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	log.Printf("On-demand collection published %d metrics from %s", len(metrics), nodeID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"node":      nodeID,
+		"published": len(metrics),
+	})
+}
+
+// handleReload re-reads the node config and reports the resulting node count
+func (c *CollectorService) handleReload(w http.ResponseWriter, r *http.Request) {
+	count, err := c.ReloadNodes()
+
+	resp := map[string]interface{}{"nodes": count}
+	status := http.StatusOK
+	if err != nil {
+		log.Printf("Failed to reload node config, keeping current nodes: %v", err)
+		resp["error"] = err.Error()
+		status = http.StatusUnprocessableEntity
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// StartControlServer serves the control endpoints until ctx is cancelled
+func (c *CollectorService) StartControlServer(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /collect", c.requireToken(c.handleCollect))
+	mux.HandleFunc("POST /admin/reload", c.requireToken(c.handleReload))
+
+	server := &http.Server{Addr: c.cfg.ControlAddr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	go func() {
+		log.Printf("Control server listening on %s", c.cfg.ControlAddr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Control server failed: %v", err)
 		}
+	}()
+}
+
+// registerPprof exposes the net/http/pprof handlers on mux
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// StartInternalServer serves operator-only endpoints on InternalAddr
+func (c *CollectorService) StartInternalServer(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", c.handleMetrics)
+	if c.cfg.PprofEnabled {
+		registerPprof(mux)
 	}
+
+	server := &http.Server{Addr: c.cfg.InternalAddr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	go func() {
+		log.Printf("Internal server listening on %s (pprof: %t)", c.cfg.InternalAddr, c.cfg.PprofEnabled)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Internal server failed: %v", err)
+		}
+	}()
 }
 
 func main() {
-	nodes := []string{"node-1", "node-2"}
-	kafkaBroker := "localhost:9093"
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	var redactor *logRedactor
+	if len(cfg.LogRedactFields) > 0 {
+		redactor = newLogRedactor(os.Stderr, cfg.LogRedactFields)
+		log.SetOutput(redactor)
+	}
+
+	nodes := defaultNodes
+	if cfg.NodesFile != "" {
+		if nodes, err = loadNodeConfig(cfg.NodesFile); err != nil {
+			log.Fatalf("Invalid node configuration: %v", err)
+		}
+	}
+	if redactor != nil {
+		redactor.setNodes(nodes)
+	}
+
+	collector, err := NewCollectorService(nodes, cfg)
+	if err != nil {
+		log.Fatalf("Failed to create collector service: %v", err)
+	}
+	collector.redactor = redactor
 
-	collector := NewCollectorService(nodes, kafkaBroker)
+	// SIGINT/SIGTERM stop collection and in-flight scrapes; Run then closes the writers
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go collector.watchReloadSignal(ctx)
+	if cfg.ControlAddr != "" {
+		collector.StartControlServer(ctx)
+	}
+	if cfg.InternalAddr != "" {
+		collector.StartInternalServer(ctx)
+	}
 
-	ctx := context.Background()
 	if err := collector.Run(ctx); err != nil {
 		log.Fatalf("Collector service failed: %v", err)
 	}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentSimulatedCollection collects from simulated nodes from many
+// goroutines at once, as the poll loop and on-demand /collect requests do.
+// Run it with -race to check the per-node generators are race-free.
+func TestConcurrentSimulatedCollection(t *testing.T) {
+	profiles, err := loadSimulationProfiles("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nodes []NodeConfig
+	for i := 0; i < 8; i++ {
+		nodes = append(nodes, NodeConfig{NodeID: fmt.Sprintf("node-%d", i)})
+	}
+	c := &CollectorService{
+		nodes: nodes,
+		sources: []Source{&simulatedSource{
+			seed:           1,
+			nodes:          make(map[string]*nodeRand),
+			profiles:       profiles,
+			defaultProfile: "uniform",
+		}},
+	}
+
+	// Several workers per node, so nodes are collected in parallel and each
+	// node's generator is also shared between goroutines
+	const workers, rounds = 4, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, len(nodes)*workers)
+	for _, node := range nodes {
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for r := 0; r < rounds; r++ {
+					metrics, err := c.CollectMetrics(context.Background(), node.NodeID)
+					if err != nil {
+						errs <- err
+						return
+					}
+					if len(metrics) != 8 {
+						errs <- fmt.Errorf("%s: got %d metrics, want 8", node.NodeID, len(metrics))
+						return
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// TestReloadNodesRejectsUnknownProfile checks a reload naming an unknown
+// simulation profile fails and keeps the current nodes, as startup would
+func TestReloadNodesRejectsUnknownProfile(t *testing.T) {
+	profiles, err := loadSimulationProfiles("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "nodes.json")
+	if err := os.WriteFile(path, []byte(`[{"node_id": "node-9", "simulation_profile": "no-such-profile"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	current := []NodeConfig{{NodeID: "node-1"}}
+	c := &CollectorService{
+		cfg:   Config{NodesFile: path},
+		nodes: current,
+		simulated: &simulatedSource{
+			nodes:          make(map[string]*nodeRand),
+			profiles:       profiles,
+			defaultProfile: "uniform",
+		},
+	}
+
+	n, err := c.ReloadNodes()
+	if err == nil {
+		t.Fatal("reload with an unknown simulation profile succeeded")
+	}
+	if got := c.currentNodes(); n != 1 || len(got) != 1 || got[0].NodeID != "node-1" {
+		t.Errorf("nodes after failed reload = %d %+v, want the current node-1", n, got)
+	}
+}
+
+// TestRequireToken checks control endpoints accept only the configured token
+// sent as "Authorization: Bearer <token>"
+func TestRequireToken(t *testing.T) {
+	c := &CollectorService{cfg: Config{ControlToken: "secret"}}
+	handler := c.requireToken(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	cases := []struct {
+		header string
+		want   int
+	}{
+		{"Bearer secret", http.StatusNoContent},
+		{"secret", http.StatusUnauthorized},
+		{"Basic secret", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/collect?node=node-1", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("Authorization %q: status %d, want %d", tc.header, rec.Code, tc.want)
+		}
+	}
+}
+
+// avroHistogramGolden is encodeAvroMetric's output for the metric in
+// TestEncodeAvroHistograms; the alert engine's tests decode the same bytes
+const avroHistogramGolden = "00000000070c6e6f64652d31060000000000e051400000000000807340000000000000e440000000000000f4400000000000405840f81e000000000000000000f6a1abfef962020c6a6f625f696410747261696e2d34320000000000000000000000020218736d5f6f63637570616e637904000000000000e03f0000000000000840000000000000f03f0000000000002440000000000000002440000000000000194000"
+
+// TestEncodeAvroHistograms checks histograms are written as the schema's
+// nullable map of Histogram records
+func TestEncodeAvroHistograms(t *testing.T) {
+	metric := GPUMetric{
+		NodeID:             "node-1",
+		GPUIndex:           3,
+		TemperatureCelsius: 71.5,
+		PowerWatts:         312,
+		MemoryUsedMB:       40960,
+		MemoryTotalMB:      81920,
+		UtilizationPercent: 97,
+		SMClockMHz:         1980,
+		CollectedAt:        time.UnixMilli(1700000000123).UTC(),
+		Labels:             map[string]string{"job_id": "train-42"},
+		Histograms: map[string]Histogram{"sm_occupancy": {
+			Buckets: []HistogramBucket{{LE: 0.5, Count: 3}, {LE: 1, Count: 10}},
+			Count:   10,
+			Sum:     6.25,
+		}},
+	}
+	if got := hex.EncodeToString(encodeAvroMetric(7, metric)); got != avroHistogramGolden {
+		t.Fatalf("encoded %s, want %s", got, avroHistogramGolden)
+	}
+
+	// Without histograms only the null branch is written
+	withHistograms := encodeAvroMetric(7, metric)
+	metric.Histograms = nil
+	without := encodeAvroMetric(7, metric)
+	if len(without) == 0 || without[len(without)-1] != 0 || len(withHistograms) <= len(without) {
+		t.Fatalf("histograms not written as a nullable union: %x", without)
+	}
+}
@@ -1,3 +1,10 @@
+-- Schema Migrations Table (one row per applied schema version; the API
+-- server's /health/schema compares the newest against the version it expects)
+CREATE TABLE IF NOT EXISTS schema_migrations (
+                                                 version INT PRIMARY KEY,
+                                                 applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
 -- GPU Nodes Table
 CREATE TABLE IF NOT EXISTS gpu_nodes (
                                          id SERIAL PRIMARY KEY,
@@ -6,6 +13,9 @@ CREATE TABLE IF NOT EXISTS gpu_nodes (
     datacenter VARCHAR(100),
     status VARCHAR(20) DEFAULT 'healthy',
     last_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    muted_until TIMESTAMP,
+    expected_gpu_count INT,
+    is_gpu BOOLEAN NOT NULL DEFAULT TRUE,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
     );
 
@@ -20,13 +30,24 @@ CREATE TABLE IF NOT EXISTS gpu_metrics (
     memory_total_mb FLOAT,
     utilization_percent FLOAT,
     sm_clock_mhz INT,
+    fan_speed_percent FLOAT,
+    row_remap_pending INT DEFAULT 0,
     collected_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    clock_skew_seconds FLOAT,
+    labels JSONB,
+    histograms JSONB,
+    -- partial marks samples missing memory_total_mb; memory rules skip them
+    partial BOOLEAN DEFAULT FALSE,
+    -- gpu_uuid follows a card across node and index changes; NULL when unreported
+    gpu_uuid VARCHAR(64),
     FOREIGN KEY (node_id) REFERENCES gpu_nodes(node_id) ON DELETE CASCADE
     );
 
 -- Create index for time-series queries
 CREATE INDEX idx_metrics_node_time ON gpu_metrics(node_id, collected_at DESC);
 CREATE INDEX idx_metrics_collected_at ON gpu_metrics(collected_at DESC);
+CREATE INDEX idx_metrics_labels ON gpu_metrics USING GIN (labels);
+CREATE INDEX idx_metrics_gpu_uuid_time ON gpu_metrics(gpu_uuid, collected_at DESC) WHERE gpu_uuid IS NOT NULL;
 
 -- Alerts Table
 CREATE TABLE IF NOT EXISTS alerts (
@@ -41,11 +62,31 @@ CREATE TABLE IF NOT EXISTS alerts (
     status VARCHAR(20) DEFAULT 'active',
     triggered_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     resolved_at TIMESTAMP,
+    resolution VARCHAR(20),
+    dedup_key VARCHAR(64),
+    acknowledged_at TIMESTAMP,
+    acknowledged_by VARCHAR(100),
+    runbook_url TEXT,
     FOREIGN KEY (node_id) REFERENCES gpu_nodes(node_id) ON DELETE CASCADE
     );
 
 CREATE INDEX idx_alerts_status ON alerts(status, triggered_at DESC);
 CREATE INDEX idx_alerts_node ON alerts(node_id, triggered_at DESC);
+-- Serves the active-alerts listing (WHERE status ORDER BY severity DESC, triggered_at DESC) without a sort
+CREATE INDEX idx_alerts_status_severity ON alerts(status, severity DESC, triggered_at DESC);
+CREATE INDEX idx_alerts_dedup_key ON alerts(dedup_key, status);
+
+-- Alert Firings Table (one row each time an alert becomes active, including
+-- reopenings, so escalation counts repeats that reuse one alert row)
+CREATE TABLE IF NOT EXISTS alert_firings (
+                                             id SERIAL PRIMARY KEY,
+                                             alert_id INT NOT NULL,
+                                             dedup_key VARCHAR(64) NOT NULL,
+    fired_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (alert_id) REFERENCES alerts(id) ON DELETE CASCADE
+    );
+
+CREATE INDEX idx_alert_firings_dedup_key ON alert_firings(dedup_key, fired_at DESC);
 
 -- Alert Actions Table (tracks what actions were taken)
 CREATE TABLE IF NOT EXISTS alert_actions (
@@ -54,16 +95,196 @@ CREATE TABLE IF NOT EXISTS alert_actions (
                                              action_type VARCHAR(50) NOT NULL,
     action_status VARCHAR(20) DEFAULT 'pending',
     action_details JSONB,
+    attempts INT DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP,
     executed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (alert_id) REFERENCES alerts(id) ON DELETE CASCADE
     );
 
+CREATE INDEX idx_alert_actions_pending ON alert_actions(next_attempt_at) WHERE action_status = 'pending';
+-- Serves action retention (ACTION_RETENTION, DELETE /api/v1/actions)
+CREATE INDEX idx_alert_actions_executed ON alert_actions(executed_at);
+
+-- Alert Rules Table (threshold rules evaluated by the alert engine)
+-- severity_bands lists thresholds with the severity each one fires at, e.g.
+-- [{"threshold": 330, "severity": "warning"}, {"threshold": 350, "severity": "critical"}];
+-- the furthest band breached determines the alert severity.
+-- auto_resolve_after, when set, resolves the rule's active alerts once they are
+-- that old (resolution 'timeout'), for one-shot conditions that never recover.
+-- node_id/gpu_index scope a rule to one GPU, where it replaces the fleet-wide
+-- rules (both NULL) of the same alert_type.
+-- runbook_url, when set, is copied onto the rule's alerts and linked from notifications.
+-- gpu_model scopes a rule to GPUs whose GPU_MODEL_LABEL label matches; it replaces
+-- the fleet-wide rules of the same alert_type there, but not GPU-scoped rules.
+-- notify_schedule, when set, limits the rule's non-critical notifications to business hours:
+-- {"days": ["mon",...,"fri"], "start": "09:00", "end": "17:00", "timezone": "Europe/Berlin", "outside": "defer"|"suppress"}
+CREATE TABLE IF NOT EXISTS alert_rules (
+                                           id SERIAL PRIMARY KEY,
+                                           alert_type VARCHAR(50) NOT NULL,
+    metric VARCHAR(50) NOT NULL,
+    operator VARCHAR(2) NOT NULL DEFAULT '>',
+    severity_bands JSONB NOT NULL,
+    auto_resolve_after INTERVAL,
+    node_id VARCHAR(50),
+    gpu_index INT,
+    runbook_url TEXT,
+    notify_schedule JSONB,
+    gpu_model VARCHAR(100),
+    enabled BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((node_id IS NULL) = (gpu_index IS NULL)),
+    CHECK (node_id IS NULL OR gpu_model IS NULL),
+    FOREIGN KEY (node_id) REFERENCES gpu_nodes(node_id) ON DELETE CASCADE
+    );
+
+-- Threshold Suggestions Table (percentile-based thresholds per GPU model, computed by
+-- the alert engine for a fleet rule; applying one creates or updates the model's rule)
+-- status: pending, applied, rejected. severity_bands are the bands the model's rule would get.
+CREATE TABLE IF NOT EXISTS threshold_suggestions (
+                                                     id SERIAL PRIMARY KEY,
+                                                     rule_id INT NOT NULL,
+                                                     gpu_model VARCHAR(100) NOT NULL,
+    percentile FLOAT NOT NULL,
+    samples INT NOT NULL,
+    current_threshold FLOAT NOT NULL,
+    suggested_threshold FLOAT NOT NULL,
+    severity_bands JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    applied_rule_id INT,
+    computed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    decided_at TIMESTAMP,
+    decided_by VARCHAR(100),
+    FOREIGN KEY (rule_id) REFERENCES alert_rules(id) ON DELETE CASCADE,
+    FOREIGN KEY (applied_rule_id) REFERENCES alert_rules(id) ON DELETE SET NULL
+    );
+
+-- One pending suggestion per fleet rule and model, refreshed in place
+CREATE UNIQUE INDEX idx_threshold_suggestions_pending ON threshold_suggestions(rule_id, gpu_model) WHERE status = 'pending';
+
+-- Applies a pending threshold suggestion as its GPU model's rule: the model-scoped rule of
+-- the alert type is updated (and enabled), or created from the fleet rule, and the suggestion
+-- is marked applied. Returns the rule's id, or NULL if the suggestion is missing or no longer
+-- pending. Used by both the API's approve endpoint and the engine's THRESHOLD_AUTO_APPLY.
+CREATE OR REPLACE FUNCTION apply_threshold_suggestion(suggestion_id INT, decider VARCHAR)
+RETURNS INT AS $$
+DECLARE
+    s RECORD;
+    applied INT;
+BEGIN
+    SELECT t.rule_id, t.gpu_model, t.severity_bands, r.alert_type INTO s
+    FROM threshold_suggestions t
+    JOIN alert_rules r ON r.id = t.rule_id
+    WHERE t.id = suggestion_id AND t.status = 'pending'
+    FOR UPDATE OF t;
+    IF NOT FOUND THEN
+        RETURN NULL;
+    END IF;
+
+    UPDATE alert_rules
+    SET severity_bands = s.severity_bands, enabled = TRUE, updated_at = NOW()
+    WHERE id = (
+        SELECT id FROM alert_rules
+        WHERE alert_type = s.alert_type AND gpu_model = s.gpu_model
+        ORDER BY enabled DESC, id
+        LIMIT 1
+    )
+    RETURNING id INTO applied;
+    IF applied IS NULL THEN
+        INSERT INTO alert_rules (alert_type, metric, operator, severity_bands, auto_resolve_after, runbook_url, notify_schedule, gpu_model)
+        SELECT alert_type, metric, operator, s.severity_bands, auto_resolve_after, runbook_url, notify_schedule, s.gpu_model
+        FROM alert_rules
+        WHERE id = s.rule_id
+        RETURNING id INTO applied;
+    END IF;
+
+    UPDATE threshold_suggestions
+    SET status = 'applied', applied_rule_id = applied, decided_at = NOW(), decided_by = decider
+    WHERE id = suggestion_id;
+    RETURN applied;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Suppression Rules Table (matching alerts are recorded as 'suppressed', never active or notified)
+-- alert_type and node_id accept * wildcards (other characters, including % and _, match literally); NULL node_id/datacenter match any
+CREATE TABLE IF NOT EXISTS suppression_rules (
+                                                 id SERIAL PRIMARY KEY,
+                                                 alert_type VARCHAR(50) NOT NULL,
+    node_id VARCHAR(50),
+    datacenter VARCHAR(100),
+    reason TEXT,
+    created_by VARCHAR(100),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+
+-- Shadow Alerts Table (hypothetical alerts from dry-run and backtest modes)
+CREATE TABLE IF NOT EXISTS shadow_alerts (
+                                             id BIGSERIAL PRIMARY KEY,
+                                             node_id VARCHAR(50) NOT NULL,
+    gpu_index INT,
+    alert_type VARCHAR(50) NOT NULL,
+    severity VARCHAR(20) NOT NULL,
+    message TEXT NOT NULL,
+    threshold_value FLOAT,
+    actual_value FLOAT,
+    source VARCHAR(20) NOT NULL,
+    metric_collected_at TIMESTAMP,
+    evaluated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+
+CREATE INDEX idx_shadow_alerts_type ON shadow_alerts(alert_type, evaluated_at DESC);
+
+-- GPU Baselines Table (per-GPU normal learned by the alert engine for drift alerts)
+CREATE TABLE IF NOT EXISTS gpu_baselines (
+                                             node_id VARCHAR(50) NOT NULL,
+                                             gpu_index INT NOT NULL,
+                                             metric VARCHAR(50) NOT NULL,
+    mean FLOAT NOT NULL,
+    stddev FLOAT NOT NULL,
+    samples INT NOT NULL,
+    computed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (node_id, gpu_index, metric),
+    FOREIGN KEY (node_id) REFERENCES gpu_nodes(node_id) ON DELETE CASCADE
+    );
+
+-- Pipeline Heartbeats Table (last message each engine instance processed)
+CREATE TABLE IF NOT EXISTS pipeline_heartbeats (
+                                                   component VARCHAR(50) NOT NULL,
+                                                   instance VARCHAR(255) NOT NULL,
+    last_processed_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (component, instance)
+    );
+
+-- Node Ingest Rates Table (samples per minute each alert engine instance receives from a node)
+CREATE TABLE IF NOT EXISTS node_ingest_rates (
+                                                 node_id VARCHAR(50) NOT NULL,
+                                                 instance VARCHAR(255) NOT NULL,
+    samples_per_minute INT NOT NULL,
+    gpus INT NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (node_id, instance)
+    );
+
 -- Insert sample nodes
 INSERT INTO gpu_nodes (node_id, hostname, datacenter, status) VALUES
                                                                   ('node-1', 'dgx-gpu-01.nvidia.com', 'us-west-1', 'healthy'),
                                                                   ('node-2', 'dgx-gpu-02.nvidia.com', 'us-west-1', 'healthy')
     ON CONFLICT (node_id) DO NOTHING;
 
+-- Insert default alert rules
+INSERT INTO alert_rules (alert_type, metric, operator, severity_bands) VALUES
+                                                                           ('high_temperature', 'temperature_celsius', '>', '[{"threshold": 90, "severity": "warning"}, {"threshold": 95, "severity": "critical"}]'),
+                                                                           ('high_power', 'power_watts', '>', '[{"threshold": 330, "severity": "warning"}, {"threshold": 350, "severity": "critical"}]'),
+                                                                           ('high_memory', 'memory_percent', '>', '[{"threshold": 95, "severity": "warning"}]'),
+                                                                           ('low_free_memory', 'memory_free_mb', '<', '[{"threshold": 2048, "severity": "warning"}, {"threshold": 512, "severity": "critical"}]'),
+                                                                           ('row_remap_pending', 'row_remap_pending', '>', '[{"threshold": 0, "severity": "critical"}]');
+
+-- Record the schema version this file creates; bump it with each schema change
+INSERT INTO schema_migrations (version) VALUES (1), (2), (3), (4), (5), (6), (7) ON CONFLICT (version) DO NOTHING;
+
 -- Create a view for latest metrics per node
 CREATE OR REPLACE VIEW latest_gpu_metrics AS
 SELECT DISTINCT ON (node_id, gpu_index)
@@ -75,6 +296,9 @@ SELECT DISTINCT ON (node_id, gpu_index)
     memory_total_mb,
     utilization_percent,
     sm_clock_mhz,
-    collected_at
+    fan_speed_percent,
+    row_remap_pending,
+    collected_at,
+    labels
 FROM gpu_metrics
 ORDER BY node_id, gpu_index, collected_at DESC;
\ No newline at end of file